@@ -0,0 +1,165 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// FakeSignatureHeader contains the signature of callbacks of the fake provider
+const FakeSignatureHeader = "X-Fake-Signature"
+
+// maxEventSize limits the size of callback bodies
+const maxEventSize = 64 << 10
+
+// Fake is a Provider that doesn't charge anything. It's used in development
+// and tests, which generate its callbacks using Event and Sign.
+type Fake struct {
+	secret []byte
+
+	lock          sync.Mutex
+	customers     map[string]string
+	subscriptions map[string]*fakeSubscription
+}
+
+type fakeSubscription struct {
+	customer  string
+	plan      *Plan
+	periodEnd time.Time
+	cancelled bool
+}
+
+// NewFake sets up a fake provider. Callbacks have to be signed using secret,
+// otherwise anyone could upgrade their account.
+func NewFake(secret string) (*Fake, error) {
+	if secret == "" {
+		return nil, ErrMissingSecret
+	}
+
+	return &Fake{
+		secret:        []byte(secret),
+		customers:     map[string]string{},
+		subscriptions: map[string]*fakeSubscription{},
+	}, nil
+}
+
+// Name returns the provider's name
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// CreateCustomer registers a new customer
+func (f *Fake) CreateCustomer(account string, email string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id := "cus_" + uniuri.New()
+	f.customers[id] = account
+	return id, nil
+}
+
+// Subscribe creates a subscription waiting for the first payment
+func (f *Fake) Subscribe(customer string, plan *Plan) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, ok := f.customers[customer]; !ok {
+		return "", ErrUnknownCustomer
+	}
+
+	id := "sub_" + uniuri.New()
+	f.subscriptions[id] = &fakeSubscription{
+		customer: customer,
+		plan:     plan,
+	}
+	return id, nil
+}
+
+// Cancel marks a subscription as cancelled
+func (f *Fake) Cancel(subscription string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	entry, ok := f.subscriptions[subscription]
+	if !ok {
+		return ErrUnknownSubscription
+	}
+
+	entry.cancelled = true
+	return nil
+}
+
+// Event creates an event of a subscription that a real provider would send.
+// Successful payments start a new period of the subscription.
+func (f *Fake) Event(subscription string, kind string) (*Event, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	entry, ok := f.subscriptions[subscription]
+	if !ok {
+		return nil, ErrUnknownSubscription
+	}
+
+	event := &Event{
+		Type:         kind,
+		Subscription: subscription,
+	}
+
+	if kind == EventPaymentSucceeded || kind == EventPaymentFailed {
+		start := entry.periodEnd
+		if start.IsZero() {
+			start = time.Now().UTC()
+		}
+
+		event.Invoice = "in_" + uniuri.New()
+		event.Amount = entry.plan.Price
+		event.Currency = entry.plan.Currency
+		event.PeriodStart = start
+		event.PeriodEnd = start.AddDate(0, entry.plan.Period, 0)
+
+		if kind == EventPaymentSucceeded {
+			entry.periodEnd = event.PeriodEnd
+		}
+	}
+
+	return event, nil
+}
+
+// Sign returns the signature of a callback body
+func (f *Fake) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseEvent decodes a JSON-encoded Event and checks its signature
+func (f *Fake) ParseEvent(r *http.Request) (*Event, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxEventSize))
+	if err != nil {
+		return nil, ErrInvalidEvent
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(FakeSignatureHeader))
+	if err != nil {
+		return nil, ErrInvalidEvent
+	}
+
+	expected, _ := hex.DecodeString(f.Sign(body))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidEvent
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || event.Type == "" || event.Subscription == "" {
+		return nil, ErrInvalidEvent
+	}
+
+	return &event, nil
+}
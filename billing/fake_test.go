@@ -0,0 +1,118 @@
+package billing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestFakeSecret(t *testing.T) {
+	if _, err := NewFake(""); err != ErrMissingSecret {
+		t.Fatalf("fake provider started without a secret: %v", err)
+	}
+}
+
+func TestFakeSubscription(t *testing.T) {
+	fake, err := NewFake("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fake.Subscribe("cus_unknown", Plans[0]); err != ErrUnknownCustomer {
+		t.Fatalf("unknown customer subscribed: %v", err)
+	}
+
+	customer, err := fake.CreateCustomer("alice", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscription, err := fake.Subscribe(customer, Plans[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Successful payments start consecutive periods
+	first, err := fake.Event(subscription, EventPaymentSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Amount != Plans[0].Price || first.Invoice == "" {
+		t.Fatalf("unexpected payment %+v", first)
+	}
+	if !first.PeriodEnd.Equal(first.PeriodStart.AddDate(0, Plans[0].Period, 0)) {
+		t.Fatalf("unexpected period %s - %s", first.PeriodStart, first.PeriodEnd)
+	}
+
+	second, err := fake.Event(subscription, EventPaymentSucceeded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.PeriodStart.Equal(first.PeriodEnd) {
+		t.Fatalf("renewal starts at %s instead of %s", second.PeriodStart, first.PeriodEnd)
+	}
+
+	if err := fake.Cancel(subscription); err != nil {
+		t.Fatal(err)
+	}
+	if err := fake.Cancel("sub_unknown"); err != ErrUnknownSubscription {
+		t.Fatalf("unknown subscription was cancelled: %v", err)
+	}
+}
+
+func TestFakeParseEvent(t *testing.T) {
+	fake, err := NewFake("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(&Event{
+		Type:         EventSubscriptionEnded,
+		Subscription: "sub_1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parse := func(body []byte, signature string) (*Event, error) {
+		req, err := http.NewRequest("POST", "/billing/callback", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature != "" {
+			req.Header.Set(FakeSignatureHeader, signature)
+		}
+
+		return fake.ParseEvent(req)
+	}
+
+	event, err := parse(body, fake.Sign(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventSubscriptionEnded || event.Subscription != "sub_1" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	if _, err := parse(body, ""); err != ErrInvalidEvent {
+		t.Fatalf("unsigned event was accepted: %v", err)
+	}
+
+	other, err := NewFake("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(body, other.Sign(body)); err != ErrInvalidEvent {
+		t.Fatalf("event signed using another secret was accepted: %v", err)
+	}
+
+	tampered := bytes.Replace(body, []byte("sub_1"), []byte("sub_2"), 1)
+	if _, err := parse(tampered, fake.Sign(body)); err != ErrInvalidEvent {
+		t.Fatalf("tampered event was accepted: %v", err)
+	}
+
+	invalid := []byte(`{"type":"payment_succeeded"}`)
+	if _, err := parse(invalid, fake.Sign(invalid)); err != ErrInvalidEvent {
+		t.Fatalf("event without a subscription was accepted: %v", err)
+	}
+}
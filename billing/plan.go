@@ -0,0 +1,100 @@
+package billing
+
+// Unlimited is used as a limit that doesn't apply
+const Unlimited = -1
+
+// DefaultType is the type of accounts without a subscription
+const DefaultType = "std"
+
+// Limits restrict resources that an account can create
+type Limits struct {
+	// Storage is the count of bytes used by emails, files and contacts
+	Storage int `json:"storage"`
+
+	// Addresses is the count of addresses, including the account's name
+	Addresses int `json:"addresses"`
+}
+
+// Plan is a paid subscription upgrading the type of an account
+type Plan struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	AccountType string `json:"account_type"`
+
+	// Price is charged every period, in cents of the currency
+	Price    int    `json:"price"`
+	Currency string `json:"currency"`
+
+	// Period is the count of months between renewals
+	Period int `json:"period"`
+
+	Limits Limits `json:"limits"`
+}
+
+// typeLimits contains limits of each account type
+var typeLimits = map[string]Limits{
+	"std": {
+		Storage:   1 << 30,
+		Addresses: 1,
+	},
+	"beta": {
+		Storage:   5 << 30,
+		Addresses: 3,
+	},
+	"premium": {
+		Storage:   20 << 30,
+		Addresses: 10,
+	},
+	"superuser": {
+		Storage:   Unlimited,
+		Addresses: Unlimited,
+	},
+}
+
+// Plans contains all plans that can be subscribed to
+var Plans = []*Plan{
+	{
+		ID:          "premium_monthly",
+		Name:        "Premium (monthly)",
+		AccountType: "premium",
+		Price:       500,
+		Currency:    "EUR",
+		Period:      1,
+		Limits:      typeLimits["premium"],
+	},
+	{
+		ID:          "premium_yearly",
+		Name:        "Premium (yearly)",
+		AccountType: "premium",
+		Price:       5000,
+		Currency:    "EUR",
+		Period:      12,
+		Limits:      typeLimits["premium"],
+	},
+}
+
+// GetPlan returns the plan with specified ID or nil if it doesn't exist
+func GetPlan(id string) *Plan {
+	for _, plan := range Plans {
+		if plan.ID == id {
+			return plan
+		}
+	}
+
+	return nil
+}
+
+// LimitsOf returns limits of an account type. Unknown types have the limits
+// of the default type.
+func LimitsOf(accountType string) Limits {
+	if limits, ok := typeLimits[accountType]; ok {
+		return limits
+	}
+
+	return typeLimits[DefaultType]
+}
+
+// Allows checks whether a limit allows having count resources
+func Allows(limit int, count int) bool {
+	return limit == Unlimited || count <= limit
+}
@@ -0,0 +1,67 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Types of events sent by providers
+const (
+	// EventPaymentSucceeded starts or renews a subscription
+	EventPaymentSucceeded = "payment_succeeded"
+
+	// EventPaymentFailed is sent when a renewal couldn't be charged
+	EventPaymentFailed = "payment_failed"
+
+	// EventSubscriptionEnded is sent after a cancelled or unpaid subscription ends
+	EventSubscriptionEnded = "subscription_ended"
+)
+
+var (
+	// ErrInvalidEvent is returned when a callback couldn't be verified or decoded
+	ErrInvalidEvent = errors.New("Invalid billing event")
+
+	// ErrMissingSecret is returned when a provider has no secret to verify callbacks
+	ErrMissingSecret = errors.New("Missing billing secret")
+
+	// ErrUnknownCustomer is returned for customers not registered with the provider
+	ErrUnknownCustomer = errors.New("Unknown customer")
+
+	// ErrUnknownSubscription is returned for subscriptions not known to the provider
+	ErrUnknownSubscription = errors.New("Unknown subscription")
+)
+
+// Provider is a payment service charging the subscriptions. Changes of
+// subscriptions are reported back using callbacks parsed by ParseEvent.
+type Provider interface {
+	// Name returns the provider's name
+	Name() string
+
+	// CreateCustomer registers an account and returns its customer ID
+	CreateCustomer(account string, email string) (string, error)
+
+	// Subscribe starts charging a customer for a plan and returns the ID of
+	// the subscription. It becomes active after the first payment succeeds.
+	Subscribe(customer string, plan *Plan) (string, error)
+
+	// Cancel stops renewals of a subscription. It ends with the current period.
+	Cancel(subscription string) error
+
+	// ParseEvent verifies and decodes a callback request of the provider
+	ParseEvent(r *http.Request) (*Event, error)
+}
+
+// Event is a change of a subscription reported by a provider
+type Event struct {
+	Type         string `json:"type"`
+	Subscription string `json:"subscription"`
+
+	// Invoice is the provider's ID of the charged invoice, set in payment events
+	Invoice  string `json:"invoice,omitempty"`
+	Amount   int    `json:"amount,omitempty"`
+	Currency string `json:"currency,omitempty"`
+
+	PeriodStart time.Time `json:"period_start,omitempty"`
+	PeriodEnd   time.Time `json:"period_end,omitempty"`
+}
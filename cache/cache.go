@@ -10,4 +10,9 @@ type Cache interface {
 	DeleteMask(mask string) error
 	DeleteMulti(keys ...interface{}) error
 	Exists(key string) (bool, error)
+
+	// Increment atomically adds delta to a counter and returns its new value.
+	// Expiration is set only when the counter is created. Counters can't be
+	// read using Get.
+	Increment(key string, delta int64, expires time.Duration) (int64, error)
 }
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned by MemoryCache if the requested key doesn't exist
+var ErrKeyNotFound = errors.New("Key not found")
+
+// MemoryCache is an implementation of Cache that keeps all values in the process memory
+type MemoryCache struct {
+	lock  sync.RWMutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	data    []byte
+	expires time.Time
+}
+
+func (m memoryItem) expired() bool {
+	return !m.expires.IsZero() && time.Now().After(m.expires)
+}
+
+// NewMemoryCache creates a new empty in-memory cache
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		items: map[string]memoryItem{},
+	}
+}
+
+// Get retrieves data from the memory and then decodes it into the passed pointer.
+func (m *MemoryCache) Get(key string, pointer interface{}) error {
+	m.lock.RLock()
+	item, ok := m.items[key]
+	m.lock.RUnlock()
+
+	if !ok || item.expired() {
+		return ErrKeyNotFound
+	}
+
+	// Initialize a new decoder
+	dec := gob.NewDecoder(bytes.NewReader(item.data))
+
+	// Decode it into pointer
+	return dec.Decode(pointer)
+}
+
+// Set encodes passed value and stores it in the memory
+func (m *MemoryCache) Set(key string, value interface{}, expires time.Duration) error {
+	// Initialize a new encoder
+	var buffer bytes.Buffer
+	enc := gob.NewEncoder(&buffer)
+
+	// Encode the value
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+
+	item := memoryItem{
+		data: buffer.Bytes(),
+	}
+	if expires != 0 {
+		item.expires = time.Now().Add(expires)
+	}
+
+	m.lock.Lock()
+	m.items[key] = item
+	m.lock.Unlock()
+
+	return nil
+}
+
+// Delete removes data by key
+func (m *MemoryCache) Delete(key string) error {
+	m.lock.Lock()
+	delete(m.items, key)
+	m.lock.Unlock()
+
+	return nil
+}
+
+// DeleteMask removes data using glob-style masks, similar to redis' KEYS
+func (m *MemoryCache) DeleteMask(mask string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for key := range m.items {
+		matched, err := path.Match(mask, key)
+		if err != nil {
+			return err
+		}
+
+		if matched {
+			delete(m.items, key)
+		}
+	}
+
+	return nil
+}
+
+// DeleteMulti removes multiple keys
+func (m *MemoryCache) DeleteMulti(keys ...interface{}) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, key := range keys {
+		delete(m.items, fmt.Sprint(key))
+	}
+
+	return nil
+}
+
+// Exists performs a check whether a key exists
+func (m *MemoryCache) Exists(key string) (bool, error) {
+	m.lock.RLock()
+	item, ok := m.items[key]
+	m.lock.RUnlock()
+
+	return ok && !item.expired(), nil
+}
+
+// Increment adds delta to a counter and sets its expiration if it's new
+func (m *MemoryCache) Increment(key string, delta int64, expires time.Duration) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var value int64
+	item, ok := m.items[key]
+	if ok && !item.expired() {
+		if err := gob.NewDecoder(bytes.NewReader(item.data)).Decode(&value); err != nil {
+			return 0, err
+		}
+	} else {
+		item = memoryItem{}
+		if expires != 0 {
+			item.expires = time.Now().Add(expires)
+		}
+	}
+
+	value += delta
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(value); err != nil {
+		return 0, err
+	}
+
+	item.data = buffer.Bytes()
+	m.items[key] = item
+
+	return value, nil
+}
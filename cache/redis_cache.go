@@ -15,6 +15,13 @@ var (
 			redis.call( 'del', k )
 		end
 	`)
+	scriptIncrement = redis.NewScript(1, `
+		local value = redis.call( 'incrby', KEYS[1], ARGV[1] )
+		if tonumber( ARGV[2] ) > 0 and redis.call( 'pttl', KEYS[1] ) == -1 then
+			redis.call( 'pexpire', KEYS[1], ARGV[2] )
+		end
+		return value
+	`)
 )
 
 // RedisCache is an implementation of Cache that uses Redis as a backend
@@ -158,3 +165,10 @@ func (r *RedisCache) Exists(key string) (bool, error) {
 	defer conn.Close()
 	return redis.Bool(conn.Do("EXISTS", key))
 }
+
+// Increment adds delta to a counter and sets its expiration if it's new
+func (r *RedisCache) Increment(key string, delta int64, expires time.Duration) (int64, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	return redis.Int64(scriptIncrement.Do(conn, key, delta, int64(expires/time.Millisecond)))
+}
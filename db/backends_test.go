@@ -0,0 +1,363 @@
+package db
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dancannon/gorethink"
+	"github.com/dchest/uniuri"
+
+	"github.com/lavab/api/cache"
+	"github.com/lavab/api/models"
+)
+
+// testBackend creates empty tables of one of the database backends
+type testBackend struct {
+	name  string
+	table func(t *testing.T, name string) RethinkCRUD
+}
+
+// forEachBackend runs the test against the in-memory tables and, if
+// RETHINKDB_TEST_ADDRESS is set, against a temporary RethinkDB database, so
+// that both implementations of the queries are checked by the same test.
+func forEachBackend(t *testing.T, test func(t *testing.T, backend *testBackend)) {
+	test(t, &testBackend{
+		name: "memory",
+		table: func(t *testing.T, name string) RethinkCRUD {
+			return NewMemoryTable("test", name, TableIndexes[name]...)
+		},
+	})
+
+	address := os.Getenv("RETHINKDB_TEST_ADDRESS")
+	if address == "" {
+		t.Log("RETHINKDB_TEST_ADDRESS is not set, skipping RethinkDB")
+		return
+	}
+
+	session, err := gorethink.Connect(gorethink.ConnectOpts{
+		Address: address,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	database := "api_test_" + uniuri.NewLenChars(8, []byte("abcdefghijklmnopqrstuvwxyz"))
+	if err := ensureDatabase(session, database); err != nil {
+		t.Fatal(err)
+	}
+	defer gorethink.DBDrop(database).Exec(session)
+
+	test(t, &testBackend{
+		name: "rethinkdb",
+		table: func(t *testing.T, name string) RethinkCRUD {
+			if err := ensureTableWithIndexes(session, database, name, TableIndexes[name]...); err != nil {
+				t.Fatal(err)
+			}
+			return NewCRUDTable(session, database, name)
+		},
+	})
+}
+
+// sortedIDs returns the sorted IDs of the emails
+func sortedIDs(emails []*models.Email) []string {
+	ids := []string{}
+	for _, email := range emails {
+		ids = append(ids, email.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBackendEmailFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		emails := &EmailsTable{RethinkCRUD: backend.table(t, "emails")}
+
+		var expected []string
+		for _, input := range []struct {
+			status string
+			thread string
+		}{
+			{"sent", "first"},
+			{"received", "second"},
+			{"queued", "first"},
+		} {
+			email := &models.Email{
+				Resource: models.MakeResource("alice", "email"),
+				Status:   input.status,
+				Thread:   input.thread,
+				Files:    []string{},
+			}
+			if err := emails.Insert(email); err != nil {
+				t.Fatal(err)
+			}
+			if input.status != "queued" {
+				expected = append(expected, email.ID)
+			}
+		}
+
+		// Documents missing the filtered field are selected by negations
+		legacy := map[string]interface{}{
+			"id":            "legacy",
+			"owner":         "alice",
+			"thread":        "first",
+			"date_modified": time.Now(),
+		}
+		if err := emails.Insert(legacy); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, "legacy")
+		sort.Strings(expected)
+
+		list, _, err := emails.List("alice", "", &Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := sortedIDs(list); !equalStrings(ids, expected) {
+			t.Fatalf("%s: expected %v, got %v", backend.name, expected, ids)
+		}
+
+		list, _, err = emails.List("alice", "second", &Page{Count: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Thread != "second" {
+			t.Fatalf("%s: unexpected thread emails %v", backend.name, list)
+		}
+	})
+}
+
+func TestBackendFileFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		emails := &EmailsTable{RethinkCRUD: backend.table(t, "emails")}
+		files := &FilesTable{
+			RethinkCRUD: backend.table(t, "files"),
+			Emails:      emails,
+		}
+
+		var ids []string
+		for _, name := range []string{"a.txt", "b.txt", "a.txt"} {
+			file := &models.File{
+				Resource: models.MakeResource("alice", name),
+			}
+			if err := files.Insert(file); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, file.ID)
+		}
+
+		email := &models.Email{
+			Resource: models.MakeResource("alice", "email"),
+			Files:    ids[:2],
+		}
+		if err := emails.Insert(email); err != nil {
+			t.Fatal(err)
+		}
+
+		attached, _, err := files.List("alice", email.ID, "", &Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attached) != 2 {
+			t.Fatalf("%s: expected 2 attached files, got %d", backend.name, len(attached))
+		}
+
+		named, _, err := files.List("alice", email.ID, "a.txt", &Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(named) != 1 || named[0].ID != ids[0] {
+			t.Fatalf("%s: unexpected files %v", backend.name, named)
+		}
+	})
+}
+
+func TestBackendThreadFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		threads := &ThreadsTable{
+			RethinkCRUD: backend.table(t, "threads"),
+			Emails:      &EmailsTable{RethinkCRUD: backend.table(t, "emails")},
+		}
+
+		now := time.Now()
+		for _, labels := range [][]string{
+			{"inbox"},
+			{"inbox", "starred"},
+			{"archive"},
+		} {
+			if err := threads.Insert(newTestThread("alice", now, labels...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		cases := []struct {
+			labels []string
+			count  int
+		}{
+			{[]string{"inbox"}, 2},
+			{[]string{"inbox", "starred"}, 1},
+			{[]string{"inbox", "-starred"}, 1},
+			{[]string{"-inbox"}, 1},
+			{[]string{"spam"}, 0},
+		}
+		for _, c := range cases {
+			list, info, err := threads.List("alice", c.labels, &Page{Count: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != c.count || info.Total != c.count {
+				t.Fatalf("%s: %v selected %d threads (total %d), expected %d", backend.name, c.labels, len(list), info.Total, c.count)
+			}
+		}
+	})
+}
+
+func TestBackendDeleteFilters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		changes := &ChangesTable{
+			RethinkCRUD: backend.table(t, "changes"),
+			Sequences:   &SequencesTable{RethinkCRUD: backend.table(t, "sequences")},
+		}
+		contacts := NewChangeLog(backend.table(t, "contacts"), changes)
+
+		for _, name := range []string{"keep", "remove", "remove"} {
+			if err := contacts.Insert(&models.Contact{
+				Resource: models.MakeResource("alice", name),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Both map and Filter predicates are supported
+		if err := contacts.Delete(fieldEquals("name", "remove")); err != nil {
+			t.Fatalf("%s: %v", backend.name, err)
+		}
+
+		var rest []*models.Contact
+		if err := contacts.FindByIndexFetch(&rest, "owner", "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 1 || rest[0].Name != "keep" {
+			t.Fatalf("%s: unexpected contacts %v", backend.name, rest)
+		}
+
+		list, err := changes.ListSince("alice", 3, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Kind != models.ChangeDeleted {
+			t.Fatalf("%s: expected 2 tombstones, got %v", backend.name, list)
+		}
+
+		tokens := &TokensTable{
+			RethinkCRUD: backend.table(t, "tokens"),
+			Cache:       cache.NewMemoryCache(),
+		}
+		for _, kind := range []string{"auth", "refresh", "api"} {
+			if err := tokens.Insert(&models.Token{
+				Resource: models.MakeResource("alice", kind),
+				Type:     kind,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tokens.Delete(fieldEquals("type", "api").Not()); err != nil {
+			t.Fatalf("%s: %v", backend.name, err)
+		}
+		if err := tokens.Delete(map[string]interface{}{"owner": "bob"}); err != nil {
+			t.Fatalf("%s: %v", backend.name, err)
+		}
+
+		var left []*models.Token
+		if err := tokens.FindByIndexFetch(&left, "owner", "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != 1 || left[0].Type != "api" {
+			t.Fatalf("%s: unexpected tokens %v", backend.name, left)
+		}
+	})
+}
+
+func TestBackendStorageUsage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		emails := &EmailsTable{RethinkCRUD: backend.table(t, "emails")}
+
+		for _, email := range []*models.Email{
+			{Resource: models.MakeResource("alice", "a"), Body: "12345", Manifest: "123"},
+			{Resource: models.MakeResource("alice", "b"), Body: "12"},
+			{Resource: models.MakeResource("bob", "c"), Body: "1234567890"},
+		} {
+			if err := emails.Insert(email); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		usage, err := emails.StorageUsage("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if usage != 10 {
+			t.Fatalf("%s: expected 10 bytes, got %d", backend.name, usage)
+		}
+	})
+}
+
+func TestBackendAuditEvents(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, backend *testBackend) {
+		events := NewAuditEventsTable(backend.table(t, "audit_events"))
+
+		now := time.Now()
+		for i, input := range []struct {
+			owner string
+			kind  string
+		}{
+			{"alice", models.AuditLogin},
+			{"alice", models.AuditLoginFailed},
+			{"bob", models.AuditLogin},
+		} {
+			event := &models.AuditEvent{
+				Resource: models.MakeResource(input.owner, ""),
+				Type:     input.kind,
+			}
+			event.DateCreated = now.Add(time.Duration(i) * time.Second)
+			event.DateModified = event.DateCreated
+			if err := events.Insert(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Newest first, only events of the owner
+		list, _, err := events.List("alice", &Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 2 || list[0].Type != models.AuditLoginFailed || list[1].Type != models.AuditLogin {
+			t.Fatalf("%s: unexpected events %v", backend.name, list)
+		}
+
+		if err := events.DeleteOwnedBy("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if list, _, err := events.List("alice", &Page{}); err != nil || len(list) != 0 {
+			t.Fatalf("%s: events survived the deletion of the owner: %v %v", backend.name, list, err)
+		}
+		if list, _, err := events.List("bob", &Page{}); err != nil || len(list) != 1 {
+			t.Fatalf("%s: events of other accounts were deleted: %v %v", backend.name, list, err)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package db
+
+import (
+	"github.com/dancannon/gorethink/encoding"
+
+	"github.com/lavab/api/models"
+)
+
+// ChangeLog wraps a table and records every write into the change log.
+// Writes that don't go through the RethinkCRUD methods are not recorded.
+type ChangeLog struct {
+	RethinkCRUD
+	Changes *ChangesTable
+}
+
+// NewChangeLog wraps a table so that its writes are recorded in changes
+func NewChangeLog(table RethinkCRUD, changes *ChangesTable) *ChangeLog {
+	return &ChangeLog{
+		RethinkCRUD: table,
+		Changes:     changes,
+	}
+}
+
+// owned contains the fields of a resource required by the change log
+type owned struct {
+	ID    string `gorethink:"id"`
+	Owner string `gorethink:"owner"`
+}
+
+// Insert inserts a document and records its creation
+func (c *ChangeLog) Insert(data interface{}) error {
+	encoded, err := encoding.Encode(data)
+	if err != nil {
+		return NewDatabaseError(c, err, "")
+	}
+
+	var documents []owned
+	if _, ok := encoded.([]interface{}); ok {
+		err = encoding.Decode(&documents, encoded)
+	} else {
+		var document owned
+		err = encoding.Decode(&document, encoded)
+		documents = append(documents, document)
+	}
+	if err != nil {
+		return NewDatabaseError(c, err, "")
+	}
+
+	if err := c.RethinkCRUD.Insert(data); err != nil {
+		return err
+	}
+
+	return c.record(documents, models.ChangeCreated)
+}
+
+// Update performs an update and records it if data contains an ID
+func (c *ChangeLog) Update(data interface{}) error {
+	encoded, err := encoding.Encode(data)
+	if err != nil {
+		return NewDatabaseError(c, err, "")
+	}
+
+	if err := c.RethinkCRUD.Update(data); err != nil {
+		return err
+	}
+
+	var document owned
+	if err := encoding.Decode(&document, encoded); err != nil || document.ID == "" {
+		return nil
+	}
+
+	return c.recordID(document.ID, models.ChangeUpdated)
+}
+
+// UpdateID updates a resource and records the change
+func (c *ChangeLog) UpdateID(id string, data interface{}) error {
+	if err := c.RethinkCRUD.UpdateID(id, data); err != nil {
+		return err
+	}
+
+	return c.recordID(id, models.ChangeUpdated)
+}
+
+// UpdateIDIf conditionally updates a resource and records the change if it was updated
+func (c *ChangeLog) UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error) {
+	updated, err := c.RethinkCRUD.UpdateIDIf(id, cond, data)
+	if err != nil || !updated {
+		return updated, err
+	}
+
+	return true, c.recordID(id, models.ChangeUpdated)
+}
+
+// Delete removes all matching resources and records tombstones for them
+func (c *ChangeLog) Delete(pred interface{}) error {
+	filter, err := toFilter(pred)
+	if err != nil {
+		return NewDatabaseError(c, err, "")
+	}
+
+	var documents []owned
+	if err := fetchMatching(c.RethinkCRUD, filter, &documents); err != nil {
+		return err
+	}
+
+	if err := c.RethinkCRUD.Delete(filter); err != nil {
+		return err
+	}
+
+	return c.record(documents, models.ChangeDeleted)
+}
+
+// DeleteID removes a resource and records its tombstone
+func (c *ChangeLog) DeleteID(id string) error {
+	var document owned
+	if err := c.FindFetchOne(id, &document); err != nil {
+		return err
+	}
+
+	if err := c.RethinkCRUD.DeleteID(id); err != nil {
+		return err
+	}
+
+	return c.record([]owned{document}, models.ChangeDeleted)
+}
+
+// DeleteIDIf conditionally removes a resource and records its tombstone if it was removed
+func (c *ChangeLog) DeleteIDIf(id string, cond map[string]interface{}) (bool, error) {
+	var document owned
+	if err := c.FindFetchOne(id, &document); err != nil {
+		return false, err
+	}
+
+	deleted, err := c.RethinkCRUD.DeleteIDIf(id, cond)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+
+	return true, c.record([]owned{document}, models.ChangeDeleted)
+}
+
+// recordID fetches the owner of a resource and records the change
+func (c *ChangeLog) recordID(id string, kind string) error {
+	var document owned
+	if err := c.FindFetchOne(id, &document); err != nil {
+		return err
+	}
+
+	return c.record([]owned{document}, kind)
+}
+
+// record appends changes of owned resources into the change log, one batch per owner
+func (c *ChangeLog) record(documents []owned, kind string) error {
+	var (
+		owners    []string
+		resources = map[string][]string{}
+	)
+	for _, document := range documents {
+		if document.ID == "" || document.Owner == "" {
+			continue
+		}
+
+		if _, ok := resources[document.Owner]; !ok {
+			owners = append(owners, document.Owner)
+		}
+		resources[document.Owner] = append(resources[document.Owner], document.ID)
+	}
+
+	for _, owner := range owners {
+		if err := c.Changes.Record(owner, c.GetTableName(), kind, resources[owner]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
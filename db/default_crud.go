@@ -70,8 +70,27 @@ func (d *Default) UpdateID(id string, data interface{}) error {
 	return nil
 }
 
-// Delete deletes resources that match the passed filter
+// UpdateIDIf atomically updates a resource only if its fields equal the values
+// in cond. It returns false if the resource doesn't exist, doesn't match or if
+// data doesn't change it.
+func (d *Default) UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error) {
+	response, err := d.GetTable().Get(id).Update(func(row gorethink.Term) interface{} {
+		return gorethink.Branch(conditionTerm(row, cond), data, map[string]interface{}{})
+	}).RunWrite(d.session)
+	if err != nil {
+		return false, NewDatabaseError(d, err, "")
+	}
+
+	return response.Replaced == 1, nil
+}
+
+// Delete deletes resources that match the passed filter, which can also be
+// a *Filter
 func (d *Default) Delete(pred interface{}) error {
+	if filter, ok := pred.(*Filter); ok {
+		pred = filter.Term
+	}
+
 	err := d.GetTable().Filter(pred).Delete().Exec(d.session)
 	if err != nil {
 		return NewDatabaseError(d, err, "")
@@ -90,6 +109,29 @@ func (d *Default) DeleteID(id string) error {
 	return nil
 }
 
+// DeleteIDIf atomically deletes a resource only if its fields equal the values
+// in cond. It returns false if the resource doesn't exist or doesn't match.
+func (d *Default) DeleteIDIf(id string, cond map[string]interface{}) (bool, error) {
+	response, err := d.GetTable().Get(id).Replace(func(row gorethink.Term) interface{} {
+		return gorethink.Branch(row.Ne(nil).And(conditionTerm(row, cond)), nil, row)
+	}).RunWrite(d.session)
+	if err != nil {
+		return false, NewDatabaseError(d, err, "")
+	}
+
+	return response.Deleted == 1, nil
+}
+
+// conditionTerm checks whether fields of row equal the values in cond
+func conditionTerm(row gorethink.Term, cond map[string]interface{}) gorethink.Term {
+	term := gorethink.Expr(true)
+	for key, value := range cond {
+		term = term.And(row.Field(key).Default(nil).Eq(value))
+	}
+
+	return term
+}
+
 // Find searches for a resource in the database and then returns a cursor
 func (d *Default) Find(id string) (*gorethink.Cursor, error) {
 	cursor, err := d.GetTable().Get(id).Run(d.session)
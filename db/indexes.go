@@ -0,0 +1,177 @@
+package db
+
+import (
+	r "github.com/dancannon/gorethink"
+)
+
+// Index describes a secondary index of a table. Indexes with a single field
+// are simple field indexes, indexes with more fields are compound.
+type Index struct {
+	Name   string
+	Fields []string
+	Multi  bool
+}
+
+// Term returns the index function of a compound index
+func (i Index) Term(row r.Term) interface{} {
+	fields := make([]interface{}, len(i.Fields))
+	for n, field := range i.Fields {
+		fields[n] = row.Field(field)
+	}
+	return fields
+}
+
+// simpleIndex creates a new single-field index
+func simpleIndex(name string) Index {
+	return Index{
+		Name:   name,
+		Fields: []string{name},
+	}
+}
+
+// multiIndex creates a new single-field multi index
+func multiIndex(name string) Index {
+	return Index{
+		Name:   name,
+		Fields: []string{name},
+		Multi:  true,
+	}
+}
+
+// compoundIndex creates a new index over multiple fields
+func compoundIndex(name string, fields ...string) Index {
+	return Index{
+		Name:   name,
+		Fields: fields,
+	}
+}
+
+// TableIndexes contains names of all tables used by the API and their secondary indexes
+var TableIndexes = map[string][]Index{
+	"accounts": []Index{
+		simpleIndex("name"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		simpleIndex("alt_email"),
+		simpleIndex("type"),
+		simpleIndex("status"),
+		simpleIndex("invited_by"),
+	},
+	"addresses": []Index{
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"audit_events": []Index{
+		simpleIndex("owner"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"blobs": []Index{
+		simpleIndex("owner"),
+		simpleIndex("blob"),
+		simpleIndex("expiry_date"),
+	},
+	"changes": []Index{
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		compoundIndex("ownerSequence", "owner", "sequence"),
+	},
+	"contacts": []Index{
+		simpleIndex("owner"),
+		simpleIndex("name"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"emails": []Index{
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		simpleIndex("thread"),
+		simpleIndex("kind"),
+		simpleIndex("from"),
+		simpleIndex("message_id"),
+		multiIndex("to"),
+		multiIndex("cc"),
+		multiIndex("bcc"),
+		compoundIndex("messageIDOwner", "message_id", "owner"),
+		compoundIndex("threadStatus", "thread", "status"),
+		compoundIndex("threadAndDate", "thread", "date_created"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"files": []Index{
+		simpleIndex("owner"),
+		simpleIndex("name"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"invoices": []Index{
+		simpleIndex("owner"),
+		simpleIndex("provider_id"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"jobs": []Index{
+		simpleIndex("owner"),
+		simpleIndex("type"),
+		simpleIndex("status"),
+	},
+	"keys": []Index{
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		simpleIndex("key_id"),
+	},
+	"labels": []Index{
+		simpleIndex("name"),
+		simpleIndex("builtin"),
+		simpleIndex("owner"),
+		compoundIndex("nameOwnerBuiltin", "name", "owner", "builtin"),
+	},
+	"oauth_clients": []Index{
+		simpleIndex("owner"),
+	},
+	"reservations": []Index{
+		simpleIndex("name"),
+		simpleIndex("email"),
+		simpleIndex("expiry_date"),
+	},
+	"sequences": []Index{},
+	"subscriptions": []Index{
+		simpleIndex("owner"),
+		simpleIndex("provider_id"),
+	},
+	"threads": []Index{
+		simpleIndex("name"),
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		multiIndex("emails"),
+		multiIndex("labels"),
+		multiIndex("members"),
+		simpleIndex("subject_hash"),
+		simpleIndex("secure"),
+		compoundIndex("subjectOwner", "subject_hash", "owner"),
+		compoundIndex("ownerModified", "owner", "date_modified", "id"),
+	},
+	"tokens": []Index{
+		simpleIndex("name"),
+		simpleIndex("owner"),
+		simpleIndex("date_created"),
+		simpleIndex("date_modified"),
+		simpleIndex("type"),
+		simpleIndex("expiry_date"),
+		simpleIndex("client"),
+	},
+	"webhooks": []Index{
+		simpleIndex("owner"),
+		simpleIndex("target"),
+		simpleIndex("type"),
+		compoundIndex("targetType", "target", "type"),
+	},
+	"webhook_deliveries": []Index{
+		simpleIndex("owner"),
+		simpleIndex("webhook"),
+	},
+}
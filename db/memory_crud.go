@@ -0,0 +1,744 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dancannon/gorethink"
+	"github.com/dancannon/gorethink/encoding"
+	"github.com/dchest/uniuri"
+)
+
+var (
+	// ErrCursorUnsupported is returned by the cursor-based methods of Memory
+	ErrCursorUnsupported = errors.New("Cursors are not supported by in-memory tables")
+	// ErrUnsupportedFilter is returned if Memory can't evaluate the passed predicate
+	ErrUnsupportedFilter = errors.New("In-memory tables support only map filters")
+	// ErrDuplicatePrimaryKey is returned when inserting a document with an already used ID
+	ErrDuplicatePrimaryKey = errors.New("Duplicate primary key")
+	// ErrInvalidDocument is returned when the passed data doesn't encode into a document
+	ErrInvalidDocument = errors.New("Data is not a valid document")
+)
+
+// Memory is an implementation of the RethinkCRUD interface that keeps all documents
+// in the process memory. It doesn't need a RethinkDB server, but it can't run raw ReQL
+// queries, so methods that build terms on top of GetTable check for a nil session and
+// fall back to the RethinkCRUD methods (see isMemory).
+type Memory struct {
+	table   string
+	db      string
+	indexes map[string]Index
+
+	lock      sync.RWMutex
+	ids       []string
+	documents map[string]map[string]interface{}
+}
+
+// NewMemoryTable sets up a new empty Memory table with the passed secondary indexes
+func NewMemoryTable(db, table string, indexes ...Index) *Memory {
+	m := &Memory{
+		db:        db,
+		table:     table,
+		indexes:   map[string]Index{},
+		documents: map[string]map[string]interface{}{},
+	}
+
+	for _, index := range indexes {
+		m.indexes[index.Name] = index
+	}
+
+	return m
+}
+
+// GetTableName returns table's name
+func (m *Memory) GetTableName() string {
+	return m.table
+}
+
+// GetDBName returns database's name
+func (m *Memory) GetDBName() string {
+	return m.db
+}
+
+// GetTable returns the table as a gorethink.Term. Memory tables can't run it.
+func (m *Memory) GetTable() gorethink.Term {
+	return gorethink.Table(m.table)
+}
+
+// GetSession returns nil, as Memory tables are not connected to any server
+func (m *Memory) GetSession() *gorethink.Session {
+	return nil
+}
+
+// Insert inserts a document or a slice of documents into the table
+func (m *Memory) Insert(data interface{}) error {
+	value, err := toDocumentValue(data)
+	if err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	var documents []map[string]interface{}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		documents = []map[string]interface{}{v}
+	case []interface{}:
+		for _, item := range v {
+			document, ok := item.(map[string]interface{})
+			if !ok {
+				return NewDatabaseError(m, ErrInvalidDocument, "")
+			}
+			documents = append(documents, document)
+		}
+	default:
+		return NewDatabaseError(m, ErrInvalidDocument, "")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// Validate the primary keys before inserting anything
+	seen := map[string]struct{}{}
+	for _, document := range documents {
+		id, ok := document["id"].(string)
+		if !ok || id == "" {
+			id = uniuri.NewLen(uniuri.UUIDLen)
+			document["id"] = id
+		}
+
+		if _, ok := m.documents[id]; ok {
+			return NewDatabaseError(m, ErrDuplicatePrimaryKey, id)
+		}
+		if _, ok := seen[id]; ok {
+			return NewDatabaseError(m, ErrDuplicatePrimaryKey, id)
+		}
+		seen[id] = struct{}{}
+	}
+
+	for _, document := range documents {
+		id := document["id"].(string)
+		m.ids = append(m.ids, id)
+		m.documents[id] = document
+	}
+
+	return nil
+}
+
+// Update merges passed data into every document of the table
+func (m *Memory) Update(data interface{}) error {
+	changes, err := toDocument(data)
+	if err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	delete(changes, "id")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, id := range m.ids {
+		mergeDocument(m.documents[id], copyValue(changes).(map[string]interface{}))
+	}
+
+	return nil
+}
+
+// UpdateID merges passed data into the document with ID that equals the id argument
+func (m *Memory) UpdateID(id string, data interface{}) error {
+	changes, err := toDocument(data)
+	if err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	delete(changes, "id")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if document, ok := m.documents[id]; ok {
+		mergeDocument(document, changes)
+	}
+
+	return nil
+}
+
+// UpdateIDIf merges passed data into the document only if its fields equal
+// the values in cond. It returns false if the document doesn't match.
+func (m *Memory) UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error) {
+	filter, err := toDocument(cond)
+	if err != nil {
+		return false, NewDatabaseError(m, ErrUnsupportedFilter, "")
+	}
+
+	changes, err := toDocument(data)
+	if err != nil {
+		return false, NewDatabaseError(m, err, "")
+	}
+
+	delete(changes, "id")
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	document, ok := m.documents[id]
+	if !ok || !matchesFilter(document, filter) {
+		return false, nil
+	}
+
+	mergeDocument(document, changes)
+	return true, nil
+}
+
+// Delete deletes documents that match the passed filter, which is either a
+// map of field values or a *Filter
+func (m *Memory) Delete(pred interface{}) error {
+	filter, err := toFilter(pred)
+	if err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ids := m.ids[:0]
+	for _, id := range m.ids {
+		if filter.matches(m.documents[id]) {
+			delete(m.documents, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	m.ids = ids
+
+	return nil
+}
+
+// DeleteID deletes a document with specified ID
+func (m *Memory) DeleteID(id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.documents[id]; !ok {
+		return nil
+	}
+
+	delete(m.documents, id)
+	for i, v := range m.ids {
+		if v == id {
+			m.ids = append(m.ids[:i], m.ids[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// DeleteIDIf deletes the document only if its fields equal the values in cond.
+// It returns false if the document doesn't exist or doesn't match.
+func (m *Memory) DeleteIDIf(id string, cond map[string]interface{}) (bool, error) {
+	filter, err := toDocument(cond)
+	if err != nil {
+		return false, NewDatabaseError(m, ErrUnsupportedFilter, "")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	document, ok := m.documents[id]
+	if !ok || !matchesFilter(document, filter) {
+		return false, nil
+	}
+
+	delete(m.documents, id)
+	for i, v := range m.ids {
+		if v == id {
+			m.ids = append(m.ids[:i], m.ids[i+1:]...)
+			break
+		}
+	}
+
+	return true, nil
+}
+
+// Find is not supported by Memory tables, use FindFetchOne instead
+func (m *Memory) Find(id string) (*gorethink.Cursor, error) {
+	return nil, NewDatabaseError(m, ErrCursorUnsupported, "")
+}
+
+// FindFetchOne searches for a document and then unmarshals it into value
+func (m *Memory) FindFetchOne(id string, value interface{}) error {
+	m.lock.RLock()
+	document, ok := m.documents[id]
+	m.lock.RUnlock()
+
+	if !ok {
+		return NewDatabaseError(m, gorethink.ErrEmptyResult, "")
+	}
+
+	return m.decode(value, document)
+}
+
+// FindBy is not supported by Memory tables, use FindByAndFetch instead
+func (m *Memory) FindBy(key string, value interface{}) (*gorethink.Cursor, error) {
+	return nil, NewDatabaseError(m, ErrCursorUnsupported, "")
+}
+
+// FindByAndCount counts documents whose key field equals value
+func (m *Memory) FindByAndCount(key string, value interface{}) (int, error) {
+	documents, err := m.filter(map[string]interface{}{
+		key: value,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(documents), nil
+}
+
+// FindByAndFetch retrieves documents by key and then fills results with them
+func (m *Memory) FindByAndFetch(key string, value interface{}, results interface{}) error {
+	return m.WhereAndFetch(map[string]interface{}{
+		key: value,
+	}, results)
+}
+
+// FindByAndFetchOne retrieves documents by key and then fills result with the first one
+func (m *Memory) FindByAndFetchOne(key string, value interface{}, result interface{}) error {
+	return m.WhereAndFetchOne(map[string]interface{}{
+		key: value,
+	}, result)
+}
+
+// Where is not supported by Memory tables, use WhereAndFetch instead
+func (m *Memory) Where(filter map[string]interface{}) (*gorethink.Cursor, error) {
+	return nil, NewDatabaseError(m, ErrCursorUnsupported, "")
+}
+
+// WhereAndFetch filters with multiple fields and then fills results with all found documents
+func (m *Memory) WhereAndFetch(filter map[string]interface{}, results interface{}) error {
+	documents, err := m.filter(filter)
+	if err != nil {
+		return err
+	}
+
+	return m.decodeAll(results, documents)
+}
+
+// WhereAndFetchOne filters with multiple fields and then fills result with the first found document
+func (m *Memory) WhereAndFetchOne(filter map[string]interface{}, result interface{}) error {
+	documents, err := m.filter(filter)
+	if err != nil {
+		return err
+	}
+
+	if len(documents) == 0 {
+		return NewDatabaseError(m, gorethink.ErrEmptyResult, "")
+	}
+
+	return m.decode(result, documents[0])
+}
+
+// FindByIndex is not supported by Memory tables, use FindByIndexFetch instead
+func (m *Memory) FindByIndex(index string, values ...interface{}) (*gorethink.Cursor, error) {
+	return nil, NewDatabaseError(m, ErrCursorUnsupported, "")
+}
+
+// FindByIndexFetch filters all documents whose index is matching and fills results with them
+func (m *Memory) FindByIndexFetch(results interface{}, index string, values ...interface{}) error {
+	documents, err := m.getAllByIndex(index, values...)
+	if err != nil {
+		return err
+	}
+
+	return m.decodeAll(results, documents)
+}
+
+// FindByIndexFetchOne filters all documents whose index is matching and fills result with the first one
+func (m *Memory) FindByIndexFetchOne(result interface{}, index string, values ...interface{}) error {
+	documents, err := m.getAllByIndex(index, values...)
+	if err != nil {
+		return err
+	}
+
+	if len(documents) == 0 {
+		return NewDatabaseError(m, gorethink.ErrEmptyResult, "")
+	}
+
+	return m.decode(result, documents[0])
+}
+
+// filter returns copies of all documents matching the passed filter
+// fetchMatching fills results with documents selected by the filter
+func (m *Memory) fetchMatching(filter *Filter, results interface{}) error {
+	m.lock.RLock()
+	documents := []interface{}{}
+	for _, id := range m.ids {
+		if filter.matches(m.documents[id]) {
+			documents = append(documents, copyValue(m.documents[id]))
+		}
+	}
+	m.lock.RUnlock()
+
+	return m.decodeAll(results, documents)
+}
+
+func (m *Memory) filter(filter map[string]interface{}) ([]interface{}, error) {
+	normalized, err := toDocument(filter)
+	if err != nil {
+		return nil, NewDatabaseError(m, err, "")
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := []interface{}{}
+	for _, id := range m.ids {
+		if matchesFilter(m.documents[id], normalized) {
+			result = append(result, copyValue(m.documents[id]))
+		}
+	}
+
+	return result, nil
+}
+
+// getAllByIndex mimics GetAllByIndex - it returns copies of documents with matching index values
+func (m *Memory) getAllByIndex(name string, values ...interface{}) ([]interface{}, error) {
+	var index Index
+	if name == "id" {
+		index = simpleIndex("id")
+	} else {
+		var ok bool
+		index, ok = m.indexes[name]
+		if !ok {
+			return nil, NewDatabaseError(m, fmt.Errorf("Index `%s` was not found", name), "")
+		}
+	}
+
+	keys := make([]interface{}, len(values))
+	for i, value := range values {
+		key, err := toDocumentValue(value)
+		if err != nil {
+			return nil, NewDatabaseError(m, err, "")
+		}
+		keys[i] = key
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := []interface{}{}
+	for _, id := range m.ids {
+		document := m.documents[id]
+		for _, key := range indexKeys(index, document) {
+			found := false
+			for _, wanted := range keys {
+				if valuesEqual(key, wanted) {
+					found = true
+					break
+				}
+			}
+
+			if found {
+				result = append(result, copyValue(document))
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// decode unmarshals a single document into value
+func (m *Memory) decode(value interface{}, document interface{}) error {
+	if err := encoding.Decode(value, copyValue(document)); err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	return nil
+}
+
+// decodeAll unmarshals a slice of documents into results
+func (m *Memory) decodeAll(results interface{}, documents []interface{}) error {
+	if err := encoding.Decode(results, documents); err != nil {
+		return NewDatabaseError(m, err, "")
+	}
+
+	return nil
+}
+
+// indexKeys returns the values under which a document is indexed
+func indexKeys(index Index, document map[string]interface{}) []interface{} {
+	if len(index.Fields) == 1 {
+		value, ok := document[index.Fields[0]]
+		if !ok {
+			return nil
+		}
+
+		if index.Multi {
+			if items, ok := value.([]interface{}); ok {
+				return items
+			}
+		}
+
+		return []interface{}{value}
+	}
+
+	key := make([]interface{}, len(index.Fields))
+	for i, field := range index.Fields {
+		value, ok := document[field]
+		if !ok {
+			return nil
+		}
+		key[i] = value
+	}
+
+	return []interface{}{key}
+}
+
+// toDocument encodes data into a document
+func toDocument(data interface{}) (map[string]interface{}, error) {
+	value, err := toDocumentValue(data)
+	if err != nil {
+		return nil, err
+	}
+
+	document, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDocument
+	}
+
+	return document, nil
+}
+
+// toDocumentValue encodes data the same way the driver does and then turns it into
+// the representation returned by RethinkDB, with pseudotypes converted back
+func toDocumentValue(data interface{}) (interface{}, error) {
+	encoded, err := encoding.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeValue(encoded)
+}
+
+// normalizeValue copies the passed value, converting all numbers to float64 and
+// TIME and BINARY pseudotypes into native values
+func normalizeValue(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case time.Time, string, bool, []byte:
+		return v, nil
+	case map[string]interface{}:
+		if reqlType, ok := v["$reql_type$"]; ok {
+			return convertPseudotype(reqlType, v)
+		}
+
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = normalized
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized, err := normalizeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = normalized
+		}
+		return result, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Slice, reflect.Array:
+		result := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			normalized, err := normalizeValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[i] = normalized
+		}
+		return result, nil
+	case reflect.Map:
+		result := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			normalized, err := normalizeValue(rv.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[fmt.Sprint(key.Interface())] = normalized
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported value type %T", value)
+}
+
+// convertPseudotype turns a RethinkDB pseudotype into a native value
+func convertPseudotype(reqlType interface{}, value map[string]interface{}) (interface{}, error) {
+	switch reqlType {
+	case "TIME":
+		epoch, ok := value["epoch_time"].(float64)
+		if !ok {
+			return nil, errors.New("Invalid TIME pseudotype")
+		}
+
+		sec, frac := math.Modf(epoch)
+		t := time.Unix(int64(sec), int64(frac*float64(time.Second)))
+
+		if timezone, ok := value["timezone"].(string); ok && len(timezone) == 6 {
+			hours, err1 := strconv.Atoi(timezone[1:3])
+			minutes, err2 := strconv.Atoi(timezone[4:6])
+			if err1 == nil && err2 == nil {
+				offset := hours*3600 + minutes*60
+				if strings.HasPrefix(timezone, "-") {
+					offset = -offset
+				}
+				if offset == 0 {
+					t = t.UTC()
+				} else {
+					t = t.In(time.FixedZone(timezone, offset))
+				}
+			}
+		}
+
+		return t, nil
+	case "BINARY":
+		data, ok := value["data"].(string)
+		if !ok {
+			return nil, errors.New("Invalid BINARY pseudotype")
+		}
+
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	return nil, fmt.Errorf("Unsupported pseudotype %v", reqlType)
+}
+
+// copyValue deep copies a normalized value
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = copyValue(item)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = copyValue(item)
+		}
+		return result
+	case []byte:
+		return append([]byte(nil), v...)
+	}
+
+	return value
+}
+
+// mergeDocument recursively merges changes into document, like RethinkDB's update does
+func mergeDocument(document, changes map[string]interface{}) {
+	for key, value := range changes {
+		if nested, ok := value.(map[string]interface{}); ok {
+			if existing, ok := document[key].(map[string]interface{}); ok {
+				mergeDocument(existing, nested)
+				continue
+			}
+		}
+
+		document[key] = value
+	}
+}
+
+// matchesFilter checks whether the document contains all fields of the filter
+func matchesFilter(document, filter map[string]interface{}) bool {
+	for key, expected := range filter {
+		value, ok := document[key]
+		if !ok {
+			return false
+		}
+
+		if nested, ok := expected.(map[string]interface{}); ok {
+			existing, ok := value.(map[string]interface{})
+			if !ok || !matchesFilter(existing, nested) {
+				return false
+			}
+			continue
+		}
+
+		if !valuesEqual(value, expected) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// valuesEqual compares two normalized values
+func valuesEqual(a, b interface{}) bool {
+	switch av := a.(type) {
+	case time.Time:
+		bv, ok := b.(time.Time)
+		return ok && av.Equal(bv)
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for key, item := range av {
+			other, ok := bv[key]
+			if !ok || !valuesEqual(item, other) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !valuesEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	case []byte:
+		bv, ok := b.([]byte)
+		return ok && string(av) == string(bv)
+	}
+
+	switch b.(type) {
+	case time.Time, map[string]interface{}, []interface{}, []byte:
+		return false
+	}
+
+	return a == b
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/cache"
+	"github.com/lavab/api/models"
+)
+
+func newTestThread(owner string, modified time.Time, labels ...string) *models.Thread {
+	thread := &models.Thread{
+		Resource: models.MakeResource(owner, "thread"),
+		Labels:   labels,
+	}
+	thread.DateModified = modified
+
+	return thread
+}
+
+func TestMemoryCRUD(t *testing.T) {
+	table := NewMemoryTable("test", "threads", TableIndexes["threads"]...)
+
+	thread := newTestThread("alice", time.Now(), "inbox", "starred")
+	if err := table.Insert(thread); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Insert(thread); err == nil {
+		t.Fatal("inserting a duplicate ID should fail")
+	}
+
+	var fetched models.Thread
+	if err := table.FindFetchOne(thread.ID, &fetched); err != nil {
+		t.Fatal(err)
+	}
+	if fetched.Owner != "alice" || len(fetched.Labels) != 2 {
+		t.Fatalf("fetched a different thread: %+v", fetched)
+	}
+	if diff := fetched.DateModified.Sub(thread.DateModified); diff > time.Millisecond || diff < -time.Millisecond {
+		t.Fatalf("date_modified changed from %s to %s", thread.DateModified, fetched.DateModified)
+	}
+
+	// Changes of the fetched value must not leak into the table
+	fetched.Labels[0] = "spam"
+
+	var byLabel []*models.Thread
+	if err := table.FindByIndexFetch(&byLabel, "labels", "inbox"); err != nil {
+		t.Fatal(err)
+	}
+	if len(byLabel) != 1 {
+		t.Fatalf("expected 1 thread in the multi index, got %d", len(byLabel))
+	}
+
+	if err := table.UpdateID(thread.ID, map[string]interface{}{
+		"is_read": true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := table.FindByAndCount("is_read", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 read thread, got %d", count)
+	}
+
+	if err := table.Delete(map[string]interface{}{"owner": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.FindFetchOne(thread.ID, &fetched); err == nil {
+		t.Fatal("deleted thread was found")
+	}
+
+	if err := table.Delete(gorethink.Row.Field("owner").Eq("alice")); err == nil {
+		t.Fatal("terms should not be accepted as filters")
+	}
+
+	if _, err := table.Find(thread.ID); err == nil {
+		t.Fatal("cursors should not be supported")
+	}
+}
+
+func TestMemoryCompoundIndex(t *testing.T) {
+	table := NewMemoryTable("test", "threads", TableIndexes["threads"]...)
+
+	thread := newTestThread("alice", time.Now())
+	thread.SubjectHash = "hash"
+	if err := table.Insert(thread); err != nil {
+		t.Fatal(err)
+	}
+
+	threads := &ThreadsTable{RethinkCRUD: table}
+	found, err := threads.GetBySubjectHash("alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != thread.ID {
+		t.Fatalf("found a different thread %s", found.ID)
+	}
+
+	if _, err := threads.GetBySubjectHash("bob", "hash"); err == nil {
+		t.Fatal("found a thread of another owner")
+	}
+}
+
+func TestMemoryPagination(t *testing.T) {
+	table := &ThreadsTable{
+		RethinkCRUD: NewMemoryTable("test", "threads", TableIndexes["threads"]...),
+		Emails: &EmailsTable{
+			RethinkCRUD: NewMemoryTable("test", "emails", TableIndexes["emails"]...),
+		},
+	}
+
+	// Five threads in the inbox, newest first, and one archived thread
+	start := time.Now().Add(-time.Hour)
+	var ids []string
+	for i := 5; i > 0; i-- {
+		thread := newTestThread("alice", start.Add(time.Duration(i)*time.Minute), "inbox")
+		if err := table.Insert(thread); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, thread.ID)
+	}
+	if err := table.Insert(newTestThread("alice", start, "archive")); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(newTestThread("bob", start, "inbox")); err != nil {
+		t.Fatal(err)
+	}
+
+	first, info, err := table.List("alice", []string{"inbox"}, &Page{Limit: 2, Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].ID != ids[0] || first[1].ID != ids[1] {
+		t.Fatalf("unexpected first page %v", first)
+	}
+	if info.Total != 5 || info.Next == "" || info.Prev != "" {
+		t.Fatalf("unexpected first page info %+v", info)
+	}
+
+	cursor, err := DecodeCursor(info.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, info, err := table.List("alice", []string{"inbox"}, &Page{Cursor: cursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 2 || second[0].ID != ids[2] || second[1].ID != ids[3] {
+		t.Fatalf("unexpected second page %v", second)
+	}
+	if info.Next == "" || info.Prev == "" || info.Total >= 0 {
+		t.Fatalf("unexpected second page info %+v", info)
+	}
+
+	cursor, err = DecodeCursor(info.Prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, info, err := table.List("alice", []string{"inbox"}, &Page{Cursor: cursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous) != 2 || previous[0].ID != ids[0] || previous[1].ID != ids[1] {
+		t.Fatalf("unexpected previous page %v", previous)
+	}
+	if info.Prev != "" || info.Next == "" {
+		t.Fatalf("unexpected previous page info %+v", info)
+	}
+
+	excluded, info, err := table.List("alice", []string{"-inbox"}, &Page{Count: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(excluded) != 1 || info.Total != 1 {
+		t.Fatalf("expected only the archived thread, got %v", excluded)
+	}
+}
+
+func TestMemoryChangeLog(t *testing.T) {
+	changes := &ChangesTable{
+		RethinkCRUD: NewMemoryTable("test", "changes", TableIndexes["changes"]...),
+		Sequences: &SequencesTable{
+			RethinkCRUD: NewMemoryTable("test", "sequences"),
+		},
+	}
+	table := NewChangeLog(NewMemoryTable("test", "contacts", TableIndexes["contacts"]...), changes)
+
+	contact := &models.Contact{
+		Resource: models.MakeResource("alice", "contact"),
+	}
+	if err := table.Insert(contact); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.UpdateID(contact.ID, map[string]interface{}{"name": "renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Delete(map[string]interface{}{"owner": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := changes.ListSince("alice", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := []string{models.ChangeCreated, models.ChangeUpdated, models.ChangeDeleted}
+	if len(list) != len(kinds) {
+		t.Fatalf("expected %d changes, got %d", len(kinds), len(list))
+	}
+	for i, change := range list {
+		if change.Kind != kinds[i] || change.Resource != contact.ID || change.Sequence != int64(i+1) {
+			t.Fatalf("unexpected change %d: %+v", i, change)
+		}
+	}
+
+	if current, err := changes.Sequences.Current("alice"); err != nil || current != 3 {
+		t.Fatalf("expected the sequence 3, got %d (%v)", current, err)
+	}
+
+	rest, err := changes.ListSince("alice", list[0].Sequence, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 {
+		t.Fatalf("expected 2 changes after the first one, got %d", len(rest))
+	}
+
+	if err := changes.DeleteOlderThan(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := changes.ListSince("alice", 0, 10); err != nil || len(list) != 0 {
+		t.Fatalf("old changes were not removed: %v (%v)", list, err)
+	}
+}
+
+func TestMemoryTokensDelete(t *testing.T) {
+	tokens := &TokensTable{
+		RethinkCRUD: NewMemoryTable("test", "tokens", TableIndexes["tokens"]...),
+		Cache:       cache.NewMemoryCache(),
+		Expires:     time.Hour,
+	}
+
+	token := &models.Token{
+		Resource: models.MakeResource("alice", ""),
+		Type:     "auth",
+	}
+	if err := tokens.Insert(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tokens.Delete(map[string]interface{}{"owner": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tokens.GetToken(token.ID); err == nil {
+		t.Fatal("deleted token is still cached")
+	}
+}
+
+func TestMemorySearch(t *testing.T) {
+	accounts := &AccountsTable{
+		RethinkCRUD: NewMemoryTable("test", "accounts", TableIndexes["accounts"]...),
+	}
+
+	for i, name := range []string{"alice", "bob", "alicia"} {
+		account := &models.Account{
+			Resource: models.MakeResource("", name),
+			Status:   models.StatusActive,
+		}
+		account.DateCreated = account.DateCreated.Add(time.Duration(i) * time.Second)
+		if err := accounts.Insert(account); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, total, err := accounts.Search(&AccountsQuery{
+		Query: "ALI",
+		Limit: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(result) != 1 || result[0].Name != "alicia" {
+		t.Fatalf("unexpected search result %v (total %d)", result, total)
+	}
+}
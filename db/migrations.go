@@ -0,0 +1,760 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	r "github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/models"
+)
+
+// MigrationsTable is the name of the table that records applied migrations
+const MigrationsTable = "_migrations"
+
+// Migration is a single named change of the database schema or of the stored documents.
+// Migrations must be idempotent, so that a migration interrupted by a crash can be rerun.
+type Migration struct {
+	Name string
+	Up   func(session *r.Session, database string) error
+}
+
+// Migrations contains all migrations in the order of applying them.
+// Never reorder or remove existing entries, only append new ones.
+var Migrations = []Migration{
+	{
+		// The schema previously created by db.Setup. The indexes are listed here
+		// instead of using TableIndexes, so that the migration doesn't change.
+		Name: "0001_initial_schema",
+		Up: func(session *r.Session, database string) error {
+			tables := map[string][]Index{
+				"accounts": {
+					simpleIndex("name"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+					simpleIndex("alt_email"),
+					simpleIndex("type"),
+					simpleIndex("status"),
+				},
+				"addresses": {
+					simpleIndex("owner"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+				},
+				"contacts": {
+					simpleIndex("owner"),
+					simpleIndex("name"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+				},
+				"emails": {
+					simpleIndex("owner"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+					simpleIndex("thread"),
+					simpleIndex("kind"),
+					simpleIndex("from"),
+					simpleIndex("message_id"),
+					multiIndex("to"),
+					multiIndex("cc"),
+					multiIndex("bcc"),
+					compoundIndex("messageIDOwner", "message_id", "owner"),
+					compoundIndex("threadStatus", "thread", "status"),
+				},
+				"files": {
+					simpleIndex("owner"),
+					simpleIndex("name"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+				},
+				"keys": {
+					simpleIndex("owner"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+					simpleIndex("key_id"),
+				},
+				"labels": {
+					simpleIndex("name"),
+					simpleIndex("builtin"),
+					simpleIndex("owner"),
+					compoundIndex("nameOwnerBuiltin", "name", "owner", "builtin"),
+				},
+				"threads": {
+					simpleIndex("name"),
+					simpleIndex("owner"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+					multiIndex("emails"),
+					multiIndex("labels"),
+					multiIndex("members"),
+					simpleIndex("subject_hash"),
+					simpleIndex("secure"),
+					compoundIndex("subjectOwner", "subject_hash", "owner"),
+				},
+				"tokens": {
+					simpleIndex("name"),
+					simpleIndex("owner"),
+					simpleIndex("date_created"),
+					simpleIndex("date_modified"),
+					simpleIndex("type"),
+					simpleIndex("expiry_date"),
+				},
+				"webhooks": {
+					simpleIndex("target"),
+					simpleIndex("type"),
+					compoundIndex("targetType", "target", "type"),
+				},
+			}
+
+			for _, table := range []string{
+				"accounts", "addresses", "contacts", "emails", "files",
+				"keys", "labels", "threads", "tokens", "webhooks",
+			} {
+				if err := ensureTableWithIndexes(session, database, table, tables[table]...); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		// ThreadsTable.List depends on this index, but it was never created
+		Name: "0002_emails_thread_and_date_index",
+		Up: func(session *r.Session, database string) error {
+			return EnsureIndex(session, database, "emails", compoundIndex("threadAndDate", "thread", "date_created"))
+		},
+	},
+	{
+		// Cursor-based pagination of the lists
+		Name: "0003_owner_modified_indexes",
+		Up: func(session *r.Session, database string) error {
+			for _, table := range []string{"addresses", "contacts", "emails", "files", "threads"} {
+				if err := EnsureIndex(session, database, table, compoundIndex("ownerModified", "owner", "date_modified", "id")); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		// Change log used by delta sync
+		Name: "0004_changes_table",
+		Up: func(session *r.Session, database string) error {
+			return ensureTableWithIndexes(session, database, "changes",
+				simpleIndex("owner"),
+				compoundIndex("ownerSequence", "owner", "sequence"),
+			)
+		},
+	},
+	{
+		// Background jobs, e.g. account deletion
+		Name: "0005_jobs_table",
+		Up: func(session *r.Session, database string) error {
+			return ensureTableWithIndexes(session, database, "jobs",
+				simpleIndex("owner"),
+				simpleIndex("type"),
+			)
+		},
+	},
+	{
+		// Webhook subscriptions and their delivery log
+		Name: "0006_webhooks",
+		Up: func(session *r.Session, database string) error {
+			if err := ensureTableWithIndexes(session, database, "webhooks",
+				simpleIndex("owner"),
+				simpleIndex("target"),
+				simpleIndex("type"),
+				compoundIndex("targetType", "target", "type"),
+			); err != nil {
+				return err
+			}
+
+			return ensureTableWithIndexes(session, database, "webhook_deliveries",
+				simpleIndex("owner"),
+				simpleIndex("webhook"),
+			)
+		},
+	},
+	{
+		// Username reservations, which were never part of the schema
+		Name: "0007_reservations_table",
+		Up: func(session *r.Session, database string) error {
+			return ensureTableWithIndexes(session, database, "reservations",
+				simpleIndex("name"),
+				simpleIndex("email"),
+				simpleIndex("expiry_date"),
+			)
+		},
+	},
+	{
+		// Invite trees of user-generated invitations
+		Name: "0008_accounts_invited_by_index",
+		Up: func(session *r.Session, database string) error {
+			return EnsureIndex(session, database, "accounts", simpleIndex("invited_by"))
+		},
+	},
+	{
+		// OAuth clients, their codes and tokens are stored in the tokens table
+		Name: "0009_oauth",
+		Up: func(session *r.Session, database string) error {
+			if err := ensureTableWithIndexes(session, database, "oauth_clients", simpleIndex("owner")); err != nil {
+				return err
+			}
+
+			return EnsureIndex(session, database, "tokens", simpleIndex("client"))
+		},
+	},
+	{
+		// The single factor of each account becomes the first one of its list
+		Name: "0010_account_factors",
+		Up: func(session *r.Session, database string) error {
+			return r.DB(database).Table("accounts").Filter(
+				r.Row.Field("factor_type").Default("").Ne(""),
+			).Replace(func(row r.Term) interface{} {
+				return row.Without("factor_type", "factor_value").Merge(map[string]interface{}{
+					"factors": []interface{}{map[string]interface{}{
+						"id":           "legacy",
+						"type":         row.Field("factor_type"),
+						"name":         row.Field("factor_type"),
+						"value":        row.Field("factor_value").Default([]interface{}{}),
+						"date_created": r.Now(),
+					}},
+				})
+			}).Exec(session)
+		},
+	},
+	{
+		// Security audit log of accounts
+		Name: "0011_audit_events",
+		Up: func(session *r.Session, database string) error {
+			return ensureTableWithIndexes(session, database, "audit_events",
+				simpleIndex("owner"),
+				compoundIndex("ownerModified", "owner", "date_modified", "id"),
+			)
+		},
+	},
+	{
+		// Account statuses follow the lifecycle in models/account_status.go
+		Name: "0012_account_statuses",
+		Up: func(session *r.Session, database string) error {
+			accounts := r.DB(database).Table("accounts")
+
+			if err := accounts.Filter(
+				r.Row.Field("status").Default("").Eq(""),
+			).Update(map[string]interface{}{
+				"status": "active",
+			}).Exec(session); err != nil {
+				return err
+			}
+
+			// Approved registrations have an unused verification token
+			if err := accounts.Filter(func(row r.Term) interface{} {
+				return row.Field("status").Eq("registered").And(
+					r.DB(database).Table("tokens").GetAllByIndex("owner", row.Field("id")).Filter(map[string]interface{}{
+						"type": "verify",
+					}).Count().Gt(0),
+				)
+			}).Update(map[string]interface{}{
+				"status": "invited",
+			}).Exec(session); err != nil {
+				return err
+			}
+
+			// Previous statuses of suspended accounts move to the history
+			return accounts.Filter(
+				r.Row.Field("suspension").Field("previous_status").Default("").Ne(""),
+			).Replace(func(row r.Term) interface{} {
+				return row.Without(map[string]interface{}{
+					"suspension": map[string]interface{}{"previous_status": true},
+				}).Merge(map[string]interface{}{
+					"status_history": []interface{}{map[string]interface{}{
+						"from":   row.Field("suspension").Field("previous_status"),
+						"to":     "suspended",
+						"reason": row.Field("suspension").Field("reason"),
+						"date":   row.Field("suspension").Field("date"),
+					}},
+				})
+			}).Exec(session)
+		},
+	},
+	{
+		// Subscriptions to paid plans and their invoices
+		Name: "0013_billing",
+		Up: func(session *r.Session, database string) error {
+			if err := ensureTableWithIndexes(session, database, "subscriptions",
+				simpleIndex("owner"),
+				simpleIndex("provider_id"),
+			); err != nil {
+				return err
+			}
+
+			return ensureTableWithIndexes(session, database, "invoices",
+				simpleIndex("owner"),
+				simpleIndex("provider_id"),
+				compoundIndex("ownerModified", "owner", "date_modified", "id"),
+			)
+		},
+	},
+	{
+		// Change log sequences come from a counter per account instead of the
+		// clocks of API instances. Entries with the old timestamp sequences are
+		// removed, sync tokens issued before are rejected anyway.
+		Name: "0014_change_sequences",
+		Up: func(session *r.Session, database string) error {
+			if err := EnsureTable(session, database, "sequences"); err != nil {
+				return err
+			}
+
+			if err := EnsureIndex(session, database, "changes", simpleIndex("date_created")); err != nil {
+				return err
+			}
+
+			return r.DB(database).Table("changes").Filter(
+				r.Row.Field("sequence").Gt(1e15),
+			).Delete().Exec(session)
+		},
+	},
+	{
+		// Data exports are stored in the database, so that every instance can serve them
+		Name: "0015_blobs_table",
+		Up: func(session *r.Session, database string) error {
+			return ensureTableWithIndexes(session, database, "blobs",
+				simpleIndex("owner"),
+				simpleIndex("blob"),
+				simpleIndex("expiry_date"),
+			)
+		},
+	},
+	{
+		// Authenticator factors created before TOTP support store secrets of the
+		// old HOTP implementation, which can't be verified. They are removed, the
+		// removal is recorded in the audit log and the owners are asked to set up
+		// 2FA again after their next login. A scheduled job emails the verified
+		// alt emails. The event and the job use IDs derived from the account, so
+		// a rerun doesn't insert them twice.
+		Name: "0016_legacy_authenticators",
+		Up: func(session *r.Session, database string) error {
+			isLegacy := func(factor r.Term) interface{} {
+				return factor.Field("id").Eq("legacy").And(factor.Field("type").Eq("authenticator"))
+			}
+
+			cursor, err := r.DB(database).Table("accounts").Filter(func(row r.Term) interface{} {
+				return row.Field("factors").Default([]interface{}{}).Filter(isLegacy).Count().Gt(0)
+			}).Pluck("id").Run(session)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close()
+
+			var accounts []*models.Account
+			if err := cursor.All(&accounts); err != nil {
+				return err
+			}
+
+			now := time.Now()
+			for _, account := range accounts {
+				event := &models.AuditEvent{
+					Resource: models.Resource{
+						ID:           "legacy_authenticators_" + account.ID,
+						Owner:        account.ID,
+						DateCreated:  now,
+						DateModified: now,
+					},
+					Type: models.AuditFactorRemoved,
+					Details: map[string]interface{}{
+						"factor": "legacy",
+						"type":   "authenticator",
+						"reason": "unsupported",
+					},
+				}
+				if err := r.DB(database).Table("audit_events").Insert(event).Exec(session); err != nil {
+					return err
+				}
+
+				job := &models.Job{
+					Resource: models.Resource{
+						ID:           "legacy_authenticators_" + account.ID,
+						Owner:        account.ID,
+						DateCreated:  now,
+						DateModified: now,
+					},
+					Type:          models.JobFactorReenrollment,
+					Status:        models.JobQueued,
+					DateScheduled: now,
+					Steps:         []string{},
+					Completed:     []string{},
+				}
+				if err := r.DB(database).Table("jobs").Insert(job).Exec(session); err != nil {
+					return err
+				}
+
+				if err := r.DB(database).Table("accounts").Get(account.ID).Update(func(row r.Term) interface{} {
+					return map[string]interface{}{
+						"factors": row.Field("factors").Filter(func(factor r.Term) interface{} {
+							return r.Not(isLegacy(factor))
+						}),
+						"factor_reenrollment_required": true,
+					}
+				}).Exec(session); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		// Scheduled jobs are looked up by their status
+		Name: "0017_jobs_status_index",
+		Up: func(session *r.Session, database string) error {
+			return EnsureIndex(session, database, "jobs", simpleIndex("status"))
+		},
+	},
+}
+
+// MigrationRecord is stored in the migrations table after a successful migration
+type MigrationRecord struct {
+	ID          string    `gorethink:"id"`
+	DateApplied time.Time `gorethink:"date_applied"`
+}
+
+// SchemaStatus describes the difference between the database and the schema expected by the API
+type SchemaStatus struct {
+	Applied        []string
+	Pending        []string
+	MissingTables  []string
+	MissingIndexes []string
+	ExtraIndexes   []string
+
+	// ChangedIndexes exist, but their fields differ from TableIndexes
+	ChangedIndexes []string
+}
+
+// UpToDate returns true if the database can be used by the API. Extra indexes are allowed.
+func (s *SchemaStatus) UpToDate() bool {
+	return len(s.Pending) == 0 && len(s.MissingTables) == 0 && len(s.MissingIndexes) == 0 &&
+		len(s.ChangedIndexes) == 0
+}
+
+// Migrate applies all pending migrations in order and returns the names of applied ones
+func Migrate(session *r.Session, database string) ([]string, error) {
+	if err := ensureDatabase(session, database); err != nil {
+		return nil, err
+	}
+
+	if err := EnsureTable(session, database, MigrationsTable); err != nil {
+		return nil, err
+	}
+
+	done, err := appliedMigrations(session, database)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, migration := range Migrations {
+		if _, ok := done[migration.Name]; ok {
+			continue
+		}
+
+		if err := migration.Up(session, database); err != nil {
+			return applied, fmt.Errorf("migration %s failed: %s", migration.Name, err)
+		}
+
+		if err := r.DB(database).Table(MigrationsTable).Insert(&MigrationRecord{
+			ID:          migration.Name,
+			DateApplied: time.Now(),
+		}).Exec(session); err != nil {
+			return applied, fmt.Errorf("unable to record migration %s: %s", migration.Name, err)
+		}
+
+		applied = append(applied, migration.Name)
+	}
+
+	return applied, nil
+}
+
+// GetSchemaStatus compares the database with Migrations and TableIndexes
+func GetSchemaStatus(session *r.Session, database string) (*SchemaStatus, error) {
+	status := &SchemaStatus{}
+
+	databases, err := listStrings(session, r.DBList())
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing was created yet
+	if !containsString(databases, database) {
+		for _, migration := range Migrations {
+			status.Pending = append(status.Pending, migration.Name)
+		}
+		status.MissingTables = tableNames()
+		return status, nil
+	}
+
+	tables, err := listStrings(session, r.DB(database).TableList())
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[string]struct{}{}
+	if containsString(tables, MigrationsTable) {
+		done, err = appliedMigrations(session, database)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, migration := range Migrations {
+		if _, ok := done[migration.Name]; ok {
+			status.Applied = append(status.Applied, migration.Name)
+		} else {
+			status.Pending = append(status.Pending, migration.Name)
+		}
+	}
+
+	for _, table := range tableNames() {
+		if !containsString(tables, table) {
+			status.MissingTables = append(status.MissingTables, table)
+			continue
+		}
+
+		indexes, err := listIndexes(session, database, table)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := map[string]struct{}{}
+		for _, index := range TableIndexes[table] {
+			expected[index.Name] = struct{}{}
+
+			existing, ok := indexes[index.Name]
+			if !ok {
+				status.MissingIndexes = append(status.MissingIndexes, table+"."+index.Name)
+			} else if !existing.matches(index) {
+				status.ChangedIndexes = append(status.ChangedIndexes, table+"."+index.Name)
+			}
+		}
+
+		for name := range indexes {
+			if _, ok := expected[name]; !ok {
+				status.ExtraIndexes = append(status.ExtraIndexes, table+"."+name)
+			}
+		}
+		sort.Strings(status.ExtraIndexes)
+	}
+
+	return status, nil
+}
+
+// EnsureTable creates a table if it doesn't exist yet
+func EnsureTable(session *r.Session, database, table string) error {
+	tables, err := listStrings(session, r.DB(database).TableList())
+	if err != nil {
+		return err
+	}
+
+	if containsString(tables, table) {
+		return nil
+	}
+
+	if err := r.DB(database).TableCreate(table).Exec(session); err != nil {
+		return fmt.Errorf("unable to create table %s: %s", table, err)
+	}
+
+	return nil
+}
+
+// EnsureIndex creates a secondary index if it doesn't exist yet and waits until it's ready.
+// An existing index with the same name has to be defined using the same fields.
+func EnsureIndex(session *r.Session, database, table string, index Index) error {
+	indexes, err := listIndexes(session, database, table)
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := indexes[index.Name]; ok {
+		if !existing.matches(index) {
+			return fmt.Errorf("index %s.%s exists, but it's not defined using %v", table, index.Name, index.Fields)
+		}
+
+		return nil
+	}
+
+	var term r.Term
+	if len(index.Fields) == 1 && index.Fields[0] == index.Name {
+		term = r.DB(database).Table(table).IndexCreate(index.Name, r.IndexCreateOpts{
+			Multi: index.Multi,
+		})
+	} else {
+		term = r.DB(database).Table(table).IndexCreateFunc(index.Name, index.Term, r.IndexCreateOpts{
+			Multi: index.Multi,
+		})
+	}
+
+	if err := term.Exec(session); err != nil {
+		return fmt.Errorf("unable to create index %s.%s: %s", table, index.Name, err)
+	}
+
+	return r.DB(database).Table(table).IndexWait(index.Name).Exec(session)
+}
+
+// ensureTableWithIndexes creates a table and the passed indexes
+func ensureTableWithIndexes(session *r.Session, database, table string, indexes ...Index) error {
+	if err := EnsureTable(session, database, table); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		if err := EnsureIndex(session, database, table, index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureDatabase creates the database if it doesn't exist yet
+func ensureDatabase(session *r.Session, database string) error {
+	databases, err := listStrings(session, r.DBList())
+	if err != nil {
+		return err
+	}
+
+	if containsString(databases, database) {
+		return nil
+	}
+
+	if err := r.DBCreate(database).Exec(session); err != nil {
+		return fmt.Errorf("unable to create database %s: %s", database, err)
+	}
+
+	return nil
+}
+
+// appliedMigrations returns a set of names of already applied migrations
+func appliedMigrations(session *r.Session, database string) (map[string]struct{}, error) {
+	cursor, err := r.DB(database).Table(MigrationsTable).Run(session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var records []*MigrationRecord
+	if err := cursor.All(&records); err != nil {
+		return nil, err
+	}
+
+	result := map[string]struct{}{}
+	for _, record := range records {
+		result[record.ID] = struct{}{}
+	}
+
+	return result, nil
+}
+
+// indexStatus is the part of the index_status result describing an index
+type indexStatus struct {
+	Index string `gorethink:"index"`
+	Multi bool   `gorethink:"multi"`
+	Query string `gorethink:"query"`
+}
+
+// indexFieldPattern matches fields of rows in printed index functions, e.g.
+// `var1("owner")` or `r.row("owner")`
+var indexFieldPattern = regexp.MustCompile(`\("([^"]*)"\)`)
+
+// matches checks whether the index was created using the fields of index.
+// Servers that don't print index functions are trusted to match.
+func (s *indexStatus) matches(index Index) bool {
+	if s.Multi != index.Multi {
+		return false
+	}
+
+	if s.Query == "" {
+		return true
+	}
+
+	// Skip the name in indexCreate('name', function(var1) { ... })
+	query := s.Query
+	if i := strings.Index(query, "function"); i >= 0 {
+		query = query[i:]
+	}
+
+	var fields []string
+	for _, match := range indexFieldPattern.FindAllStringSubmatch(query, -1) {
+		fields = append(fields, match[1])
+	}
+
+	if len(fields) != len(index.Fields) {
+		return false
+	}
+	for i, field := range fields {
+		if field != index.Fields[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// listIndexes returns the indexes of a table by their names
+func listIndexes(session *r.Session, database, table string) (map[string]*indexStatus, error) {
+	cursor, err := r.DB(database).Table(table).IndexStatus().Run(session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var statuses []*indexStatus
+	if err := cursor.All(&statuses); err != nil {
+		return nil, err
+	}
+
+	result := map[string]*indexStatus{}
+	for _, status := range statuses {
+		result[status.Index] = status
+	}
+
+	return result, nil
+}
+
+// listStrings runs a term returning a list of names and fetches the result
+func listStrings(session *r.Session, term r.Term) ([]string, error) {
+	cursor, err := term.Run(session)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var result []string
+	if err := cursor.All(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// tableNames returns sorted names of tables declared in TableIndexes
+func tableNames() []string {
+	names := make([]string, 0, len(TableIndexes))
+	for name := range TableIndexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
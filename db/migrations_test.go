@@ -0,0 +1,55 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestIndexStatusMatches(t *testing.T) {
+	cases := []struct {
+		status  indexStatus
+		index   Index
+		matches bool
+	}{
+		{
+			indexStatus{Index: "owner", Query: `indexCreate('owner', function(var1) { return var1("owner"); })`},
+			simpleIndex("owner"),
+			true,
+		},
+		{
+			indexStatus{Index: "labels", Multi: true, Query: `indexCreate('labels', function(var1) { return var1("labels"); }, {multi: true})`},
+			multiIndex("labels"),
+			true,
+		},
+		{
+			indexStatus{Index: "labels", Query: `indexCreate('labels', function(var1) { return var1("labels"); })`},
+			multiIndex("labels"),
+			false,
+		},
+		{
+			indexStatus{Index: "threadAndDate", Query: `indexCreate('threadAndDate', function(var1) { return [var1("thread"), var1("date_created")]; })`},
+			compoundIndex("threadAndDate", "thread", "date_created"),
+			true,
+		},
+		{
+			indexStatus{Index: "threadAndDate", Query: `indexCreate('threadAndDate', function(var1) { return [var1("thread"), var1("date_modified")]; })`},
+			compoundIndex("threadAndDate", "thread", "date_created"),
+			false,
+		},
+		{
+			indexStatus{Index: "name", Query: `indexCreate('name', function(var1) { return r.row("title"); })`},
+			simpleIndex("name"),
+			false,
+		},
+		{
+			indexStatus{Index: "owner"},
+			simpleIndex("owner"),
+			true,
+		},
+	}
+
+	for i, c := range cases {
+		if result := c.status.matches(c.index); result != c.matches {
+			t.Errorf("case %d: expected %v, got %v", i, c.matches, result)
+		}
+	}
+}
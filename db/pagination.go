@@ -0,0 +1,301 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dancannon/gorethink"
+	"github.com/dancannon/gorethink/encoding"
+)
+
+const (
+	// DefaultPageLimit is used when the client doesn't specify a limit
+	DefaultPageLimit = 50
+	// MaxPageLimit is the biggest page a client can request
+	MaxPageLimit = 500
+
+	// paginationIndex is the compound [owner, date_modified, id] index used by all lists
+	paginationIndex = "ownerModified"
+)
+
+// ErrInvalidCursor is returned if a pagination token can't be decoded
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Cursor points at a document of a list sorted by date_modified, newest first.
+// Before specifies that the page should contain documents newer than the cursor.
+type Cursor struct {
+	DateModified time.Time `json:"d"`
+	ID           string    `json:"i"`
+	Before       bool      `json:"b,omitempty"`
+}
+
+// Encode returns an opaque token representing the cursor, base64url-encoded
+// without padding
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
+
+// DecodeCursor parses a token created by Cursor.Encode
+func DecodeCursor(token string) (*Cursor, error) {
+	if n := len(token) % 4; n != 0 {
+		token += strings.Repeat("=", 4-n)
+	}
+
+	data, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// Page describes the requested part of a list. Nil cursor means the first page.
+// Counting a filtered list scans all documents of the owner, so it's done only
+// if Count is set.
+type Page struct {
+	Cursor *Cursor
+	Limit  int
+	Count  bool
+}
+
+// PageResult contains tokens of the neighbouring pages and the count of all
+// documents matching the filter. Empty token means that there is no such page,
+// negative Total means that the list wasn't counted.
+type PageResult struct {
+	Next  string
+	Prev  string
+	Total int
+}
+
+// paginate fetches a page of documents owned by owner into results. filter
+// is optional, transform is applied to the fetched page by RethinkDB.
+func paginate(
+	table RethinkCRUD,
+	owner string,
+	filter *Filter,
+	transform func(gorethink.Term) gorethink.Term,
+	page *Page,
+	results interface{},
+) (*PageResult, error) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+
+	// Fetch one more document to find out whether there's another page
+	var (
+		documents []map[string]interface{}
+		total     int
+		err       error
+	)
+	if isMemory(table) {
+		documents, total, err = fetchMemoryPage(table, owner, filter, page.Cursor, limit+1)
+	} else {
+		documents, err = fetchPage(table, owner, filter, transform, page.Cursor, limit+1)
+	}
+	if err != nil {
+		return nil, NewDatabaseError(table, err, "")
+	}
+
+	switch {
+	case filter != nil && !page.Count:
+		total = -1
+	case !isMemory(table):
+		total, err = countPage(table, owner, filter)
+		if err != nil {
+			return nil, NewDatabaseError(table, err, "")
+		}
+	}
+
+	hasMore := len(documents) > limit
+	if hasMore {
+		documents = documents[:limit]
+	}
+
+	// Pages before the cursor are fetched in ascending order
+	if page.Cursor != nil && page.Cursor.Before {
+		for i, j := 0, len(documents)-1; i < j; i, j = i+1, j-1 {
+			documents[i], documents[j] = documents[j], documents[i]
+		}
+	}
+
+	result := &PageResult{
+		Total: total,
+	}
+	if len(documents) > 0 {
+		before := page.Cursor != nil && page.Cursor.Before
+		if before || hasMore {
+			result.Next, err = cursorOf(documents[len(documents)-1], false)
+			if err != nil {
+				return nil, NewDatabaseError(table, err, "")
+			}
+		}
+
+		if (before && hasMore) || (!before && page.Cursor != nil) {
+			result.Prev, err = cursorOf(documents[0], true)
+			if err != nil {
+				return nil, NewDatabaseError(table, err, "")
+			}
+		}
+	}
+
+	if err := encoding.Decode(results, documents); err != nil {
+		return nil, NewDatabaseError(table, err, "")
+	}
+
+	return result, nil
+}
+
+// fetchPage runs the page query in RethinkDB
+func fetchPage(
+	table RethinkTable,
+	owner string,
+	filter *Filter,
+	transform func(gorethink.Term) gorethink.Term,
+	after *Cursor,
+	limit int,
+) ([]map[string]interface{}, error) {
+	// Select the range of the index that's after (or before) the cursor
+	var term gorethink.Term
+	if after == nil {
+		term = table.GetTable().Between(
+			[]interface{}{owner, gorethink.MinVal, gorethink.MinVal},
+			[]interface{}{owner, gorethink.MaxVal, gorethink.MaxVal},
+			gorethink.BetweenOpts{Index: paginationIndex},
+		).OrderBy(gorethink.OrderByOpts{Index: gorethink.Desc(paginationIndex)})
+	} else if !after.Before {
+		term = table.GetTable().Between(
+			[]interface{}{owner, gorethink.MinVal, gorethink.MinVal},
+			[]interface{}{owner, after.DateModified, after.ID},
+			gorethink.BetweenOpts{Index: paginationIndex},
+		).OrderBy(gorethink.OrderByOpts{Index: gorethink.Desc(paginationIndex)})
+	} else {
+		term = table.GetTable().Between(
+			[]interface{}{owner, after.DateModified, after.ID},
+			[]interface{}{owner, gorethink.MaxVal, gorethink.MaxVal},
+			gorethink.BetweenOpts{Index: paginationIndex, LeftBound: "open"},
+		).OrderBy(gorethink.OrderByOpts{Index: gorethink.Asc(paginationIndex)})
+	}
+
+	if filter != nil {
+		term = term.Filter(filter.Term)
+	}
+
+	term = term.Limit(limit)
+
+	if transform != nil {
+		term = term.Map(transform)
+	}
+
+	cursor, err := term.Run(table.GetSession())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	var documents []map[string]interface{}
+	if err := cursor.All(&documents); err != nil {
+		return nil, err
+	}
+
+	return documents, nil
+}
+
+// countPage counts all documents of the owner that match the filter in RethinkDB
+func countPage(table RethinkTable, owner string, filter *Filter) (int, error) {
+	countTerm := table.GetTable().GetAllByIndex("owner", owner)
+	if filter != nil {
+		countTerm = countTerm.Filter(filter.Term)
+	}
+
+	countCursor, err := countTerm.Count().Run(table.GetSession())
+	if err != nil {
+		return 0, err
+	}
+	defer countCursor.Close()
+
+	var total int
+	if err := countCursor.One(&total); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// fetchMemoryPage emulates fetchPage for in-memory tables
+func fetchMemoryPage(
+	table RethinkCRUD,
+	owner string,
+	filter *Filter,
+	after *Cursor,
+	limit int,
+) ([]map[string]interface{}, int, error) {
+	var all []map[string]interface{}
+	if err := table.FindByIndexFetch(&all, "owner", owner); err != nil {
+		return nil, 0, err
+	}
+
+	matching := []map[string]interface{}{}
+	for _, document := range all {
+		if filter.matches(document) {
+			matching = append(matching, document)
+		}
+	}
+	sortByModified(matching)
+
+	documents := []map[string]interface{}{}
+	switch {
+	case after == nil:
+		documents = matching
+	case !after.Before:
+		for _, document := range matching {
+			if compareModified(document, after.DateModified, after.ID) < 0 {
+				documents = append(documents, document)
+			}
+		}
+	default:
+		// Walk from the oldest document, just like the ascending index
+		for i := len(matching) - 1; i >= 0; i-- {
+			if compareModified(matching[i], after.DateModified, after.ID) > 0 {
+				documents = append(documents, matching[i])
+			}
+		}
+	}
+
+	if len(documents) > limit {
+		documents = documents[:limit]
+	}
+
+	return documents, len(matching), nil
+}
+
+// cursorOf creates a token pointing at the document
+func cursorOf(document map[string]interface{}, before bool) (string, error) {
+	id, ok := document["id"].(string)
+	if !ok {
+		return "", errors.New("document has no id")
+	}
+
+	date, ok := document["date_modified"].(time.Time)
+	if !ok {
+		return "", errors.New("document has no date_modified")
+	}
+
+	return (&Cursor{
+		DateModified: date,
+		ID:           id,
+		Before:       before,
+	}).Encode(), nil
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dancannon/gorethink"
+)
+
+// Filter selects documents of a list. Term is evaluated by RethinkDB and
+// Match by the in-memory tables, so both have to select the same documents.
+// Tables build their filters using the constructors below, which are checked
+// against both backends by the db tests.
+type Filter struct {
+	Term  interface{}
+	Match func(document map[string]interface{}) bool
+}
+
+// fieldEquals selects documents whose field equals the value
+func fieldEquals(field string, value interface{}) *Filter {
+	normalized, _ := toDocumentValue(value)
+	return &Filter{
+		Term: gorethink.Row.Field(field).Eq(value).Default(false),
+		Match: func(document map[string]interface{}) bool {
+			current, ok := document[field]
+			return ok && valuesEqual(current, normalized)
+		},
+	}
+}
+
+// fieldContains selects documents whose array field contains the value
+func fieldContains(field string, value interface{}) *Filter {
+	normalized, _ := toDocumentValue(value)
+	return &Filter{
+		Term: gorethink.Row.Field(field).Contains(value).Default(false),
+		Match: func(document map[string]interface{}) bool {
+			return documentContains(document, field, normalized)
+		},
+	}
+}
+
+// idIn selects documents with one of the IDs
+func idIn(ids []string) *Filter {
+	set := map[string]struct{}{}
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	return &Filter{
+		Term: gorethink.Expr(ids).Contains(gorethink.Row.Field("id")),
+		Match: func(document map[string]interface{}) bool {
+			_, ok := set[documentString(document, "id")]
+			return ok
+		},
+	}
+}
+
+// Not selects documents that the filter doesn't select. The field filters
+// default to false, so documents missing the field are selected by both
+// backends.
+func (f *Filter) Not() *Filter {
+	return &Filter{
+		Term: gorethink.Not(f.Term),
+		Match: func(document map[string]interface{}) bool {
+			return !f.Match(document)
+		},
+	}
+}
+
+// toFilter converts a predicate passed to Delete, which is either a *Filter
+// or a map of field values, into a Filter
+func toFilter(pred interface{}) (*Filter, error) {
+	switch p := pred.(type) {
+	case *Filter:
+		return p, nil
+	case map[string]interface{}:
+		normalized, err := toDocument(p)
+		if err != nil {
+			return nil, ErrUnsupportedFilter
+		}
+
+		return &Filter{
+			Term: p,
+			Match: func(document map[string]interface{}) bool {
+				return matchesFilter(document, normalized)
+			},
+		}, nil
+	}
+
+	return nil, ErrUnsupportedFilter
+}
+
+// fetchMatching fills results with all documents of the table selected by
+// the filter
+func fetchMatching(table RethinkCRUD, filter *Filter, results interface{}) error {
+	if memory, ok := table.(*Memory); ok {
+		return memory.fetchMatching(filter, results)
+	}
+
+	cursor, err := table.GetTable().Filter(filter.Term).Run(table.GetSession())
+	if err != nil {
+		return NewDatabaseError(table, err, "")
+	}
+	defer cursor.Close()
+
+	if err := cursor.All(results); err != nil {
+		return NewDatabaseError(table, err, "")
+	}
+
+	return nil
+}
+
+// And combines two filters, any of which can be nil
+func (f *Filter) And(other *Filter) *Filter {
+	if f == nil {
+		return other
+	}
+	if other == nil {
+		return f
+	}
+
+	return &Filter{
+		Term: gorethink.And(f.Term, other.Term),
+		Match: func(document map[string]interface{}) bool {
+			return f.Match(document) && other.Match(document)
+		},
+	}
+}
+
+// matches checks a document against an optional filter
+func (f *Filter) matches(document map[string]interface{}) bool {
+	return f == nil || f.Match(document)
+}
+
+// isMemory checks whether the table is kept in the process memory, where raw
+// ReQL queries can't run. Such tables are queried using the RethinkCRUD
+// methods and the results are processed in Go.
+func isMemory(table RethinkTable) bool {
+	return table.GetSession() == nil
+}
+
+// documentTime returns a time field of a fetched document
+func documentTime(document map[string]interface{}, field string) time.Time {
+	value, _ := document[field].(time.Time)
+	return value
+}
+
+// documentContains checks whether an array field of a fetched document
+// contains the value
+func documentContains(document map[string]interface{}, field string, value interface{}) bool {
+	items, _ := document[field].([]interface{})
+	for _, item := range items {
+		if valuesEqual(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// documentString returns a string field of a fetched document
+func documentString(document map[string]interface{}, field string) string {
+	value, _ := document[field].(string)
+	return value
+}
+
+// sortByModified orders documents by date_modified and id, newest first
+func sortByModified(documents []map[string]interface{}) {
+	sort.Sort(byModified(documents))
+}
+
+type byModified []map[string]interface{}
+
+func (b byModified) Len() int      { return len(b) }
+func (b byModified) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byModified) Less(i, j int) bool {
+	return compareModified(b[i], documentTime(b[j], "date_modified"), documentString(b[j], "id")) > 0
+}
+
+// compareModified compares a document with a [date_modified, id] key
+func compareModified(document map[string]interface{}, date time.Time, id string) int {
+	modified := documentTime(document, "date_modified")
+	switch {
+	case modified.After(date):
+		return 1
+	case modified.Before(date):
+		return -1
+	}
+
+	switch current := documentString(document, "id"); {
+	case current > id:
+		return 1
+	case current < id:
+		return -1
+	}
+
+	return 0
+}
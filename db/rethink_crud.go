@@ -40,12 +40,14 @@ type RethinkReader interface {
 type RethinkUpdater interface {
 	Update(data interface{}) error
 	UpdateID(id string, data interface{}) error
+	UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error)
 }
 
 // RethinkDeleter allows deleting resources from the database
 type RethinkDeleter interface {
 	Delete(pred interface{}) error
 	DeleteID(id string) error
+	DeleteIDIf(id string, cond map[string]interface{}) (bool, error)
 }
 
 // RethinkCRUD is the interface that every table should implement
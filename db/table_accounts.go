@@ -2,15 +2,159 @@ package db
 
 import (
 	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/cache"
 	"github.com/lavab/api/models"
 )
 
+// statusExpires is how long the status of an account stays cached. Writes
+// through the table remove it sooner.
+const statusExpires = 30 * time.Second
+
+// AccountsQuery describes a search of accounts. Empty fields don't filter.
+type AccountsQuery struct {
+	// Query is a case-insensitive part of the name or the alt email
+	Query  string
+	Status string
+
+	// OldestFirst sorts the accounts by their creation date ascending
+	OldestFirst bool
+
+	Offset int
+	Limit  int
+}
+
 // AccountsTable implements the CRUD interface for accounts
 type AccountsTable struct {
 	RethinkCRUD
 
 	Tokens *TokensTable
+	Cache  cache.Cache
+}
+
+// AccountStatus is the part of an account checked on every request
+type AccountStatus struct {
+	ID           string                    `json:"id"`
+	Type         string                    `json:"type"`
+	Status       string                    `json:"status"`
+	Suspension   *models.AccountSuspension `json:"suspension,omitempty"`
+	DeletionDate time.Time                 `json:"deletion_date"`
+}
+
+// GetAccountStatus returns the status of an account, cached for statusExpires
+func (a *AccountsTable) GetAccountStatus(id string) (*AccountStatus, error) {
+	var status AccountStatus
+	if a.Cache != nil {
+		if err := a.Cache.Get(a.statusKey(id), &status); err == nil {
+			return &status, nil
+		}
+	}
+
+	account, err := a.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	status = AccountStatus{
+		ID:           account.ID,
+		Type:         account.Type,
+		Status:       account.Status,
+		Suspension:   account.Suspension,
+		DeletionDate: account.DeletionDate,
+	}
+	if a.Cache != nil {
+		if err := a.Cache.Set(a.statusKey(id), &status, statusExpires); err != nil {
+			return nil, err
+		}
+	}
+
+	return &status, nil
+}
+
+func (a *AccountsTable) statusKey(id string) string {
+	return a.RethinkCRUD.GetTableName() + ":status:" + id
+}
+
+// forgetStatus removes the cached status of an account, or of all accounts
+// if id is empty
+func (a *AccountsTable) forgetStatus(id string) error {
+	if a.Cache == nil {
+		return nil
+	}
+
+	if id == "" {
+		return a.Cache.DeleteMask(a.statusKey("*"))
+	}
+
+	return a.Cache.Delete(a.statusKey(id))
+}
+
+// Update clears the cached statuses
+func (a *AccountsTable) Update(data interface{}) error {
+	if err := a.RethinkCRUD.Update(data); err != nil {
+		return err
+	}
+
+	if account, ok := data.(*models.Account); ok {
+		return a.forgetStatus(account.ID)
+	}
+
+	return a.forgetStatus("")
+}
+
+// UpdateID updates the account and clears its cached status
+func (a *AccountsTable) UpdateID(id string, data interface{}) error {
+	if err := a.RethinkCRUD.UpdateID(id, data); err != nil {
+		return err
+	}
+
+	return a.forgetStatus(id)
+}
+
+// UpdateIDIf updates the account if it matches the condition and clears its
+// cached status
+func (a *AccountsTable) UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error) {
+	updated, err := a.RethinkCRUD.UpdateIDIf(id, cond, data)
+	if err != nil {
+		return false, err
+	}
+
+	return updated, a.forgetStatus(id)
+}
+
+// Delete removes the accounts and clears the cached statuses
+func (a *AccountsTable) Delete(pred interface{}) error {
+	if err := a.RethinkCRUD.Delete(pred); err != nil {
+		return err
+	}
+
+	return a.forgetStatus("")
+}
+
+// DeleteID removes the account and its cached status
+func (a *AccountsTable) DeleteID(id string) error {
+	if err := a.RethinkCRUD.DeleteID(id); err != nil {
+		return err
+	}
+
+	return a.forgetStatus(id)
+}
+
+// DeleteIDIf removes the account if it matches the condition and clears its
+// cached status
+func (a *AccountsTable) DeleteIDIf(id string, cond map[string]interface{}) (bool, error) {
+	deleted, err := a.RethinkCRUD.DeleteIDIf(id, cond)
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, a.forgetStatus(id)
 }
 
 // GetAccount returns an account with specified ID
@@ -35,6 +179,17 @@ func (users *AccountsTable) FindAccountByName(name string) (*models.Account, err
 	return &result, nil
 }
 
+// GetInvitedBy returns all accounts created using invitations of id
+func (users *AccountsTable) GetInvitedBy(id string) ([]*models.Account, error) {
+	var result []*models.Account
+
+	if err := users.FindByIndexFetch(&result, "invited_by", id); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
 func (a *AccountsTable) GetTokenOwner(token *models.Token) (*models.Account, error) {
 	user, err := a.GetAccount(token.Owner)
 	if err != nil {
@@ -71,3 +226,106 @@ func (a *AccountsTable) IsEmailUsed(email string) (bool, error) {
 
 	return true, nil
 }
+
+// Search returns a page of accounts matching the query and the count of all
+// matching accounts
+func (a *AccountsTable) Search(query *AccountsQuery) ([]*models.Account, int, error) {
+	if isMemory(a) {
+		return a.searchMemory(query)
+	}
+
+	term := a.GetTable().Filter(func(row gorethink.Term) interface{} {
+		match := gorethink.Expr(true)
+		if query.Query != "" {
+			pattern := "(?i)" + regexp.QuoteMeta(query.Query)
+			match = row.Field("name").Match(pattern).Ne(nil).Or(
+				row.Field("alt_email").Default("").Match(pattern).Ne(nil),
+			)
+		}
+		if query.Status != "" {
+			match = match.And(row.Field("status").Default("").Eq(query.Status))
+		}
+		return match
+	})
+
+	countCursor, err := term.Count().Run(a.GetSession())
+	if err != nil {
+		return nil, 0, NewDatabaseError(a, err, "")
+	}
+	defer countCursor.Close()
+
+	var total int
+	if err := countCursor.One(&total); err != nil {
+		return nil, 0, NewDatabaseError(a, err, "")
+	}
+
+	order := gorethink.Desc("date_created")
+	if query.OldestFirst {
+		order = gorethink.Asc("date_created")
+	}
+
+	cursor, err := term.OrderBy(order).Skip(query.Offset).Limit(query.Limit).Run(a.GetSession())
+	if err != nil {
+		return nil, 0, NewDatabaseError(a, err, "")
+	}
+	defer cursor.Close()
+
+	var result []*models.Account
+	if err := cursor.All(&result); err != nil {
+		return nil, 0, NewDatabaseError(a, err, "")
+	}
+
+	return result, total, nil
+}
+
+// searchMemory emulates Search for in-memory tables
+func (a *AccountsTable) searchMemory(query *AccountsQuery) ([]*models.Account, int, error) {
+	var accounts []*models.Account
+	if err := a.WhereAndFetch(map[string]interface{}{}, &accounts); err != nil {
+		return nil, 0, err
+	}
+
+	pattern := strings.ToLower(query.Query)
+	matching := []*models.Account{}
+	for _, account := range accounts {
+		if pattern != "" &&
+			!strings.Contains(strings.ToLower(account.Name), pattern) &&
+			!strings.Contains(strings.ToLower(account.AltEmail), pattern) {
+			continue
+		}
+
+		if query.Status != "" && account.Status != query.Status {
+			continue
+		}
+
+		matching = append(matching, account)
+	}
+
+	sort.Sort(accountsByCreation(matching))
+	if !query.OldestFirst {
+		for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+			matching[i], matching[j] = matching[j], matching[i]
+		}
+	}
+
+	total := len(matching)
+	if query.Offset >= total {
+		return []*models.Account{}, total, nil
+	}
+
+	matching = matching[query.Offset:]
+	if query.Limit < len(matching) {
+		matching = matching[:query.Limit]
+	}
+
+	return matching, total, nil
+}
+
+// accountsByCreation sorts accounts from the oldest one
+type accountsByCreation []*models.Account
+
+func (b accountsByCreation) Len() int      { return len(b) }
+func (b accountsByCreation) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b accountsByCreation) Less(i, j int) bool {
+	return b[i].DateCreated.Before(b[j].DateCreated)
+}
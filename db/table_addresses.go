@@ -1,6 +1,8 @@
 package db
 
 import (
+	"time"
+
 	"github.com/lavab/api/models"
 )
 
@@ -17,18 +19,47 @@ func (a *AddressesTable) GetAddress(id string) (*models.Address, error) {
 }
 
 func (a *AddressesTable) GetOwnedBy(id string) ([]*models.Address, error) {
-	cursor, err := a.GetTable().GetAllByIndex("owner", id).Run(a.GetSession())
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close()
 	var result []*models.Address
-	if err := cursor.All(&result); err != nil {
+	if err := a.FindByIndexFetch(&result, "owner", id); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
 
 func (a *AddressesTable) DeleteOwnedBy(id string) error {
-	return a.GetTable().GetAllByIndex("owner", id).Delete().Exec(a.GetSession())
+	return a.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
+
+// QuarantineOwnedBy detaches all addresses owned by id from the account, but
+// keeps them in the database so that they can't be registered again
+func (a *AddressesTable) QuarantineOwnedBy(id string) error {
+	addresses, err := a.GetOwnedBy(id)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range addresses {
+		if err := a.UpdateID(address.ID, map[string]interface{}{
+			"owner":         "",
+			"quarantined":   true,
+			"date_modified": time.Now(),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List returns a page of addresses owned by owner
+func (a *AddressesTable) List(owner string, page *Page) ([]*models.Address, *PageResult, error) {
+	var result []*models.Address
+	info, err := paginate(a, owner, nil, nil, page, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, info, nil
 }
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"github.com/lavab/api/models"
+)
+
+// AuditEventsTable stores the security audit log of accounts. Events are
+// only appended, so the table doesn't expose other CRUD operations.
+type AuditEventsTable struct {
+	table RethinkCRUD
+}
+
+// NewAuditEventsTable wraps the CRUD implementation of the audit_events table
+func NewAuditEventsTable(table RethinkCRUD) *AuditEventsTable {
+	return &AuditEventsTable{
+		table: table,
+	}
+}
+
+// Insert appends an event to the audit log
+func (a *AuditEventsTable) Insert(event *models.AuditEvent) error {
+	return a.table.Insert(event)
+}
+
+// List returns a page of account's audit events, newest first
+func (a *AuditEventsTable) List(owner string, page *Page) ([]*models.AuditEvent, *PageResult, error) {
+	var result []*models.AuditEvent
+	info, err := paginate(a.table, owner, nil, nil, page, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, info, nil
+}
+
+// DeleteOwnedBy removes the audit log of an account
+func (a *AuditEventsTable) DeleteOwnedBy(id string) error {
+	return a.table.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/models"
+)
+
+// BlobChunkSize is the maximal size of a single chunk of a blob
+const BlobChunkSize = 1 << 20
+
+var (
+	// ErrBlobNotFound is returned when opening a blob that doesn't exist
+	ErrBlobNotFound = errors.New("Blob not found")
+	// ErrBlobIncomplete is returned when reading a blob that wasn't completely written
+	ErrBlobIncomplete = errors.New("Blob is incomplete")
+)
+
+// BlobsTable stores binary objects, like data exports, in chunks
+type BlobsTable struct {
+	RethinkCRUD
+}
+
+// chunkID returns the ID of a chunk of the blob
+func chunkID(blob string, index int) string {
+	return blob + ":" + strconv.Itoa(index)
+}
+
+// Create removes the blob if it exists and returns a writer storing it again.
+// The blob can't be read until the writer is closed.
+func (b *BlobsTable) Create(owner, blob string, expiry time.Time) (io.WriteCloser, error) {
+	if err := b.DeleteBlob(blob); err != nil {
+		return nil, err
+	}
+
+	return &blobWriter{
+		table:  b,
+		owner:  owner,
+		blob:   blob,
+		expiry: expiry,
+	}, nil
+}
+
+// Open returns a reader of a completely written blob
+func (b *BlobsTable) Open(blob string) (io.Reader, error) {
+	first, err := b.getChunk(blob, 0)
+	if err != nil {
+		return nil, err
+	}
+	if first == nil {
+		return nil, ErrBlobNotFound
+	}
+
+	return &blobReader{
+		table: b,
+		chunk: first,
+	}, nil
+}
+
+// getChunk returns a chunk of the blob or nil if it doesn't exist
+func (b *BlobsTable) getChunk(blob string, index int) (*models.BlobChunk, error) {
+	var chunks []*models.BlobChunk
+	if err := b.FindByIndexFetch(&chunks, "id", chunkID(blob, index)); err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		return nil, nil
+	}
+
+	return chunks[0], nil
+}
+
+// DeleteBlob removes all chunks of the blob
+func (b *BlobsTable) DeleteBlob(blob string) error {
+	if isMemory(b) {
+		return b.Delete(map[string]interface{}{
+			"blob": blob,
+		})
+	}
+
+	if err := b.GetTable().GetAllByIndex("blob", blob).Delete().Exec(b.GetSession()); err != nil {
+		return NewDatabaseError(b, err, "")
+	}
+
+	return nil
+}
+
+// DeleteExpired removes chunks of all expired blobs
+func (b *BlobsTable) DeleteExpired() error {
+	if isMemory(b) {
+		var chunks []*models.BlobChunk
+		if err := b.WhereAndFetch(map[string]interface{}{}, &chunks); err != nil {
+			return err
+		}
+
+		for _, chunk := range chunks {
+			if chunk.Expired() {
+				if err := b.DeleteID(chunk.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := b.GetTable().Between(
+		gorethink.MinVal,
+		time.Now(),
+		gorethink.BetweenOpts{Index: "expiry_date"},
+	).Delete().Exec(b.GetSession()); err != nil {
+		return NewDatabaseError(b, err, "")
+	}
+
+	return nil
+}
+
+// DeleteOwnedBy removes all blobs of an account
+func (b *BlobsTable) DeleteOwnedBy(id string) error {
+	return b.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
+
+// blobWriter buffers written data and inserts it in chunks
+type blobWriter struct {
+	table  *BlobsTable
+	owner  string
+	blob   string
+	expiry time.Time
+	index  int
+	buffer []byte
+}
+
+func (w *blobWriter) Write(data []byte) (int, error) {
+	w.buffer = append(w.buffer, data...)
+
+	for len(w.buffer) >= BlobChunkSize {
+		if err := w.flush(w.buffer[:BlobChunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buffer = w.buffer[BlobChunkSize:]
+	}
+
+	return len(data), nil
+}
+
+// Close stores the rest of the data as the last chunk
+func (w *blobWriter) Close() error {
+	return w.flush(w.buffer, true)
+}
+
+func (w *blobWriter) flush(data []byte, last bool) error {
+	chunk := &models.BlobChunk{
+		ID:    chunkID(w.blob, w.index),
+		Owner: w.owner,
+		Blob:  w.blob,
+		Index: w.index,
+		Last:  last,
+		Data:  append([]byte{}, data...),
+	}
+	chunk.ExpiryDate = w.expiry
+
+	if err := w.table.Insert(chunk); err != nil {
+		return err
+	}
+
+	w.index++
+	return nil
+}
+
+// blobReader fetches chunks of a blob one by one
+type blobReader struct {
+	table  *BlobsTable
+	chunk  *models.BlobChunk
+	offset int
+}
+
+func (r *blobReader) Read(data []byte) (int, error) {
+	for r.offset == len(r.chunk.Data) {
+		if r.chunk.Last {
+			return 0, io.EOF
+		}
+
+		next, err := r.table.getChunk(r.chunk.Blob, r.chunk.Index+1)
+		if err != nil {
+			return 0, err
+		}
+		if next == nil {
+			return 0, ErrBlobIncomplete
+		}
+
+		r.chunk = next
+		r.offset = 0
+	}
+
+	n := copy(data, r.chunk.Data[r.offset:])
+	r.offset += n
+	return n, nil
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestMemoryBlobs(t *testing.T) {
+	blobs := &BlobsTable{
+		RethinkCRUD: NewMemoryTable("test", "blobs", TableIndexes["blobs"]...),
+	}
+
+	data := bytes.Repeat([]byte("lavaboom"), BlobChunkSize/4)
+
+	writer, err := blobs.Create("alice", "export", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blobs can't be read before they are completely written
+	reader, err := blobs.Open("export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != ErrBlobIncomplete {
+		t.Fatalf("read an incomplete blob: %v", err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err = blobs.Open("export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(read, data) {
+		t.Fatalf("read %d bytes instead of %d", len(read), len(data))
+	}
+
+	if err := blobs.DeleteExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobs.Open("export"); err != nil {
+		t.Fatalf("blob was removed before it expired: %v", err)
+	}
+
+	if err := blobs.DeleteBlob("export"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blobs.Open("export"); err != ErrBlobNotFound {
+		t.Fatalf("deleted blob was opened: %v", err)
+	}
+}
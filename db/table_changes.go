@@ -0,0 +1,134 @@
+package db
+
+import (
+	"sort"
+	"time"
+
+	"github.com/dancannon/gorethink"
+	"github.com/dchest/uniuri"
+
+	"github.com/lavab/api/models"
+)
+
+// ChangesTable stores the change log used by delta sync
+type ChangesTable struct {
+	RethinkCRUD
+	Sequences *SequencesTable
+}
+
+// Record appends entries of resources of a single owner to the change log.
+// The entries get consecutive sequences and are inserted at once.
+func (c *ChangesTable) Record(owner, table, kind string, resources ...string) error {
+	if len(resources) == 0 {
+		return nil
+	}
+
+	first, err := c.Sequences.Reserve(owner, len(resources))
+	if err != nil {
+		return err
+	}
+
+	changes := make([]*models.Change, len(resources))
+	for i, resource := range resources {
+		changes[i] = &models.Change{
+			ID:          uniuri.NewLen(uniuri.UUIDLen),
+			Owner:       owner,
+			Sequence:    first + int64(i),
+			Table:       table,
+			Resource:    resource,
+			Kind:        kind,
+			DateCreated: time.Now(),
+		}
+	}
+
+	return c.Insert(changes)
+}
+
+// ListSince returns up to limit changes of owner's resources newer than the sequence
+func (c *ChangesTable) ListSince(owner string, since int64, limit int) ([]*models.Change, error) {
+	if isMemory(c) {
+		var changes []*models.Change
+		if err := c.FindByIndexFetch(&changes, "owner", owner); err != nil {
+			return nil, err
+		}
+
+		result := []*models.Change{}
+		for _, change := range changes {
+			if change.Sequence > since {
+				result = append(result, change)
+			}
+		}
+		sort.Sort(changesBySequence(result))
+
+		if len(result) > limit {
+			result = result[:limit]
+		}
+
+		return result, nil
+	}
+
+	cursor, err := c.GetTable().Between(
+		[]interface{}{owner, since},
+		[]interface{}{owner, gorethink.MaxVal},
+		gorethink.BetweenOpts{Index: "ownerSequence", LeftBound: "open"},
+	).OrderBy(gorethink.OrderByOpts{Index: "ownerSequence"}).Limit(limit).Run(c.GetSession())
+	if err != nil {
+		return nil, NewDatabaseError(c, err, "")
+	}
+	defer cursor.Close()
+
+	var result []*models.Change
+	if err := cursor.All(&result); err != nil {
+		return nil, NewDatabaseError(c, err, "")
+	}
+
+	return result, nil
+}
+
+// DeleteOlderThan removes entries of the change log created before the date
+func (c *ChangesTable) DeleteOlderThan(date time.Time) error {
+	if isMemory(c) {
+		var changes []*models.Change
+		if err := c.WhereAndFetch(map[string]interface{}{}, &changes); err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if change.DateCreated.Before(date) {
+				if err := c.DeleteID(change.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	if err := c.GetTable().Between(
+		gorethink.MinVal,
+		date,
+		gorethink.BetweenOpts{Index: "date_created"},
+	).Delete().Exec(c.GetSession()); err != nil {
+		return NewDatabaseError(c, err, "")
+	}
+
+	return nil
+}
+
+// DeleteOwnedBy removes the change log of an account and its counter
+func (c *ChangesTable) DeleteOwnedBy(id string) error {
+	if err := c.Delete(map[string]interface{}{
+		"owner": id,
+	}); err != nil {
+		return err
+	}
+
+	return c.Sequences.DeleteOwnedBy(id)
+}
+
+// changesBySequence sorts changes from the oldest one
+type changesBySequence []*models.Change
+
+func (b changesBySequence) Len() int           { return len(b) }
+func (b changesBySequence) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b changesBySequence) Less(i, j int) bool { return b[i].Sequence < b[j].Sequence }
@@ -40,3 +40,19 @@ func (c *ContactsTable) DeleteOwnedBy(id string) error {
 		"owner": id,
 	})
 }
+
+// List returns a page of contacts owned by owner
+func (c *ContactsTable) List(owner string, page *Page) ([]*models.Contact, *PageResult, error) {
+	var result []*models.Contact
+	info, err := paginate(c, owner, nil, nil, page, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, info, nil
+}
+
+// StorageUsage returns the count of bytes used by contacts of the owner
+func (c *ContactsTable) StorageUsage(owner string) (int, error) {
+	return storageUsage(c, owner, "data")
+}
@@ -47,70 +47,32 @@ func (e *EmailsTable) CountOwnedBy(id string) (int, error) {
 	return e.FindByAndCount("owner", id)
 }
 
-func (e *EmailsTable) List(
-	owner string,
-	sort []string,
-	offset int,
-	limit int,
-	thread string,
-) ([]*models.Email, error) {
-
-	filter := map[string]interface{}{}
-
-	if owner != "" {
-		filter["owner"] = owner
-	}
-
+// List returns a page of emails owned by owner, optionally limited to a single thread.
+// Queued emails are not listed.
+func (e *EmailsTable) List(owner string, thread string, page *Page) ([]*models.Email, *PageResult, error) {
+	filter := fieldEquals("status", "queued").Not()
 	if thread != "" {
-		filter["thread"] = thread
-	}
-
-	term := e.GetTable().Filter(filter).Filter(gorethink.Not(gorethink.Row.Field("status").Eq(gorethink.Expr("queued"))))
-
-	// If sort array has contents, parse them and add to the term
-	if sort != nil && len(sort) > 0 {
-		var conds []interface{}
-		for _, cond := range sort {
-			if cond[0] == '-' {
-				conds = append(conds, gorethink.Desc(cond[1:]))
-			} else if cond[0] == '+' || cond[0] == ' ' {
-				conds = append(conds, gorethink.Asc(cond[1:]))
-			} else {
-				conds = append(conds, gorethink.Asc(cond))
-			}
-		}
-
-		term = term.OrderBy(conds...)
+		filter = filter.And(fieldEquals("thread", thread))
 	}
 
-	// Slice the result in 3 cases
-	if offset != 0 && limit == 0 {
-		term = term.Skip(offset)
-	}
-
-	if offset == 0 && limit != 0 {
-		term = term.Limit(limit)
+	var result []*models.Email
+	info, err := paginate(e, owner, filter, nil, page, &result)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if offset != 0 && limit != 0 {
-		term = term.Slice(offset, offset+limit)
-	}
+	return result, info, nil
+}
 
-	// Run the query
-	cursor, err := term.Run(e.GetSession())
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close()
+// GetByMessageID returns an email of the owner with specified Message-ID
+func (e *EmailsTable) GetByMessageID(owner string, messageID string) (*models.Email, error) {
+	var result models.Email
 
-	// Fetch the cursor
-	var resp []*models.Email
-	err = cursor.All(&resp)
-	if err != nil {
+	if err := e.FindByIndexFetchOne(&result, "messageIDOwner", []interface{}{messageID, owner}); err != nil {
 		return nil, err
 	}
 
-	return resp, nil
+	return &result, nil
 }
 
 func (e *EmailsTable) GetByThread(thread string) ([]*models.Email, error) {
@@ -131,6 +93,25 @@ func (e *EmailsTable) DeleteByThread(id string) error {
 }
 
 func (e *EmailsTable) GetThreadManifest(thread string) (string, error) {
+	if isMemory(e) {
+		var emails []*models.Email
+		if err := e.FindByIndexFetch(&emails, "thread", thread); err != nil {
+			return "", err
+		}
+
+		var first *models.Email
+		for _, email := range emails {
+			if first == nil || email.DateCreated.Before(first.DateCreated) {
+				first = email
+			}
+		}
+		if first == nil {
+			return "", NewDatabaseError(e, gorethink.ErrEmptyResult, "")
+		}
+
+		return first.Manifest, nil
+	}
+
 	cursor, err := e.GetTable().
 		GetAllByIndex("thread", thread).
 		OrderBy("date_created").
@@ -150,3 +131,8 @@ func (e *EmailsTable) GetThreadManifest(thread string) (string, error) {
 
 	return manifest, nil
 }
+
+// StorageUsage returns the count of bytes used by emails of the owner
+func (e *EmailsTable) StorageUsage(owner string) (int, error) {
+	return storageUsage(e, owner, "body", "manifest")
+}
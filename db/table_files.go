@@ -2,8 +2,6 @@ package db
 
 import (
 	"github.com/lavab/api/models"
-
-	"github.com/dancannon/gorethink"
 )
 
 type FilesTable struct {
@@ -22,18 +20,17 @@ func (f *FilesTable) GetFile(id string) (*models.File, error) {
 }
 
 func (f *FilesTable) GetFiles(ids ...string) ([]*models.File, error) {
+	var result []*models.File
+	if len(ids) == 0 {
+		return result, nil
+	}
+
 	iids := make([]interface{}, len(ids))
 	for i, v := range ids {
 		iids[i] = v
 	}
 
-	query, err := f.GetTable().GetAll(iids...).Run(f.GetSession())
-	if err != nil {
-		return nil, err
-	}
-
-	var result []*models.File
-	if err := query.All(&result); err != nil {
+	if err := f.FindByIndexFetch(&result, "id", iids...); err != nil {
 		return nil, err
 	}
 
@@ -65,59 +62,41 @@ func (f *FilesTable) GetEmailFiles(id string) ([]*models.File, error) {
 		return nil, err
 	}
 
-	query, err := f.Emails.GetTable().Filter(func(row gorethink.Term) gorethink.Term {
-		return gorethink.Expr(email.Files).Contains(row.Field("id"))
-	}).GetAll().Run(f.Emails.GetSession())
-	if err != nil {
-		return nil, err
-	}
-
-	var result []*models.File
-	err = query.All(&result)
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
+	return f.GetFiles(email.Files...)
 }
 
 func (f *FilesTable) CountByEmail(id string) (int, error) {
-	query, err := f.GetTable().GetAllByIndex("owner", id).Count().Run(f.GetSession())
-	if err != nil {
-		return 0, err
-	}
+	return f.FindByAndCount("owner", id)
+}
 
-	var result int
-	err = query.One(&result)
-	if err != nil {
-		return 0, err
-	}
+// List returns a page of files owned by owner. If email is set, only files
+// attached to that email are listed. If name is set, files are filtered by name.
+func (f *FilesTable) List(owner string, email string, name string, page *Page) ([]*models.File, *PageResult, error) {
+	var filter *Filter
 
-	return result, nil
-}
+	if email != "" {
+		e, err := f.Emails.GetEmail(email)
+		if err != nil {
+			return nil, nil, err
+		}
 
-func (f *FilesTable) GetInEmail(owner string, email string, name string) ([]*models.File, error) {
-	e, err := f.Emails.GetEmail(email)
-	if err != nil {
-		return nil, err
+		filter = idIn(e.Files)
 	}
 
-	query, err := f.GetTable().Filter(func(row gorethink.Term) gorethink.Term {
-		return gorethink.And(
-			row.Field("owner").Eq(gorethink.Expr(owner)),
-			gorethink.Expr(e.Files).Contains(row.Field("id")),
-			row.Field("name").Eq(gorethink.Expr(name)),
-		)
-	}).Run(f.GetSession())
-	if err != nil {
-		return nil, err
+	if name != "" {
+		filter = filter.And(fieldEquals("name", name))
 	}
 
 	var result []*models.File
-	err = query.All(&result)
+	info, err := paginate(f, owner, filter, nil, page, &result)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return result, nil
+	return result, info, nil
+}
+
+// StorageUsage returns the count of bytes used by files of the owner
+func (f *FilesTable) StorageUsage(owner string) (int, error) {
+	return storageUsage(f, owner, "data")
 }
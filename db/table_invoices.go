@@ -0,0 +1,36 @@
+package db
+
+import (
+	"github.com/lavab/api/models"
+)
+
+// InvoicesTable stores charges of subscriptions
+type InvoicesTable struct {
+	RethinkCRUD
+}
+
+// GetByProviderID returns an invoice with specified provider's ID or nil
+func (i *InvoicesTable) GetByProviderID(id string) (*models.Invoice, error) {
+	var result []*models.Invoice
+
+	if err := i.FindByIndexFetch(&result, "provider_id", id); err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	return result[0], nil
+}
+
+// List returns a page of account's invoices, newest first
+func (i *InvoicesTable) List(owner string, page *Page) ([]*models.Invoice, *PageResult, error) {
+	var result []*models.Invoice
+	info, err := paginate(i, owner, nil, nil, page, &result)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, info, nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"github.com/lavab/api/models"
+)
+
+// JobsTable stores background jobs and their progress
+type JobsTable struct {
+	RethinkCRUD
+}
+
+// GetJob returns a job with specified ID
+func (j *JobsTable) GetJob(id string) (*models.Job, error) {
+	var result models.Job
+
+	if err := j.FindFetchOne(id, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetUnfinished returns an unfinished job of the owner with specified type
+func (j *JobsTable) GetUnfinished(owner string, kind string) (*models.Job, error) {
+	var jobs []*models.Job
+
+	if err := j.WhereAndFetch(map[string]interface{}{
+		"owner": owner,
+		"type":  kind,
+	}, &jobs); err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		if !job.IsFinished() {
+			return job, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetScheduled returns queued jobs that start at a set date
+func (j *JobsTable) GetScheduled() ([]*models.Job, error) {
+	var jobs []*models.Job
+
+	if err := j.FindByIndexFetch(&jobs, "status", models.JobQueued); err != nil {
+		return nil, err
+	}
+
+	result := []*models.Job{}
+	for _, job := range jobs {
+		if !job.DateScheduled.IsZero() {
+			result = append(result, job)
+		}
+	}
+
+	return result, nil
+}
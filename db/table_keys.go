@@ -27,3 +27,10 @@ func (k *KeysTable) FindByFingerprint(fp string) (*models.Key, error) {
 
 	return &result, nil
 }
+
+// DeleteOwnedBy deletes all keys owned by id
+func (k *KeysTable) DeleteOwnedBy(id string) error {
+	return k.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"time"
 
-	//"github.com/dancannon/gorethink"
+	"github.com/dancannon/gorethink"
 
 	//"github.com/lavab/api/cache"
 	"github.com/lavab/api/models"
@@ -62,8 +62,7 @@ func (l *LabelsTable) Delete(cond interface{}) error {
 	/*result, err := l.GetTable().Filter(cond).Delete(gorethink.DeleteOpts{
 		ReturnChanges: true,
 	}).RunWrite(l.GetSession())*/
-	_, err := l.GetTable().Filter(cond).Delete().RunWrite(l.GetSession())
-	if err != nil {
+	if err := l.RethinkCRUD.Delete(cond); err != nil {
 		return err
 	}
 
@@ -84,7 +83,7 @@ func (l *LabelsTable) DeleteID(id string) error {
 		return err
 	}*/
 
-	if err := l.RethinkCRUD.DeleteID(l.RethinkCRUD.GetTableName() + ":" + id); err != nil {
+	if err := l.RethinkCRUD.DeleteID(id); err != nil {
 		return err
 	}
 
@@ -153,3 +152,96 @@ func (l *LabelsTable) GetLabelByNameAndOwner(owner string, name string) (*models
 
 	return &result, nil
 }
+
+// GetBuiltin returns builtin labels of the owner with specified names
+func (l *LabelsTable) GetBuiltin(owner string, names ...string) ([]*models.Label, error) {
+	keys := make([]interface{}, len(names))
+	for i, name := range names {
+		keys[i] = []interface{}{name, owner, true}
+	}
+
+	var result []*models.Label
+	if err := l.FindByIndexFetch(&result, "nameOwnerBuiltin", keys...); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// ListWithCounts returns all labels of the owner with counts of their
+// threads. Threads with any of the hidden labels are not counted as unread.
+func (l *LabelsTable) ListWithCounts(owner string, threads *ThreadsTable, hidden []string) ([]*models.Label, error) {
+	var result []*models.Label
+
+	if isMemory(l) {
+		if err := l.FindByIndexFetch(&result, "owner", owner); err != nil {
+			return nil, err
+		}
+
+		for _, label := range result {
+			labelled, err := threads.GetByLabel(label.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			label.TotalThreadsCount = len(labelled)
+			label.UnreadThreadsCount = 0
+			for _, thread := range labelled {
+				if !thread.IsRead && !containsAny(thread.Labels, hidden) {
+					label.UnreadThreadsCount++
+				}
+			}
+		}
+
+		return result, nil
+	}
+
+	cursor, err := l.GetTable().GetAllByIndex("owner", owner).Map(func(label gorethink.Term) gorethink.Term {
+		return label.Merge(map[string]interface{}{
+			"total_threads_count": threads.GetTable().GetAllByIndex("labels", label.Field("id")).Count(),
+			"unread_threads_count": threads.GetTable().GetAllByIndex("labels", label.Field("id")).Filter(func(thread gorethink.Term) gorethink.Term {
+				return gorethink.Not(thread.Field("is_read")).And(
+					gorethink.Expr(hidden).SetIntersection(thread.Field("labels")).IsEmpty(),
+				)
+			}).Count(),
+		})
+	}).Run(l.GetSession())
+	if err != nil {
+		return nil, NewDatabaseError(l, err, "")
+	}
+	defer cursor.Close()
+
+	if err := cursor.All(&result); err != nil {
+		return nil, NewDatabaseError(l, err, "")
+	}
+
+	return result, nil
+}
+
+// containsAny checks whether items contain any of the values
+func containsAny(items []string, values []string) bool {
+	for _, item := range items {
+		for _, value := range values {
+			if item == value {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// DeleteOwnedBy deletes all labels owned by id
+func (l *LabelsTable) DeleteOwnedBy(id string) error {
+	return l.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
+
+// DeleteCustomOwnedBy deletes all labels owned by id except the builtin ones
+func (l *LabelsTable) DeleteCustomOwnedBy(id string) error {
+	return l.Delete(map[string]interface{}{
+		"owner":   id,
+		"builtin": false,
+	})
+}
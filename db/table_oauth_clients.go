@@ -0,0 +1,32 @@
+package db
+
+import (
+	"github.com/lavab/api/models"
+)
+
+// OAuthClientsTable stores OAuth clients registered by the users
+type OAuthClientsTable struct {
+	RethinkCRUD
+}
+
+// GetClient returns an OAuth client with specified ID
+func (o *OAuthClientsTable) GetClient(id string) (*models.OAuthClient, error) {
+	var result models.OAuthClient
+
+	if err := o.FindFetchOne(id, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetOwnedBy returns all OAuth clients registered by id
+func (o *OAuthClientsTable) GetOwnedBy(id string) ([]*models.OAuthClient, error) {
+	var result []*models.OAuthClient
+
+	if err := o.FindByIndexFetch(&result, "owner", id); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
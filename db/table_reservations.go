@@ -1,32 +1,106 @@
 package db
 
+import (
+	"time"
+
+	"github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/models"
+)
+
 // ReservationsTable is a CRUD interface for accessing the "reservation" table
 type ReservationsTable struct {
 	RethinkCRUD
 }
 
 func (r *ReservationsTable) IsUsernameUsed(name string) (bool, error) {
-	count, err := r.FindByAndCount("name", name)
+	reservation, err := r.GetByName(name)
 	if err != nil {
 		return false, err
 	}
 
-	if count == 0 {
-		return false, nil
-	}
-
-	return true, nil
+	return reservation != nil, nil
 }
 
 func (r *ReservationsTable) IsEmailUsed(email string) (bool, error) {
-	count, err := r.FindByAndCount("email", email)
+	reservation, err := r.GetByEmail(email)
 	if err != nil {
 		return false, err
 	}
 
-	if count == 0 {
-		return false, nil
+	return reservation != nil, nil
+}
+
+// GetByName returns a confirmed active reservation of the username or nil
+func (r *ReservationsTable) GetByName(name string) (*models.Reservation, error) {
+	return r.getActive("name", name)
+}
+
+// GetByEmail returns a confirmed active reservation made by the email or nil
+func (r *ReservationsTable) GetByEmail(email string) (*models.Reservation, error) {
+	return r.getActive("email", email)
+}
+
+// getActive returns the first matching confirmed reservation that hasn't
+// expired yet. Expired reservations are removed on the way.
+func (r *ReservationsTable) getActive(key string, value string) (*models.Reservation, error) {
+	var reservations []*models.Reservation
+
+	if err := r.FindByAndFetch(key, value, &reservations); err != nil {
+		return nil, err
+	}
+
+	for _, reservation := range reservations {
+		if !reservation.Expired() {
+			if reservation.Confirmed {
+				return reservation, nil
+			}
+			continue
+		}
+
+		if err := r.DeleteID(reservation.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteUnconfirmed removes reservations of the email that weren't confirmed
+func (r *ReservationsTable) DeleteUnconfirmed(email string) error {
+	return r.Delete(map[string]interface{}{
+		"email":     email,
+		"confirmed": false,
+	})
+}
+
+// DeleteExpired removes all expired reservations
+func (r *ReservationsTable) DeleteExpired() error {
+	if isMemory(r) {
+		var reservations []*models.Reservation
+		if err := r.WhereAndFetch(map[string]interface{}{}, &reservations); err != nil {
+			return err
+		}
+
+		for _, reservation := range reservations {
+			if reservation.Expired() {
+				if err := r.DeleteID(reservation.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	err := r.GetTable().Between(
+		gorethink.MinVal,
+		time.Now(),
+		gorethink.BetweenOpts{Index: "expiry_date"},
+	).Delete().Exec(r.GetSession())
+	if err != nil {
+		return NewDatabaseError(r, err, "")
 	}
 
-	return true, nil
+	return nil
 }
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dancannon/gorethink"
+	"github.com/dancannon/gorethink/encoding"
+)
+
+// memorySequencesLock serializes the read-modify-write of in-memory counters
+var memorySequencesLock sync.Mutex
+
+// sequence is a counter of the change log of a single account
+type sequence struct {
+	ID    string `gorethink:"id"`
+	Value int64  `gorethink:"value"`
+}
+
+// SequencesTable stores the change log counters. Every account has its own
+// counter, so sequences of its changes are dense and gaps can be detected.
+type SequencesTable struct {
+	RethinkCRUD
+}
+
+// Current returns the last sequence reserved for the owner
+func (s *SequencesTable) Current(owner string) (int64, error) {
+	var result []*sequence
+	if err := s.FindByIndexFetch(&result, "id", owner); err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	return result[0].Value, nil
+}
+
+// Reserve atomically increments the counter of the owner by count and returns
+// the first reserved sequence.
+func (s *SequencesTable) Reserve(owner string, count int) (int64, error) {
+	if isMemory(s) {
+		memorySequencesLock.Lock()
+		defer memorySequencesLock.Unlock()
+
+		current, err := s.Current(owner)
+		if err != nil {
+			return 0, err
+		}
+
+		if current == 0 {
+			err = s.Insert(&sequence{ID: owner, Value: int64(count)})
+		} else {
+			err = s.UpdateID(owner, map[string]interface{}{"value": current + int64(count)})
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		return current + 1, nil
+	}
+
+	// A single document replace is atomic in RethinkDB
+	response, err := s.GetTable().Get(owner).Replace(func(row gorethink.Term) interface{} {
+		return gorethink.Branch(
+			row.Eq(nil),
+			map[string]interface{}{"id": owner, "value": count},
+			row.Merge(map[string]interface{}{"value": row.Field("value").Add(count)}),
+		)
+	}, gorethink.ReplaceOpts{ReturnChanges: true}).RunWrite(s.GetSession())
+	if err != nil {
+		return 0, NewDatabaseError(s, err, "")
+	}
+	if len(response.Changes) != 1 {
+		return 0, NewDatabaseError(s, errors.New("counter wasn't changed"), owner)
+	}
+
+	var result sequence
+	if err := encoding.Decode(&result, response.Changes[0].NewValue); err != nil {
+		return 0, NewDatabaseError(s, err, "")
+	}
+
+	return result.Value - int64(count) + 1, nil
+}
+
+// DeleteOwnedBy removes the counter of an account
+func (s *SequencesTable) DeleteOwnedBy(id string) error {
+	return s.Delete(map[string]interface{}{
+		"id": id,
+	})
+}
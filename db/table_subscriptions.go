@@ -0,0 +1,42 @@
+package db
+
+import (
+	"github.com/lavab/api/models"
+)
+
+// SubscriptionsTable stores subscriptions of accounts to paid plans
+type SubscriptionsTable struct {
+	RethinkCRUD
+}
+
+// GetByProviderID returns a subscription with specified provider's ID or nil
+func (s *SubscriptionsTable) GetByProviderID(id string) (*models.Subscription, error) {
+	var result []*models.Subscription
+
+	if err := s.FindByIndexFetch(&result, "provider_id", id); err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, nil
+	}
+
+	return result[0], nil
+}
+
+// GetCurrent returns a subscription of the owner that hasn't ended yet or nil
+func (s *SubscriptionsTable) GetCurrent(owner string) (*models.Subscription, error) {
+	var result []*models.Subscription
+
+	if err := s.FindByIndexFetch(&result, "owner", owner); err != nil {
+		return nil, err
+	}
+
+	for _, subscription := range result {
+		if subscription.IsCurrent() {
+			return subscription, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -10,6 +10,7 @@ import (
 
 type ThreadsTable struct {
 	RethinkCRUD
+	Emails *EmailsTable
 }
 
 func (t *ThreadsTable) GetThread(id string) (*models.Thread, error) {
@@ -22,6 +23,17 @@ func (t *ThreadsTable) GetThread(id string) (*models.Thread, error) {
 	return &result, nil
 }
 
+// GetBySubjectHash returns a thread of the owner with specified subject hash
+func (t *ThreadsTable) GetBySubjectHash(owner string, hash string) (*models.Thread, error) {
+	var result models.Thread
+
+	if err := t.FindByIndexFetchOne(&result, "subjectOwner", []interface{}{hash, owner}); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 func (t *ThreadsTable) GetOwnedBy(id string) ([]*models.Thread, error) {
 	var result []*models.Thread
 
@@ -45,92 +57,21 @@ func (t *ThreadsTable) CountOwnedBy(id string) (int, error) {
 	return t.FindByAndCount("owner", id)
 }
 
-func (t *ThreadsTable) List(
-	owner string,
-	sort []string,
-	offset int,
-	limit int,
-	labels []string,
-) ([]*models.Thread, error) {
-
-	term := t.GetTable()
-
-	if owner != "" {
-		term = t.GetTable().GetAllByIndex("owner", owner)
-	}
-
-	// If sort array has contents, parse them and add to the term
-	if sort != nil && len(sort) > 0 {
-		var conds []interface{}
-		for _, cond := range sort {
-			if cond[0] == '-' {
-				conds = append(conds, gorethink.Desc(cond[1:]))
-			} else if cond[0] == '+' || cond[0] == ' ' {
-				conds = append(conds, gorethink.Asc(cond[1:]))
-			} else {
-				conds = append(conds, gorethink.Asc(cond))
-			}
-		}
-
-		term = term.OrderBy(conds...)
-	}
-
+// List returns a page of threads owned by owner. Labels prefixed with "-" are
+// excluded, threads have to contain all the other ones.
+func (t *ThreadsTable) List(owner string, labels []string, page *Page) ([]*models.Thread, *PageResult, error) {
 	// Parse labels
-	hasLabels := []string{}
-	excLabels := []string{}
+	var filter *Filter
 	for _, label := range labels {
 		if label[0] == '-' {
-			excLabels = append(excLabels, label[1:])
+			filter = filter.And(fieldContains("labels", label[1:]).Not())
 		} else {
-			hasLabels = append(hasLabels, label)
+			filter = filter.And(fieldContains("labels", label))
 		}
 	}
 
-	// Transform that into a term
-	if len(hasLabels) > 0 || len(excLabels) > 0 {
-		var hasTerm gorethink.Term
-		if len(hasLabels) == 1 {
-			hasTerm = gorethink.Row.Field("labels").Contains(hasLabels[0])
-		} else if len(hasLabels) > 0 {
-			for i, label := range hasLabels {
-				if i == 0 {
-					hasTerm = gorethink.Row.Field("labels").Contains(label)
-				} else {
-					hasTerm = hasTerm.And(gorethink.Row.Field("labels").Contains(label))
-				}
-			}
-		}
-
-		var excTerm gorethink.Term
-		if len(excLabels) == 1 {
-			excTerm = gorethink.Not(gorethink.Row.Field("labels").Contains(excLabels[0]))
-		} else {
-			for i, label := range excLabels {
-				if i == 0 {
-					excTerm = gorethink.Not(gorethink.Row.Field("labels").Contains(label))
-				} else {
-					excTerm = excTerm.And(gorethink.Not(gorethink.Row.Field("labels").Contains(label)))
-				}
-			}
-		}
-
-		// Append them into the term
-		if len(hasLabels) > 0 && len(excLabels) > 0 {
-			term = term.Filter(hasTerm.And(excTerm))
-		} else if len(hasLabels) > 0 && len(excLabels) == 0 {
-			term = term.Filter(hasTerm)
-		} else if len(hasLabels) == 0 && len(excLabels) > 0 {
-			term = term.Filter(excTerm)
-		}
-	}
-
-	// Slice the result
-	if offset != 0 || limit != 0 {
-		term = term.Slice(offset, offset+limit)
-	}
-
 	// Add manifests
-	term = term.Map(func(thread gorethink.Term) gorethink.Term {
+	transform := func(thread gorethink.Term) gorethink.Term {
 		return thread.Merge(gorethink.DB(t.GetDBName()).Table("emails").Between([]interface{}{
 			thread.Field("id"),
 			time.Date(1990, time.January, 1, 23, 0, 0, 0, time.UTC),
@@ -141,37 +82,31 @@ func (t *ThreadsTable) List(
 			Index: "threadAndDate",
 		}).OrderBy(gorethink.OrderByOpts{Index: "threadAndDate"}).
 			Nth(0).Pluck("manifest"))
-	})
+	}
 
-	// Run the query
-	cursor, err := term.Run(t.GetSession())
+	var result []*models.Thread
+	info, err := paginate(t, owner, filter, transform, page, &result)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer cursor.Close()
 
-	// Fetch the cursor
-	var resp []*models.Thread
-	err = cursor.All(&resp)
-	if err != nil {
-		return nil, err
+	// In-memory tables don't run the transform
+	if isMemory(t) {
+		for _, thread := range result {
+			manifest, err := t.Emails.GetThreadManifest(thread.ID)
+			if err == nil {
+				thread.Manifest = manifest
+			}
+		}
 	}
 
-	return resp, nil
+	return result, info, nil
 }
 
 func (t *ThreadsTable) GetByLabel(label string) ([]*models.Thread, error) {
 	var result []*models.Thread
 
-	cursor, err := t.GetTable().Filter(func(row gorethink.Term) gorethink.Term {
-		return row.Field("labels").Contains(label)
-	}).GetAll().Run(t.GetSession())
-	if err != nil {
-		return nil, err
-	}
-
-	err = cursor.All(&result)
-	if err != nil {
+	if err := t.FindByIndexFetch(&result, "labels", label); err != nil {
 		return nil, err
 	}
 
@@ -179,38 +114,44 @@ func (t *ThreadsTable) GetByLabel(label string) ([]*models.Thread, error) {
 }
 
 func (t *ThreadsTable) CountByLabel(label string) (int, error) {
-	var result int
+	return t.countByLabel(label, false)
+}
 
-	cursor, err := t.GetTable().Filter(func(row gorethink.Term) gorethink.Term {
-		return row.Field("labels").Contains(label)
-	}).Count().Run(t.GetSession())
-	if err != nil {
-		return 0, err
-	}
+func (t *ThreadsTable) CountByLabelUnread(label string) (int, error) {
+	return t.countByLabel(label, true)
+}
 
-	err = cursor.One(&result)
-	if err != nil {
-		return 0, err
-	}
+// countByLabel counts threads with the label, optionally only the unread ones
+func (t *ThreadsTable) countByLabel(label string, unread bool) (int, error) {
+	if isMemory(t) {
+		threads, err := t.GetByLabel(label)
+		if err != nil {
+			return 0, err
+		}
 
-	return result, nil
-}
+		result := 0
+		for _, thread := range threads {
+			if !unread || !thread.IsRead {
+				result++
+			}
+		}
 
-func (t *ThreadsTable) CountByLabelUnread(label string) (int, error) {
-	var result int
+		return result, nil
+	}
+
+	term := t.GetTable().GetAllByIndex("labels", label)
+	if unread {
+		term = term.Filter(gorethink.Row.Field("is_read").Eq(false))
+	}
 
-	cursor, err := t.GetTable().Filter(func(row gorethink.Term) gorethink.Term {
-		return gorethink.And(
-			row.Field("labels").Contains(label),
-			row.Field("is_read").Eq(false),
-		)
-	}).Count().Run(t.GetSession())
+	cursor, err := term.Count().Run(t.GetSession())
 	if err != nil {
 		return 0, err
 	}
+	defer cursor.Close()
 
-	err = cursor.One(&result)
-	if err != nil {
+	var result int
+	if err := cursor.One(&result); err != nil {
 		return 0, err
 	}
 
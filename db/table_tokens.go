@@ -1,10 +1,9 @@
 package db
 
 import (
+	"sort"
 	"time"
 
-	"github.com/dancannon/gorethink"
-
 	"github.com/lavab/api/cache"
 	"github.com/lavab/api/models"
 )
@@ -45,26 +44,56 @@ func (t *TokensTable) UpdateID(id string, data interface{}) error {
 		return err
 	}
 
-	token, err := t.GetToken(id)
-	if err != nil {
+	// Skip the cache, it still contains the old version
+	var token models.Token
+	if err := t.RethinkCRUD.FindFetchOne(id, &token); err != nil {
 		return err
 	}
 
-	return t.Cache.Set(t.RethinkCRUD.GetTableName()+":"+id, token, t.Expires)
+	return t.Cache.Set(t.RethinkCRUD.GetTableName()+":"+id, &token, t.Expires)
+}
+
+// UpdateIDIf updates the token if it matches the condition and removes it
+// from the cache, so that the next read sees the result
+func (t *TokensTable) UpdateIDIf(id string, cond map[string]interface{}, data interface{}) (bool, error) {
+	updated, err := t.RethinkCRUD.UpdateIDIf(id, cond, data)
+	if err != nil {
+		return false, err
+	}
+
+	return updated, t.Cache.Delete(t.RethinkCRUD.GetTableName() + ":" + id)
 }
 
-// Delete removes from db and cache using filter
+// DeleteIDIf removes the token from db and cache if it matches the condition
+func (t *TokensTable) DeleteIDIf(id string, cond map[string]interface{}) (bool, error) {
+	deleted, err := t.RethinkCRUD.DeleteIDIf(id, cond)
+	if err != nil {
+		return false, err
+	}
+
+	return deleted, t.Cache.Delete(t.RethinkCRUD.GetTableName() + ":" + id)
+}
+
+// Delete removes from db and cache using filter, which is either a map of
+// field values or a *Filter
 func (t *TokensTable) Delete(cond interface{}) error {
-	result, err := t.GetTable().Filter(cond).Delete(gorethink.DeleteOpts{
-		ReturnChanges: true,
-	}).RunWrite(t.GetSession())
+	filter, err := toFilter(cond)
 	if err != nil {
+		return NewDatabaseError(t, err, "")
+	}
+
+	var tokens []*models.Token
+	if err := fetchMatching(t.RethinkCRUD, filter, &tokens); err != nil {
+		return err
+	}
+
+	if err := t.RethinkCRUD.Delete(filter); err != nil {
 		return err
 	}
 
 	var ids []interface{}
-	for _, change := range result.Changes {
-		ids = append(ids, t.RethinkCRUD.GetTableName()+":"+change.OldValue.(map[string]interface{})["id"].(string))
+	for _, token := range tokens {
+		ids = append(ids, t.RethinkCRUD.GetTableName()+":"+token.ID)
 	}
 
 	return t.Cache.DeleteMulti(ids...)
@@ -104,9 +133,244 @@ func (t *TokensTable) GetToken(id string) (*models.Token, error) {
 	return &result, nil
 }
 
+// GetOwnedByType returns unexpired tokens of the owner with specified type
+func (t *TokensTable) GetOwnedByType(owner string, kind string) ([]*models.Token, error) {
+	var tokens []*models.Token
+
+	if err := t.FindByIndexFetch(&tokens, "owner", owner); err != nil {
+		return nil, err
+	}
+
+	result := []*models.Token{}
+	for _, token := range tokens {
+		if token.Type == kind && !token.Expired() {
+			result = append(result, token)
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteOwnedByType deletes all tokens of the owner with specified type
+func (t *TokensTable) DeleteOwnedByType(owner string, kind string) error {
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "owner", owner); err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.Type != kind {
+			continue
+		}
+
+		if err := t.DeleteID(token.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // DeleteOwnedBy deletes all tokens owned by id
 func (t *TokensTable) DeleteOwnedBy(id string) error {
-	return t.Delete(map[string]interface{}{
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "owner", id); err != nil {
+		return err
+	}
+
+	if err := t.RethinkCRUD.Delete(map[string]interface{}{
 		"owner": id,
+	}); err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	ids := make([]interface{}, len(tokens))
+	for i, token := range tokens {
+		ids[i] = t.RethinkCRUD.GetTableName() + ":" + token.ID
+	}
+
+	return t.Cache.DeleteMulti(ids...)
+}
+
+// GetSessions returns active sessions of the owner, most recently used first.
+// Refreshable sessions are represented by their refresh tokens.
+func (t *TokensTable) GetSessions(owner string) ([]*models.Token, error) {
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "owner", owner); err != nil {
+		return nil, err
+	}
+
+	result := []*models.Token{}
+	for _, token := range tokens {
+		if token.Expired() {
+			continue
+		}
+
+		if token.Type == "refresh" || (token.Type == "auth" && token.Family == "") {
+			result = append(result, token)
+		}
+	}
+
+	sort.Sort(tokensByActivity(result))
+	return result, nil
+}
+
+// DeleteSessions removes auth and refresh tokens of the owner from the
+// database and the cache, except for the session passed as except. IDs of
+// the deleted tokens and their families are returned.
+func (t *TokensTable) DeleteSessions(owner string, except string) ([]string, error) {
+	deleted, err := t.deleteSessionTokens(owner, func(token *models.Token) bool {
+		return token.Client == "" && token.ID != except && (token.Family == "" || token.Family != except)
+	})
+	return SessionIDs(deleted), err
+}
+
+// DeleteFamily removes all auth and refresh tokens of a session or an OAuth grant
+func (t *TokensTable) DeleteFamily(owner string, family string) ([]string, error) {
+	deleted, err := t.deleteSessionTokens(owner, func(token *models.Token) bool {
+		return token.Family == family
+	})
+	return SessionIDs(deleted), err
+}
+
+// DeleteAllSessions removes all session tokens of the owner, including the
+// ones issued to OAuth clients, and returns the deleted tokens
+func (t *TokensTable) DeleteAllSessions(owner string) ([]*models.Token, error) {
+	return t.deleteSessionTokens(owner, func(token *models.Token) bool {
+		return true
 	})
 }
+
+// sessionTypes are the types of tokens used to access accounts
+var sessionTypes = map[string]struct{}{
+	"auth":           {},
+	"refresh":        {},
+	".refresh":       {},
+	"oauth":          {},
+	"oauth_refresh":  {},
+	".oauth_refresh": {},
+}
+
+// deleteSessionTokens removes session tokens of the owner that match the
+// filter and returns the deleted tokens
+func (t *TokensTable) deleteSessionTokens(owner string, filter func(*models.Token) bool) ([]*models.Token, error) {
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "owner", owner); err != nil {
+		return nil, err
+	}
+
+	deleted := []*models.Token{}
+	for _, token := range tokens {
+		if _, ok := sessionTypes[token.Type]; !ok {
+			continue
+		}
+
+		if !filter(token) {
+			continue
+		}
+
+		if err := t.DeleteID(token.ID); err != nil {
+			return deleted, err
+		}
+
+		deleted = append(deleted, token)
+	}
+
+	return deleted, nil
+}
+
+// SessionIDs returns the unique IDs and families of the tokens, which are
+// used to close their subscriptions
+func SessionIDs(tokens []*models.Token) []string {
+	ids := []string{}
+	seen := map[string]struct{}{}
+	for _, token := range tokens {
+		for _, id := range []string{token.ID, token.Family} {
+			if _, ok := seen[id]; id != "" && !ok {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return ids
+}
+
+// tokensByActivity sorts tokens from the most recently used
+type tokensByActivity []*models.Token
+
+func (b tokensByActivity) Len() int      { return len(b) }
+func (b tokensByActivity) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b tokensByActivity) Less(i, j int) bool {
+	return lastActivity(b[i]).After(lastActivity(b[j]))
+}
+
+// lastActivity returns the last usage of a token, or its creation date if
+// it wasn't used yet
+func lastActivity(token *models.Token) time.Time {
+	if token.DateLastUsed.IsZero() {
+		return token.DateCreated
+	}
+
+	return token.DateLastUsed
+}
+
+// GetConsent returns the consent given by the owner to an OAuth client or nil
+func (t *TokensTable) GetConsent(owner string, client string) (*models.Token, error) {
+	tokens, err := t.GetOwnedByType(owner, "consent")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if token.Client == client {
+			return token, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DeleteOwnedByClient removes all tokens that the owner gave to an OAuth
+// client, including the consent. IDs of the deleted tokens are returned.
+func (t *TokensTable) DeleteOwnedByClient(owner string, client string) ([]string, error) {
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "owner", owner); err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+	for _, token := range tokens {
+		if token.Client != client {
+			continue
+		}
+
+		if err := t.DeleteID(token.ID); err != nil {
+			return ids, err
+		}
+
+		ids = append(ids, token.ID)
+	}
+
+	return ids, nil
+}
+
+// DeleteByClient removes all tokens of an OAuth client and returns them
+func (t *TokensTable) DeleteByClient(client string) ([]*models.Token, error) {
+	var tokens []*models.Token
+	if err := t.FindByIndexFetch(&tokens, "client", client); err != nil {
+		return nil, err
+	}
+
+	for i, token := range tokens {
+		if err := t.DeleteID(token.ID); err != nil {
+			return tokens[:i], err
+		}
+	}
+
+	return tokens, nil
+}
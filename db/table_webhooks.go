@@ -0,0 +1,112 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/lavab/api/models"
+)
+
+// WebhooksTable stores webhook subscriptions
+type WebhooksTable struct {
+	RethinkCRUD
+}
+
+// GetWebhook returns a webhook with specified ID
+func (w *WebhooksTable) GetWebhook(id string) (*models.Webhook, error) {
+	var result models.Webhook
+
+	if err := w.FindFetchOne(id, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetOwnedBy returns all webhooks owned by id
+func (w *WebhooksTable) GetOwnedBy(id string) ([]*models.Webhook, error) {
+	var result []*models.Webhook
+
+	if err := w.FindByIndexFetch(&result, "owner", id); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// GetSubscribed returns enabled webhooks of the target subscribed to an event
+func (w *WebhooksTable) GetSubscribed(target string, event string) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+
+	if err := w.FindByIndexFetch(&webhooks, "targetType", []interface{}{target, event}); err != nil {
+		return nil, err
+	}
+
+	result := []*models.Webhook{}
+	for _, webhook := range webhooks {
+		if !webhook.Disabled {
+			result = append(result, webhook)
+		}
+	}
+
+	return result, nil
+}
+
+// DeleteOwnedBy deletes all webhooks owned by id
+func (w *WebhooksTable) DeleteOwnedBy(id string) error {
+	return w.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
+
+// WebhookDeliveriesTable is the log of events sent to webhooks
+type WebhookDeliveriesTable struct {
+	RethinkCRUD
+}
+
+// GetDelivery returns a delivery with specified ID
+func (w *WebhookDeliveriesTable) GetDelivery(id string) (*models.WebhookDelivery, error) {
+	var result models.WebhookDelivery
+
+	if err := w.FindFetchOne(id, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetByWebhook returns up to limit latest deliveries of a webhook
+func (w *WebhookDeliveriesTable) GetByWebhook(webhook string, limit int) ([]*models.WebhookDelivery, error) {
+	var result []*models.WebhookDelivery
+
+	if err := w.FindByIndexFetch(&result, "webhook", webhook); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(deliveriesByDate(result))
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+// DeleteByWebhook deletes the delivery log of a webhook
+func (w *WebhookDeliveriesTable) DeleteByWebhook(webhook string) error {
+	return w.Delete(map[string]interface{}{
+		"webhook": webhook,
+	})
+}
+
+// DeleteOwnedBy deletes all deliveries owned by id
+func (w *WebhookDeliveriesTable) DeleteOwnedBy(id string) error {
+	return w.Delete(map[string]interface{}{
+		"owner": id,
+	})
+}
+
+// deliveriesByDate sorts deliveries from the newest
+type deliveriesByDate []*models.WebhookDelivery
+
+func (d deliveriesByDate) Len() int           { return len(d) }
+func (d deliveriesByDate) Less(i, j int) bool { return d[i].DateCreated.After(d[j].DateCreated) }
+func (d deliveriesByDate) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
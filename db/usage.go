@@ -0,0 +1,47 @@
+package db
+
+import (
+	"github.com/dancannon/gorethink"
+)
+
+// storageUsage returns the total length of the fields of all documents owned
+// by owner. Missing fields count as empty.
+func storageUsage(table RethinkCRUD, owner string, fields ...string) (int, error) {
+	// Memory tables can't run the query, so the documents are summed up here
+	if isMemory(table) {
+		var documents []map[string]interface{}
+		if err := table.FindByIndexFetch(&documents, "owner", owner); err != nil {
+			return 0, err
+		}
+
+		result := 0
+		for _, document := range documents {
+			for _, field := range fields {
+				if value, ok := document[field].(string); ok {
+					result += len(value)
+				}
+			}
+		}
+
+		return result, nil
+	}
+
+	cursor, err := table.GetTable().GetAllByIndex("owner", owner).Map(func(row gorethink.Term) interface{} {
+		size := row.Field(fields[0]).Default("").Count()
+		for _, field := range fields[1:] {
+			size = size.Add(row.Field(field).Default("").Count())
+		}
+		return size
+	}).Sum().Run(table.GetSession())
+	if err != nil {
+		return 0, NewDatabaseError(table, err, "")
+	}
+	defer cursor.Close()
+
+	var result int
+	if err := cursor.One(&result); err != nil {
+		return 0, NewDatabaseError(table, err, "")
+	}
+
+	return result, nil
+}
@@ -7,8 +7,14 @@ type Flags struct {
 	LogFormatterType string
 	ForceColors      bool
 	EmailDomain      string
+	WebURL           string
+	TrustedProxies   string
 
-	SessionDuration int
+	SessionDuration     int
+	RememberMeDuration  int
+	AccessTokenDuration int
+	DeletionGracePeriod int
+	SyncRetention       int
 
 	RedisAddress  string
 	RedisDatabase int
@@ -17,6 +23,9 @@ type Flags struct {
 	RethinkDBAddress  string
 	RethinkDBKey      string
 	RethinkDBDatabase string
+	AutoMigrate       bool
+
+	MemoryBackend bool
 
 	LookupdAddress string
 	NSQdAddress    string
@@ -24,6 +33,12 @@ type Flags struct {
 	YubiCloudID  string
 	YubiCloudKey string
 
+	WebAuthnRPID    string
+	WebAuthnOrigins string
+
+	BillingProvider string
+	BillingSecret   string
+
 	SlackURL      string
 	SlackLevels   string
 	SlackChannel  string
@@ -1,12 +1,14 @@
 package env
 
 import (
+	"net"
+
 	"github.com/Sirupsen/logrus"
-	"github.com/bitly/go-nsq"
 	"github.com/dancannon/gorethink"
 	"github.com/getsentry/raven-go"
 	"github.com/willf/bloom"
 
+	"github.com/lavab/api/billing"
 	"github.com/lavab/api/cache"
 	"github.com/lavab/api/db"
 	"github.com/lavab/api/factor"
@@ -41,12 +43,40 @@ var (
 	Files *db.FilesTable
 	// Threads is the global instance of ThreadsTable
 	Threads *db.ThreadsTable
+	// Changes is the global instance of ChangesTable
+	Changes *db.ChangesTable
+	// Blobs is the global instance of BlobsTable
+	Blobs *db.BlobsTable
+	// Jobs is the global instance of JobsTable
+	Jobs *db.JobsTable
+	// Webhooks is the global instance of WebhooksTable
+	Webhooks *db.WebhooksTable
+	// WebhookDeliveries is the global instance of WebhookDeliveriesTable
+	WebhookDeliveries *db.WebhookDeliveriesTable
+	// OAuthClients is the global instance of OAuthClientsTable
+	OAuthClients *db.OAuthClientsTable
+	// AuditEvents is the global instance of AuditEventsTable
+	AuditEvents *db.AuditEventsTable
+	// Subscriptions is the global instance of SubscriptionsTable
+	Subscriptions *db.SubscriptionsTable
+	// Invoices is the global instance of InvoicesTable
+	Invoices *db.InvoicesTable
 	// Factors contains all currently registered factors
 	Factors map[string]factor.Factor
-	// Producer is the nsq producer used to send messages to other components of the system
-	Producer *nsq.Producer
+	// Billing is the payment provider, nil if billing is disabled
+	Billing billing.Provider
+	// Producer is used to send messages to other components of the system
+	Producer Publisher
 	// PasswordBF is the bloom filter used for leaked password matching
 	PasswordBF *bloom.BloomFilter
 	// Raven is the raven client used for reporting panics to Sentry
 	Raven *raven.Client
+	// TrustedProxies are the networks of reverse proxies allowed to set X-Real-IP
+	TrustedProxies []*net.IPNet
 )
+
+// Publisher sends messages to nsq topics. It's implemented by nsq.Producer
+// and by the in-process queue used with the memory backend.
+type Publisher interface {
+	Publish(topic string, body []byte) error
+}
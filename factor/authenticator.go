@@ -1,38 +1,167 @@
 package factor
 
 import (
-	"github.com/gokyle/hotp"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.google.com/p/rsc/qr"
+
+	"github.com/lavab/api/cache"
 )
 
+// Authenticator is an implementation of Factor using RFC 6238 time-based
+// one-time passwords, generated by apps like Google Authenticator
 type Authenticator struct {
+	// Issuer is shown in the authenticator apps
+	Issuer string
+
+	// Period is the lifetime of a single code
+	Period time.Duration
+
+	// Skew is the count of periods before and after the current one that are
+	// also accepted, to allow for clock drift
+	Skew int
+
 	length int
+	cache  cache.Cache
+	now    func() time.Time
 }
 
-func NewAuthenticator(length int) *Authenticator {
+// NewAuthenticator sets up a new TOTP factor generating codes of the passed
+// length. Used time steps are stored in the cache, so that codes can't be
+// replayed.
+func NewAuthenticator(length int, store cache.Cache) *Authenticator {
 	return &Authenticator{
+		Issuer: "Lavaboom",
+		Period: 30 * time.Second,
+		Skew:   1,
 		length: length,
+		cache:  store,
+		now:    time.Now,
 	}
 }
 
+// Type returns factor's type
 func (a *Authenticator) Type() string {
 	return "authenticator"
 }
 
+// Request does nothing in this driver, the codes are generated by the app
 func (a *Authenticator) Request(data string) (string, error) {
-	otp, err := hotp.GenerateHOTP(a.length, false)
+	return "", nil
+}
+
+// Verify checks the code against the secret stored in data[0]. Each time step
+// can be used only once. Secrets that aren't valid base32 never verify.
+func (a *Authenticator) Verify(data []string, input string) (bool, error) {
+	if len(data) == 0 || len(input) != a.length {
+		return false, nil
+	}
+
+	key, err := decodeSecret(data[0])
+	if err != nil || len(key) == 0 {
+		return false, nil
+	}
+
+	current := a.now().Unix() / int64(a.Period/time.Second)
+	for step := current - int64(a.Skew); step <= current+int64(a.Skew); step++ {
+		if subtle.ConstantTimeCompare([]byte(a.code(key, step)), []byte(input)) != 1 {
+			continue
+		}
+
+		return a.useStep(data[0], step)
+	}
+
+	return false, nil
+}
+
+// useStep records the time step as used. Only the first of concurrent
+// requests using the same step increments its counter to 1.
+func (a *Authenticator) useStep(secret string, step int64) (bool, error) {
+	hash := sha256.Sum256([]byte(secret))
+	key := "totp:" + hex.EncodeToString(hash[:]) + ":" + strconv.FormatInt(step, 10)
+
+	// The step can't be accepted after the drift window passes anyway
+	expires := a.Period * time.Duration(2*a.Skew+2)
+	uses, err := a.cache.Increment(key, 1, expires)
 	if err != nil {
+		return false, err
+	}
+
+	return uses == 1, nil
+}
+
+// code generates the code of a time step as described in RFC 4226
+func (a *Authenticator) code(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < a.length; i++ {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", a.length, value%modulo)
+}
+
+// GenerateSecret returns a new random base32-encoded secret
+func (a *Authenticator) GenerateSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
 		return "", err
 	}
 
-	return otp.URL(data), nil
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(key), "="), nil
 }
 
-func (a *Authenticator) Verify(data []string, input string) (bool, error) {
-	// obviously broken
-	hotp, err := hotp.Unmarshal([]byte(data[0]))
+// URI returns the otpauth:// URI that configures an authenticator app
+func (a *Authenticator) URI(secret string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", a.Issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprintf("%d", a.length))
+	query.Set("period", fmt.Sprintf("%d", int(a.Period/time.Second)))
+
+	// Spaces in the label have to be encoded as %20
+	label := strings.Replace(url.QueryEscape(a.Issuer+":"+account), "+", "%20", -1)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// QRCode returns a PNG image of the QR code encoding the URI
+func (a *Authenticator) QRCode(uri string) ([]byte, error) {
+	code, err := qr.Encode(uri, qr.M)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	return code.PNG(), nil
+}
+
+// decodeSecret decodes a base32 secret, with or without padding
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(secret, "="))
+	if n := len(secret) % 8; n != 0 {
+		secret += strings.Repeat("=", 8-n)
 	}
 
-	return hotp.Check(input), nil
+	return base32.StdEncoding.DecodeString(secret)
 }
@@ -0,0 +1,119 @@
+package factor
+
+import (
+	"encoding/base32"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lavab/api/cache"
+)
+
+// rfcSecret is the SHA1 seed of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// newTestAuthenticator returns an authenticator stopped at the time
+func newTestAuthenticator(length int, now time.Time) *Authenticator {
+	a := NewAuthenticator(length, cache.NewMemoryCache())
+	a.now = func() time.Time {
+		return now
+	}
+	return a
+}
+
+func TestAuthenticatorVectors(t *testing.T) {
+	// Appendix B of RFC 6238, SHA1 mode
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		a := newTestAuthenticator(8, time.Unix(v.time, 0))
+
+		ok, err := a.Verify([]string{rfcSecret}, v.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("code %s wasn't accepted at %d", v.code, v.time)
+		}
+	}
+}
+
+func TestAuthenticatorSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One period before and after the current one is accepted
+	for offset, accepted := range map[int64]bool{
+		-2: false,
+		-1: true,
+		0:  true,
+		1:  true,
+		2:  false,
+	} {
+		a := newTestAuthenticator(6, now)
+		code := a.code(key, now.Unix()/30+offset)
+
+		ok, err := a.Verify([]string{rfcSecret}, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != accepted {
+			t.Fatalf("code of step %+d: expected %v, got %v", offset, accepted, ok)
+		}
+	}
+}
+
+func TestAuthenticatorReplay(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	a := newTestAuthenticator(8, now)
+
+	if ok, err := a.Verify([]string{rfcSecret}, "89005924"); err != nil || !ok {
+		t.Fatalf("code wasn't accepted: %v", err)
+	}
+	if ok, err := a.Verify([]string{rfcSecret}, "89005924"); err != nil || ok {
+		t.Fatalf("code was replayed: %v", err)
+	}
+
+	// Only one of concurrent requests using the same code succeeds
+	key, err := decodeSecret(rfcSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := a.code(key, now.Unix()/30+1)
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		accepted int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if ok, err := a.Verify([]string{rfcSecret}, code); err == nil && ok {
+				lock.Lock()
+				accepted++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Fatalf("code was accepted %d times", accepted)
+	}
+}
@@ -0,0 +1,167 @@
+package factor
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxCBORDepth limits nesting of decoded CBOR items
+const maxCBORDepth = 16
+
+var errInvalidCBOR = errors.New("invalid CBOR data")
+
+// decodeCBOR decodes the first CBOR (RFC 7049) item of data and returns it
+// together with the remaining bytes. Only the subset used by WebAuthn is
+// supported: integers are decoded into int64, byte strings into []byte,
+// text strings into string, arrays into []interface{}, maps into
+// map[interface{}]interface{} and simple values into bool or nil.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	d := &cborDecoder{data: data}
+
+	value, err := d.value(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return value, data[d.pos:], nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte of an item and its argument
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errInvalidCBOR
+	}
+
+	major := d.data[d.pos] >> 5
+	info := d.data[d.pos] & 0x1f
+	d.pos++
+
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+
+	var size int
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		// Indefinite lengths aren't used by WebAuthn
+		return 0, 0, errInvalidCBOR
+	}
+
+	raw, err := d.read(uint64(size))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var buf [8]byte
+	copy(buf[8-size:], raw)
+	return major, binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// read returns the next n bytes
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errInvalidCBOR
+	}
+
+	result := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return result, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errInvalidCBOR
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errInvalidCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errInvalidCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		raw, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, raw...), nil
+	case 3:
+		raw, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(raw), nil
+	case 4:
+		// Every item takes at least a byte
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errInvalidCBOR
+		}
+
+		result := make([]interface{}, arg)
+		for i := range result {
+			if result[i], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errInvalidCBOR
+		}
+
+		result := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errInvalidCBOR
+			}
+
+			if result[key], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+
+	// Tags and floats aren't used by WebAuthn
+	return nil, errInvalidCBOR
+}
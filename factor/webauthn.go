@@ -0,0 +1,589 @@
+package factor
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lavab/api/cache"
+)
+
+// COSE algorithms supported by the WebAuthn factor
+const (
+	coseES256 = -7
+	coseRS256 = -257
+)
+
+// Flags of the authenticator data
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+var (
+	// ErrInvalidChallenge is returned if the challenge of a ceremony is
+	// unknown, expired or issued for a different ceremony
+	ErrInvalidChallenge = errors.New("invalid challenge")
+
+	// ErrInvalidCredential is returned if a credential can't be parsed or
+	// doesn't match the relying party
+	ErrInvalidCredential = errors.New("invalid credential")
+
+	// ErrInvalidSignature is returned if a signature doesn't match
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrUnsupportedAlgorithm is returned if a credential uses an algorithm
+	// that can't be verified
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+
+	// ErrUnsupportedAttestation is returned if the attestation statement
+	// format isn't supported
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+)
+
+// WebAuthn is an implementation of Factor using W3C Web Authentication
+// credentials, such as security keys and platform authenticators. Values of
+// the factor are the base64url-encoded credential ID, the COSE-encoded public
+// key and the signature counter.
+type WebAuthn struct {
+	// RPID is the relying party ID, a domain that the origins belong to
+	RPID string
+
+	// RPName is shown by the authenticators
+	RPName string
+
+	// Origins are the origins of the clients performing the ceremonies
+	Origins []string
+
+	// Timeout is the time a ceremony can take
+	Timeout time.Duration
+
+	cache cache.Cache
+}
+
+// NewWebAuthn sets up a new WebAuthn factor accepting ceremonies from the
+// passed origins. If rpID is empty, the host of the first origin is used.
+// Challenges are stored in the cache.
+func NewWebAuthn(rpID string, origins []string, store cache.Cache) (*WebAuthn, error) {
+	if len(origins) == 0 {
+		return nil, errors.New("no WebAuthn origins were passed")
+	}
+
+	if rpID == "" {
+		parsed, err := url.Parse(origins[0])
+		if err != nil {
+			return nil, err
+		}
+
+		rpID = parsed.Host
+		if i := strings.LastIndex(rpID, ":"); i != -1 {
+			rpID = rpID[:i]
+		}
+	}
+
+	return &WebAuthn{
+		RPID:    rpID,
+		RPName:  "Lavaboom",
+		Origins: origins,
+		Timeout: 5 * time.Minute,
+		cache:   store,
+	}, nil
+}
+
+// Type returns factor's type
+func (w *WebAuthn) Type() string {
+	return "webauthn"
+}
+
+// Request starts an authentication ceremony of the credential passed in data
+// and returns PublicKeyCredentialRequestOptions encoded in JSON
+func (w *WebAuthn) Request(data string) (string, error) {
+	challenge, err := w.challenge("assert:" + data)
+	if err != nil {
+		return "", err
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"challenge": challenge,
+		"rpId":      w.RPID,
+		"timeout":   int(w.Timeout / time.Millisecond),
+		"allowCredentials": []map[string]string{{
+			"type": "public-key",
+			"id":   data,
+		}},
+		"userVerification": "preferred",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(options), nil
+}
+
+// Verify checks the assertion passed as a JSON-encoded PublicKeyCredential.
+// The signature counter in data[2] is updated in place, so the values have
+// to be saved after a successful verification.
+func (w *WebAuthn) Verify(data []string, input string) (bool, error) {
+	if len(data) < 3 {
+		return false, nil
+	}
+
+	var credential struct {
+		RawID    string `json:"rawId"`
+		Type     string `json:"type"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AuthenticatorData string `json:"authenticatorData"`
+			Signature         string `json:"signature"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(input), &credential); err != nil || credential.Type != "public-key" {
+		return false, nil
+	}
+
+	// Assertions of other credentials are rejected before the challenge is used
+	rawID, err := decodeBase64URL(credential.RawID)
+	if err != nil {
+		return false, nil
+	}
+	storedID, err := decodeBase64URL(data[0])
+	if err != nil || !bytes.Equal(rawID, storedID) {
+		return false, nil
+	}
+
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		return false, nil
+	}
+	if err := w.checkClientData(clientDataJSON, "webauthn.get", "assert:"+data[0]); err != nil {
+		return false, nil
+	}
+
+	rawAuthData, err := decodeBase64URL(credential.Response.AuthenticatorData)
+	if err != nil {
+		return false, nil
+	}
+	authData, err := w.parseAuthData(rawAuthData)
+	if err != nil {
+		return false, nil
+	}
+
+	signature, err := decodeBase64URL(credential.Response.Signature)
+	if err != nil {
+		return false, nil
+	}
+
+	coseKey, err := decodeBase64URL(data[1])
+	if err != nil {
+		return false, err
+	}
+	alg, key, _, err := parseCOSEKey(coseKey)
+	if err != nil {
+		return false, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if err := verifySignature(alg, key, append(rawAuthData[:len(rawAuthData):len(rawAuthData)], clientDataHash[:]...), signature); err != nil {
+		return false, nil
+	}
+
+	// A counter that didn't increase means that the credential was cloned
+	stored, _ := strconv.ParseUint(data[2], 10, 32)
+	if (authData.signCount != 0 || stored != 0) && uint64(authData.signCount) <= stored {
+		return false, nil
+	}
+
+	data[2] = strconv.FormatUint(uint64(authData.signCount), 10)
+	return true, nil
+}
+
+// BeginRegistration starts a registration ceremony of a new credential of
+// the user and returns PublicKeyCredentialCreationOptions encoded in JSON.
+// Credentials in exclude can't be registered again.
+func (w *WebAuthn) BeginRegistration(userID string, userName string, exclude []string) (string, error) {
+	challenge, err := w.challenge("register:" + userID)
+	if err != nil {
+		return "", err
+	}
+
+	excluded := []map[string]string{}
+	for _, id := range exclude {
+		excluded = append(excluded, map[string]string{
+			"type": "public-key",
+			"id":   id,
+		})
+	}
+
+	options, err := json.Marshal(map[string]interface{}{
+		"challenge": challenge,
+		"rp": map[string]string{
+			"id":   w.RPID,
+			"name": w.RPName,
+		},
+		"user": map[string]string{
+			"id":          encodeBase64URL([]byte(userID)),
+			"name":        userName,
+			"displayName": userName,
+		},
+		"pubKeyCredParams": []map[string]interface{}{
+			{"type": "public-key", "alg": coseES256},
+			{"type": "public-key", "alg": coseRS256},
+		},
+		"timeout":            int(w.Timeout / time.Millisecond),
+		"attestation":        "none",
+		"excludeCredentials": excluded,
+		"authenticatorSelection": map[string]string{
+			"userVerification": "preferred",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(options), nil
+}
+
+// FinishRegistration verifies the JSON-encoded PublicKeyCredential created
+// by the authenticator and returns values of the new factor
+func (w *WebAuthn) FinishRegistration(userID string, input string) ([]string, error) {
+	var credential struct {
+		RawID    string `json:"rawId"`
+		Type     string `json:"type"`
+		Response struct {
+			ClientDataJSON    string `json:"clientDataJSON"`
+			AttestationObject string `json:"attestationObject"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(input), &credential); err != nil || credential.Type != "public-key" {
+		return nil, ErrInvalidCredential
+	}
+
+	clientDataJSON, err := decodeBase64URL(credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	if err := w.checkClientData(clientDataJSON, "webauthn.create", "register:"+userID); err != nil {
+		return nil, err
+	}
+
+	rawObject, err := decodeBase64URL(credential.Response.AttestationObject)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	decoded, _, err := decodeCBOR(rawObject)
+	if err != nil {
+		return nil, ErrInvalidCredential
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrInvalidCredential
+	}
+
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[interface{}]interface{})
+	rawAuthData, _ := object["authData"].([]byte)
+	if statement == nil || rawAuthData == nil {
+		return nil, ErrInvalidCredential
+	}
+
+	authData, err := w.parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.flags&flagAttested == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	rawID, err := decodeBase64URL(credential.RawID)
+	if err != nil || !bytes.Equal(rawID, authData.credentialID) {
+		return nil, ErrInvalidCredential
+	}
+
+	alg, key, _, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(rawAuthData[:len(rawAuthData):len(rawAuthData)], clientDataHash[:]...)
+
+	switch format {
+	case "none":
+		if len(statement) != 0 {
+			return nil, ErrInvalidCredential
+		}
+	case "packed":
+		if err := verifyPackedAttestation(statement, alg, key, signed); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedAttestation
+	}
+
+	return []string{
+		encodeBase64URL(authData.credentialID),
+		encodeBase64URL(authData.publicKey),
+		strconv.FormatUint(uint64(authData.signCount), 10),
+	}, nil
+}
+
+// challenge generates a new challenge of the ceremony
+func (w *WebAuthn) challenge(ceremony string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	challenge := encodeBase64URL(raw)
+	if err := w.cache.Set("webauthn:"+challenge, ceremony, w.Timeout); err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+// checkClientData verifies the client data of a ceremony and uses up its
+// challenge
+func (w *WebAuthn) checkClientData(clientDataJSON []byte, kind string, ceremony string) error {
+	var clientData struct {
+		Type      string `json:"type"`
+		Challenge string `json:"challenge"`
+		Origin    string `json:"origin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return ErrInvalidCredential
+	}
+
+	if clientData.Type != kind || !w.isOrigin(clientData.Origin) {
+		return ErrInvalidCredential
+	}
+
+	key := "webauthn:" + strings.TrimRight(clientData.Challenge, "=")
+
+	var stored string
+	if err := w.cache.Get(key, &stored); err != nil || stored != ceremony {
+		return ErrInvalidChallenge
+	}
+
+	// Challenges can be used only once
+	if err := w.cache.Delete(key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (w *WebAuthn) isOrigin(origin string) bool {
+	for _, allowed := range w.Origins {
+		if origin == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authenticatorData contains the parsed fields of the authenticator data
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthData parses the authenticator data and checks that it was
+// created for the relying party with the user present
+func (w *WebAuthn) parseAuthData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, ErrInvalidCredential
+	}
+
+	rpIDHash := sha256.Sum256([]byte(w.RPID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, ErrInvalidCredential
+	}
+
+	result := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if result.flags&flagUserPresent == 0 {
+		return nil, ErrInvalidCredential
+	}
+
+	if result.flags&flagAttested != 0 {
+		// AAGUID, length of the credential ID, the ID and the public key
+		rest := data[37:]
+		if len(rest) < 18 {
+			return nil, ErrInvalidCredential
+		}
+
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < length {
+			return nil, ErrInvalidCredential
+		}
+
+		result.credentialID = rest[:length]
+
+		_, _, remaining, err := parseCOSEKey(rest[length:])
+		if err != nil {
+			return nil, err
+		}
+
+		result.publicKey = rest[length : len(rest)-len(remaining)]
+	}
+
+	return result, nil
+}
+
+// parseCOSEKey decodes a COSE_Key (RFC 8152) from the start of data and
+// returns its algorithm, the public key and the remaining bytes
+func parseCOSEKey(data []byte) (int64, crypto.PublicKey, []byte, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, nil, ErrInvalidCredential
+	}
+
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, nil, ErrInvalidCredential
+	}
+
+	kty, _ := fields[int64(1)].(int64)
+	alg, _ := fields[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == coseES256:
+		crv, _ := fields[int64(-1)].(int64)
+		x, _ := fields[int64(-2)].([]byte)
+		y, _ := fields[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, nil, ErrInvalidCredential
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return 0, nil, nil, ErrInvalidCredential
+		}
+
+		return alg, key, rest, nil
+	case kty == 3 && alg == coseRS256:
+		n, _ := fields[int64(-1)].([]byte)
+		e, _ := fields[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, nil, ErrInvalidCredential
+		}
+
+		return alg, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, rest, nil
+	}
+
+	return 0, nil, nil, ErrUnsupportedAlgorithm
+}
+
+// verifySignature checks a signature made using a COSE algorithm
+func verifySignature(alg int64, key crypto.PublicKey, data []byte, signature []byte) error {
+	switch alg {
+	case coseES256:
+		key, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidSignature
+		}
+
+		// The signature is a DER-encoded sequence of r and s
+		var values struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(signature, &values)
+		if err != nil || len(rest) != 0 || values.R.Sign() <= 0 || values.S.Sign() <= 0 {
+			return ErrInvalidSignature
+		}
+
+		hash := sha256.Sum256(data)
+		if !ecdsa.Verify(key, hash[:], values.R, values.S) {
+			return ErrInvalidSignature
+		}
+	case coseRS256:
+		key, ok := key.(*rsa.PublicKey)
+		hash := sha256.Sum256(data)
+		if !ok || rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+
+	return nil
+}
+
+// verifyPackedAttestation checks the signature of a packed attestation
+// statement. Certificates aren't checked against trusted roots, the
+// attestation only proves that the authenticator holds the key.
+func verifyPackedAttestation(statement map[interface{}]interface{}, credentialAlg int64, credentialKey crypto.PublicKey, signed []byte) error {
+	alg, _ := statement["alg"].(int64)
+	signature, _ := statement["sig"].([]byte)
+	if signature == nil {
+		return ErrInvalidCredential
+	}
+
+	chain, ok := statement["x5c"].([]interface{})
+	if !ok {
+		// Self attestation is signed using the credential itself
+		if alg != credentialAlg {
+			return ErrInvalidCredential
+		}
+
+		return verifySignature(alg, credentialKey, signed, signature)
+	}
+
+	if len(chain) == 0 {
+		return ErrInvalidCredential
+	}
+
+	raw, _ := chain[0].([]byte)
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return ErrInvalidCredential
+	}
+
+	return verifySignature(alg, certificate.PublicKey, signed, signature)
+}
+
+// encodeBase64URL encodes data using base64url without padding
+func encodeBase64URL(data []byte) string {
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(data), "=")
+}
+
+// decodeBase64URL decodes base64url-encoded data, with or without padding
+func decodeBase64URL(data string) ([]byte, error) {
+	data = strings.TrimRight(data, "=")
+	if n := len(data) % 4; n != 0 {
+		data += strings.Repeat("=", 4-n)
+	}
+
+	return base64.URLEncoding.DecodeString(data)
+}
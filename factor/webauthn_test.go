@@ -0,0 +1,404 @@
+package factor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/lavab/api/cache"
+)
+
+// cborMap is a CBOR map encoded with its keys in the listed order
+type cborMap []interface{}
+
+// encodeCBOR encodes the subset of CBOR that the decoder supports
+func encodeCBOR(value interface{}) []byte {
+	head := func(major byte, arg uint64) []byte {
+		switch {
+		case arg < 24:
+			return []byte{major<<5 | byte(arg)}
+		case arg < 1<<8:
+			return []byte{major<<5 | 24, byte(arg)}
+		case arg < 1<<16:
+			return []byte{major<<5 | 25, byte(arg >> 8), byte(arg)}
+		}
+
+		buf := make([]byte, 9)
+		buf[0] = major<<5 | 27
+		binary.BigEndian.PutUint64(buf[1:], arg)
+		return buf
+	}
+
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []interface{}:
+		result := head(4, uint64(len(v)))
+		for _, item := range v {
+			result = append(result, encodeCBOR(item)...)
+		}
+		return result
+	case cborMap:
+		result := head(5, uint64(len(v)/2))
+		for _, item := range v {
+			result = append(result, encodeCBOR(item)...)
+		}
+		return result
+	case bool:
+		if v {
+			return []byte{0xf5}
+		}
+		return []byte{0xf4}
+	case nil:
+		return []byte{0xf6}
+	}
+
+	panic("unsupported CBOR value")
+}
+
+// softAuthenticator performs WebAuthn ceremonies using an ES256 key in memory
+type softAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	rpID    string
+	origin  string
+	counter uint32
+}
+
+func newSoftAuthenticator(t *testing.T, rpID string, origin string) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{
+		key:    key,
+		id:     []byte("credential-" + rpID),
+		rpID:   rpID,
+		origin: origin,
+	}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	coordinate := func(value *big.Int) []byte {
+		raw := value.Bytes()
+		return append(make([]byte, 32-len(raw)), raw...)
+	}
+
+	return encodeCBOR(cborMap{
+		1, 2,
+		3, coseES256,
+		-1, 1,
+		-2, coordinate(a.key.X),
+		-3, coordinate(a.key.Y),
+	})
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+
+	flags := byte(flagUserPresent)
+	if attested {
+		flags |= flagAttested
+	}
+	data = append(data, flags)
+
+	var counter [4]byte
+	binary.BigEndian.PutUint32(counter[:], a.counter)
+	data = append(data, counter[:]...)
+
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = append(data, byte(len(a.id)>>8), byte(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+func (a *softAuthenticator) clientData(kind string, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      kind,
+		"challenge": challenge,
+		"origin":    a.origin,
+	})
+	return data
+}
+
+func (a *softAuthenticator) sign(t *testing.T, authData []byte, clientData []byte) []byte {
+	clientDataHash := sha256.Sum256(clientData)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+// register creates a credential using the creation options
+func (a *softAuthenticator) register(t *testing.T, options string, format string) string {
+	challenge := readChallenge(t, options)
+	clientData := a.clientData("webauthn.create", challenge)
+	authData := a.authData(true)
+
+	statement := cborMap{}
+	if format == "packed" {
+		statement = cborMap{
+			"alg", coseES256,
+			"sig", a.sign(t, authData, clientData),
+		}
+	}
+
+	return a.credential(map[string]string{
+		"clientDataJSON": encodeBase64URL(clientData),
+		"attestationObject": encodeBase64URL(encodeCBOR(cborMap{
+			"fmt", format,
+			"attStmt", statement,
+			"authData", authData,
+		})),
+	})
+}
+
+// assert signs the challenge of the request options
+func (a *softAuthenticator) assert(t *testing.T, options string) string {
+	a.counter++
+
+	clientData := a.clientData("webauthn.get", readChallenge(t, options))
+	authData := a.authData(false)
+
+	return a.credential(map[string]string{
+		"clientDataJSON":    encodeBase64URL(clientData),
+		"authenticatorData": encodeBase64URL(authData),
+		"signature":         encodeBase64URL(a.sign(t, authData, clientData)),
+	})
+}
+
+func (a *softAuthenticator) credential(response map[string]string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"rawId":    encodeBase64URL(a.id),
+		"type":     "public-key",
+		"response": response,
+	})
+	return string(data)
+}
+
+func readChallenge(t *testing.T, options string) string {
+	var decoded struct {
+		Challenge string `json:"challenge"`
+	}
+	if err := json.Unmarshal([]byte(options), &decoded); err != nil || decoded.Challenge == "" {
+		t.Fatalf("invalid options %s: %v", options, err)
+	}
+	return decoded.Challenge
+}
+
+func newTestWebAuthn(t *testing.T) *WebAuthn {
+	w, err := NewWebAuthn("", []string{"https://mail.lavaboom.com"}, cache.NewMemoryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.RPID != "mail.lavaboom.com" {
+		t.Fatalf("unexpected relying party ID %s", w.RPID)
+	}
+	return w
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	w := newTestWebAuthn(t)
+
+	for _, format := range []string{"none", "packed"} {
+		authenticator := newSoftAuthenticator(t, w.RPID, w.Origins[0])
+
+		options, err := w.BeginRegistration("user", "alice", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values, err := w.FinishRegistration("user", authenticator.register(t, options, format))
+		if err != nil {
+			t.Fatalf("%s attestation: %v", format, err)
+		}
+		if len(values) != 3 || values[0] != encodeBase64URL(authenticator.id) || values[2] != "0" {
+			t.Fatalf("unexpected values %v", values)
+		}
+	}
+
+	// Challenges are bound to the user and can be used only once
+	authenticator := newSoftAuthenticator(t, w.RPID, w.Origins[0])
+	options, err := w.BeginRegistration("user", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := authenticator.register(t, options, "none")
+
+	if _, err := w.FinishRegistration("other", credential); err != ErrInvalidChallenge {
+		t.Fatalf("registered a credential of another user: %v", err)
+	}
+
+	options, err = w.BeginRegistration("user", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential = authenticator.register(t, options, "none")
+	if _, err := w.FinishRegistration("user", credential); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.FinishRegistration("user", credential); err != ErrInvalidChallenge {
+		t.Fatalf("registration was replayed: %v", err)
+	}
+
+	// Credentials of other relying parties and origins are rejected
+	for _, other := range []*softAuthenticator{
+		newSoftAuthenticator(t, "evil.com", w.Origins[0]),
+		newSoftAuthenticator(t, w.RPID, "https://evil.com"),
+	} {
+		options, err := w.BeginRegistration("user", "alice", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.FinishRegistration("user", other.register(t, options, "none")); err == nil {
+			t.Fatalf("registered a credential of %s at %s", other.rpID, other.origin)
+		}
+	}
+}
+
+func TestWebAuthnAssertion(t *testing.T) {
+	w := newTestWebAuthn(t)
+	authenticator := newSoftAuthenticator(t, w.RPID, w.Origins[0])
+
+	options, err := w.BeginRegistration("user", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := w.FinishRegistration("user", authenticator.register(t, options, "none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options, err = w.Request(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion := authenticator.assert(t, options)
+
+	if ok, err := w.Verify(values, assertion); !ok || err != nil {
+		t.Fatalf("valid assertion was rejected: %v", err)
+	}
+	if values[2] != "1" {
+		t.Fatalf("signature counter wasn't updated: %s", values[2])
+	}
+
+	// The challenge is used up
+	if ok, _ := w.Verify(values, assertion); ok {
+		t.Fatal("assertion was replayed")
+	}
+
+	// A counter that didn't increase means a cloned credential
+	options, err = w.Request(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator.counter--
+	if ok, _ := w.Verify(values, authenticator.assert(t, options)); ok {
+		t.Fatal("assertion with a stale counter was accepted")
+	}
+
+	// Signatures of other keys are rejected
+	options, err = w.Request(values[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := newSoftAuthenticator(t, w.RPID, w.Origins[0])
+	clone.counter = 10
+	if ok, _ := w.Verify(values, clone.assert(t, options)); ok {
+		t.Fatal("assertion signed by another key was accepted")
+	}
+
+	// Registration challenges can't be used for assertions
+	options, err = w.BeginRegistration("user", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := w.Verify(values, authenticator.assert(t, options)); ok {
+		t.Fatal("registration challenge was accepted in an assertion")
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	data := encodeCBOR(cborMap{
+		"int", 500,
+		"negative", -70000,
+		"bytes", []byte{1, 2, 3},
+		"array", []interface{}{true, false, nil},
+		-1, cborMap{1, "nested"},
+	})
+
+	decoded, rest, err := decodeCBOR(append(data, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 1 || rest[0] != 0xff {
+		t.Fatalf("unexpected remaining bytes %x", rest)
+	}
+
+	fields, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("unexpected value %#v", decoded)
+	}
+	if fields["int"] != int64(500) || fields["negative"] != int64(-70000) {
+		t.Fatalf("unexpected integers %#v", fields)
+	}
+	if bytes, _ := fields["bytes"].([]byte); len(bytes) != 3 || bytes[2] != 3 {
+		t.Fatalf("unexpected bytes %#v", fields["bytes"])
+	}
+	if array, _ := fields["array"].([]interface{}); len(array) != 3 || array[0] != true || array[1] != false || array[2] != nil {
+		t.Fatalf("unexpected array %#v", fields["array"])
+	}
+	if nested, _ := fields[int64(-1)].(map[interface{}]interface{}); nested[int64(1)] != "nested" {
+		t.Fatalf("unexpected nested map %#v", fields[int64(-1)])
+	}
+
+	deep := []byte{}
+	for i := 0; i <= maxCBORDepth+1; i++ {
+		deep = append(deep, 0x81)
+	}
+	deep = append(deep, 0x00)
+
+	for name, invalid := range map[string][]byte{
+		"empty":               {},
+		"truncated string":    {0x43, 1, 2},
+		"truncated argument":  {0x19, 1},
+		"indefinite length":   {0x9f, 0x00, 0xff},
+		"huge array":          {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"integer overflow":    {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"byte string map key": {0xa1, 0x41, 0x00, 0x00},
+		"float":               {0xf9, 0x3c, 0x00},
+		"tag":                 {0xc1, 0x00},
+		"too deep":            deep,
+	} {
+		if _, _, err := decodeCBOR(invalid); err == nil {
+			t.Errorf("%s was decoded", name)
+		}
+	}
+}
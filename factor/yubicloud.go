@@ -31,6 +31,11 @@ func (y *YubiCloud) Request(data string) (string, error) {
 
 // Verify checks if the token is valid
 func (y *YubiCloud) Verify(data []string, input string) (bool, error) {
+	// OTPs are prefixed with the 12 characters long public ID of the key
+	if len(input) <= 12 {
+		return false, nil
+	}
+
 	publicKey := input[:12]
 
 	found := false
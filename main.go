@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"os"
@@ -26,8 +27,14 @@ var (
 	logFormatterType = flag.String("log", "text", "Log formatter type. Either \"json\" or \"text\"")
 	forceColors      = flag.Bool("force_colors", false, "Force colored prompt?")
 	emailDomain      = flag.String("email_domain", "lavaboom.io", "Domain of the default email service")
+	webURL           = flag.String("web_url", "https://mail.lavaboom.com", "URL of the web client used in generated links")
+	trustedProxies   = flag.String("trusted_proxies", "", "Addresses or CIDR ranges of reverse proxies allowed to set X-Real-IP split by commas")
 	// Registration settings
-	sessionDuration = flag.Int("session_duration", 72, "Session duration expressed in hours")
+	sessionDuration     = flag.Int("session_duration", 72, "Session duration expressed in hours")
+	rememberMeDuration  = flag.Int("remember_me_duration", 720, "Default duration of remembered sessions expressed in hours")
+	accessTokenDuration = flag.Int("access_token_duration", 15, "Lifetime of access tokens expressed in minutes")
+	deletionGracePeriod = flag.Int("deletion_grace_period", 168, "Hours before a requested account deletion starts")
+	syncRetention       = flag.Int("sync_retention", 720, "Hours after which change log entries are removed and older sync tokens expire")
 	// Cache-related flags
 	redisAddress = flag.String("redis_address", func() string {
 		address := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
@@ -54,6 +61,8 @@ var (
 		}
 		return database
 	}(), "Database name on the RethinkDB server")
+	autoMigrate   = flag.Bool("auto_migrate", false, "Apply pending database migrations on startup")
+	memoryBackend = flag.Bool("memory_backend", false, "Use in-memory database and cache instead of RethinkDB and Redis")
 	// nsq and lookupd addresses
 	nsqdAddress = flag.String("nsqd_address", func() string {
 		address := os.Getenv("NSQD_PORT_4150_TCP_ADDR")
@@ -72,6 +81,12 @@ var (
 	// YubiCloud params
 	yubiCloudID  = flag.String("yubicloud_id", "", "YubiCloud API id")
 	yubiCloudKey = flag.String("yubicloud_key", "", "YubiCloud API key")
+
+	webAuthnRPID    = flag.String("webauthn_rp_id", "", "WebAuthn relying party ID, defaults to the host of the first origin")
+	webAuthnOrigins = flag.String("webauthn_origins", "", "Origins of the web clients allowed to use WebAuthn split by commas")
+	// Billing
+	billingProvider = flag.String("billing_provider", "", "Payment provider charging subscriptions, either \"fake\" or empty to disable billing")
+	billingSecret   = flag.String("billing_secret", "", "Secret used to verify callbacks of the payment provider")
 	// etcd
 	etcdAddress  = flag.String("etcd_address", "", "etcd peer addresses split by commas")
 	etcdCAFile   = flag.String("etcd_ca_file", "", "etcd path to server cert's ca")
@@ -102,8 +117,14 @@ func main() {
 		LogFormatterType: *logFormatterType,
 		ForceColors:      *forceColors,
 		EmailDomain:      *emailDomain,
+		WebURL:           *webURL,
+		TrustedProxies:   *trustedProxies,
 
-		SessionDuration: *sessionDuration,
+		SessionDuration:     *sessionDuration,
+		RememberMeDuration:  *rememberMeDuration,
+		AccessTokenDuration: *accessTokenDuration,
+		DeletionGracePeriod: *deletionGracePeriod,
+		SyncRetention:       *syncRetention,
 
 		RedisAddress:  *redisAddress,
 		RedisDatabase: *redisDatabase,
@@ -112,6 +133,9 @@ func main() {
 		RethinkDBAddress:  *rethinkdbAddress,
 		RethinkDBKey:      *rethinkdbKey,
 		RethinkDBDatabase: *rethinkdbDatabase,
+		AutoMigrate:       *autoMigrate,
+
+		MemoryBackend: *memoryBackend,
 
 		NSQdAddress:    *nsqdAddress,
 		LookupdAddress: *lookupdAddress,
@@ -119,6 +143,12 @@ func main() {
 		YubiCloudID:  *yubiCloudID,
 		YubiCloudKey: *yubiCloudKey,
 
+		WebAuthnRPID:    *webAuthnRPID,
+		WebAuthnOrigins: *webAuthnOrigins,
+
+		BillingProvider: *billingProvider,
+		BillingSecret:   *billingSecret,
+
 		SlackURL:      *slackURL,
 		SlackLevels:   *slackLevels,
 		SlackChannel:  *slackChannel,
@@ -131,6 +161,15 @@ func main() {
 		RavenDSN: *ravenDSN,
 	}
 
+	// Run the migrate command instead of the server if requested
+	if flag.Arg(0) == "migrate" {
+		if err := setup.RunMigrations(env.Config, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Generate a mux
 	mux := setup.PrepareMux(env.Config)
 
@@ -1,9 +1,15 @@
 package models
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
 	"github.com/gyepisam/mcf"
 	_ "github.com/gyepisam/mcf/scrypt" // Required to have mcf hash the password into scrypt
-	"github.com/lavab/api/factor"
 	"golang.org/x/crypto/openpgp"
 )
 
@@ -14,7 +20,6 @@ type Account struct {
 	StyledName string `json:"styled_name" gorethink:"styled_name"`
 
 	// Billing is a struct containing billing information.
-	// TODO Work in progress
 	Billing BillingData `json:"billing" gorethink:"billing"`
 
 	// Password is the password used to login to the account.
@@ -27,8 +32,16 @@ type Account struct {
 	// Settings contains data needed to customize the user experience.
 	Settings interface{} `json:"settings" gorethink:"settings"`
 
-	// Type is the account type.
-	// Examples (work in progress):
+	// RememberMe is the count of hours a remembered session lasts. If it's 0,
+	// the default duration is used.
+	RememberMe int `json:"remember_me" gorethink:"remember_me"`
+
+	// IdleTimeout is the count of minutes after which an unused session ends.
+	// If it's 0, sessions end only when they expire.
+	IdleTimeout int `json:"idle_timeout" gorethink:"idle_timeout"`
+
+	// Type is the account type, which determines the limits of the account.
+	// Subscriptions change it using the payment provider's callbacks.
 	//		* beta: while in beta these are full accounts; after beta, these are normal accounts with special privileges
 	//		* std: standard, free account
 	//		* premium: premium account
@@ -37,11 +50,48 @@ type Account struct {
 
 	AltEmail string `json:"alt_email" gorethink:"alt_email"`
 
-	FactorType  string   `json:"-" gorethink:"factor_type"`
-	FactorValue []string `json:"-" gorethink:"factor_value"`
+	// AltEmailVerified is true if the owner of AltEmail confirmed it
+	AltEmailVerified bool `json:"alt_email_verified" gorethink:"alt_email_verified"`
+
+	// PendingAltEmail is the new alt email waiting for a confirmation. Until it's
+	// confirmed, AltEmail is used.
+	PendingAltEmail string `json:"pending_alt_email,omitempty" gorethink:"pending_alt_email"`
+
+	// InvitedBy is the ID of the account that created the used invitation
+	InvitedBy string `json:"invited_by,omitempty" gorethink:"invited_by"`
+
+	// Factors are the second factors of the account. If there are any, one
+	// of them has to be used to log in.
+	Factors []*AccountFactor `json:"-" gorethink:"factors"`
 
+	// PendingAuthenticator is the TOTP secret waiting for the first code
+	PendingAuthenticator string `json:"-" gorethink:"pending_authenticator"`
+
+	// RecoveryCodes contains SHA256 hashes of unused one-time recovery codes
+	RecoveryCodes []string `json:"-" gorethink:"recovery_codes"`
+
+	// Devices are the devices that the account has logged in from
+	Devices []*AccountDevice `json:"-" gorethink:"devices"`
+
+	// PasswordResetRequired disables password logins until the password is reset
+	PasswordResetRequired bool `json:"password_reset_required" gorethink:"password_reset_required"`
+
+	// FactorReenrollmentRequired is set when an unusable second factor was
+	// removed. The owner is asked to set up 2FA again after logging in.
+	FactorReenrollmentRequired bool `json:"factor_reenrollment_required" gorethink:"factor_reenrollment_required"`
+
+	// Status is one of the Status* constants, changed using SetStatus
 	Status string `json:"status" gorethink:"status"`
 
+	// StatusHistory contains all changes of the status
+	StatusHistory []*AccountStatusChange `json:"status_history,omitempty" gorethink:"status_history"`
+
+	// Suspension describes why and by whom a suspended account was suspended
+	Suspension *AccountSuspension `json:"suspension,omitempty" gorethink:"suspension,omitempty"`
+
+	// DeletionDate is when the deletion of an account pending deletion starts
+	DeletionDate time.Time `json:"deletion_date,omitempty" gorethink:"deletion_date"`
+
 	Key *openpgp.Entity `json:"-" gorethink:"-"`
 }
 
@@ -86,30 +136,247 @@ func (a *Account) VerifyPassword(password string) (bool, bool, error) {
 	return true, false, nil
 }
 
-// Verify2FA verifies the 2FA token with the account settings.
-// Returns verified, challenge, error
-func (a *Account) Verify2FA(factor factor.Factor, token string) (bool, string, error) {
-	if token == "" {
-		req, err := factor.Request(a.ID)
-		if err != nil {
-			return false, "", err
+// GenerateRecoveryCodes replaces account's recovery codes with n new ones.
+// Only hashes are stored, so the codes are returned to be shown to the user.
+func (a *Account) GenerateRecoveryCodes(n int) []string {
+	codes, hashes := generateCodes(n)
+	a.RecoveryCodes = hashes
+	return codes
+}
+
+// UseRecoveryCode checks whether code is one of account's recovery codes and
+// removes it, so that it can't be used again
+func (a *Account) UseRecoveryCode(code string) bool {
+	var ok bool
+	a.RecoveryCodes, ok = useCode(a.RecoveryCodes, code)
+	return ok
+}
+
+// generateCodes returns n new one-time codes and their hashes
+func generateCodes(n int) ([]string, []string) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+
+	for i := range codes {
+		code := uniuri.NewLenChars(10, recoveryCodeChars)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes
+}
+
+// useCode looks up the hash of code in hashes and returns hashes without it
+func useCode(hashes []string, code string) ([]string, bool) {
+	hash := hashRecoveryCode(code)
+
+	for i, stored := range hashes {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			return append(hashes[:i:i], hashes[i+1:]...), true
 		}
+	}
+
+	return hashes, false
+}
+
+// recoveryCodeChars are the characters used in recovery codes
+var recoveryCodeChars = []byte("abcdefghijklmnopqrstuvwxyz0123456789")
 
-		return false, req, nil
+// hashRecoveryCode normalizes a recovery code and returns its hex-encoded SHA256 hash
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// AddFactor registers a new second factor of the account. Any factor except
+// backup codes completes a required re-enrollment.
+func (a *Account) AddFactor(kind string, name string, value []string) *AccountFactor {
+	factor := &AccountFactor{
+		ID:          uniuri.New(),
+		Type:        kind,
+		Name:        name,
+		Value:       value,
+		DateCreated: time.Now().UTC(),
 	}
 
-	ok, err := factor.Verify(a.FactorValue, token)
-	if err != nil {
-		return false, "", err
+	a.Factors = append(a.Factors, factor)
+	if kind != "backup_codes" {
+		a.FactorReenrollmentRequired = false
+	}
+
+	return factor
+}
+
+// GetFactor returns the factor with the passed ID or nil if there's none
+func (a *Account) GetFactor(id string) *AccountFactor {
+	for _, factor := range a.Factors {
+		if factor.ID == id {
+			return factor
+		}
+	}
+
+	return nil
+}
+
+// RemoveFactor removes the factor with the passed ID. Backup codes are
+// removed too once no other factor is left.
+func (a *Account) RemoveFactor(id string) bool {
+	removed := false
+	factors := []*AccountFactor{}
+	for _, factor := range a.Factors {
+		if factor.ID == id {
+			removed = true
+			continue
+		}
+
+		factors = append(factors, factor)
+	}
+
+	if len(factors) == 0 || (len(factors) == 1 && factors[0].Type == "backup_codes") {
+		factors = nil
 	}
 
-	return ok, "", nil
+	a.Factors = factors
+	return removed
+}
+
+// GenerateBackupCodes replaces account's 2FA backup codes with n new ones.
+// Only hashes are stored, so the codes are returned to be shown to the user.
+func (a *Account) GenerateBackupCodes(n int) []string {
+	codes, hashes := generateCodes(n)
+
+	for _, factor := range a.Factors {
+		if factor.Type == "backup_codes" {
+			factor.Value = hashes
+			factor.DateCreated = time.Now().UTC()
+			return codes
+		}
+	}
+
+	a.AddFactor("backup_codes", "Backup codes", hashes)
+	return codes
+}
+
+// UseBackupCode checks whether code is one of account's 2FA backup codes
+// and removes it, so that it can't be used again
+func (a *Account) UseBackupCode(code string) bool {
+	for _, factor := range a.Factors {
+		if factor.Type != "backup_codes" {
+			continue
+		}
+
+		var ok bool
+		factor.Value, ok = useCode(factor.Value, code)
+		if ok {
+			factor.DateLastUsed = time.Now().UTC()
+		}
+		return ok
+	}
+
+	return false
+}
+
+// AccountFactor is a second factor registered by an account
+type AccountFactor struct {
+	ID   string `json:"id" gorethink:"id"`
+	Type string `json:"type" gorethink:"type"`
+	Name string `json:"name" gorethink:"name"`
+
+	// Value is passed to the factor's driver. Backup codes store SHA256
+	// hashes of the unused codes.
+	Value []string `json:"-" gorethink:"value"`
+
+	// Remaining is the count of unused backup codes
+	Remaining int `json:"remaining,omitempty" gorethink:"-"`
+
+	DateCreated  time.Time `json:"date_created" gorethink:"date_created"`
+	DateLastUsed time.Time `json:"date_last_used,omitempty" gorethink:"date_last_used"`
+}
+
+// maxDevices is the count of remembered devices of an account
+const maxDevices = 50
+
+// HasDevice checks whether a login from the device was seen before
+func (a *Account) HasDevice(fingerprint string) bool {
+	for _, device := range a.Devices {
+		if device.Fingerprint == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SeeDevice records a login from the device and returns true if the device
+// wasn't seen before. The least recently seen device is forgotten when there
+// are too many of them.
+func (a *Account) SeeDevice(fingerprint string, name string, ip string) (*AccountDevice, bool) {
+	now := time.Now().UTC()
+
+	for _, device := range a.Devices {
+		if device.Fingerprint == fingerprint {
+			device.IP = ip
+			device.DateLastSeen = now
+			return device, false
+		}
+	}
+
+	if len(a.Devices) >= maxDevices {
+		oldest := 0
+		for i, device := range a.Devices {
+			if device.DateLastSeen.Before(a.Devices[oldest].DateLastSeen) {
+				oldest = i
+			}
+		}
+		a.Devices = append(a.Devices[:oldest], a.Devices[oldest+1:]...)
+	}
+
+	device := &AccountDevice{
+		Fingerprint:   fingerprint,
+		Name:          name,
+		IP:            ip,
+		DateFirstSeen: now,
+		DateLastSeen:  now,
+	}
+	a.Devices = append(a.Devices, device)
+	return device, true
+}
+
+// AccountDevice is a device that the account has logged in from
+type AccountDevice struct {
+	// Fingerprint is a hash of the user agent family and the IP network
+	Fingerprint string `json:"fingerprint" gorethink:"fingerprint"`
+
+	Name string `json:"name" gorethink:"name"`
+	IP   string `json:"ip" gorethink:"ip"`
+
+	DateFirstSeen time.Time `json:"date_first_seen" gorethink:"date_first_seen"`
+	DateLastSeen  time.Time `json:"date_last_seen" gorethink:"date_last_seen"`
+}
+
+// AccountSuspension is set on accounts suspended by an admin
+type AccountSuspension struct {
+	Reason string `json:"reason" gorethink:"reason"`
+
+	// By is the ID of the admin who suspended the account
+	By string `json:"-" gorethink:"by"`
+
+	Date time.Time `json:"date" gorethink:"date"`
 }
 
 // SettingsData TODO
 type SettingsData struct {
 }
 
-// BillingData TODO
+// BillingData links an account to its payment provider
 type BillingData struct {
+	// Provider is the name of the payment provider
+	Provider string `json:"provider,omitempty" gorethink:"provider"`
+
+	// Customer is the ID of the account at the provider
+	Customer string `json:"-" gorethink:"customer"`
+
+	// Subscription is the ID of the current subscription
+	Subscription string `json:"subscription,omitempty" gorethink:"subscription"`
 }
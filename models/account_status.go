@@ -0,0 +1,99 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Account statuses. An account goes through them in this order, but it can
+// be suspended or scheduled for a deletion on the way.
+const (
+	// StatusRegistered accounts wait in the beta queue
+	StatusRegistered = "registered"
+
+	// StatusInvited accounts have a verification code and can be set up
+	StatusInvited = "invited"
+
+	// StatusSetup accounts have a password, but weren't used yet
+	StatusSetup = "setup"
+
+	// StatusActive accounts have logged in at least once
+	StatusActive = "active"
+
+	// StatusSuspended accounts were suspended by an admin and can't be used
+	StatusSuspended = "suspended"
+
+	// StatusPendingDeletion accounts are read-only until the deletion starts
+	// or it's cancelled
+	StatusPendingDeletion = "pending_deletion"
+
+	// StatusDeleted accounts are being removed
+	StatusDeleted = "deleted"
+)
+
+// statusTransitions contains the statuses that an account can change to
+// from each status. Suspensions and cancelled deletions return to the
+// previous status.
+var statusTransitions = map[string][]string{
+	StatusRegistered:      {StatusInvited, StatusSuspended, StatusPendingDeletion},
+	StatusInvited:         {StatusSetup, StatusSuspended, StatusPendingDeletion},
+	StatusSetup:           {StatusActive, StatusSuspended, StatusPendingDeletion},
+	StatusActive:          {StatusSuspended, StatusPendingDeletion},
+	StatusSuspended:       {StatusRegistered, StatusInvited, StatusSetup, StatusActive},
+	StatusPendingDeletion: {StatusRegistered, StatusInvited, StatusSetup, StatusActive, StatusDeleted},
+	StatusDeleted:         {},
+}
+
+// ErrInvalidTransition is returned when an account can't change to a status
+var ErrInvalidTransition = errors.New("Invalid account status transition")
+
+// AccountStatusChange records a change of the account status
+type AccountStatusChange struct {
+	From   string    `json:"from" gorethink:"from"`
+	To     string    `json:"to" gorethink:"to"`
+	Reason string    `json:"reason,omitempty" gorethink:"reason,omitempty"`
+	Date   time.Time `json:"date" gorethink:"date"`
+}
+
+// CanSetStatus checks whether the account can change to the status
+func (a *Account) CanSetStatus(status string) bool {
+	for _, allowed := range statusTransitions[a.Status] {
+		if allowed == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetStatus changes the status of the account and records the change
+func (a *Account) SetStatus(status string, reason string) error {
+	if !a.CanSetStatus(status) {
+		return ErrInvalidTransition
+	}
+
+	a.StatusHistory = append(a.StatusHistory, &AccountStatusChange{
+		From:   a.Status,
+		To:     status,
+		Reason: reason,
+		Date:   time.Now().UTC(),
+	})
+	a.Status = status
+	return nil
+}
+
+// PreviousStatus returns the status before the last change, which is
+// restored when a suspension is lifted or a deletion cancelled
+func (a *Account) PreviousStatus() string {
+	if len(a.StatusHistory) == 0 {
+		return ""
+	}
+
+	return a.StatusHistory[len(a.StatusHistory)-1].From
+}
+
+// CanLogIn checks whether the account's status allows logging in.
+// Accounts pending deletion can log in to cancel it.
+func (a *Account) CanLogIn() bool {
+	return a.Status == StatusSetup || a.Status == StatusActive || a.Status == StatusPendingDeletion
+}
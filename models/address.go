@@ -4,4 +4,8 @@ type Address struct {
 	// ID is an unique string <val>@lavaboom.com
 	// Owner is the user whose address it is
 	Resource
+
+	// Quarantined addresses belong to deleted accounts. They can't be
+	// registered again, so that nobody receives the old owner's emails.
+	Quarantined bool `json:"quarantined,omitempty" gorethink:"quarantined"`
 }
@@ -0,0 +1,62 @@
+package models
+
+// Types of audit events
+const (
+	AuditLogin                 = "login"
+	AuditLoginFailed           = "login_failed"
+	AuditNewDevice             = "new_device"
+	AuditDeviceRejected        = "device_rejected"
+	AuditFactorFailed          = "factor_failed"
+	AuditRecoveryCodeFailed    = "recovery_code_failed"
+	AuditAccountLocked         = "account_locked"
+	AuditAccountUnlocked       = "account_unlocked"
+	AuditPasswordChanged       = "password_changed"
+	AuditPasswordReset         = "password_reset"
+	AuditAltEmailChanged       = "alt_email_changed"
+	AuditPublicKeyChanged      = "public_key_changed"
+	AuditKeyUploaded           = "key_uploaded"
+	AuditFactorAdded           = "factor_added"
+	AuditFactorRemoved         = "factor_removed"
+	AuditBackupCodesGenerated  = "backup_codes_generated"
+	AuditRecoveryCodesCreated  = "recovery_codes_generated"
+	AuditTokenRevoked          = "token_revoked"
+	AuditSessionsRevoked       = "sessions_revoked"
+	AuditAPITokenCreated       = "api_token_created"
+	AuditAPITokenRevoked       = "api_token_revoked"
+	AuditAccountApproved       = "account_approved"
+	AuditAccountSuspended      = "account_suspended"
+	AuditAccountUnsuspended    = "account_unsuspended"
+	AuditDeletionRequested     = "deletion_requested"
+	AuditDeletionCancelled     = "deletion_cancelled"
+	AuditSubscriptionCreated   = "subscription_created"
+	AuditSubscriptionRenewed   = "subscription_renewed"
+	AuditPaymentFailed         = "payment_failed"
+	AuditSubscriptionCancelled = "subscription_cancelled"
+	AuditSubscriptionEnded     = "subscription_ended"
+
+	// AuditAdminAction is recorded in the log of the admin, Details contain
+	// the action and its target
+	AuditAdminAction = "admin_action"
+)
+
+// AuditEvent records a security-related action on an account. Events are
+// only appended, never modified.
+type AuditEvent struct {
+	Resource
+
+	// Type is one of the Audit* constants
+	Type string `json:"type" gorethink:"type"`
+
+	// IP is the address of the client that made the request
+	IP string `json:"ip,omitempty" gorethink:"ip"`
+
+	// UserAgent of the client that made the request
+	UserAgent string `json:"user_agent,omitempty" gorethink:"user_agent"`
+
+	// RequestID is the ID assigned to the request by the API
+	RequestID string `json:"request_id,omitempty" gorethink:"request_id"`
+
+	// Details contains the type-specific information, such as the name of
+	// the added factor
+	Details map[string]interface{} `json:"details,omitempty" gorethink:"details"`
+}
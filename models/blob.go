@@ -0,0 +1,26 @@
+package models
+
+// BlobChunk is a part of a binary object stored in the database, e.g. an
+// account data export. Blobs are split into chunks, so that documents stay
+// small, and are shared by all API instances.
+type BlobChunk struct {
+	Expiring
+
+	// ID is the ID of the blob followed by the index of the chunk
+	ID string `json:"id" gorethink:"id"`
+
+	// Owner is the ID of the account that owns the blob
+	Owner string `json:"owner" gorethink:"owner"`
+
+	// Blob is the ID of the blob
+	Blob string `json:"blob" gorethink:"blob"`
+
+	// Index is the position of the chunk in the blob, starting at 0
+	Index int `json:"index" gorethink:"index"`
+
+	// Last is true for the final chunk of a completely written blob
+	Last bool `json:"last" gorethink:"last"`
+
+	// Data contains the bytes of the chunk
+	Data []byte `json:"data" gorethink:"data"`
+}
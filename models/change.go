@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+)
+
+// Change kinds recorded in the change log
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// Change is an entry of the change log used by delta sync. Deleted resources
+// are represented only by their change entries (tombstones).
+type Change struct {
+	// ID is an unique identifier of the change
+	ID string `json:"id" gorethink:"id"`
+
+	// Owner is the ID of the account that owns the changed resource
+	Owner string `json:"owner" gorethink:"owner"`
+
+	// Sequence orders changes of a single account. It also serves as the sync token.
+	Sequence int64 `json:"sequence" gorethink:"sequence"`
+
+	// Table is the name of the table containing the resource, e.g. "emails"
+	Table string `json:"table" gorethink:"table"`
+
+	// Resource is the ID of the changed resource
+	Resource string `json:"resource" gorethink:"resource"`
+
+	// Kind is one of "created", "updated" and "deleted"
+	Kind string `json:"kind" gorethink:"kind"`
+
+	// DateCreated is the time when the change happened
+	DateCreated time.Time `json:"date_created" gorethink:"date_created"`
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// Invoice statuses
+const (
+	InvoicePaid   = "paid"
+	InvoiceFailed = "failed"
+)
+
+// Invoice is a charge of a subscription period
+type Invoice struct {
+	Resource
+
+	// Subscription is the ID of the charged subscription
+	Subscription string `json:"subscription" gorethink:"subscription"`
+
+	// ProviderID is the ID of the invoice at the payment provider
+	ProviderID string `json:"-" gorethink:"provider_id"`
+
+	// Amount is in cents of the currency
+	Amount   int    `json:"amount" gorethink:"amount"`
+	Currency string `json:"currency" gorethink:"currency"`
+
+	// Status is either "paid" or "failed"
+	Status string `json:"status" gorethink:"status"`
+
+	PeriodStart time.Time `json:"period_start" gorethink:"period_start"`
+	PeriodEnd   time.Time `json:"period_end" gorethink:"period_end"`
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+)
+
+// Job types
+const (
+	JobAccountDelete = "account_delete"
+	JobAccountWipe   = "account_wipe"
+	JobAccountExport = "account_export"
+	JobAccountImport = "account_import"
+
+	// JobFactorReenrollment notifies the owner of an account that an unusable
+	// second factor was removed
+	JobFactorReenrollment = "factor_reenrollment"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a long-running task executed in the background, e.g. an account deletion.
+// Jobs consist of steps, completed steps are skipped when a job is resumed.
+type Job struct {
+	Resource
+
+	// Type is the kind of the job, e.g. "account_delete"
+	Type string `json:"type" gorethink:"type"`
+
+	// Status is one of "queued", "running", "done", "failed" and "cancelled"
+	Status string `json:"status" gorethink:"status"`
+
+	// DateScheduled is when a delayed job starts
+	DateScheduled time.Time `json:"date_scheduled,omitempty" gorethink:"date_scheduled"`
+
+	// Steps contains names of all steps of the job in order
+	Steps []string `json:"steps" gorethink:"steps"`
+
+	// Completed contains names of already finished steps
+	Completed []string `json:"completed" gorethink:"completed"`
+
+	// Total is the count of items handled by the job, e.g. imported messages
+	Total int `json:"total,omitempty" gorethink:"total"`
+
+	// Processed is the count of already handled items, including the failed ones
+	Processed int `json:"processed,omitempty" gorethink:"processed"`
+
+	// Failed is the count of items that couldn't be handled
+	Failed int `json:"failed,omitempty" gorethink:"failed"`
+
+	// Result is the outcome of a finished job, e.g. a download link
+	Result string `json:"result,omitempty" gorethink:"result"`
+
+	// Error is the message of the last failure
+	Error string `json:"error,omitempty" gorethink:"error"`
+
+	// Claim identifies the run of the job that is allowed to change it
+	Claim string `json:"-" gorethink:"claim"`
+
+	// DateClaimExpires is when another instance can take over a running job
+	DateClaimExpires time.Time `json:"-" gorethink:"date_claim_expires"`
+}
+
+// IsCompleted checks whether a step has already been finished
+func (j *Job) IsCompleted(step string) bool {
+	for _, completed := range j.Completed {
+		if completed == step {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinished returns true if the job won't be executed anymore
+func (j *Job) IsFinished() bool {
+	return j.Status == JobDone || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// IsDue returns true if a delayed job can be started
+func (j *Job) IsDue() bool {
+	return !time.Now().Before(j.DateScheduled)
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/dchest/uniuri"
+)
+
+// OAuthClient is a third-party application accessing accounts using OAuth 2.0.
+// Owner is the account that registered it, Name is shown to the users.
+type OAuthClient struct {
+	Resource
+
+	// Homepage is shown to the users when they're asked for consent
+	Homepage string `json:"homepage,omitempty" gorethink:"homepage"`
+
+	// RedirectURIs are the only URIs that authorization codes can be sent to
+	RedirectURIs []string `json:"redirect_uris" gorethink:"redirect_uris"`
+
+	// Confidential clients are able to keep a secret, public ones (e.g. mobile
+	// apps) rely only on PKCE
+	Confidential bool `json:"confidential" gorethink:"confidential"`
+
+	// Secret is the SHA256 hash of the client secret of confidential clients
+	Secret string `json:"-" gorethink:"secret"`
+}
+
+// GenerateSecret replaces the client's secret. The secret is returned only
+// here, as just its hash is stored.
+func (o *OAuthClient) GenerateSecret() string {
+	secret := uniuri.NewLen(40)
+	o.Secret = hashClientSecret(secret)
+	return secret
+}
+
+// VerifySecret checks the secret of a confidential client
+func (o *OAuthClient) VerifySecret(secret string) bool {
+	if o.Secret == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(o.Secret), []byte(hashClientSecret(secret))) == 1
+}
+
+// HasRedirectURI checks whether the URI was registered by the client
+func (o *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, registered := range o.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hashClientSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
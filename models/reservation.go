@@ -0,0 +1,17 @@
+package models
+
+// Reservation is an username reserved for an email before the account gets created.
+// Reservations are held only after the email is confirmed.
+type Reservation struct {
+	Resource
+	Expiring
+
+	// StyledName is the username as entered by the user, Name is its normalized form
+	StyledName string `json:"styled_name" gorethink:"styled_name"`
+
+	// Email is the address that is allowed to register the username
+	Email string `json:"email" gorethink:"email"`
+
+	// Confirmed is true once the email was confirmed using the emailed token
+	Confirmed bool `json:"confirmed" gorethink:"confirmed"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+// Subscription statuses
+const (
+	SubscriptionPending = "pending"
+	SubscriptionActive  = "active"
+	SubscriptionPastDue = "past_due"
+	SubscriptionEnded   = "ended"
+)
+
+// Subscription is a paid plan of an account, charged by a payment provider
+type Subscription struct {
+	Resource
+
+	// Plan is the ID of the subscribed plan
+	Plan string `json:"plan" gorethink:"plan"`
+
+	// Provider is the name of the payment provider
+	Provider string `json:"provider" gorethink:"provider"`
+
+	// ProviderID is the ID of the subscription at the provider
+	ProviderID string `json:"-" gorethink:"provider_id"`
+
+	// Status is one of "pending", "active", "past_due" and "ended"
+	Status string `json:"status" gorethink:"status"`
+
+	// Cancelled subscriptions end with the current period
+	Cancelled bool `json:"cancelled" gorethink:"cancelled"`
+
+	// PreviousType is the account type restored when the subscription ends
+	PreviousType string `json:"-" gorethink:"previous_type"`
+
+	PeriodStart time.Time `json:"period_start" gorethink:"period_start"`
+	PeriodEnd   time.Time `json:"period_end" gorethink:"period_end"`
+}
+
+// IsCurrent checks whether the subscription hasn't ended yet
+func (s *Subscription) IsCurrent() bool {
+	return s.Status != SubscriptionEnded
+}
@@ -1,14 +1,51 @@
 package models
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
 // Token is a volatile, unique object. It can be used for user authentication, confirmations, invites, etc.
 type Token struct {
 	Expiring
 	Resource
 
-	// Type describes the token's purpose: auth, invite, confirm, upgrade.
+	// Type describes the token's purpose: auth, invite, confirm, upgrade, export, reset, api, refresh,
+	// oauth, oauth_refresh, oauth_code, consent, device.
 	Type string `json:"type" gorethink:"type"`
+
+	// Scopes limit what an API token can access, e.g. "emails:read" or "contacts:*"
+	Scopes []string `json:"scopes,omitempty" gorethink:"scopes,omitempty"`
+
+	// DateLastUsed is the last time the token authenticated a request
+	DateLastUsed time.Time `json:"date_last_used,omitempty" gorethink:"date_last_used,omitempty"`
+
+	// IP, UserAgent and Device describe the client that created an auth token
+	IP        string `json:"ip,omitempty" gorethink:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty" gorethink:"user_agent,omitempty"`
+	Device    string `json:"device,omitempty" gorethink:"device,omitempty"`
+
+	// Family is the ID of the session that the auth and refresh tokens belong to.
+	// Refresh tokens are rotated, replaying a used one revokes the whole family.
+	Family string `json:"family,omitempty" gorethink:"family,omitempty"`
+
+	// SessionExpiryDate is when the session ends, no matter how often it's refreshed
+	SessionExpiryDate time.Time `json:"session_expiry_date,omitempty" gorethink:"session_expiry_date,omitempty"`
+
+	// Client is the ID of the OAuth client that the token was issued to
+	Client string `json:"client,omitempty" gorethink:"client,omitempty"`
+
+	// RedirectURI and CodeChallenge are checked when an authorization code is
+	// exchanged. CodeChallenge is the PKCE S256 challenge.
+	RedirectURI   string `json:"-" gorethink:"redirect_uri,omitempty"`
+	CodeChallenge string `json:"-" gorethink:"code_challenge,omitempty"`
 }
 
+// neverExpires is the lifetime of tokens that don't expire, in hours
+const neverExpires = 100 * 365 * 24
+
 // MakeToken creates a generic token.
 func MakeToken(accountID, _type string, nHours int) Token {
 	out := Token{
@@ -31,7 +68,167 @@ func MakeAuthToken(accountID string) Token {
 	return MakeToken(accountID, "auth", 80)
 }
 
+// MakeAPIToken creates a named personal access token limited to the scopes.
+// If nHours is 0, the token never expires.
+func MakeAPIToken(accountID string, name string, scopes []string, nHours int) Token {
+	if nHours == 0 {
+		nHours = neverExpires
+	}
+
+	out := MakeToken(accountID, "api", nHours)
+	out.Name = name
+	out.Scopes = scopes
+	return out
+}
+
+// PublicID returns the hex-encoded SHA256 hash of the token's ID. It
+// identifies the token without revealing the bearer secret.
+func (t *Token) PublicID() string {
+	hash := sha256.Sum256([]byte(t.ID))
+	return hex.EncodeToString(hash[:])
+}
+
+// IsScoped checks whether the token's access is limited by its scopes
+func (t *Token) IsScoped() bool {
+	return t.Type == "api" || t.Type == "oauth"
+}
+
+// HasScope checks whether the token grants the scope. "resource:*" grants
+// every action on the resource.
+func (t *Token) HasScope(scope string) bool {
+	resource := strings.SplitN(scope, ":", 2)[0]
+
+	for _, granted := range t.Scopes {
+		if granted == scope || granted == resource+":*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MakeRefreshToken creates a token used to obtain new auth tokens of the
+// session. It expires after idleTimeout minutes, but no later than the end of
+// the session. If family is empty, a new session is started.
+func MakeRefreshToken(accountID string, family string, sessionExpiry time.Time, idleTimeout int) Token {
+	out := Token{
+		Expiring:          Expiring{ExpiryDate: sessionExpiry},
+		Resource:          MakeResource(accountID, ""),
+		Type:              "refresh",
+		Family:            family,
+		SessionExpiryDate: sessionExpiry,
+	}
+
+	if out.Family == "" {
+		out.Family = out.ID
+	}
+
+	if idleTimeout > 0 {
+		if idle := time.Now().UTC().Add(time.Duration(idleTimeout) * time.Minute); idle.Before(sessionExpiry) {
+			out.ExpiryDate = idle
+		}
+	}
+
+	return out
+}
+
+// MakeOAuthCodeToken creates an authorization code of the OAuth client, valid
+// for 10 minutes.
+func MakeOAuthCodeToken(accountID string, client string, redirectURI string, challenge string, scopes []string) Token {
+	out := MakeToken(accountID, "oauth_code", 0)
+	out.ExpiryDate = time.Now().UTC().Add(10 * time.Minute)
+	out.Client = client
+	out.RedirectURI = redirectURI
+	out.CodeChallenge = challenge
+	out.Scopes = scopes
+	return out
+}
+
+// MakeOAuthToken creates an access token of the OAuth client. Family is the
+// ID of the authorization code that started the grant.
+func MakeOAuthToken(accountID string, client string, family string, scopes []string) Token {
+	out := MakeToken(accountID, "oauth", 1)
+	out.Client = client
+	out.Family = family
+	out.Scopes = scopes
+	return out
+}
+
+// MakeOAuthRefreshToken creates a refresh token of the OAuth client, which is
+// replaced each time it's used.
+func MakeOAuthRefreshToken(accountID string, client string, family string, scopes []string) Token {
+	out := MakeToken(accountID, "oauth_refresh", 30*24)
+	out.Client = client
+	out.Family = family
+	out.Scopes = scopes
+	return out
+}
+
+// MakeConsentToken records that the account allowed the OAuth client to access
+// the scopes. Consents don't expire.
+func MakeConsentToken(accountID string, client string, scopes []string) Token {
+	out := MakeToken(accountID, "consent", neverExpires)
+	out.Client = client
+	out.Scopes = scopes
+	return out
+}
+
 // MakeInviteToken creates an invitation to create an account.
 func MakeInviteToken(accountID string) Token {
 	return MakeToken(accountID, "invite", 240)
 }
+
+// MakeVerifyToken creates a code approving a registration from the beta queue.
+func MakeVerifyToken(accountID string) Token {
+	return MakeToken(accountID, "verify", 240)
+}
+
+// MakeConfirmToken creates a token confirming the ownership of an email.
+// Name of the token is the confirmed email.
+func MakeConfirmToken(accountID string, email string) Token {
+	out := MakeToken(accountID, "confirm", 48)
+	out.Name = email
+	return out
+}
+
+// MakeReservationToken creates a token confirming the email of a reservation.
+// Name of the token is the ID of the reservation.
+func MakeReservationToken(reservationID string) Token {
+	out := MakeToken("", "reservation", 24)
+	out.Name = reservationID
+	return out
+}
+
+// MakeResetToken creates a password reset token sent to account's alternative email.
+func MakeResetToken(accountID string) Token {
+	return MakeToken(accountID, "reset", 2)
+}
+
+// MakeDeviceToken creates a token returned by a login, which the device
+// passes when logging in again, so that it isn't locked out by failed logins
+// of others.
+func MakeDeviceToken(accountID string) Token {
+	return MakeToken(accountID, "device", 365*24)
+}
+
+// MakeUnlockToken creates a token unlocking an account locked after too many
+// failed logins. It's sent to account's alternative email.
+func MakeUnlockToken(accountID string) Token {
+	return MakeToken(accountID, "unlock", 24)
+}
+
+// MakeNotMeToken creates a "this wasn't me" link of a login from a new
+// device. Family is the session that the link revokes.
+func MakeNotMeToken(accountID string, family string) Token {
+	out := MakeToken(accountID, "not_me", 168)
+	out.Family = family
+	return out
+}
+
+// MakeExportToken creates a link to download an account's data export.
+// Name of the token is the ID of the export job.
+func MakeExportToken(accountID string, jobID string) Token {
+	out := MakeToken(accountID, "export", 48)
+	out.Name = jobID
+	return out
+}
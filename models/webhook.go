@@ -1,9 +1,85 @@
 package models
 
+import (
+	"time"
+)
+
+// Webhook events
+const (
+	EventDelivery     = "delivery"
+	EventReceipt      = "receipt"
+	EventThreadUpdate = "thread_update"
+	EventLabelCreate  = "label_create"
+	EventLabelUpdate  = "label_update"
+	EventLabelDelete  = "label_delete"
+)
+
+// WebhookEvents contains all events that can be subscribed to
+var WebhookEvents = []string{
+	EventDelivery,
+	EventReceipt,
+	EventThreadUpdate,
+	EventLabelCreate,
+	EventLabelUpdate,
+	EventLabelDelete,
+}
+
+// Webhook is a subscription of an URL to events of an account
 type Webhook struct {
 	Resource
 
-	Target  string `json:"target" gorethink:"target"`
-	Type    string `json:"type" gorethink:"type"`
+	// Target is the ID of the account whose events are sent
+	Target string `json:"target" gorethink:"target"`
+
+	// Type is the subscribed event, e.g. "delivery"
+	Type string `json:"type" gorethink:"type"`
+
+	// Address is the URL that receives POST requests with the events
 	Address string `json:"address" gorethink:"address"`
+
+	// Secret is the key of the HMAC-SHA256 signatures of the deliveries. It's
+	// returned only when the webhook is created.
+	Secret string `json:"-" gorethink:"secret"`
+
+	// Disabled webhooks don't receive any events
+	Disabled bool `json:"disabled" gorethink:"disabled"`
+
+	// Failures is the count of consecutive failed deliveries
+	Failures int `json:"failures" gorethink:"failures"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a log entry of a single event sent to a webhook
+type WebhookDelivery struct {
+	Resource
+
+	// Webhook is the ID of the receiving webhook
+	Webhook string `json:"webhook" gorethink:"webhook"`
+
+	// Event is the type of the sent event
+	Event string `json:"event" gorethink:"event"`
+
+	// Payload is the request body
+	Payload string `json:"payload" gorethink:"payload"`
+
+	// Status is one of "pending", "succeeded" and "failed"
+	Status string `json:"status" gorethink:"status"`
+
+	// Attempts is the count of requests made so far
+	Attempts int `json:"attempts" gorethink:"attempts"`
+
+	// ResponseCode is the HTTP status of the last response
+	ResponseCode int `json:"response_code,omitempty" gorethink:"response_code"`
+
+	// Error is the reason of the last failure
+	Error string `json:"error,omitempty" gorethink:"error"`
+
+	// DateDelivered is the time of the successful request
+	DateDelivered time.Time `json:"date_delivered,omitempty" gorethink:"date_delivered"`
 }
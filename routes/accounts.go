@@ -38,9 +38,10 @@ type AccountsCreateRequest struct {
 
 // AccountsCreateResponse contains the output of the AccountsCreate request.
 type AccountsCreateResponse struct {
-	Success bool            `json:"success"`
-	Message string          `json:"message"`
-	Account *models.Account `json:"account,omitempty"`
+	Success       bool            `json:"success"`
+	Message       string          `json:"message"`
+	Account       *models.Account `json:"account,omitempty"`
+	RecoveryCodes []string        `json:"recovery_codes,omitempty"`
 }
 
 // AccountsCreate creates a new account in the system.
@@ -65,10 +66,11 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 
 	// Accounts flow:
 	// 1) POST /accounts {username, alt_email}             => status = registered
+	//    POST /accounts {username, alt_email, invite_code} => status = registered, skips the beta queue
 	// 2) POST /accounts {username, invite_code}           => checks invite_code validity
 	// 3) POST /accounts {username, invite_code, password} => status = setup
 	requestType := "unknown"
-	if input.Username != "" && input.Password == "" && input.AltEmail != "" && input.InviteCode == "" {
+	if input.Username != "" && input.Password == "" && input.AltEmail != "" {
 		requestType = "register"
 	} else if input.Username != "" && input.Password == "" && input.AltEmail == "" && input.InviteCode != "" {
 		requestType = "verify"
@@ -90,7 +92,7 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 		input.Username = utils.NormalizeUsername(input.Username)
 
 		// Validate the username
-		if len(input.Username) < 3 || len(utils.RemoveDots(input.Username)) < 3 || len(input.Username) > 32 {
+		if !isValidUsername(input.Username) {
 			utils.JSONResponse(w, 400, &AccountsCreateResponse{
 				Success: false,
 				Message: "Invalid username - it has to be at least 3 and at max 32 characters long",
@@ -98,17 +100,35 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Ensure that the username is not used in address table
-		if used, err := env.Addresses.GetAddress(utils.RemoveDots(input.Username)); err == nil || used != nil {
-			utils.JSONResponse(w, 409, &AccountsCreateResponse{
-				Success: false,
-				Message: "Username already used",
-			})
-			return
+		// Check the invitation if an user was invited by someone
+		var invite *models.Token
+		if input.InviteCode != "" {
+			invite, err = env.Tokens.GetToken(input.InviteCode)
+			if err != nil || invite.Type != "invite" {
+				utils.JSONResponse(w, 400, &AccountsCreateResponse{
+					Success: false,
+					Message: "Invalid invitation code",
+				})
+				return
+			}
+
+			if invite.Expired() {
+				utils.JSONResponse(w, 400, &AccountsCreateResponse{
+					Success: false,
+					Message: "Expired invitation code",
+				})
+				return
+			}
 		}
 
-		// Then check it in the accounts table
-		if ok, err := env.Accounts.IsUsernameUsed(utils.RemoveDots(input.Username)); ok || err != nil {
+		// Ensure that the username is neither used nor reserved by someone else
+		if taken, err := isUsernameTaken(utils.RemoveDots(input.Username), input.AltEmail); taken || err != nil {
+			if err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to check whether an username is taken")
+			}
+
 			utils.JSONResponse(w, 409, &AccountsCreateResponse{
 				Success: false,
 				Message: "Username already used",
@@ -137,7 +157,39 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			StyledName: input.Username,
 			Type:       "beta", // Is this the proper value?
 			AltEmail:   input.AltEmail,
-			Status:     "registered",
+			Status:     models.StatusRegistered,
+		}
+		if invite != nil {
+			account.InvitedBy = invite.Owner
+			account.SetStatus(models.StatusInvited, "Invitation")
+
+			// Redeem the invitation before creating the account, so that it
+			// can't be used twice. It becomes a verification code of the account.
+			claimed, err := env.Tokens.UpdateIDIf(invite.ID, map[string]interface{}{
+				"type": "invite",
+			}, map[string]interface{}{
+				"type":  "verify",
+				"owner": account.ID,
+			})
+			if err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"id":    invite.ID,
+				}).Error("Unable to redeem an invitation")
+
+				utils.JSONResponse(w, 500, &AccountsCreateResponse{
+					Success: false,
+					Message: "Internal server error - AC/CR/04",
+				})
+				return
+			}
+			if !claimed {
+				utils.JSONResponse(w, 400, &AccountsCreateResponse{
+					Success: false,
+					Message: "Invalid invitation code",
+				})
+				return
+			}
 		}
 
 		// Try to save it in the database
@@ -150,6 +202,47 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			env.Log.WithFields(logrus.Fields{
 				"error": err.Error(),
 			}).Error("Could not insert an user into the database")
+
+			// Give the invitation back
+			if invite != nil {
+				if err := env.Tokens.UpdateID(invite.ID, map[string]interface{}{
+					"type":  "invite",
+					"owner": invite.Owner,
+				}); err != nil {
+					env.Log.WithFields(logrus.Fields{
+						"error": err.Error(),
+						"id":    invite.ID,
+					}).Error("Unable to restore a redeemed invitation")
+				}
+			}
+			return
+		}
+
+		// The reservation was used up
+		if reservation, err := env.Reservations.GetByName(account.Name); err == nil && reservation != nil {
+			if err := env.Reservations.DeleteID(reservation.ID); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"id":    reservation.ID,
+				}).Error("Unable to remove a used reservation")
+			}
+		}
+
+		// Ask the user to confirm the alt email
+		if err := sendEmailConfirmation(account, account.AltEmail); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    account.ID,
+			}).Error("Unable to send an email confirmation")
+		}
+
+		// Invited accounts skip the beta queue
+		if invite != nil {
+			utils.JSONResponse(w, 201, &AccountsCreateResponse{
+				Success: true,
+				Message: "Your invitation has been accepted",
+				Account: account,
+			})
 			return
 		}
 
@@ -229,8 +322,8 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Ensure that the account is "registered"
-		if account.Status != "registered" {
+		// Ensure that the account wasn't set up yet
+		if !account.CanSetStatus(models.StatusSetup) {
 			utils.JSONResponse(w, 403, &AccountsCreateResponse{
 				Success: true,
 				Message: "This account was already configured",
@@ -312,8 +405,8 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Ensure that the account is "registered"
-		if account.Status != "registered" {
+		// Ensure that the account wasn't set up yet
+		if !account.CanSetStatus(models.StatusSetup) {
 			utils.JSONResponse(w, 403, &AccountsCreateResponse{
 				Success: true,
 				Message: "This account was already configured",
@@ -351,7 +444,33 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		account.Status = "setup"
+		account.SetStatus(models.StatusSetup, "")
+
+		// Recovery codes are shown only once, in the response
+		recoveryCodes := account.GenerateRecoveryCodes(recoveryCodesCount)
+
+		// Ensure that the plan allows the address mapping
+		fits, err := hasAddressFor(account.ID)
+		if err != nil {
+			utils.JSONResponse(w, 500, &AccountsCreateResponse{
+				Success: false,
+				Message: "Internal server error - AC/CR/05",
+			})
+
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    account.ID,
+			}).Error("Unable to count addresses")
+			return
+		}
+
+		if !fits {
+			utils.JSONResponse(w, 403, &AccountsCreateResponse{
+				Success: false,
+				Message: "Your plan doesn't allow more addresses",
+			})
+			return
+		}
 
 		// Create labels
 		err = env.Labels.Insert([]*models.Label{
@@ -438,9 +557,10 @@ func AccountsCreate(w http.ResponseWriter, r *http.Request) {
 		}
 
 		utils.JSONResponse(w, 200, &AccountsCreateResponse{
-			Success: true,
-			Message: "Your account has been initialized successfully",
-			Account: account,
+			Success:       true,
+			Message:       "Your account has been initialized successfully",
+			Account:       account,
+			RecoveryCodes: recoveryCodes,
 		})
 		return
 	}
@@ -492,20 +612,22 @@ type AccountsUpdateRequest struct {
 	AltEmail        string      `json:"alt_email" schema:"alt_email"`
 	CurrentPassword string      `json:"current_password" schema:"current_password"`
 	NewPassword     string      `json:"new_password" schema:"new_password"`
-	FactorType      string      `json:"factor_type" schema:"factor_type"`
-	FactorValue     []string    `json:"factor_value" schema:"factor_value"`
+	FactorID        string      `json:"factor_id" schema:"factor_id"`
 	Token           string      `json:"token" schema:"token"`
 	Settings        interface{} `json:"settings" schema:"settings"`
 	PublicKey       string      `json:"public_key" schema:"public_key"`
+	RememberMe      *int        `json:"remember_me" schema:"remember_me"`
+	IdleTimeout     *int        `json:"idle_timeout" schema:"idle_timeout"`
 }
 
 // AccountsUpdateResponse contains the result of the AccountsUpdate request.
 type AccountsUpdateResponse struct {
-	Success         bool            `json:"success"`
-	Message         string          `json:"message,omitempty"`
-	Account         *models.Account `json:"account,omitempty"`
-	FactorType      string          `json:"factor_type,omitempty"`
-	FactorChallenge string          `json:"factor_challenge,omitempty"`
+	Success         bool                    `json:"success"`
+	Message         string                  `json:"message,omitempty"`
+	Account         *models.Account         `json:"account,omitempty"`
+	FactorType      string                  `json:"factor_type,omitempty"`
+	FactorChallenge string                  `json:"factor_challenge,omitempty"`
+	Factors         []*models.AccountFactor `json:"factors,omitempty"`
 }
 
 // AccountsUpdate allows changing the account's information (password etc.)
@@ -561,44 +683,36 @@ func AccountsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check for 2nd factor
-	if user.FactorType != "" {
-		factor, ok := env.Factors[user.FactorType]
-		if ok {
-			// Verify the 2FA
-			verified, challenge, err := user.Verify2FA(factor, input.Token)
-			if err != nil {
-				utils.JSONResponse(w, 500, &AccountsUpdateResponse{
-					Success: false,
-					Message: "Internal 2FA error",
-				})
+	if len(user.Factors) > 0 {
+		verified, challenge, err := verifySecondFactor(user, input.FactorID, input.Token)
+		if err != nil {
+			utils.JSONResponse(w, 500, &AccountsUpdateResponse{
+				Success: false,
+				Message: "Internal 2FA error",
+			})
 
-				env.Log.WithFields(logrus.Fields{
-					"err":    err.Error(),
-					"factor": user.FactorType,
-				}).Warn("2FA authentication error")
-				return
-			}
+			env.Log.WithFields(logrus.Fields{
+				"err":    err.Error(),
+				"factor": chosenFactorType(user, input.FactorID),
+			}).Warn("2FA authentication error")
+			return
+		}
 
-			// Token was probably empty. Return the challenge.
-			if !verified && challenge != "" {
-				utils.JSONResponse(w, 403, &AccountsUpdateResponse{
-					Success:         false,
-					Message:         "2FA token was not passed",
-					FactorType:      user.FactorType,
-					FactorChallenge: challenge,
-				})
-				return
+		if !verified {
+			// The client can pick one of the factors and retry
+			message := "Invalid token passed"
+			if input.Token == "" {
+				message = "2FA token was not passed"
 			}
 
-			// Token was incorrect
-			if !verified {
-				utils.JSONResponse(w, 403, &AccountsUpdateResponse{
-					Success:    false,
-					Message:    "Invalid token passed",
-					FactorType: user.FactorType,
-				})
-				return
-			}
+			utils.JSONResponse(w, 403, &AccountsUpdateResponse{
+				Success:         false,
+				Message:         message,
+				FactorType:      chosenFactorType(user, input.FactorID),
+				FactorChallenge: challenge,
+				Factors:         listFactors(user),
+			})
+			return
 		}
 	}
 
@@ -623,16 +737,67 @@ func AccountsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		}
+		user.PasswordResetRequired = false
 	}
 
-	if input.AltEmail != "" {
-		user.AltEmail = input.AltEmail
+	// A changed alt email has to be confirmed first, until then the old one stays in use
+	confirmEmail := ""
+	if input.AltEmail != "" && input.AltEmail != user.AltEmail {
+		if used, err := env.Accounts.IsEmailUsed(input.AltEmail); err != nil || used {
+			if err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to lookup registered accounts for emails")
+			}
+
+			utils.JSONResponse(w, 409, &AccountsUpdateResponse{
+				Success: false,
+				Message: "Email already used",
+			})
+			return
+		}
+
+		user.PendingAltEmail = input.AltEmail
+		confirmEmail = input.AltEmail
+	} else if input.AltEmail != "" {
+		// Setting the current email again cancels a pending change
+		user.PendingAltEmail = ""
+
+		if !user.AltEmailVerified {
+			// Send the confirmation again
+			confirmEmail = input.AltEmail
+		}
 	}
 
 	if input.Settings != nil {
 		user.Settings = input.Settings
 	}
 
+	if input.RememberMe != nil {
+		if *input.RememberMe < 0 || *input.RememberMe > maxRememberMe {
+			utils.JSONResponse(w, 400, &AccountsUpdateResponse{
+				Success: false,
+				Message: "Invalid remember me duration",
+			})
+			return
+		}
+
+		user.RememberMe = *input.RememberMe
+	}
+
+	if input.IdleTimeout != nil {
+		if *input.IdleTimeout < 0 || *input.IdleTimeout > maxIdleTimeout {
+			utils.JSONResponse(w, 400, &AccountsUpdateResponse{
+				Success: false,
+				Message: "Invalid idle timeout",
+			})
+			return
+		}
+
+		user.IdleTimeout = *input.IdleTimeout
+	}
+
+	var publicKeyChanged bool
 	if input.PublicKey != "" {
 		key, err := env.Keys.FindByFingerprint(input.PublicKey)
 		if err != nil {
@@ -662,26 +827,10 @@ func AccountsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		publicKeyChanged = input.PublicKey != user.PublicKey
 		user.PublicKey = input.PublicKey
 	}
 
-	if input.FactorType != "" {
-		// Check if such factor exists
-		if _, exists := env.Factors[input.FactorType]; !exists {
-			utils.JSONResponse(w, 400, &AccountsUpdateResponse{
-				Success: false,
-				Message: "Invalid new 2FA type",
-			})
-			return
-		}
-
-		user.FactorType = input.FactorType
-	}
-
-	if input.FactorValue != nil && len(input.FactorValue) > 0 {
-		user.FactorValue = input.FactorValue
-	}
-
 	user.DateModified = time.Now()
 
 	err = env.Accounts.UpdateID(session.Owner, user)
@@ -697,6 +846,35 @@ func AccountsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if input.NewPassword != "" {
+		recordAudit(c, r, user.ID, models.AuditPasswordChanged, nil)
+	}
+	if confirmEmail != "" {
+		recordAudit(c, r, user.ID, models.AuditAltEmailChanged, map[string]interface{}{
+			"email": confirmEmail,
+		})
+	}
+	if publicKeyChanged {
+		recordAudit(c, r, user.ID, models.AuditPublicKeyChanged, map[string]interface{}{
+			"fingerprint": user.PublicKey,
+		})
+	}
+
+	if confirmEmail != "" {
+		if err := sendEmailConfirmation(user, confirmEmail); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    user.ID,
+			}).Error("Unable to send an email confirmation")
+
+			utils.JSONResponse(w, 500, &AccountsUpdateResponse{
+				Success: false,
+				Message: "Internal error (code AC/UP/03)",
+			})
+			return
+		}
+	}
+
 	utils.JSONResponse(w, 200, &AccountsUpdateResponse{
 		Success: true,
 		Message: "Your account has been successfully updated",
@@ -706,11 +884,14 @@ func AccountsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 
 // AccountsDeleteResponse contains the result of the AccountsDelete request.
 type AccountsDeleteResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Job     *models.Job `json:"job,omitempty"`
 }
 
-// AccountsDelete deletes an account and everything related to it.
+// AccountsDelete schedules a deletion of an account and everything related to it.
+// The account is read-only during the grace period, in which the deletion can
+// be cancelled. Progress of the deletion can be checked using GET /jobs/:id.
 func AccountsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Get the account ID from the request
 	id := c.URLParams["id"]
@@ -737,56 +918,168 @@ func AccountsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Delete contacts
+	if err := user.SetStatus(models.StatusPendingDeletion, ""); err != nil {
+		utils.JSONResponse(w, 409, &AccountsDeleteResponse{
+			Success: false,
+			Message: "Your account is already being deleted",
+		})
+		return
+	}
+	user.DeletionDate = time.Now().UTC().Add(time.Duration(env.Config.DeletionGracePeriod) * time.Hour)
+	user.Touch()
+
+	// The job checks the status before deleting anything, so it's safe to
+	// schedule it before the account is updated
+	job, err := scheduleJob(user.ID, models.JobAccountDelete, user.DeletionDate)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"id":    user.ID,
+			"error": err.Error(),
+		}).Error("Unable to start an account deletion")
+
+		utils.JSONResponse(w, 500, &AccountsDeleteResponse{
+			Success: false,
+			Message: "Internal error (code AC/DE/05)",
+		})
+		return
+	}
+
+	if err := env.Accounts.UpdateID(user.ID, user); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"id":    user.ID,
+			"error": err.Error(),
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsDeleteResponse{
+			Success: false,
+			Message: "Internal error (code AC/DE/06)",
+		})
+		return
+	}
+
+	recordAudit(c, r, user.ID, models.AuditDeletionRequested, map[string]interface{}{
+		"deletion_date": user.DeletionDate,
+	})
 
-	// TODO: Delete emails
+	message := "Your account is being deleted"
+	if !job.IsDue() {
+		message = "Your account will be deleted on " + user.DeletionDate.Format(time.RFC1123)
+	}
 
-	// TODO: Delete labels
+	utils.JSONResponse(w, 202, &AccountsDeleteResponse{
+		Success: true,
+		Message: message,
+		Job:     job,
+	})
+}
+
+// AccountsCancelDeletion cancels a scheduled deletion of an account during
+// its grace period
+func AccountsCancelDeletion(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsDeleteResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+
+	user, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &AccountsDeleteResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return
+	}
 
-	// TODO: Delete threads
+	if user.Status != models.StatusPendingDeletion {
+		utils.JSONResponse(w, 409, &AccountsDeleteResponse{
+			Success: false,
+			Message: "Your account is not pending deletion",
+		})
+		return
+	}
 
-	// Delete tokens
-	err = env.Tokens.DeleteOwnedBy(user.ID)
+	job, err := env.Jobs.GetUnfinished(user.ID, models.JobAccountDelete)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"id":    user.ID,
 			"error": err.Error(),
-		}).Error("Unable to remove account's tokens")
+		}).Error("Unable to fetch an account deletion")
 
 		utils.JSONResponse(w, 500, &AccountsDeleteResponse{
 			Success: false,
-			Message: "Internal error (code AC/DE/05)",
+			Message: "Internal error (code AC/CD/01)",
 		})
 		return
 	}
 
-	// Delete account
-	err = env.Accounts.DeleteID(user.ID)
-	if err != nil {
+	if job != nil && job.Status != models.JobQueued {
+		utils.JSONResponse(w, 409, &AccountsDeleteResponse{
+			Success: false,
+			Message: "The deletion has already started",
+		})
+		return
+	}
+
+	if err := user.SetStatus(user.PreviousStatus(), "Deletion cancelled"); err != nil {
+		user.SetStatus(models.StatusActive, "Deletion cancelled")
+	}
+	user.DeletionDate = time.Time{}
+	user.Touch()
+
+	if err := env.Accounts.UpdateID(user.ID, user); err != nil {
 		env.Log.WithFields(logrus.Fields{
+			"id":    user.ID,
 			"error": err.Error(),
-		}).Error("Unable to delete an account")
+		}).Error("Unable to update an account")
 
 		utils.JSONResponse(w, 500, &AccountsDeleteResponse{
 			Success: false,
-			Message: "Internal error (code AC/DE/06)",
+			Message: "Internal error (code AC/CD/02)",
 		})
 		return
 	}
 
+	// The job would be cancelled when it starts anyway
+	if job != nil {
+		job.Status = models.JobCancelled
+		job.DateModified = time.Now()
+		if _, err := env.Jobs.UpdateIDIf(job.ID, map[string]interface{}{
+			"status": models.JobQueued,
+		}, map[string]interface{}{
+			"status":        job.Status,
+			"date_modified": job.DateModified,
+		}); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"id":    job.ID,
+				"error": err.Error(),
+			}).Error("Unable to cancel a job")
+		}
+	}
+
+	recordAudit(c, r, user.ID, models.AuditDeletionCancelled, nil)
+
 	utils.JSONResponse(w, 200, &AccountsDeleteResponse{
 		Success: true,
-		Message: "Your account has been successfully deleted",
+		Message: "The deletion of your account has been cancelled",
+		Job:     job,
 	})
 }
 
 // AccountsWipeDataResponse contains the result of the AccountsWipeData request.
 type AccountsWipeDataResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Job     *models.Job `json:"job,omitempty"`
 }
 
-// AccountsWipeData wipes all data except the actual account and billing info.
+// AccountsWipeData schedules a wipe of all data except the actual account, its
+// addresses, keys, builtin labels and billing info.
 func AccountsWipeData(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Get the account ID from the request
 	id := c.URLParams["id"]
@@ -819,21 +1112,13 @@ func AccountsWipeData(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// TODO: Delete contacts
-
-	// TODO: Delete emails
-
-	// TODO: Delete labels
-
-	// TODO: Delete threads
-
-	// Delete tokens
-	err = env.Tokens.DeleteOwnedBy(user.ID)
+	// Start the wipe job
+	job, err := startJob(user.ID, models.JobAccountWipe)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"id":    user.ID,
 			"error": err.Error(),
-		}).Error("Unable to remove account's tokens")
+		}).Error("Unable to start an account wipe")
 
 		utils.JSONResponse(w, 500, &AccountsWipeDataResponse{
 			Success: false,
@@ -842,9 +1127,10 @@ func AccountsWipeData(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.JSONResponse(w, 200, &AccountsWipeDataResponse{
+	utils.JSONResponse(w, 202, &AccountsWipeDataResponse{
 		Success: true,
-		Message: "Your account has been successfully wiped",
+		Message: "Your account is being wiped",
+		Job:     job,
 	})
 }
 
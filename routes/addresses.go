@@ -15,11 +15,23 @@ type AddressesListResponse struct {
 	Success   bool              `json:"success"`
 	Message   string            `json:"message,omitempty"`
 	Addresses []*models.Address `json:"addresses,omitempty"`
+	Next      string            `json:"next,omitempty"`
+	Prev      string            `json:"prev,omitempty"`
 }
 
 func AddressesList(c web.C, w http.ResponseWriter, r *http.Request) {
 	session := c.Env["token"].(*models.Token)
-	addresses, err := env.Addresses.GetOwnedBy(session.Owner)
+
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AddressesListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	addresses, result, err := env.Addresses.List(session.Owner, page)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -32,8 +44,11 @@ func AddressesList(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setTotalCount(w, result)
 	utils.JSONResponse(w, 200, &AddressesListResponse{
 		Success:   true,
 		Addresses: addresses,
+		Next:      result.Next,
+		Prev:      result.Prev,
 	})
 }
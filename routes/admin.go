@@ -0,0 +1,520 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// AdminMiddleware lets through only auth tokens of active superuser
+// accounts. It has to be used after AuthMiddleware. The admin's cached
+// *db.AccountStatus is put into c.Env["admin"].
+func AdminMiddleware(c *web.C, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := c.Env["token"].(*models.Token)
+		if session.Type != "auth" {
+			utils.JSONResponse(w, 403, &AuthMiddlewareResponse{
+				Success: false,
+				Message: "The admin API can be used only using an auth token",
+			})
+			return
+		}
+
+		account, err := env.Accounts.GetAccountStatus(session.Owner)
+		if err != nil || account.Type != "superuser" || account.Status == models.StatusSuspended {
+			utils.JSONResponse(w, 403, &AuthMiddlewareResponse{
+				Success: false,
+				Message: "Admin privileges required",
+			})
+			return
+		}
+
+		c.Env["admin"] = account
+		h.ServeHTTP(w, r)
+	})
+}
+
+// recordAdminAction appends an admin action to the audit log of the admin
+func recordAdminAction(c web.C, r *http.Request, action string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["action"] = action
+
+	admin := c.Env["admin"].(*db.AccountStatus)
+	recordAudit(c, r, admin.ID, models.AuditAdminAction, details)
+}
+
+// parseOffset reads the offset and limit parameters of admin lists
+func parseOffset(r *http.Request) (int, int, error) {
+	var (
+		query  = r.URL.Query()
+		offset = 0
+		limit  = db.DefaultPageLimit
+		err    error
+	)
+
+	if raw := query.Get("offset"); raw != "" {
+		offset, err = strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("Invalid offset")
+		}
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > db.MaxPageLimit {
+			return 0, 0, errors.New("Invalid limit")
+		}
+	}
+
+	return offset, limit, nil
+}
+
+// AdminAccountsResponse contains the result of the admin account requests.
+type AdminAccountsResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message,omitempty"`
+	Accounts []*models.Account `json:"accounts,omitempty"`
+	Account  *models.Account   `json:"account,omitempty"`
+	Token    string            `json:"token,omitempty"`
+	Usage    *StorageUsage     `json:"usage,omitempty"`
+}
+
+// adminSearch writes a page of accounts matching the query
+func adminSearch(w http.ResponseWriter, r *http.Request, query *db.AccountsQuery) bool {
+	var err error
+	query.Offset, query.Limit, err = parseOffset(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AdminAccountsResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return false
+	}
+
+	accounts, total, err := env.Accounts.Search(query)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to search accounts")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/LI/01)",
+		})
+		return false
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success:  true,
+		Accounts: accounts,
+	})
+	return true
+}
+
+// AdminAccountsList lists accounts, newest first. The query parameter
+// searches names and alt emails, status filters by the account status.
+func AdminAccountsList(c web.C, w http.ResponseWriter, r *http.Request) {
+	query := &db.AccountsQuery{
+		Query:  r.URL.Query().Get("query"),
+		Status: r.URL.Query().Get("status"),
+	}
+
+	if adminSearch(w, r, query) {
+		recordAdminAction(c, r, "search", map[string]interface{}{
+			"query":  query.Query,
+			"status": query.Status,
+		})
+	}
+}
+
+// AdminBetaQueue lists registered accounts waiting for an approval, oldest first
+func AdminBetaQueue(c web.C, w http.ResponseWriter, r *http.Request) {
+	query := &db.AccountsQuery{
+		Status:      models.StatusRegistered,
+		OldestFirst: true,
+	}
+
+	if adminSearch(w, r, query) {
+		recordAdminAction(c, r, "beta_queue", nil)
+	}
+}
+
+// adminTarget resolves the account that the admin request is about. If it
+// fails, the response is already written.
+func adminTarget(c web.C, w http.ResponseWriter) (*models.Account, bool) {
+	account, err := env.Accounts.GetAccount(c.URLParams["id"])
+	if err != nil {
+		utils.JSONResponse(w, 404, &AdminAccountsResponse{
+			Success: false,
+			Message: "Account not found",
+		})
+		return nil, false
+	}
+
+	return account, true
+}
+
+// AdminAccountsGet returns an account
+func AdminAccountsGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	recordAdminAction(c, r, "view", map[string]interface{}{
+		"account": account.ID,
+	})
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Account: account,
+	})
+}
+
+// AdminAccountsApprove approves a registration from the beta queue. The
+// verification code is returned and sent to the alt email using the
+// hook_account_approved topic.
+func AdminAccountsApprove(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	if account.Status != models.StatusRegistered {
+		utils.JSONResponse(w, 409, &AdminAccountsResponse{
+			Success: false,
+			Message: "This account is not in the beta queue",
+		})
+		return
+	}
+
+	account.SetStatus(models.StatusInvited, "Approved")
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"status":         account.Status,
+		"status_history": account.StatusHistory,
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/AP/03)",
+		})
+		return
+	}
+
+	// Only the latest code is valid
+	if err := env.Tokens.DeleteOwnedByType(account.ID, "verify"); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to remove old verification codes")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/AP/01)",
+		})
+		return
+	}
+
+	token := models.MakeVerifyToken(account.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to insert a verification code")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/AP/02)",
+		})
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account": account.ID,
+		"email":   account.AltEmail,
+		"token":   token.ID,
+	})
+	if err == nil {
+		err = env.Producer.Publish("hook_account_approved", data)
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to queue an approval email")
+	}
+
+	recordAdminAction(c, r, "approve", map[string]interface{}{
+		"account": account.ID,
+	})
+	recordAudit(c, r, account.ID, models.AuditAccountApproved, nil)
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Message: "The account has been approved",
+		Token:   token.ID,
+	})
+}
+
+// revokeAccess removes all sessions, OAuth grants and API tokens of the
+// account and returns the count of removed tokens
+func revokeAccess(owner string) (int, error) {
+	sessions, err := env.Tokens.DeleteAllSessions(owner)
+	closeSubscriptions(owner, db.SessionIDs(sessions)...)
+	if err != nil {
+		return 0, err
+	}
+
+	// Unused authorization codes would issue new OAuth tokens
+	if err := env.Tokens.DeleteOwnedByType(owner, "oauth_code"); err != nil {
+		return 0, err
+	}
+
+	tokens, err := env.Tokens.GetOwnedByType(owner, "api")
+	if err != nil {
+		return 0, err
+	}
+
+	if err := env.Tokens.DeleteOwnedByType(owner, "api"); err != nil {
+		return 0, err
+	}
+
+	closeSubscriptions(owner, db.SessionIDs(tokens)...)
+
+	return len(sessions) + len(tokens), nil
+}
+
+// AdminSuspendRequest contains the input for the AdminAccountsSuspend endpoint.
+type AdminSuspendRequest struct {
+	Reason string `json:"reason" schema:"reason"`
+}
+
+// AdminAccountsSuspend suspends an account and ends all its sessions.
+// Suspended accounts can't log in.
+func AdminAccountsSuspend(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AdminSuspendRequest
+	if err := utils.ParseRequest(r, &input); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AdminAccountsResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	if input.Reason == "" || len(input.Reason) > 256 {
+		utils.JSONResponse(w, 400, &AdminAccountsResponse{
+			Success: false,
+			Message: "Invalid reason - it has to be at least 1 and at max 256 characters long",
+		})
+		return
+	}
+
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	if account.Type == "superuser" {
+		utils.JSONResponse(w, 403, &AdminAccountsResponse{
+			Success: false,
+			Message: "Admin accounts can't be suspended",
+		})
+		return
+	}
+
+	if err := account.SetStatus(models.StatusSuspended, input.Reason); err != nil {
+		utils.JSONResponse(w, 409, &AdminAccountsResponse{
+			Success: false,
+			Message: "Accounts with status " + account.Status + " can't be suspended",
+		})
+		return
+	}
+
+	admin := c.Env["admin"].(*db.AccountStatus)
+	account.Suspension = &models.AccountSuspension{
+		Reason: input.Reason,
+		By:     admin.ID,
+		Date:   time.Now().UTC(),
+	}
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/SU/01)",
+		})
+		return
+	}
+
+	if _, err := revokeAccess(account.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to revoke tokens of a suspended account")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/SU/02)",
+		})
+		return
+	}
+
+	recordAdminAction(c, r, "suspend", map[string]interface{}{
+		"account": account.ID,
+		"reason":  input.Reason,
+	})
+	recordAudit(c, r, account.ID, models.AuditAccountSuspended, map[string]interface{}{
+		"reason": input.Reason,
+	})
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Message: "The account has been suspended",
+		Account: account,
+	})
+}
+
+// AdminAccountsUnsuspend lifts the suspension of an account
+func AdminAccountsUnsuspend(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	if account.Status != models.StatusSuspended {
+		utils.JSONResponse(w, 409, &AdminAccountsResponse{
+			Success: false,
+			Message: "The account is not suspended",
+		})
+		return
+	}
+
+	if err := account.SetStatus(account.PreviousStatus(), ""); err != nil {
+		// Accounts suspended before statuses were recorded were in use
+		account.SetStatus(models.StatusActive, "")
+	}
+	account.Suspension = nil
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"status":         account.Status,
+		"status_history": account.StatusHistory,
+		"suspension":     nil,
+		"date_modified":  account.DateModified,
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/UN/01)",
+		})
+		return
+	}
+
+	recordAdminAction(c, r, "unsuspend", map[string]interface{}{
+		"account": account.ID,
+	})
+	recordAudit(c, r, account.ID, models.AuditAccountUnsuspended, nil)
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Message: "The suspension has been lifted",
+		Account: account,
+	})
+}
+
+// AdminAccountsLogout ends all sessions of an account and revokes its OAuth
+// grants and API tokens
+func AdminAccountsLogout(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	count, err := revokeAccess(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to revoke tokens of an account")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/LO/01)",
+		})
+		return
+	}
+
+	recordAdminAction(c, r, "logout", map[string]interface{}{
+		"account": account.ID,
+	})
+	recordAudit(c, r, account.ID, models.AuditSessionsRevoked, map[string]interface{}{
+		"count": count,
+		"admin": true,
+	})
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Message: "All sessions of the account have been revoked",
+	})
+}
+
+// AdminAccountsUsage returns the storage used by an account
+func AdminAccountsUsage(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := adminTarget(c, w)
+	if !ok {
+		return
+	}
+
+	usage, err := storageUsage(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to compute storage usage")
+
+		utils.JSONResponse(w, 500, &AdminAccountsResponse{
+			Success: false,
+			Message: "Internal error (code AD/US/01)",
+		})
+		return
+	}
+
+	recordAdminAction(c, r, "usage", map[string]interface{}{
+		"account": account.ID,
+	})
+
+	utils.JSONResponse(w, 200, &AdminAccountsResponse{
+		Success: true,
+		Usage:   usage,
+	})
+}
@@ -0,0 +1,275 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// maxAPITokens is the count of API tokens that an account can have
+const maxAPITokens = 50
+
+// APIToken describes an API token without its secret. ID is the token's
+// public ID, which is used to revoke it.
+type APIToken struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Scopes       []string  `json:"scopes"`
+	DateCreated  time.Time `json:"date_created"`
+	DateLastUsed time.Time `json:"date_last_used,omitempty"`
+	ExpiryDate   time.Time `json:"expiry_date"`
+}
+
+// newAPIToken hides the secret of an API token
+func newAPIToken(token *models.Token) *APIToken {
+	return &APIToken{
+		ID:           token.PublicID(),
+		Name:         token.Name,
+		Scopes:       token.Scopes,
+		DateCreated:  token.DateCreated,
+		DateLastUsed: token.DateLastUsed,
+		ExpiryDate:   token.ExpiryDate,
+	}
+}
+
+// findAPIToken returns the API token of the owner with the public ID
+func findAPIToken(owner string, id string) (*models.Token, error) {
+	tokens, err := env.Tokens.GetOwnedByType(owner, "api")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		if token.PublicID() == id {
+			return token, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// APITokensListResponse contains the result of the APITokensList request.
+type APITokensListResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message,omitempty"`
+	Tokens  []*APIToken `json:"tokens,omitempty"`
+}
+
+// APITokensList returns the unexpired API tokens of the current user. Secrets
+// of the tokens aren't returned.
+func APITokensList(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	tokens, err := env.Tokens.GetOwnedByType(session.Owner, "api")
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch API tokens")
+
+		utils.JSONResponse(w, 500, &APITokensListResponse{
+			Success: false,
+			Message: "Internal error (code AT/LI/01)",
+		})
+		return
+	}
+
+	result := make([]*APIToken, len(tokens))
+	for i, token := range tokens {
+		result[i] = newAPIToken(token)
+	}
+
+	utils.JSONResponse(w, 200, &APITokensListResponse{
+		Success: true,
+		Tokens:  result,
+	})
+}
+
+// APITokensCreateRequest contains the input for the APITokensCreate endpoint.
+type APITokensCreateRequest struct {
+	Name      string   `json:"name" schema:"name"`
+	Scopes    []string `json:"scopes" schema:"scopes"`
+	ExpiresIn int      `json:"expires_in" schema:"expires_in"`
+}
+
+// APITokensCreateResponse contains the result of the APITokensCreate request.
+// Secret is the bearer token, it's returned only once.
+type APITokensCreateResponse struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message,omitempty"`
+	Token   *APIToken `json:"token,omitempty"`
+	Secret  string    `json:"secret,omitempty"`
+}
+
+// APITokensCreate creates a new API token with the passed scopes. ExpiresIn
+// is the token's lifetime in hours, tokens without it never expire.
+func APITokensCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input APITokensCreateRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &APITokensCreateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+
+	// API tokens can't create other API tokens
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &APITokensCreateResponse{
+			Success: false,
+			Message: "API tokens can be created only using an auth token",
+		})
+		return
+	}
+
+	if input.Name == "" || len(input.Name) > 64 {
+		utils.JSONResponse(w, 400, &APITokensCreateResponse{
+			Success: false,
+			Message: "Invalid token name - it has to be at max 64 characters long",
+		})
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		utils.JSONResponse(w, 400, &APITokensCreateResponse{
+			Success: false,
+			Message: "No scopes were passed",
+		})
+		return
+	}
+
+	for _, scope := range input.Scopes {
+		if !isValidScope(scope) {
+			utils.JSONResponse(w, 400, &APITokensCreateResponse{
+				Success: false,
+				Message: "Invalid scope " + scope,
+			})
+			return
+		}
+	}
+
+	if input.ExpiresIn < 0 {
+		utils.JSONResponse(w, 400, &APITokensCreateResponse{
+			Success: false,
+			Message: "Invalid expiry time",
+		})
+		return
+	}
+
+	tokens, err := env.Tokens.GetOwnedByType(session.Owner, "api")
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch API tokens")
+
+		utils.JSONResponse(w, 500, &APITokensCreateResponse{
+			Success: false,
+			Message: "Internal error (code AT/CR/01)",
+		})
+		return
+	}
+
+	if len(tokens) >= maxAPITokens {
+		utils.JSONResponse(w, 403, &APITokensCreateResponse{
+			Success: false,
+			Message: "Too many API tokens",
+		})
+		return
+	}
+
+	token := models.MakeAPIToken(session.Owner, input.Name, input.Scopes, input.ExpiresIn)
+	if err := env.Tokens.Insert(&token); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert an API token")
+
+		utils.JSONResponse(w, 500, &APITokensCreateResponse{
+			Success: false,
+			Message: "Internal error (code AT/CR/02)",
+		})
+		return
+	}
+
+	recordAudit(c, r, session.Owner, models.AuditAPITokenCreated, map[string]interface{}{
+		"name":   token.Name,
+		"scopes": token.Scopes,
+	})
+
+	utils.JSONResponse(w, 201, &APITokensCreateResponse{
+		Success: true,
+		Token:   newAPIToken(&token),
+		Secret:  token.ID,
+	})
+}
+
+// APITokensDeleteResponse contains the result of the APITokensDelete request.
+type APITokensDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// APITokensDelete revokes an API token of the current user using its public ID
+func APITokensDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	token, err := findAPIToken(session.Owner, c.URLParams["id"])
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch API tokens")
+
+		utils.JSONResponse(w, 500, &APITokensDeleteResponse{
+			Success: false,
+			Message: "Internal error (code AT/DE/02)",
+		})
+		return
+	}
+
+	if token == nil {
+		utils.JSONResponse(w, 404, &APITokensDeleteResponse{
+			Success: false,
+			Message: "API token not found",
+		})
+		return
+	}
+
+	if err := env.Tokens.DeleteID(token.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to delete an API token")
+
+		utils.JSONResponse(w, 500, &APITokensDeleteResponse{
+			Success: false,
+			Message: "Internal error (code AT/DE/01)",
+		})
+		return
+	}
+
+	closeSubscriptions(token.Owner, token.ID)
+
+	recordAudit(c, r, token.Owner, models.AuditAPITokenRevoked, map[string]interface{}{
+		"name": token.Name,
+	})
+
+	utils.JSONResponse(w, 200, &APITokensDeleteResponse{
+		Success: true,
+		Message: "API token successfully revoked",
+	})
+}
@@ -0,0 +1,113 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+	"github.com/zenazn/goji/web/middleware"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// recordAudit appends a security event to the audit log of the account and
+// sends it to the account's subscribed sessions as an audit_event. Failures
+// are only logged, the request goes on.
+func recordAudit(c web.C, r *http.Request, owner string, kind string, details map[string]interface{}) {
+	event := &models.AuditEvent{
+		Resource:  models.MakeResource(owner, ""),
+		Type:      kind,
+		IP:        utils.RequestIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: middleware.GetReqID(c),
+		Details:   details,
+	}
+
+	if err := env.AuditEvents.Insert(event); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+			"type":  kind,
+		}).Error("Unable to record an audit event")
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":  "audit_event",
+		"owner": owner,
+		"event": event,
+	})
+	if err == nil {
+		err = env.Producer.Publish("account_events", data)
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+		}).Error("Unable to publish an audit event")
+	}
+}
+
+// AccountsAuditListResponse contains the result of the AccountsAuditList request.
+type AccountsAuditListResponse struct {
+	Success bool                 `json:"success"`
+	Message string               `json:"message,omitempty"`
+	Events  []*models.AuditEvent `json:"events,omitempty"`
+	Next    string               `json:"next,omitempty"`
+	Prev    string               `json:"prev,omitempty"`
+}
+
+// AccountsAuditList returns a page of the account's audit log, newest first
+func AccountsAuditList(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsAuditListResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &AccountsAuditListResponse{
+			Success: false,
+			Message: "The audit log can be read only using an auth token",
+		})
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AccountsAuditListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	events, result, err := env.AuditEvents.List(session.Owner, page)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch audit events")
+
+		utils.JSONResponse(w, 500, &AccountsAuditListResponse{
+			Success: false,
+			Message: "Internal error (code AC/AL/01)",
+		})
+		return
+	}
+
+	setTotalCount(w, result)
+	utils.JSONResponse(w, 200, &AccountsAuditListResponse{
+		Success: true,
+		Events:  events,
+		Next:    result.Next,
+		Prev:    result.Prev,
+	})
+}
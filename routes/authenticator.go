@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/factor"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// AccountsAuthenticatorRequest contains the input for the authenticator endpoints.
+type AccountsAuthenticatorRequest struct {
+	CurrentPassword string `json:"current_password" schema:"current_password"`
+	Name            string `json:"name" schema:"name"`
+	Code            string `json:"code" schema:"code"`
+}
+
+// AccountsAuthenticatorResponse contains the output of the authenticator requests.
+type AccountsAuthenticatorResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message,omitempty"`
+	Secret  string                `json:"secret,omitempty"`
+	URI     string                `json:"uri,omitempty"`
+	QRCode  string                `json:"qr_code,omitempty"`
+	Factor  *models.AccountFactor `json:"factor,omitempty"`
+}
+
+// AccountsAuthenticatorCreate starts the enrollment of a TOTP authenticator.
+// The secret is returned as an otpauth:// URI and a QR code, and the
+// authenticator is enabled once it's confirmed using the first code.
+func AccountsAuthenticatorCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsAuthenticatorRequest
+	account, ok := factorsAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	if len(account.Factors) >= maxFactors {
+		utils.JSONResponse(w, 403, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Too many second factors",
+		})
+		return
+	}
+
+	authenticator := env.Factors["authenticator"].(*factor.Authenticator)
+
+	secret, err := authenticator.GenerateSecret()
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to generate a TOTP secret")
+
+		utils.JSONResponse(w, 500, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Internal error (code AC/AU/01)",
+		})
+		return
+	}
+
+	uri := authenticator.URI(secret, account.Name+"@"+env.Config.EmailDomain)
+
+	png, err := authenticator.QRCode(uri)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to render a QR code")
+
+		utils.JSONResponse(w, 500, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Internal error (code AC/AU/02)",
+		})
+		return
+	}
+
+	account.PendingAuthenticator = secret
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Internal error (code AC/AU/03)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &AccountsAuthenticatorResponse{
+		Success: true,
+		Message: "Scan the QR code and confirm the authenticator using the first code",
+		Secret:  secret,
+		URI:     uri,
+		QRCode:  "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// AccountsAuthenticatorConfirm enables the pending authenticator if the code
+// is valid. It's added to the other second factors of the account.
+func AccountsAuthenticatorConfirm(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsAuthenticatorRequest
+	account, ok := factorsAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if account.PendingAuthenticator == "" {
+		utils.JSONResponse(w, 400, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "No authenticator is waiting for a confirmation",
+		})
+		return
+	}
+
+	verified, err := env.Factors["authenticator"].Verify([]string{account.PendingAuthenticator}, input.Code)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to verify a TOTP code")
+
+		utils.JSONResponse(w, 500, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Internal error (code AC/AU/04)",
+		})
+		return
+	}
+
+	if !verified {
+		utils.JSONResponse(w, 403, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Invalid code",
+		})
+		return
+	}
+
+	if input.Name == "" {
+		input.Name = "Authenticator"
+	}
+
+	if len(input.Name) > 64 {
+		utils.JSONResponse(w, 400, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Invalid factor name - it has to be at max 64 characters long",
+		})
+		return
+	}
+
+	enabled := account.AddFactor("authenticator", input.Name, []string{account.PendingAuthenticator})
+	account.PendingAuthenticator = ""
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsAuthenticatorResponse{
+			Success: false,
+			Message: "Internal error (code AC/AU/05)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditFactorAdded, factorDetails(enabled))
+
+	utils.JSONResponse(w, 200, &AccountsAuthenticatorResponse{
+		Success: true,
+		Message: "Authenticator successfully enabled",
+		Factor:  enabled,
+	})
+}
@@ -0,0 +1,778 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/billing"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// StorageUsage contains the count of bytes used by an account
+type StorageUsage struct {
+	Emails   int `json:"emails"`
+	Files    int `json:"files"`
+	Contacts int `json:"contacts"`
+	Total    int `json:"total"`
+}
+
+// storageUsage sums up the sizes of emails, files and contacts of the owner
+func storageUsage(owner string) (*StorageUsage, error) {
+	usage := &StorageUsage{}
+
+	var err error
+	if usage.Emails, err = env.Emails.StorageUsage(owner); err != nil {
+		return nil, err
+	}
+	if usage.Files, err = env.Files.StorageUsage(owner); err != nil {
+		return nil, err
+	}
+	if usage.Contacts, err = env.Contacts.StorageUsage(owner); err != nil {
+		return nil, err
+	}
+
+	usage.Total = usage.Emails + usage.Files + usage.Contacts
+	return usage, nil
+}
+
+// storageExpires is how long the storage counter of an account is kept
+// before it's summed up again, correcting changes made outside the API
+const storageExpires = time.Hour
+
+func storageKey(owner string) string {
+	return "storage:" + owner
+}
+
+// usedStorage returns the count of bytes used by the owner, read from the
+// storage counter. Missing or unreadable counters are summed up again using
+// storageUsage.
+func usedStorage(owner string) (int, error) {
+	key := storageKey(owner)
+
+	used, err := env.Cache.Increment(key, 0, storageExpires)
+	if err == nil && used != 0 {
+		return int(used), nil
+	}
+
+	usage, err := storageUsage(owner)
+	if err != nil {
+		return 0, err
+	}
+	if usage.Total == 0 {
+		return 0, nil
+	}
+
+	// Drop the counter if it changed while it was being summed up
+	used, err = env.Cache.Increment(key, int64(usage.Total), storageExpires)
+	if err != nil || used != int64(usage.Total) {
+		resetStorage(owner)
+	}
+
+	return usage.Total, nil
+}
+
+// AddStorage updates the storage counter of the owner after delta bytes
+// were stored or, if negative, removed
+func AddStorage(owner string, delta int) {
+	if delta == 0 {
+		return
+	}
+
+	used, err := env.Cache.Increment(storageKey(owner), int64(delta), storageExpires)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+			"delta": delta,
+		}).Warn("Unable to update the storage counter")
+	}
+
+	// Counters that didn't exist, were empty or missed the update are
+	// summed up again
+	if err != nil || used == int64(delta) {
+		resetStorage(owner)
+	}
+}
+
+// resetStorage drops the storage counter of the owner, logging failures
+func resetStorage(owner string) {
+	if err := ResetStorage(owner); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+		}).Error("Unable to reset the storage counter")
+	}
+}
+
+// ResetStorage drops the storage counter of the owner after an unknown
+// count of bytes was removed, so that it's summed up again
+func ResetStorage(owner string) error {
+	return env.Cache.Delete(storageKey(owner))
+}
+
+// hasStorageFor checks whether the plan of the owner allows storing size
+// more bytes
+func hasStorageFor(owner string, size int) (bool, error) {
+	account, err := env.Accounts.GetAccountStatus(owner)
+	if err != nil {
+		return false, err
+	}
+
+	limit := billing.LimitsOf(account.Type).Storage
+	if limit == billing.Unlimited {
+		return true, nil
+	}
+
+	used, err := usedStorage(owner)
+	if err != nil {
+		return false, err
+	}
+
+	return billing.Allows(limit, used+size), nil
+}
+
+// hasAddressFor checks whether the plan of the owner allows another address
+func hasAddressFor(owner string) (bool, error) {
+	account, err := env.Accounts.GetAccountStatus(owner)
+	if err != nil {
+		return false, err
+	}
+
+	limit := billing.LimitsOf(account.Type).Addresses
+	if limit == billing.Unlimited {
+		return true, nil
+	}
+
+	addresses, err := env.Addresses.GetOwnedBy(owner)
+	if err != nil {
+		return false, err
+	}
+
+	return billing.Allows(limit, len(addresses)+1), nil
+}
+
+// BillingPlansResponse contains the result of the BillingPlans request.
+type BillingPlansResponse struct {
+	Success bool            `json:"success"`
+	Plans   []*billing.Plan `json:"plans"`
+}
+
+// BillingPlans lists plans that can be subscribed to
+func BillingPlans(w http.ResponseWriter, r *http.Request) {
+	utils.JSONResponse(w, 200, &BillingPlansResponse{
+		Success: true,
+		Plans:   billing.Plans,
+	})
+}
+
+// AccountsBillingResponse contains the result of the billing requests of an account.
+type AccountsBillingResponse struct {
+	Success      bool                 `json:"success"`
+	Message      string               `json:"message,omitempty"`
+	Type         string               `json:"type,omitempty"`
+	Limits       *billing.Limits      `json:"limits,omitempty"`
+	Usage        *StorageUsage        `json:"usage,omitempty"`
+	Addresses    int                  `json:"addresses,omitempty"`
+	Subscription *models.Subscription `json:"subscription,omitempty"`
+}
+
+// billingAccount resolves the account of a billing request. Billing can be
+// managed only by the owner using an auth token.
+func billingAccount(c web.C, w http.ResponseWriter) (*models.Account, bool) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsBillingResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return nil, false
+	}
+
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &AccountsBillingResponse{
+			Success: false,
+			Message: "Billing can be managed only using an auth token",
+		})
+		return nil, false
+	}
+
+	account, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return nil, false
+	}
+
+	return account, true
+}
+
+// AccountsBillingGet returns the limits of the account, their usage and the
+// current subscription
+func AccountsBillingGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := billingAccount(c, w)
+	if !ok {
+		return
+	}
+
+	usage, err := storageUsage(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to compute storage usage")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/GE/01)",
+		})
+		return
+	}
+
+	addresses, err := env.Addresses.GetOwnedBy(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch addresses")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/GE/02)",
+		})
+		return
+	}
+
+	subscription, err := env.Subscriptions.GetCurrent(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/GE/03)",
+		})
+		return
+	}
+
+	limits := billing.LimitsOf(account.Type)
+	utils.JSONResponse(w, 200, &AccountsBillingResponse{
+		Success:      true,
+		Type:         account.Type,
+		Limits:       &limits,
+		Usage:        usage,
+		Addresses:    len(addresses),
+		Subscription: subscription,
+	})
+}
+
+// SubscriptionsCreateRequest contains the input for the SubscriptionsCreate endpoint.
+type SubscriptionsCreateRequest struct {
+	Plan string `json:"plan" schema:"plan"`
+}
+
+// SubscriptionsCreate subscribes the account to a plan. The account is
+// upgraded when the provider reports the first payment.
+func SubscriptionsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input SubscriptionsCreateRequest
+	if err := utils.ParseRequest(r, &input); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsBillingResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	if env.Billing == nil {
+		utils.JSONResponse(w, 501, &AccountsBillingResponse{
+			Success: false,
+			Message: "Billing is disabled",
+		})
+		return
+	}
+
+	account, ok := billingAccount(c, w)
+	if !ok {
+		return
+	}
+
+	plan := billing.GetPlan(input.Plan)
+	if plan == nil {
+		utils.JSONResponse(w, 400, &AccountsBillingResponse{
+			Success: false,
+			Message: "Unknown plan",
+		})
+		return
+	}
+
+	if account.Type == "superuser" {
+		utils.JSONResponse(w, 409, &AccountsBillingResponse{
+			Success: false,
+			Message: "Staff accounts can't subscribe to plans",
+		})
+		return
+	}
+
+	current, err := env.Subscriptions.GetCurrent(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SC/01)",
+		})
+		return
+	}
+
+	if current != nil {
+		utils.JSONResponse(w, 409, &AccountsBillingResponse{
+			Success: false,
+			Message: "You already have a subscription",
+		})
+		return
+	}
+
+	// Register the account with the provider if it wasn't yet
+	if account.Billing.Customer == "" || account.Billing.Provider != env.Billing.Name() {
+		customer, err := env.Billing.CreateCustomer(account.ID, account.AltEmail)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    account.ID,
+			}).Error("Unable to create a customer")
+
+			utils.JSONResponse(w, 500, &AccountsBillingResponse{
+				Success: false,
+				Message: "Internal error (code BI/SC/02)",
+			})
+			return
+		}
+
+		account.Billing.Provider = env.Billing.Name()
+		account.Billing.Customer = customer
+	}
+
+	providerID, err := env.Billing.Subscribe(account.Billing.Customer, plan)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+			"plan":  plan.ID,
+		}).Error("Unable to create a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SC/03)",
+		})
+		return
+	}
+
+	subscription := &models.Subscription{
+		Resource:     models.MakeResource(account.ID, plan.Name),
+		Plan:         plan.ID,
+		Provider:     env.Billing.Name(),
+		ProviderID:   providerID,
+		Status:       models.SubscriptionPending,
+		PreviousType: account.Type,
+	}
+	if err := env.Subscriptions.Insert(subscription); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to insert a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SC/04)",
+		})
+		return
+	}
+
+	account.Billing.Subscription = subscription.ID
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"billing":       account.Billing,
+		"date_modified": time.Now(),
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SC/05)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditSubscriptionCreated, map[string]interface{}{
+		"plan": plan.ID,
+	})
+
+	utils.JSONResponse(w, 201, &AccountsBillingResponse{
+		Success:      true,
+		Message:      "Your subscription will start after the first payment",
+		Subscription: subscription,
+	})
+}
+
+// SubscriptionsDelete cancels the current subscription. Paid subscriptions
+// end with their current period, unpaid ones immediately.
+func SubscriptionsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	if env.Billing == nil {
+		utils.JSONResponse(w, 501, &AccountsBillingResponse{
+			Success: false,
+			Message: "Billing is disabled",
+		})
+		return
+	}
+
+	account, ok := billingAccount(c, w)
+	if !ok {
+		return
+	}
+
+	subscription, err := env.Subscriptions.GetCurrent(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SD/01)",
+		})
+		return
+	}
+
+	if subscription == nil {
+		utils.JSONResponse(w, 404, &AccountsBillingResponse{
+			Success: false,
+			Message: "You don't have a subscription",
+		})
+		return
+	}
+
+	if subscription.Cancelled {
+		utils.JSONResponse(w, 409, &AccountsBillingResponse{
+			Success: false,
+			Message: "Your subscription is already cancelled",
+		})
+		return
+	}
+
+	if err := env.Billing.Cancel(subscription.ProviderID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    subscription.ID,
+		}).Error("Unable to cancel a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SD/02)",
+		})
+		return
+	}
+
+	subscription.Cancelled = true
+	if subscription.Status == models.SubscriptionPending {
+		subscription.Status = models.SubscriptionEnded
+	}
+	subscription.Touch()
+
+	if err := env.Subscriptions.UpdateID(subscription.ID, subscription); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    subscription.ID,
+		}).Error("Unable to update a subscription")
+
+		utils.JSONResponse(w, 500, &AccountsBillingResponse{
+			Success: false,
+			Message: "Internal error (code BI/SD/03)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditSubscriptionCancelled, map[string]interface{}{
+		"plan": subscription.Plan,
+	})
+
+	message := "Your subscription will end on " + subscription.PeriodEnd.Format(time.RFC1123)
+	if !subscription.IsCurrent() {
+		message = "Your subscription has been cancelled"
+	}
+
+	utils.JSONResponse(w, 200, &AccountsBillingResponse{
+		Success:      true,
+		Message:      message,
+		Subscription: subscription,
+	})
+}
+
+// AccountsInvoicesListResponse contains the result of the AccountsInvoicesList request.
+type AccountsInvoicesListResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message,omitempty"`
+	Invoices []*models.Invoice `json:"invoices,omitempty"`
+	Next     string            `json:"next,omitempty"`
+	Prev     string            `json:"prev,omitempty"`
+}
+
+// AccountsInvoicesList returns a page of the account's invoices, newest first
+func AccountsInvoicesList(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := billingAccount(c, w)
+	if !ok {
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AccountsInvoicesListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	invoices, result, err := env.Invoices.List(account.ID, page)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": account.ID,
+		}).Error("Unable to fetch invoices")
+
+		utils.JSONResponse(w, 500, &AccountsInvoicesListResponse{
+			Success: false,
+			Message: "Internal error (code BI/IL/01)",
+		})
+		return
+	}
+
+	setTotalCount(w, result)
+	utils.JSONResponse(w, 200, &AccountsInvoicesListResponse{
+		Success:  true,
+		Invoices: invoices,
+		Next:     result.Next,
+		Prev:     result.Prev,
+	})
+}
+
+// BillingCallbackResponse contains the result of the BillingCallback request.
+type BillingCallbackResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+// BillingCallback handles events sent by the payment provider. Payments
+// upgrade the account to the type of the plan and ended subscriptions
+// restore the type the account had before.
+func BillingCallback(c web.C, w http.ResponseWriter, r *http.Request) {
+	if env.Billing == nil {
+		utils.JSONResponse(w, 501, &BillingCallbackResponse{
+			Success: false,
+			Message: "Billing is disabled",
+		})
+		return
+	}
+
+	event, err := env.Billing.ParseEvent(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &BillingCallbackResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	subscription, err := env.Subscriptions.GetByProviderID(event.Subscription)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":        err.Error(),
+			"subscription": event.Subscription,
+		}).Error("Unable to fetch a subscription")
+
+		utils.JSONResponse(w, 500, &BillingCallbackResponse{
+			Success: false,
+			Message: "Internal error (code BI/CB/01)",
+		})
+		return
+	}
+
+	if subscription == nil || subscription.Provider != env.Billing.Name() {
+		utils.JSONResponse(w, 404, &BillingCallbackResponse{
+			Success: false,
+			Message: "Subscription not found",
+		})
+		return
+	}
+
+	account, err := env.Accounts.GetAccount(subscription.Owner)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    subscription.Owner,
+		}).Error("Unable to fetch an account")
+
+		utils.JSONResponse(w, 500, &BillingCallbackResponse{
+			Success: false,
+			Message: "Internal error (code BI/CB/02)",
+		})
+		return
+	}
+
+	accountType := account.Type
+	var audit string
+
+	switch event.Type {
+	case billing.EventPaymentSucceeded, billing.EventPaymentFailed:
+		// Providers retry callbacks, so the invoice may be already recorded
+		existing, err := env.Invoices.GetByProviderID(event.Invoice)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error":   err.Error(),
+				"invoice": event.Invoice,
+			}).Error("Unable to fetch an invoice")
+
+			utils.JSONResponse(w, 500, &BillingCallbackResponse{
+				Success: false,
+				Message: "Internal error (code BI/CB/03)",
+			})
+			return
+		}
+
+		if existing != nil {
+			utils.JSONResponse(w, 200, &BillingCallbackResponse{
+				Success: true,
+				Message: "The event was already processed",
+			})
+			return
+		}
+
+		invoice := &models.Invoice{
+			Resource:     models.MakeResource(account.ID, ""),
+			Subscription: subscription.ID,
+			ProviderID:   event.Invoice,
+			Amount:       event.Amount,
+			Currency:     event.Currency,
+			Status:       models.InvoicePaid,
+			PeriodStart:  event.PeriodStart,
+			PeriodEnd:    event.PeriodEnd,
+		}
+
+		if event.Type == billing.EventPaymentSucceeded {
+			subscription.Status = models.SubscriptionActive
+			subscription.PeriodStart = event.PeriodStart
+			subscription.PeriodEnd = event.PeriodEnd
+
+			if plan := billing.GetPlan(subscription.Plan); plan != nil {
+				accountType = plan.AccountType
+			}
+			audit = models.AuditSubscriptionRenewed
+		} else {
+			invoice.Status = models.InvoiceFailed
+			subscription.Status = models.SubscriptionPastDue
+			audit = models.AuditPaymentFailed
+		}
+
+		if err := env.Invoices.Insert(invoice); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error":   err.Error(),
+				"invoice": event.Invoice,
+			}).Error("Unable to insert an invoice")
+
+			utils.JSONResponse(w, 500, &BillingCallbackResponse{
+				Success: false,
+				Message: "Internal error (code BI/CB/04)",
+			})
+			return
+		}
+	case billing.EventSubscriptionEnded:
+		if !subscription.IsCurrent() {
+			utils.JSONResponse(w, 200, &BillingCallbackResponse{
+				Success: true,
+				Message: "The event was already processed",
+			})
+			return
+		}
+
+		subscription.Status = models.SubscriptionEnded
+		accountType = subscription.PreviousType
+		if accountType == "" {
+			accountType = billing.DefaultType
+		}
+		if account.Billing.Subscription == subscription.ID {
+			account.Billing.Subscription = ""
+		}
+		audit = models.AuditSubscriptionEnded
+	default:
+		utils.JSONResponse(w, 400, &BillingCallbackResponse{
+			Success: false,
+			Message: "Unknown event type",
+		})
+		return
+	}
+
+	subscription.Touch()
+	if err := env.Subscriptions.UpdateID(subscription.ID, subscription); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    subscription.ID,
+		}).Error("Unable to update a subscription")
+
+		utils.JSONResponse(w, 500, &BillingCallbackResponse{
+			Success: false,
+			Message: "Internal error (code BI/CB/05)",
+		})
+		return
+	}
+
+	// Staff accounts keep their type no matter what they pay for
+	if account.Type == "superuser" {
+		accountType = account.Type
+	}
+
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"type":          accountType,
+		"billing":       account.Billing,
+		"date_modified": time.Now(),
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &BillingCallbackResponse{
+			Success: false,
+			Message: "Internal error (code BI/CB/06)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, audit, map[string]interface{}{
+		"plan":     subscription.Plan,
+		"old_type": account.Type,
+		"new_type": accountType,
+	})
+
+	utils.JSONResponse(w, 200, &BillingCallbackResponse{
+		Success: true,
+		Message: "The event was processed",
+	})
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// sendEmailConfirmation emails a confirmation token of the address using
+// the hook_confirm_email topic. Previous confirmations stop working.
+func sendEmailConfirmation(account *models.Account, email string) error {
+	if err := env.Tokens.DeleteOwnedByType(account.ID, "confirm"); err != nil {
+		return err
+	}
+
+	token := models.MakeConfirmToken(account.ID, email)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account": account.ID,
+		"email":   email,
+		"token":   token.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_confirm_email", data)
+}
+
+// AccountsConfirmRequest contains the input for the AccountsConfirm endpoint.
+type AccountsConfirmRequest struct {
+	Token string `json:"token" schema:"token"`
+}
+
+// AccountsConfirmResponse contains the output of the AccountsConfirm request.
+type AccountsConfirmResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// AccountsConfirm confirms an alt email using the token sent to it. A pending
+// alt email replaces the current one.
+func AccountsConfirm(w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input AccountsConfirmRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Type != "confirm" {
+		utils.JSONResponse(w, 400, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Invalid confirmation token",
+		})
+		return
+	}
+
+	if token.Expired() {
+		utils.JSONResponse(w, 400, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Expired confirmation token",
+		})
+		return
+	}
+
+	account, err := env.Accounts.GetTokenOwner(token)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Invalid confirmation token",
+		})
+		return
+	}
+
+	switch token.Name {
+	case account.PendingAltEmail:
+		account.AltEmail = account.PendingAltEmail
+		account.PendingAltEmail = ""
+	case account.AltEmail:
+	default:
+		// The email was changed after sending the token
+		utils.JSONResponse(w, 400, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Outdated confirmation token",
+		})
+		return
+	}
+
+	account.AltEmailVerified = true
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsConfirmResponse{
+			Success: false,
+			Message: "Internal error (code AC/CO/01)",
+		})
+		return
+	}
+
+	if err := env.Tokens.DeleteID(token.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to remove a used confirmation token")
+	}
+
+	utils.JSONResponse(w, 200, &AccountsConfirmResponse{
+		Success: true,
+		Message: "Your email has been confirmed",
+	})
+}
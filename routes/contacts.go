@@ -16,15 +16,26 @@ type ContactsListResponse struct {
 	Success  bool               `json:"success"`
 	Message  string             `json:"message,omitempty"`
 	Contacts *[]*models.Contact `json:"contacts,omitempty"`
+	Next     string             `json:"next,omitempty"`
+	Prev     string             `json:"prev,omitempty"`
 }
 
-// ContactsList does *something* - TODO
+// ContactsList returns a page of the user's contacts
 func ContactsList(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Fetch the current session from the database
 	session := c.Env["token"].(*models.Token)
 
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &ContactsListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
 	// Get contacts from the database
-	contacts, err := env.Contacts.GetOwnedBy(session.Owner)
+	contacts, result, err := env.Contacts.List(session.Owner, page)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -37,9 +48,12 @@ func ContactsList(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setTotalCount(w, result)
 	utils.JSONResponse(w, 200, &ContactsListResponse{
 		Success:  true,
 		Contacts: &contacts,
+		Next:     result.Next,
+		Prev:     result.Prev,
 	})
 }
 
@@ -103,6 +117,29 @@ func ContactsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		Resource: models.MakeResource(session.Owner, input.Name),
 	}
 
+	// Ensure that the plan allows storing it
+	fits, err := hasStorageFor(session.Owner, len(contact.Data))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to check storage usage")
+
+		utils.JSONResponse(w, 500, &ContactsCreateResponse{
+			Success: false,
+			Message: "internal server error - CO/CR/02",
+		})
+		return
+	}
+
+	if !fits {
+		utils.JSONResponse(w, 403, &ContactsCreateResponse{
+			Success: false,
+			Message: "Your plan's storage limit has been reached",
+		})
+		return
+	}
+
 	// Insert the contact into the database
 	if err := env.Contacts.Insert(contact); err != nil {
 		utils.JSONResponse(w, 500, &ContactsCreateResponse{
@@ -115,6 +152,7 @@ func ContactsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		}).Error("Could not insert a contact into the database")
 		return
 	}
+	AddStorage(contact.Owner, len(contact.Data))
 
 	utils.JSONResponse(w, 201, &ContactsCreateResponse{
 		Success: true,
@@ -217,6 +255,35 @@ func ContactsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Ensure that the plan allows storing the new version
+	delta := 0
+	if input.Data != "" {
+		delta = len(input.Data) - len(contact.Data)
+	}
+	if delta > 0 {
+		fits, err := hasStorageFor(session.Owner, delta)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"owner": session.Owner,
+			}).Error("Unable to check storage usage")
+
+			utils.JSONResponse(w, 500, &ContactsUpdateResponse{
+				Success: false,
+				Message: "Internal error (code CO/UP/02)",
+			})
+			return
+		}
+
+		if !fits {
+			utils.JSONResponse(w, 403, &ContactsUpdateResponse{
+				Success: false,
+				Message: "Your plan's storage limit has been reached",
+			})
+			return
+		}
+	}
+
 	if input.Data != "" {
 		contact.Data = input.Data
 	}
@@ -255,6 +322,7 @@ func ContactsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	AddStorage(contact.Owner, delta)
 
 	// Write the contact to the response
 	utils.JSONResponse(w, 200, &ContactsUpdateResponse{
@@ -307,6 +375,7 @@ func ContactsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	AddStorage(contact.Owner, -len(contact.Data))
 
 	// Write the contact to the response
 	utils.JSONResponse(w, 200, &ContactsDeleteResponse{
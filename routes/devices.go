@@ -0,0 +1,224 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// NewDeviceEvent is published to the hook_new_device topic after a login from
+// a device that the account hasn't used before. Email is set only if the
+// account has a verified alt email.
+type NewDeviceEvent struct {
+	Account   string    `json:"account"`
+	Email     string    `json:"email,omitempty"`
+	Device    string    `json:"device"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Date      time.Time `json:"date"`
+	Token     string    `json:"token"`
+	Link      string    `json:"link"`
+}
+
+// checkDevice remembers the device that the session was created from and
+// notifies the account if it's a new one. The first device of an account
+// isn't announced.
+func checkDevice(c web.C, r *http.Request, account *models.Account, session *models.Token) {
+	known := len(account.Devices)
+	device, isNew := account.SeeDevice(utils.DeviceFingerprint(session.UserAgent, session.IP), session.Device, session.IP)
+
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"devices": account.Devices,
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to remember a device")
+		return
+	}
+
+	if !isNew || known == 0 {
+		return
+	}
+
+	if err := notifyNewDevice(c, r, account, session, device); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to send a new device notification")
+	}
+}
+
+// notifyNewDevice sends the new device event to the account's subscribed
+// sessions and to the hook_new_device topic, which puts a notice into the
+// account's Inbox and emails the alt email
+func notifyNewDevice(c web.C, r *http.Request, account *models.Account, session *models.Token, device *models.AccountDevice) error {
+	recordAudit(c, r, account.ID, models.AuditNewDevice, map[string]interface{}{
+		"device": device.Name,
+	})
+
+	token := models.MakeNotMeToken(account.ID, session.Family)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	event := &NewDeviceEvent{
+		Account:   account.ID,
+		Device:    device.Name,
+		IP:        device.IP,
+		UserAgent: session.UserAgent,
+		Date:      device.DateFirstSeen,
+		Token:     token.ID,
+		Link:      env.Config.WebURL + "/not-me/" + token.ID,
+	}
+	if account.AltEmailVerified {
+		event.Email = account.AltEmail
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"type":   "new_device",
+		"owner":  account.ID,
+		"device": device.Name,
+		"ip":     device.IP,
+		"date":   device.DateFirstSeen,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := env.Producer.Publish("account_events", data); err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_new_device", data)
+}
+
+// AccountsNotMeRequest contains the input for the AccountsNotMe endpoint.
+type AccountsNotMeRequest struct {
+	Token string `json:"token" schema:"token"`
+}
+
+// AccountsNotMeResponse contains the output of the AccountsNotMe request.
+type AccountsNotMeResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// AccountsNotMe handles the "this wasn't me" link of a new device
+// notification. It revokes the session created from the device and disables
+// password logins until the password is reset.
+func AccountsNotMe(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input AccountsNotMeRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Type != "not_me" {
+		utils.JSONResponse(w, 400, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Invalid token",
+		})
+		return
+	}
+
+	if token.Expired() {
+		utils.JSONResponse(w, 400, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Expired token",
+		})
+		return
+	}
+
+	account, err := env.Accounts.GetTokenOwner(token)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Invalid token",
+		})
+		return
+	}
+
+	revoked, err := env.Tokens.DeleteFamily(account.ID, token.Family)
+	closeSubscriptions(account.ID, revoked...)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"id":     account.ID,
+			"family": token.Family,
+		}).Error("Unable to revoke a session")
+
+		utils.JSONResponse(w, 500, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Internal error (code AC/NM/01)",
+		})
+		return
+	}
+
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"password_reset_required": true,
+		"date_modified":           time.Now(),
+	}); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsNotMeResponse{
+			Success: false,
+			Message: "Internal error (code AC/NM/02)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditDeviceRejected, map[string]interface{}{
+		"sessions": len(revoked),
+	})
+
+	if err := env.Tokens.DeleteID(token.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to remove a not_me token")
+	}
+
+	message := "The session has been revoked. Reset your password using a recovery code"
+	// Same as the new device notifications, reset links go only to verified emails
+	if account.AltEmail != "" && account.AltEmailVerified {
+		if err := sendResetLink(account); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    account.ID,
+			}).Error("Unable to queue a password reset email")
+		} else {
+			message = "The session has been revoked. A password reset link has been sent to your alternative email"
+		}
+	}
+
+	utils.JSONResponse(w, 200, &AccountsNotMeResponse{
+		Success: true,
+		Message: message,
+	})
+}
@@ -7,8 +7,6 @@ import (
 	"encoding/hex"
 	"net/http"
 	"net/mail"
-	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -22,72 +20,31 @@ import (
 	"github.com/lavab/api/utils"
 )
 
-var prefixesRegex = regexp.MustCompile(`([\[\(] *)?(RE?S?|FYI|RIF|I|FS|VB|RV|ENC|ODP|PD|YNT|ILT|SV|VS|VL|AW|WG|ΑΠ|ΣΧΕΤ|ΠΡΘ|תגובה|הועבר|主题|转发|FWD?) *([-:;)\]][ :;\])-]*|$)|\]+ *$`)
-
 // EmailsListResponse contains the result of the EmailsList request.
 type EmailsListResponse struct {
 	Success bool             `json:"success"`
 	Message string           `json:"message,omitempty"`
 	Emails  *[]*models.Email `json:"emails,omitempty"`
+	Next    string           `json:"next,omitempty"`
+	Prev    string           `json:"prev,omitempty"`
 }
 
-// EmailsList sends a list of the emails in the inbox.
+// EmailsList sends a page of the emails in the inbox, newest first.
 func EmailsList(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Fetch the current session from the database
 	session := c.Env["token"].(*models.Token)
 
-	// Parse the query
-	var (
-		query     = r.URL.Query()
-		sortRaw   = query.Get("sort")
-		offsetRaw = query.Get("offset")
-		limitRaw  = query.Get("limit")
-		thread    = query.Get("thread")
-		sort      []string
-		offset    int
-		limit     int
-	)
-
-	if offsetRaw != "" {
-		o, err := strconv.Atoi(offsetRaw)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error":  err,
-				"offset": offset,
-			}).Error("Invalid offset")
-
-			utils.JSONResponse(w, 400, &EmailsListResponse{
-				Success: false,
-				Message: "Invalid offset",
-			})
-			return
-		}
-		offset = o
-	}
-
-	if limitRaw != "" {
-		l, err := strconv.Atoi(limitRaw)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-				"limit": limit,
-			}).Error("Invalid limit")
-
-			utils.JSONResponse(w, 400, &EmailsListResponse{
-				Success: false,
-				Message: "Invalid limit",
-			})
-			return
-		}
-		limit = l
-	}
-
-	if sortRaw != "" {
-		sort = strings.Split(sortRaw, ",")
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &EmailsListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
 	}
 
-	// Get contacts from the database
-	emails, err := env.Emails.List(session.Owner, sort, offset, limit, thread)
+	// Get emails from the database
+	emails, result, err := env.Emails.List(session.Owner, r.URL.Query().Get("thread"), page)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -100,30 +57,17 @@ func EmailsList(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if offsetRaw != "" || limitRaw != "" {
-		count, err := env.Emails.CountOwnedBy(session.Owner)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Unable to count emails")
-
-			utils.JSONResponse(w, 500, &EmailsListResponse{
-				Success: false,
-				Message: "Internal error (code EM/LI/02)",
-			})
-			return
-		}
-		w.Header().Set("X-Total-Count", strconv.Itoa(count))
-	}
-
+	setTotalCount(w, result)
 	utils.JSONResponse(w, 200, &EmailsListResponse{
 		Success: true,
 		Emails:  &emails,
+		Next:    result.Next,
+		Prev:    result.Prev,
 	})
 
 	// GET parameters:
-	//   sort - split by commas, prefixes: - is desc, + is asc
-	//   offset, limit - for pagination
+	//   thread - only list emails of a thread
+	//   cursor, limit - for pagination
 	// Pagination ADDS X-Total-Count to the response!
 }
 
@@ -417,6 +361,29 @@ func EmailsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		Status: "queued",
 	}
 
+	// Ensure that the plan allows storing it
+	fits, err := hasStorageFor(session.Owner, len(email.Body)+len(email.Manifest))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to check storage usage")
+
+		utils.JSONResponse(w, 500, &EmailsCreateResponse{
+			Success: false,
+			Message: "internal server error - EM/CR/04",
+		})
+		return
+	}
+
+	if !fits {
+		utils.JSONResponse(w, 403, &EmailsCreateResponse{
+			Success: false,
+			Message: "Your plan's storage limit has been reached",
+		})
+		return
+	}
+
 	// Insert the email into the database
 	if err := env.Emails.Insert(email); err != nil {
 		utils.JSONResponse(w, 500, &EmailsCreateResponse{
@@ -429,6 +396,7 @@ func EmailsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		}).Error("Could not insert an email into the database")
 		return
 	}
+	AddStorage(email.Owner, len(email.Body)+len(email.Manifest))
 
 	// I'm going to whine at this part, as we are doubling the email sending code
 
@@ -558,6 +526,7 @@ func EmailsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	AddStorage(email.Owner, -len(email.Body)-len(email.Manifest))
 
 	// Write the email to the response
 	utils.JSONResponse(w, 200, &EmailsDeleteResponse{
@@ -691,7 +660,7 @@ func EmailsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// strip prefixes from the subject
-	rawSubject := prefixesRegex.ReplaceAllString(newEmail.Name, "")
+	rawSubject := utils.StripSubjectPrefixes(newEmail.Name)
 
 	emailResource := models.MakeResource(recipient.ID, newEmail.Name)
 
@@ -766,6 +735,7 @@ func EmailsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		}).Error("Unable to create a new email")
 		return
 	}
+	AddStorage(newEmail.Owner, len(newEmail.Body)+len(newEmail.Manifest))
 
 	// Send notifications
 	err = env.Producer.Publish("email_delivery", map[string]interface{}{
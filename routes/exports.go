@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// AccountsExportResponse contains the result of the AccountsExport request.
+type AccountsExportResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Job     *models.Job `json:"job,omitempty"`
+}
+
+// AccountsExport starts an export of all account's data. When the export is
+// finished, the download link is sent in an export_ready event and stored in
+// the job's result.
+func AccountsExport(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsExportResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	// Fetch the current session from the database
+	session := c.Env["token"].(*models.Token)
+
+	job, err := startJob(session.Owner, models.JobAccountExport)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"id":    session.Owner,
+			"error": err.Error(),
+		}).Error("Unable to start an account export")
+
+		utils.JSONResponse(w, 500, &AccountsExportResponse{
+			Success: false,
+			Message: "Internal error (code AC/EX/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 202, &AccountsExportResponse{
+		Success: true,
+		Message: "Your data is being exported",
+		Job:     job,
+	})
+}
+
+// ExportsGetResponse contains the result of a failed ExportsGet request.
+type ExportsGetResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// ExportsGet sends an export archive. The ID in the URL is an export token.
+func ExportsGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	token, err := env.Tokens.GetToken(c.URLParams["id"])
+	if err != nil || token.Type != "export" {
+		utils.JSONResponse(w, 404, &ExportsGetResponse{
+			Success: false,
+			Message: "Export not found",
+		})
+		return
+	}
+
+	// Remove expired exports
+	if token.Expired() {
+		if err := env.Blobs.DeleteBlob(token.Name); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"job":   token.Name,
+			}).Error("Unable to remove an expired export")
+		}
+
+		utils.JSONResponse(w, 410, &ExportsGetResponse{
+			Success: false,
+			Message: "Export expired",
+		})
+		return
+	}
+
+	archive, err := env.Blobs.Open(token.Name)
+	if err == db.ErrBlobNotFound {
+		utils.JSONResponse(w, 404, &ExportsGetResponse{
+			Success: false,
+			Message: "Export not found",
+		})
+		return
+	} else if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"job":   token.Name,
+		}).Error("Unable to open an export")
+
+		utils.JSONResponse(w, 500, &ExportsGetResponse{
+			Success: false,
+			Message: "Internal error (code EX/GE/01)",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="lavaboom-export.zip"`)
+	if _, err := io.Copy(w, archive); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"job":   token.Name,
+		}).Error("Unable to send an export")
+	}
+}
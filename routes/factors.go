@@ -0,0 +1,449 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+const (
+	// maxFactors is the count of second factors that an account can have
+	maxFactors = 10
+
+	// backupCodesCount is the count of 2FA backup codes generated at once
+	backupCodesCount = 10
+)
+
+// verifySecondFactor checks the token against the chosen factor or, if
+// factorID is empty, against all factors of the account. Without a token, the
+// challenge of the chosen factor is returned. Used factors are saved, so that
+// backup codes can't be used twice and WebAuthn signature counters are kept.
+// Returns verified, challenge, error
+func verifySecondFactor(account *models.Account, factorID string, token string) (bool, string, error) {
+	factors := account.Factors
+	if factorID != "" {
+		chosen := account.GetFactor(factorID)
+		if chosen == nil {
+			return false, "", nil
+		}
+
+		factors = []*models.AccountFactor{chosen}
+	}
+
+	if token == "" {
+		if factorID == "" {
+			return false, "", nil
+		}
+
+		driver, ok := env.Factors[factors[0].Type]
+		if !ok {
+			return false, "", nil
+		}
+
+		// Drivers get the first value of the factor, e.g. the WebAuthn credential ID
+		data := ""
+		if len(factors[0].Value) > 0 {
+			data = factors[0].Value[0]
+		}
+
+		challenge, err := driver.Request(data)
+		return false, challenge, err
+	}
+
+	verified := false
+	for _, factor := range factors {
+		if factor.Type == "backup_codes" {
+			if account.UseBackupCode(token) {
+				verified = true
+				break
+			}
+
+			continue
+		}
+
+		driver, ok := env.Factors[factor.Type]
+		if !ok {
+			continue
+		}
+
+		ok, err := driver.Verify(factor.Value, token)
+		if err != nil {
+			return false, "", err
+		}
+
+		if ok {
+			factor.DateLastUsed = time.Now().UTC()
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return false, "", nil
+	}
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		return false, "", err
+	}
+
+	return true, "", nil
+}
+
+// chosenFactorType returns the type of the chosen factor, or of the first one
+// if none was chosen
+func chosenFactorType(account *models.Account, factorID string) string {
+	if factor := account.GetFactor(factorID); factor != nil {
+		return factor.Type
+	}
+
+	if len(account.Factors) > 0 {
+		return account.Factors[0].Type
+	}
+
+	return ""
+}
+
+// listFactors returns factors of the account with the counts of unused
+// backup codes filled in
+func listFactors(account *models.Account) []*models.AccountFactor {
+	for _, factor := range account.Factors {
+		if factor.Type == "backup_codes" {
+			factor.Remaining = len(factor.Value)
+		}
+	}
+
+	return account.Factors
+}
+
+// factorDetails describes a factor in the audit log without its secrets
+func factorDetails(entry *models.AccountFactor) map[string]interface{} {
+	return map[string]interface{}{
+		"factor": entry.ID,
+		"type":   entry.Type,
+		"name":   entry.Name,
+	}
+}
+
+// factorsAccount decodes a request managing second factors and resolves the
+// account of the current user. If it fails, the response is already written.
+func factorsAccount(c web.C, w http.ResponseWriter, r *http.Request, input interface{}) (*models.Account, bool) {
+	if input != nil {
+		if err := utils.ParseRequest(r, input); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warn("Unable to decode a request")
+
+			utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+				Success: false,
+				Message: "Invalid input format",
+			})
+			return nil, false
+		}
+	}
+
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsFactorsResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return nil, false
+	}
+
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Second factors can be managed only using an auth token",
+		})
+		return nil, false
+	}
+
+	account, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return nil, false
+	}
+
+	return account, true
+}
+
+// AccountsFactorsResponse contains the result of the requests managing
+// second factors.
+type AccountsFactorsResponse struct {
+	Success     bool                    `json:"success"`
+	Message     string                  `json:"message,omitempty"`
+	Factor      *models.AccountFactor   `json:"factor,omitempty"`
+	Factors     []*models.AccountFactor `json:"factors,omitempty"`
+	BackupCodes []string                `json:"backup_codes,omitempty"`
+}
+
+// AccountsFactorsList returns second factors of the account
+func AccountsFactorsList(c web.C, w http.ResponseWriter, r *http.Request) {
+	account, ok := factorsAccount(c, w, r, nil)
+	if !ok {
+		return
+	}
+
+	utils.JSONResponse(w, 200, &AccountsFactorsResponse{
+		Success: true,
+		Factors: listFactors(account),
+	})
+}
+
+// AccountsFactorsCreateRequest contains the input for the AccountsFactorsCreate endpoint.
+type AccountsFactorsCreateRequest struct {
+	CurrentPassword string `json:"current_password" schema:"current_password"`
+	Type            string `json:"type" schema:"type"`
+	Name            string `json:"name" schema:"name"`
+	Token           string `json:"token" schema:"token"`
+}
+
+// AccountsFactorsCreate registers a YubiKey. The key is identified using
+// an OTP generated by it, so several keys can be registered.
+func AccountsFactorsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsFactorsCreateRequest
+	account, ok := factorsAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	switch input.Type {
+	case "authenticator":
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Authenticators have to be set up using /accounts/me/authenticator",
+		})
+		return
+	case "webauthn":
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "WebAuthn credentials have to be registered using /accounts/me/webauthn",
+		})
+		return
+	case "backup_codes":
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Backup codes have to be generated using /accounts/me/backup-codes",
+		})
+		return
+	}
+
+	driver, exists := env.Factors[input.Type]
+	if !exists || input.Type != "yubicloud" {
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid 2FA type",
+		})
+		return
+	}
+
+	if len(input.Name) > 64 {
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid factor name - it has to be at max 64 characters long",
+		})
+		return
+	}
+
+	if len(account.Factors) >= maxFactors {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Too many second factors",
+		})
+		return
+	}
+
+	if len(input.Token) <= 12 {
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid token passed",
+		})
+		return
+	}
+
+	publicKey := input.Token[:12]
+	for _, factor := range account.Factors {
+		if factor.Type == input.Type && len(factor.Value) > 0 && factor.Value[0] == publicKey {
+			utils.JSONResponse(w, 409, &AccountsFactorsResponse{
+				Success: false,
+				Message: "This key is already registered",
+			})
+			return
+		}
+	}
+
+	verified, err := driver.Verify([]string{publicKey}, input.Token)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"factor": input.Type,
+		}).Error("Unable to verify a 2FA token")
+
+		utils.JSONResponse(w, 500, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Internal error (code AC/FA/01)",
+		})
+		return
+	}
+
+	if !verified {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid token passed",
+		})
+		return
+	}
+
+	if input.Name == "" {
+		input.Name = "YubiKey " + publicKey
+	}
+
+	factor := account.AddFactor(input.Type, input.Name, []string{publicKey})
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Internal error (code AC/FA/02)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditFactorAdded, factorDetails(factor))
+
+	utils.JSONResponse(w, 201, &AccountsFactorsResponse{
+		Success: true,
+		Message: "Factor successfully added",
+		Factor:  factor,
+	})
+}
+
+// AccountsFactorsPasswordRequest contains the input for the endpoints that
+// only require the current password.
+type AccountsFactorsPasswordRequest struct {
+	CurrentPassword string `json:"current_password" schema:"current_password"`
+}
+
+// AccountsFactorsDelete removes a second factor of the account. Backup codes
+// are removed together with the last other factor.
+func AccountsFactorsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsFactorsPasswordRequest
+	account, ok := factorsAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	removed := account.GetFactor(c.URLParams["factor"])
+	if removed == nil {
+		utils.JSONResponse(w, 404, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Factor not found",
+		})
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	account.RemoveFactor(c.URLParams["factor"])
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Internal error (code AC/FA/03)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditFactorRemoved, factorDetails(removed))
+
+	utils.JSONResponse(w, 200, &AccountsFactorsResponse{
+		Success: true,
+		Message: "Factor successfully removed",
+		Factors: listFactors(account),
+	})
+}
+
+// AccountsBackupCodes replaces 2FA backup codes of the account. Codes are
+// shown only once, old codes stop working.
+func AccountsBackupCodes(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsFactorsPasswordRequest
+	account, ok := factorsAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	// Backup codes alone aren't a second factor
+	if len(account.Factors) == 0 || (len(account.Factors) == 1 && account.Factors[0].Type == "backup_codes") {
+		utils.JSONResponse(w, 400, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Backup codes require another second factor",
+		})
+		return
+	}
+
+	codes := account.GenerateBackupCodes(backupCodesCount)
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsFactorsResponse{
+			Success: false,
+			Message: "Internal error (code AC/BC/01)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditBackupCodesGenerated, nil)
+
+	utils.JSONResponse(w, 200, &AccountsFactorsResponse{
+		Success:     true,
+		BackupCodes: codes,
+	})
+}
@@ -15,6 +15,8 @@ type FilesListResponse struct {
 	Success bool            `json:"success"`
 	Message string          `json:"message,omitempty"`
 	Files   *[]*models.File `json:"files,omitempty"`
+	Next    string          `json:"next,omitempty"`
+	Prev    string          `json:"prev,omitempty"`
 }
 
 func FilesList(c web.C, w http.ResponseWriter, r *http.Request) {
@@ -24,15 +26,16 @@ func FilesList(c web.C, w http.ResponseWriter, r *http.Request) {
 	email := query.Get("email")
 	name := query.Get("name")
 
-	if email == "" || name == "" {
+	page, err := parsePage(r)
+	if err != nil {
 		utils.JSONResponse(w, 400, &FilesListResponse{
 			Success: false,
-			Message: "No email or name in get params",
+			Message: err.Error(),
 		})
 		return
 	}
 
-	files, err := env.Files.GetInEmail(session.Owner, email, name)
+	files, result, err := env.Files.List(session.Owner, email, name, page)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -45,9 +48,12 @@ func FilesList(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	setTotalCount(w, result)
 	utils.JSONResponse(w, 200, &FilesListResponse{
 		Success: true,
 		Files:   &files,
+		Next:    result.Next,
+		Prev:    result.Prev,
 	})
 }
 
@@ -108,6 +114,29 @@ func FilesCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		Resource: models.MakeResource(session.Owner, input.Name),
 	}
 
+	// Ensure that the plan allows storing it
+	fits, err := hasStorageFor(session.Owner, len(file.Data))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to check storage usage")
+
+		utils.JSONResponse(w, 500, &FilesCreateResponse{
+			Success: false,
+			Message: "internal server error - FI/CR/02",
+		})
+		return
+	}
+
+	if !fits {
+		utils.JSONResponse(w, 403, &FilesCreateResponse{
+			Success: false,
+			Message: "Your plan's storage limit has been reached",
+		})
+		return
+	}
+
 	// Insert the file into the database
 	if err := env.Files.Insert(file); err != nil {
 		utils.JSONResponse(w, 500, &FilesCreateResponse{
@@ -120,6 +149,7 @@ func FilesCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		}).Error("Could not insert a file into the database")
 		return
 	}
+	AddStorage(file.Owner, len(file.Data))
 
 	utils.JSONResponse(w, 201, &FilesCreateResponse{
 		Success: true,
@@ -222,6 +252,35 @@ func FilesUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Ensure that the plan allows storing the new version
+	delta := 0
+	if input.Data != "" {
+		delta = len(input.Data) - len(file.Data)
+	}
+	if delta > 0 {
+		fits, err := hasStorageFor(session.Owner, delta)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"owner": session.Owner,
+			}).Error("Unable to check storage usage")
+
+			utils.JSONResponse(w, 500, &FilesUpdateResponse{
+				Success: false,
+				Message: "Internal error (code FI/UP/02)",
+			})
+			return
+		}
+
+		if !fits {
+			utils.JSONResponse(w, 403, &FilesUpdateResponse{
+				Success: false,
+				Message: "Your plan's storage limit has been reached",
+			})
+			return
+		}
+	}
+
 	if input.Data != "" {
 		file.Data = input.Data
 	}
@@ -260,6 +319,7 @@ func FilesUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	AddStorage(file.Owner, delta)
 
 	// Write the file to the response
 	utils.JSONResponse(w, 200, &FilesUpdateResponse{
@@ -312,6 +372,7 @@ func FilesDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	AddStorage(file.Owner, -len(file.Data))
 
 	// Write the file to the response
 	utils.JSONResponse(w, 200, &FilesDeleteResponse{
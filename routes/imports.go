@@ -0,0 +1,159 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// maxImportSize is the biggest mailbox that can be uploaded
+const maxImportSize = 4 << 30
+
+// importRetention is how long uploaded mailboxes of jobs that never finish are kept
+const importRetention = 7 * 24 * time.Hour
+
+// AccountsImportResponse contains the result of the AccountsImport request.
+type AccountsImportResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message"`
+	Job     *models.Job `json:"job,omitempty"`
+}
+
+// AccountsImport accepts an mbox or EML file in the request body and starts
+// importing it. Imported emails are filed under the "Imported" label.
+func AccountsImport(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsImportResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	// Fetch the current session from the database
+	session := c.Env["token"].(*models.Token)
+
+	job := &models.Job{
+		Resource: models.MakeResource(session.Owner, models.JobAccountImport),
+		Type:     models.JobAccountImport,
+		Status:   models.JobQueued,
+	}
+
+	// Store the uploaded mailbox, the job may run on another instance
+	blob, err := env.Blobs.Create(session.Owner, job.ID, time.Now().Add(importRetention))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to create an import blob")
+
+		utils.JSONResponse(w, 500, &AccountsImportResponse{
+			Success: false,
+			Message: "Internal error (code AC/IM/01)",
+		})
+		return
+	}
+
+	size, err := io.Copy(blob, http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil || size == 0 {
+		removeImport(job.ID)
+
+		utils.JSONResponse(w, 400, &AccountsImportResponse{
+			Success: false,
+			Message: "Invalid or too big mailbox",
+		})
+		return
+	}
+
+	if err := blob.Close(); err != nil {
+		removeImport(job.ID)
+
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to store an uploaded mailbox")
+
+		utils.JSONResponse(w, 500, &AccountsImportResponse{
+			Success: false,
+			Message: "Internal error (code AC/IM/02)",
+		})
+		return
+	}
+
+	// Imported messages are stored, so they have to fit into the plan
+	fits, err := hasStorageFor(session.Owner, int(size))
+	if err != nil {
+		removeImport(job.ID)
+
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to check storage usage")
+
+		utils.JSONResponse(w, 500, &AccountsImportResponse{
+			Success: false,
+			Message: "Internal error (code AC/IM/05)",
+		})
+		return
+	}
+
+	if !fits {
+		removeImport(job.ID)
+
+		utils.JSONResponse(w, 403, &AccountsImportResponse{
+			Success: false,
+			Message: "Your plan's storage limit has been reached",
+		})
+		return
+	}
+
+	// Queue the import
+	if err := env.Jobs.Insert(job); err != nil {
+		removeImport(job.ID)
+
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert an import job")
+
+		utils.JSONResponse(w, 500, &AccountsImportResponse{
+			Success: false,
+			Message: "Internal error (code AC/IM/03)",
+		})
+		return
+	}
+
+	if err := publishJob(job.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    job.ID,
+		}).Error("Unable to publish an import job")
+
+		utils.JSONResponse(w, 500, &AccountsImportResponse{
+			Success: false,
+			Message: "Internal error (code AC/IM/04)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 202, &AccountsImportResponse{
+		Success: true,
+		Message: "Your mailbox is being imported",
+		Job:     job,
+	})
+}
+
+// removeImport removes an uploaded mailbox of a job that wasn't queued
+func removeImport(id string) {
+	if err := env.Blobs.DeleteBlob(id); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    id,
+		}).Error("Unable to remove an uploaded mailbox")
+	}
+}
@@ -0,0 +1,271 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// maxInviteTreeDepth limits the levels of invitees returned by InvitesList
+const maxInviteTreeDepth = 3
+
+// inviteQuotas contains the count of invitations that accounts of each type
+// can create. Both pending invitations and already created accounts count.
+var inviteQuotas = map[string]int{
+	"beta":      5,
+	"std":       3,
+	"premium":   10,
+	"superuser": 100,
+}
+
+// InviteTreeNode is an account created using an invitation
+type InviteTreeNode struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	DateCreated time.Time         `json:"date_created"`
+	Invited     []*InviteTreeNode `json:"invited,omitempty"`
+}
+
+// inviteTree returns accounts invited by id and their invitees
+func inviteTree(id string, depth int) ([]*InviteTreeNode, error) {
+	accounts, err := env.Accounts.GetInvitedBy(id)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*InviteTreeNode, len(accounts))
+	for i, account := range accounts {
+		nodes[i] = &InviteTreeNode{
+			ID:          account.ID,
+			Name:        account.StyledName,
+			DateCreated: account.DateCreated,
+		}
+
+		if depth > 1 {
+			nodes[i].Invited, err = inviteTree(account.ID, depth-1)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nodes, nil
+}
+
+// usedInvites returns pending invitations of the account and the count of
+// accounts created using its invitations.
+func usedInvites(id string) ([]*models.Token, int, error) {
+	invites, err := env.Tokens.GetOwnedByType(id, "invite")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	invited, err := env.Accounts.GetInvitedBy(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return invites, len(invited), nil
+}
+
+// InvitesListResponse contains the result of the InvitesList request.
+type InvitesListResponse struct {
+	Success   bool              `json:"success"`
+	Message   string            `json:"message,omitempty"`
+	Quota     int               `json:"quota"`
+	Remaining int               `json:"remaining"`
+	Invites   []*models.Token   `json:"invites,omitempty"`
+	Invited   []*InviteTreeNode `json:"invited,omitempty"`
+}
+
+// InvitesList returns pending invitations of the current user and the tree
+// of accounts created using them
+func InvitesList(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	account, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &InvitesListResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return
+	}
+
+	invites, invited, err := usedInvites(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch invitations of an account")
+
+		utils.JSONResponse(w, 500, &InvitesListResponse{
+			Success: false,
+			Message: "Internal error (code IN/LI/01)",
+		})
+		return
+	}
+
+	tree, err := inviteTree(account.ID, maxInviteTreeDepth)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch the invite tree of an account")
+
+		utils.JSONResponse(w, 500, &InvitesListResponse{
+			Success: false,
+			Message: "Internal error (code IN/LI/02)",
+		})
+		return
+	}
+
+	quota := inviteQuotas[account.Type]
+	remaining := quota - len(invites) - invited
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	utils.JSONResponse(w, 200, &InvitesListResponse{
+		Success:   true,
+		Quota:     quota,
+		Remaining: remaining,
+		Invites:   invites,
+		Invited:   tree,
+	})
+}
+
+// InvitesCreateResponse contains the result of the InvitesCreate request.
+type InvitesCreateResponse struct {
+	Success bool          `json:"success"`
+	Message string        `json:"message,omitempty"`
+	Invite  *models.Token `json:"invite,omitempty"`
+}
+
+// InvitesCreate creates a new invitation if the current user hasn't used up
+// the quota of their account type. The invitation's ID is the invite code.
+func InvitesCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	account, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &InvitesCreateResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return
+	}
+
+	invites, invited, err := usedInvites(account.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to fetch invitations of an account")
+
+		utils.JSONResponse(w, 500, &InvitesCreateResponse{
+			Success: false,
+			Message: "Internal error (code IN/CR/01)",
+		})
+		return
+	}
+
+	if len(invites)+invited >= inviteQuotas[account.Type] {
+		utils.JSONResponse(w, 403, &InvitesCreateResponse{
+			Success: false,
+			Message: "You have no invitations left",
+		})
+		return
+	}
+
+	invite := models.MakeInviteToken(account.ID)
+	if err := env.Tokens.Insert(&invite); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert an invitation")
+
+		utils.JSONResponse(w, 500, &InvitesCreateResponse{
+			Success: false,
+			Message: "Internal error (code IN/CR/02)",
+		})
+		return
+	}
+
+	// Concurrent requests could have passed the check above, so the quota is
+	// checked again with the new invitation counted in
+	invites, invited, err = usedInvites(account.ID)
+	if err != nil || len(invites)+invited > inviteQuotas[account.Type] {
+		if err := env.Tokens.DeleteID(invite.ID); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    invite.ID,
+			}).Error("Unable to remove an invitation over the quota")
+		}
+
+		utils.JSONResponse(w, 403, &InvitesCreateResponse{
+			Success: false,
+			Message: "You have no invitations left",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &InvitesCreateResponse{
+		Success: true,
+		Invite:  &invite,
+	})
+}
+
+// InvitesDeleteResponse contains the result of the InvitesDelete request.
+type InvitesDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// InvitesDelete revokes a pending invitation of the current user
+func InvitesDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	invite, err := env.Tokens.GetToken(c.URLParams["id"])
+	if err != nil || invite.Type != "invite" || invite.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &InvitesDeleteResponse{
+			Success: false,
+			Message: "Invitation not found",
+		})
+		return
+	}
+
+	// The invitation could have been redeemed in the meantime
+	deleted, err := env.Tokens.DeleteIDIf(invite.ID, map[string]interface{}{
+		"type": "invite",
+	})
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    invite.ID,
+		}).Error("Unable to delete an invitation")
+
+		utils.JSONResponse(w, 500, &InvitesDeleteResponse{
+			Success: false,
+			Message: "Internal error (code IN/DE/01)",
+		})
+		return
+	}
+	if !deleted {
+		utils.JSONResponse(w, 404, &InvitesDeleteResponse{
+			Success: false,
+			Message: "Invitation not found",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &InvitesDeleteResponse{
+		Success: true,
+		Message: "Invitation successfully revoked",
+	})
+}
@@ -0,0 +1,88 @@
+package routes
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// startJob queues a new background job of the owner. If a job of the same
+// type is already in progress, it is queued again and returned instead.
+func startJob(owner string, kind string) (*models.Job, error) {
+	return scheduleJob(owner, kind, time.Time{})
+}
+
+// scheduleJob works like startJob, but the job doesn't start before date.
+// Delayed jobs are queued by the scheduler once they are due.
+func scheduleJob(owner string, kind string, date time.Time) (*models.Job, error) {
+	job, err := env.Jobs.GetUnfinished(owner, kind)
+	if err != nil {
+		return nil, err
+	}
+
+	if job == nil {
+		job = &models.Job{
+			Resource:      models.MakeResource(owner, kind),
+			Type:          kind,
+			Status:        models.JobQueued,
+			DateScheduled: date,
+		}
+
+		if err := env.Jobs.Insert(job); err != nil {
+			return nil, err
+		}
+	} else if job.Status == models.JobQueued && !job.DateScheduled.Equal(date) {
+		job.DateScheduled = date
+		if err := env.Jobs.UpdateID(job.ID, map[string]interface{}{
+			"date_scheduled": date,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if !job.IsDue() {
+		return job, nil
+	}
+
+	if err := publishJob(job.ID); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// publishJob queues a job for execution by one of the API instances
+func publishJob(id string) error {
+	return env.Producer.Publish("account_jobs", []byte(`"`+id+`"`))
+}
+
+// JobsGetResponse contains the result of the JobsGet request.
+type JobsGetResponse struct {
+	Success bool        `json:"success"`
+	Message string      `json:"message,omitempty"`
+	Job     *models.Job `json:"job,omitempty"`
+}
+
+// JobsGet returns the progress of a background job
+func JobsGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	job, err := env.Jobs.GetJob(c.URLParams["id"])
+	if err != nil || job.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &JobsGetResponse{
+			Success: false,
+			Message: "Job not found",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &JobsGetResponse{
+		Success: true,
+		Job:     job,
+	})
+}
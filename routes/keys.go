@@ -185,6 +185,10 @@ func KeysCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAudit(c, r, session.Owner, models.AuditKeyUploaded, map[string]interface{}{
+		"fingerprint": key.ID,
+	})
+
 	// Return the inserted key
 	utils.JSONResponse(w, 201, &KeysCreateResponse{
 		Success: true,
@@ -4,7 +4,6 @@ import (
 	"net/http"
 
 	"github.com/Sirupsen/logrus"
-	r "github.com/dancannon/gorethink"
 	"github.com/lavab/api/env"
 	"github.com/lavab/api/models"
 	"github.com/lavab/api/utils"
@@ -22,19 +21,7 @@ type LabelsListResponse struct {
 func LabelsList(c web.C, w http.ResponseWriter, req *http.Request) {
 	session := c.Env["token"].(*models.Token)
 
-	cursor, err := env.Labels.GetTable().GetAllByIndex("nameOwnerBuiltin", []interface{}{
-		"Spam",
-		session.Owner,
-		true,
-	}, []interface{}{
-		"Trash",
-		session.Owner,
-		true,
-	}, []interface{}{
-		"Sent",
-		session.Owner,
-		true,
-	}).Run(env.Rethink)
+	spamTrashSent, err := env.Labels.GetBuiltin(session.Owner, "Spam", "Trash", "Sent")
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -46,19 +33,6 @@ func LabelsList(c web.C, w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	defer cursor.Close()
-	var spamTrashSent []*models.Label
-	if err := cursor.All(&spamTrashSent); err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Error("Unable to unmarshal account's specified labels")
-
-		utils.JSONResponse(w, 500, &LabelsListResponse{
-			Success: false,
-			Message: err.Error(),
-		})
-		return
-	}
 
 	if len(spamTrashSent) != 3 {
 		env.Log.WithFields(logrus.Fields{
@@ -73,22 +47,11 @@ func LabelsList(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	cursor, err = env.Labels.GetTable().GetAllByIndex("owner", session.Owner).Map(func(label r.Term) r.Term {
-		return label.Merge(map[string]interface{}{
-			"total_threads_count": env.Threads.GetTable().GetAllByIndex("labels", label.Field("id")).Count(),
-			"unread_threads_count": env.Threads.GetTable().GetAllByIndex("labels", label.Field("id")).Filter(func(thread r.Term) r.Term {
-				return r.Not(thread.Field("is_read")).And(
-					r.Not(
-						thread.Field("labels").Contains(spamTrashSent[0].ID).Or(
-							thread.Field("labels").Contains(spamTrashSent[1].ID).Or(
-								thread.Field("labels").Contains(spamTrashSent[2].ID),
-							),
-						),
-					),
-				)
-			}).Count(),
-		})
-	}).Run(env.Rethink)
+	labels, err := env.Labels.ListWithCounts(session.Owner, env.Threads, []string{
+		spamTrashSent[0].ID,
+		spamTrashSent[1].ID,
+		spamTrashSent[2].ID,
+	})
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -100,19 +63,6 @@ func LabelsList(c web.C, w http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	defer cursor.Close()
-	var labels []*models.Label
-	if err := cursor.All(&labels); err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Error("Unable to unmarshal account's labels")
-
-		utils.JSONResponse(w, 500, &LabelsListResponse{
-			Success: false,
-			Message: err.Error(),
-		})
-		return
-	}
 
 	utils.JSONResponse(w, 200, &LabelsListResponse{
 		Success: true,
@@ -187,6 +137,8 @@ func LabelsCreate(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	publishEvent(session.Owner, models.EventLabelCreate, label)
+
 	utils.JSONResponse(w, 201, &LabelsCreateResponse{
 		Success: true,
 		Label:   label,
@@ -331,6 +283,8 @@ func LabelsUpdate(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	publishEvent(session.Owner, models.EventLabelUpdate, label)
+
 	// Write the contact to the response
 	utils.JSONResponse(w, 200, &LabelsUpdateResponse{
 		Success: true,
@@ -383,6 +337,8 @@ func LabelsDelete(c web.C, w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	publishEvent(session.Owner, models.EventLabelDelete, label)
+
 	utils.JSONResponse(w, 200, &LabelsDeleteResponse{
 		Success: true,
 		Message: "Label successfully removed",
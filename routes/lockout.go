@@ -0,0 +1,264 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+const (
+	// lockoutThreshold is the count of failed logins after which the account is locked
+	lockoutThreshold = 5
+
+	// lockoutBase is the duration of the first lockout, it doubles after every
+	// next failed login
+	lockoutBase = time.Minute
+
+	// lockoutMax is the longest lockout
+	lockoutMax = 24 * time.Hour
+
+	// lockoutMemory is how long failed logins are counted
+	lockoutMemory = 24 * time.Hour
+)
+
+// usernameLimit counts login attempts of a username from all IPs, including
+// attempts of usernames that don't exist
+var usernameLimit = NewRateLimiter("login_username", 10, time.Minute)
+
+// getDeviceToken returns the device token issued to the account by one of its
+// previous logins or nil. User agents and networks can be forged, so only the
+// tokens identify known devices.
+func getDeviceToken(account *models.Account, id string) *models.Token {
+	if id == "" {
+		return nil
+	}
+
+	token, err := env.Tokens.GetToken(id)
+	if err != nil || token.Type != "device" || token.Owner != account.ID || token.Expired() {
+		return nil
+	}
+
+	return token
+}
+
+// checkLoginLimits applies the per-username limit and the lockout of the
+// account, which is nil if the username doesn't exist. Logins passing a device
+// token of the account aren't locked out, so that others can't lock its owner
+// out. False is returned after responding with 429 Too Many Requests.
+func checkLoginLimits(w http.ResponseWriter, r *http.Request, username string, account *models.Account, deviceToken string) bool {
+	allowed, wait, err := usernameLimit.Hit(username)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"username": username,
+		}).Error("Unable to check a rate limit")
+	} else if !allowed {
+		setRetryAfter(w, wait)
+		utils.JSONResponse(w, 429, &RateLimitResponse{
+			Success: false,
+			Message: "Too many login attempts, try again later",
+		})
+		return false
+	}
+
+	if account == nil || getDeviceToken(account, deviceToken) != nil {
+		return true
+	}
+
+	if wait := loginLockout(account); wait > 0 {
+		setRetryAfter(w, wait)
+		utils.JSONResponse(w, 429, &RateLimitResponse{
+			Success: false,
+			Message: "Too many failed login attempts, try again later",
+		})
+		return false
+	}
+
+	return true
+}
+
+// loginLockout returns how long the account stays locked
+func loginLockout(account *models.Account) time.Duration {
+	var until time.Time
+	if err := env.Cache.Get("lockout:until:"+account.ID, &until); err != nil {
+		return 0
+	}
+
+	if wait := until.Sub(time.Now()); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// recordFailedLogin audits and counts a failed password, 2FA or recovery code
+// check and locks the account once there were too many of them. An unlock link is sent
+// to the alt email when the account gets locked for the first time.
+func recordFailedLogin(c web.C, r *http.Request, account *models.Account, kind string) {
+	recordAudit(c, r, account.ID, kind, nil)
+
+	failures, err := env.Cache.Increment("lockout:failures:"+account.ID, 1, lockoutMemory)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to count a failed login")
+		return
+	}
+
+	if failures < lockoutThreshold {
+		return
+	}
+
+	duration := lockoutMax
+	if shift := failures - lockoutThreshold; shift < 12 {
+		if d := lockoutBase << uint(shift); d < lockoutMax {
+			duration = d
+		}
+	}
+
+	if err := env.Cache.Set("lockout:until:"+account.ID, time.Now().Add(duration), duration); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to lock an account")
+		return
+	}
+
+	env.Log.WithFields(logrus.Fields{
+		"id":       account.ID,
+		"failures": failures,
+		"duration": duration.String(),
+	}).Warn("Account locked after failed logins")
+
+	recordAudit(c, r, account.ID, models.AuditAccountLocked, map[string]interface{}{
+		"failures": failures,
+		"until":    time.Now().Add(duration),
+	})
+
+	if failures == lockoutThreshold {
+		if err := sendUnlockLink(account); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    account.ID,
+			}).Error("Unable to send an unlock link")
+		}
+	}
+}
+
+// resetFailedLogins unlocks the account and forgets its failed logins
+func resetFailedLogins(account *models.Account) {
+	if err := env.Cache.DeleteMulti("lockout:failures:"+account.ID, "lockout:until:"+account.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to reset failed logins")
+	}
+}
+
+// sendUnlockLink emails an unlock token to the verified alt email of the
+// account using the hook_account_unlock topic
+func sendUnlockLink(account *models.Account) error {
+	if account.AltEmail == "" || !account.AltEmailVerified {
+		return nil
+	}
+
+	// Only the latest token is valid
+	if err := env.Tokens.DeleteOwnedByType(account.ID, "unlock"); err != nil {
+		return err
+	}
+
+	token := models.MakeUnlockToken(account.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account": account.ID,
+		"email":   account.AltEmail,
+		"token":   token.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_account_unlock", data)
+}
+
+// AccountsUnlockRequest contains the input for the AccountsUnlock endpoint.
+type AccountsUnlockRequest struct {
+	Token string `json:"token" schema:"token"`
+}
+
+// AccountsUnlockResponse contains the output of the AccountsUnlock request.
+type AccountsUnlockResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// AccountsUnlock unlocks an account locked after failed logins using the
+// token sent to its alt email.
+func AccountsUnlock(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input AccountsUnlockRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsUnlockResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Type != "unlock" {
+		utils.JSONResponse(w, 400, &AccountsUnlockResponse{
+			Success: false,
+			Message: "Invalid unlock token",
+		})
+		return
+	}
+
+	if token.Expired() {
+		utils.JSONResponse(w, 400, &AccountsUnlockResponse{
+			Success: false,
+			Message: "Expired unlock token",
+		})
+		return
+	}
+
+	account, err := env.Accounts.GetTokenOwner(token)
+	if err != nil {
+		utils.JSONResponse(w, 400, &AccountsUnlockResponse{
+			Success: false,
+			Message: "Invalid unlock token",
+		})
+		return
+	}
+
+	resetFailedLogins(account)
+	recordAudit(c, r, account.ID, models.AuditAccountUnlocked, nil)
+
+	if err := env.Tokens.DeleteID(token.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to remove an unlock token")
+	}
+
+	utils.JSONResponse(w, 200, &AccountsUnlockResponse{
+		Success: true,
+		Message: "Your account has been unlocked",
+	})
+}
@@ -3,18 +3,164 @@ package routes
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/zenazn/goji/web"
 
 	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
 	"github.com/lavab/api/utils"
 )
 
+// scopedResources are the resources that API tokens can be given access to.
+// Other routes can be used only with auth tokens.
+var scopedResources = map[string]struct{}{
+	"accounts":  {},
+	"addresses": {},
+	"contacts":  {},
+	"emails":    {},
+	"files":     {},
+	"jobs":      {},
+	"keys":      {},
+	"labels":    {},
+	"sync":      {},
+	"threads":   {},
+	"webhooks":  {},
+}
+
+// readOnlyResources can only be read using API tokens
+var readOnlyResources = map[string]struct{}{
+	"accounts": {},
+}
+
+// lastUsedPrecision limits how often date_last_used of tokens is updated
+const lastUsedPrecision = time.Minute
+
+// isValidScope checks whether a scope is in the "resource:action" format,
+// where action is read, write or *. Read-only resources accept only read.
+func isValidScope(scope string) bool {
+	parts := strings.SplitN(scope, ":", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	if _, ok := scopedResources[parts[0]]; !ok {
+		return false
+	}
+
+	if _, ok := readOnlyResources[parts[0]]; ok {
+		return parts[1] == "read"
+	}
+
+	return parts[1] == "read" || parts[1] == "write" || parts[1] == "*"
+}
+
+// requiredScope returns the scope needed to perform the request using an API
+// token. An empty string is returned for requests that API tokens can't do.
+func requiredScope(r *http.Request) string {
+	resource := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if _, ok := scopedResources[resource]; !ok {
+		return ""
+	}
+
+	if r.Method == "GET" || r.Method == "HEAD" {
+		return resource + ":read"
+	}
+
+	// Account changes, including passwords, need a real session
+	if _, ok := readOnlyResources[resource]; ok {
+		return ""
+	}
+
+	return resource + ":write"
+}
+
 // AuthMiddlewareResponse is the response sent by the middleware if user is not logged in
 type AuthMiddlewareResponse struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success      bool       `json:"success"`
+	Message      string     `json:"message"`
+	Status       string     `json:"status,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	DeletionDate *time.Time `json:"deletion_date,omitempty"`
+}
+
+// pendingDeletionRoutes can change accounts pending deletion, which are
+// otherwise read-only
+var pendingDeletionRoutes = map[string]struct{}{
+	"POST /accounts/me/cancel-deletion": {},
+	"DELETE /tokens":                    {},
+	"DELETE /tokens/":                   {},
+}
+
+// isPendingDeletionRoute checks whether the request matches one of the
+// pendingDeletionRoutes. Routes ending with a slash match any ID.
+func isPendingDeletionRoute(r *http.Request) bool {
+	route := r.Method + " " + r.URL.Path
+	if _, ok := pendingDeletionRoutes[route]; ok {
+		return true
+	}
+
+	if i := strings.LastIndex(route, "/"); i != -1 && i < len(route)-1 {
+		_, ok := pendingDeletionRoutes[route[:i+1]]
+		return ok
+	}
+
+	return false
+}
+
+// isReadOnly checks whether the request doesn't change anything
+func isReadOnly(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+}
+
+// checkAccountStatus makes sure that the account's status allows the
+// request. If it doesn't, the response is written and false returned.
+func checkAccountStatus(w http.ResponseWriter, r *http.Request, owner string) bool {
+	account, err := env.Accounts.GetAccountStatus(owner)
+	if err != nil {
+		utils.JSONResponse(w, 401, &AuthMiddlewareResponse{
+			Success: false,
+			Message: "Invalid authorization token",
+		})
+		return false
+	}
+
+	switch account.Status {
+	case models.StatusSuspended:
+		response := &AuthMiddlewareResponse{
+			Success: false,
+			Message: "Your account is suspended",
+			Status:  account.Status,
+		}
+		if account.Suspension != nil {
+			response.Reason = account.Suspension.Reason
+		}
+
+		utils.JSONResponse(w, 403, response)
+		return false
+	case models.StatusPendingDeletion:
+		if isPendingDeletionRoute(r) || isReadOnly(r) {
+			return true
+		}
+
+		utils.JSONResponse(w, 403, &AuthMiddlewareResponse{
+			Success:      false,
+			Message:      "Your account is pending deletion and can't be changed until the deletion is cancelled",
+			Status:       account.Status,
+			DeletionDate: &account.DeletionDate,
+		})
+		return false
+	case models.StatusDeleted:
+		utils.JSONResponse(w, 403, &AuthMiddlewareResponse{
+			Success: false,
+			Message: "Your account is being deleted",
+			Status:  account.Status,
+		})
+		return false
+	}
+
+	return true
 }
 
 // AuthMiddleware checks whether the token passed with the request is valid
@@ -54,6 +200,15 @@ func AuthMiddleware(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 
+		// Only auth, API and OAuth tokens can be used to authenticate requests
+		if token.Type != "auth" && !token.IsScoped() {
+			utils.JSONResponse(w, 401, &AuthMiddlewareResponse{
+				Success: false,
+				Message: "Invalid authorization token",
+			})
+			return
+		}
+
 		// Check if it's expired
 		if token.Expired() {
 			utils.JSONResponse(w, 419, &AuthMiddlewareResponse{
@@ -64,6 +219,36 @@ func AuthMiddleware(c *web.C, h http.Handler) http.Handler {
 			return
 		}
 
+		// API and OAuth tokens are limited to their scopes
+		if token.IsScoped() {
+			if scope := requiredScope(r); scope == "" || !token.HasScope(scope) {
+				utils.JSONResponse(w, 403, &AuthMiddlewareResponse{
+					Success: false,
+					Message: "Insufficient token scope",
+				})
+				return
+			}
+		}
+
+		// Enforce the status of the account
+		if !checkAccountStatus(w, r, token.Owner) {
+			return
+		}
+
+		// Record the activity, at most once per lastUsedPrecision
+		if time.Since(token.DateLastUsed) > lastUsedPrecision {
+			token.DateLastUsed = time.Now()
+
+			if err := env.Tokens.UpdateID(token.ID, map[string]interface{}{
+				"date_last_used": token.DateLastUsed,
+			}); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"id":    token.ID,
+				}).Warn("Unable to update the last usage of a token")
+			}
+		}
+
 		// Continue to the next middleware/route
 		c.Env["token"] = token
 		h.ServeHTTP(w, r)
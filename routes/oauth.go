@@ -0,0 +1,973 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// maxOAuthClients is the count of OAuth clients that an account can register
+const maxOAuthClients = 20
+
+// OAuthClientsListResponse contains the result of the OAuthClientsList request.
+type OAuthClientsListResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message,omitempty"`
+	Clients []*models.OAuthClient `json:"clients,omitempty"`
+}
+
+// OAuthClientsList returns OAuth clients registered by the current user
+func OAuthClientsList(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	clients, err := env.OAuthClients.GetOwnedBy(session.Owner)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch OAuth clients")
+
+		utils.JSONResponse(w, 500, &OAuthClientsListResponse{
+			Success: false,
+			Message: "Internal error (code OA/CL/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &OAuthClientsListResponse{
+		Success: true,
+		Clients: clients,
+	})
+}
+
+// OAuthClientsCreateRequest contains the input for the OAuthClientsCreate endpoint.
+type OAuthClientsCreateRequest struct {
+	Name         string   `json:"name" schema:"name"`
+	Homepage     string   `json:"homepage" schema:"homepage"`
+	RedirectURIs []string `json:"redirect_uris" schema:"redirect_uris"`
+	Confidential bool     `json:"confidential" schema:"confidential"`
+}
+
+// OAuthClientsCreateResponse contains the result of the OAuthClientsCreate request.
+type OAuthClientsCreateResponse struct {
+	Success bool                `json:"success"`
+	Message string              `json:"message,omitempty"`
+	Client  *models.OAuthClient `json:"client,omitempty"`
+	Secret  string              `json:"secret,omitempty"`
+}
+
+// OAuthClientsCreate registers a new OAuth client. The secret of confidential
+// clients is returned only once.
+func OAuthClientsCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input OAuthClientsCreateRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "OAuth clients can be registered only using an auth token",
+		})
+		return
+	}
+
+	if input.Name == "" || len(input.Name) > 64 {
+		utils.JSONResponse(w, 400, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Invalid client name - it has to be at max 64 characters long",
+		})
+		return
+	}
+
+	if input.Homepage != "" && !isHomepage(input.Homepage) {
+		utils.JSONResponse(w, 400, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Invalid homepage",
+		})
+		return
+	}
+
+	if len(input.RedirectURIs) == 0 {
+		utils.JSONResponse(w, 400, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "No redirect URIs were passed",
+		})
+		return
+	}
+
+	for _, uri := range input.RedirectURIs {
+		if !isRedirectURI(uri) {
+			utils.JSONResponse(w, 400, &OAuthClientsCreateResponse{
+				Success: false,
+				Message: "Invalid redirect URI " + uri,
+			})
+			return
+		}
+	}
+
+	clients, err := env.OAuthClients.GetOwnedBy(session.Owner)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch OAuth clients")
+
+		utils.JSONResponse(w, 500, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Internal error (code OA/CC/01)",
+		})
+		return
+	}
+
+	if len(clients) >= maxOAuthClients {
+		utils.JSONResponse(w, 403, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Too many OAuth clients",
+		})
+		return
+	}
+
+	client := &models.OAuthClient{
+		Resource:     models.MakeResource(session.Owner, input.Name),
+		Homepage:     input.Homepage,
+		RedirectURIs: input.RedirectURIs,
+		Confidential: input.Confidential,
+	}
+
+	var secret string
+	if client.Confidential {
+		secret = client.GenerateSecret()
+	}
+
+	if err := env.OAuthClients.Insert(client); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert an OAuth client")
+
+		utils.JSONResponse(w, 500, &OAuthClientsCreateResponse{
+			Success: false,
+			Message: "Internal error (code OA/CC/02)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &OAuthClientsCreateResponse{
+		Success: true,
+		Client:  client,
+		Secret:  secret,
+	})
+}
+
+// OAuthClientsDeleteResponse contains the result of the OAuthClientsDelete request.
+type OAuthClientsDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// OAuthClientsDelete removes an OAuth client of the current user and revokes
+// all tokens issued to it
+func OAuthClientsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	client, err := env.OAuthClients.GetClient(c.URLParams["id"])
+	if err != nil || client.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &OAuthClientsDeleteResponse{
+			Success: false,
+			Message: "OAuth client not found",
+		})
+		return
+	}
+
+	if err := deleteOAuthClient(client); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    client.ID,
+		}).Error("Unable to delete an OAuth client")
+
+		utils.JSONResponse(w, 500, &OAuthClientsDeleteResponse{
+			Success: false,
+			Message: "Internal error (code OA/CD/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &OAuthClientsDeleteResponse{
+		Success: true,
+		Message: "OAuth client successfully removed",
+	})
+}
+
+// deleteOAuthClient revokes all tokens of the client and removes it
+func deleteOAuthClient(client *models.OAuthClient) error {
+	tokens, err := env.Tokens.DeleteByClient(client.ID)
+
+	revoked := map[string][]string{}
+	for _, token := range tokens {
+		revoked[token.Owner] = append(revoked[token.Owner], token.ID)
+	}
+	for owner, ids := range revoked {
+		closeSubscriptions(owner, ids...)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return env.OAuthClients.DeleteID(client.ID)
+}
+
+// isHomepage checks whether an URI is a plain HTTP(S) address of a website
+func isHomepage(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.User != nil || parsed.Host == "" {
+		return false
+	}
+
+	return parsed.Scheme == "http" || parsed.Scheme == "https"
+}
+
+// isRedirectURI checks whether an URI can be used as a redirect URI. Native
+// apps can use custom schemes, fragments are not allowed.
+func isRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return parsed.Host != ""
+	case "http":
+		// Plain HTTP is allowed only for local development
+		host := parsed.Host
+		if i := strings.LastIndex(host, ":"); i != -1 {
+			host = host[:i]
+		}
+
+		return host == "localhost" || host == "127.0.0.1"
+	case "javascript", "data", "file", "vbscript":
+		return false
+	}
+
+	return true
+}
+
+// OAuthAuthorizeRequest contains the parameters of an authorization request
+type OAuthAuthorizeRequest struct {
+	ResponseType        string `json:"response_type" schema:"response_type"`
+	ClientID            string `json:"client_id" schema:"client_id"`
+	RedirectURI         string `json:"redirect_uri" schema:"redirect_uri"`
+	Scope               string `json:"scope" schema:"scope"`
+	State               string `json:"state" schema:"state"`
+	CodeChallenge       string `json:"code_challenge" schema:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method" schema:"code_challenge_method"`
+	Approve             bool   `json:"approve" schema:"approve"`
+}
+
+// OAuthAuthorizeResponse contains the result of the OAuthAuthorize requests.
+type OAuthAuthorizeResponse struct {
+	Success   bool                `json:"success"`
+	Message   string              `json:"message,omitempty"`
+	Client    *models.OAuthClient `json:"client,omitempty"`
+	Scopes    []string            `json:"scopes,omitempty"`
+	Consented bool                `json:"consented,omitempty"`
+	Redirect  string              `json:"redirect,omitempty"`
+}
+
+// checkAuthorizeRequest validates an authorization request. The returned
+// message describes the problem.
+func checkAuthorizeRequest(input *OAuthAuthorizeRequest) (*models.OAuthClient, []string, string) {
+	client, err := env.OAuthClients.GetClient(input.ClientID)
+	if err != nil {
+		return nil, nil, "Invalid client ID"
+	}
+
+	// Clients with a single redirect URI don't have to pass it
+	if input.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		input.RedirectURI = client.RedirectURIs[0]
+	}
+	if !client.HasRedirectURI(input.RedirectURI) {
+		return nil, nil, "Invalid redirect URI"
+	}
+
+	if input.ResponseType != "code" {
+		return nil, nil, "Only the code response type is supported"
+	}
+
+	// PKCE is required for all clients
+	if input.CodeChallenge == "" || input.CodeChallengeMethod != "S256" {
+		return nil, nil, "A S256 code challenge is required"
+	}
+
+	scopes := strings.Fields(input.Scope)
+	if len(scopes) == 0 {
+		return nil, nil, "No scopes were requested"
+	}
+
+	for _, scope := range scopes {
+		if !isValidScope(scope) {
+			return nil, nil, "Invalid scope " + scope
+		}
+	}
+
+	return client, scopes, ""
+}
+
+// OAuthAuthorizeGet validates an authorization request, so that the user can
+// be asked for consent. Consented is true if the user has already allowed
+// the client to access all requested scopes.
+func OAuthAuthorizeGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	input := OAuthAuthorizeRequest{
+		ResponseType:        query.Get("response_type"),
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, scopes, message := checkAuthorizeRequest(&input)
+	if message != "" {
+		utils.JSONResponse(w, 400, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+
+	consent, err := env.Tokens.GetConsent(session.Owner, client.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"client": client.ID,
+		}).Error("Unable to fetch a consent")
+
+		utils.JSONResponse(w, 500, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: "Internal error (code OA/AG/01)",
+		})
+		return
+	}
+
+	consented := consent != nil
+	for _, scope := range scopes {
+		if consented && !consent.HasScope(scope) {
+			consented = false
+		}
+	}
+
+	utils.JSONResponse(w, 200, &OAuthAuthorizeResponse{
+		Success:   true,
+		Client:    client,
+		Scopes:    scopes,
+		Consented: consented,
+	})
+}
+
+// OAuthAuthorize records the user's decision about an authorization request.
+// Redirect is the URI that the user should be sent to, containing either
+// the authorization code or an error.
+func OAuthAuthorize(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input OAuthAuthorizeRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	// Only the user can consent, not other clients
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: "Clients can be authorized only using an auth token",
+		})
+		return
+	}
+
+	client, scopes, message := checkAuthorizeRequest(&input)
+	if message != "" {
+		utils.JSONResponse(w, 400, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	query := url.Values{}
+	if input.State != "" {
+		query.Set("state", input.State)
+	}
+
+	if !input.Approve {
+		query.Set("error", "access_denied")
+
+		utils.JSONResponse(w, 200, &OAuthAuthorizeResponse{
+			Success:  true,
+			Redirect: redirectWithQuery(input.RedirectURI, query),
+		})
+		return
+	}
+
+	// A new consent replaces the previous one
+	consent, err := env.Tokens.GetConsent(session.Owner, client.ID)
+	if err == nil && consent != nil {
+		err = env.Tokens.DeleteID(consent.ID)
+	}
+	if err == nil {
+		newConsent := models.MakeConsentToken(session.Owner, client.ID, scopes)
+		err = env.Tokens.Insert(&newConsent)
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"client": client.ID,
+		}).Error("Unable to record a consent")
+
+		utils.JSONResponse(w, 500, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: "Internal error (code OA/AU/01)",
+		})
+		return
+	}
+
+	code := models.MakeOAuthCodeToken(session.Owner, client.ID, input.RedirectURI, input.CodeChallenge, scopes)
+	if err := env.Tokens.Insert(&code); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"client": client.ID,
+		}).Error("Unable to insert an authorization code")
+
+		utils.JSONResponse(w, 500, &OAuthAuthorizeResponse{
+			Success: false,
+			Message: "Internal error (code OA/AU/02)",
+		})
+		return
+	}
+
+	query.Set("code", code.ID)
+
+	utils.JSONResponse(w, 200, &OAuthAuthorizeResponse{
+		Success:  true,
+		Redirect: redirectWithQuery(input.RedirectURI, query),
+	})
+}
+
+// redirectWithQuery appends the query to a redirect URI
+func redirectWithQuery(uri string, query url.Values) string {
+	if strings.Contains(uri, "?") {
+		return uri + "&" + query.Encode()
+	}
+
+	return uri + "?" + query.Encode()
+}
+
+// OAuthTokenRequest contains the input for the OAuthToken endpoint.
+type OAuthTokenRequest struct {
+	GrantType    string `json:"grant_type" schema:"grant_type"`
+	Code         string `json:"code" schema:"code"`
+	RedirectURI  string `json:"redirect_uri" schema:"redirect_uri"`
+	CodeVerifier string `json:"code_verifier" schema:"code_verifier"`
+	RefreshToken string `json:"refresh_token" schema:"refresh_token"`
+	ClientID     string `json:"client_id" schema:"client_id"`
+	ClientSecret string `json:"client_secret" schema:"client_secret"`
+	Token        string `json:"token" schema:"token"`
+}
+
+// OAuthTokenResponse is the token response defined in RFC 6749
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// OAuthErrorResponse is the error response defined in RFC 6749
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authenticateClient returns the client making a request to the token,
+// introspection or revocation endpoints. Credentials are read either from
+// the Authorization header or from the request body. Public clients only
+// pass their ID.
+func authenticateClient(r *http.Request, input *OAuthTokenRequest) (*models.OAuthClient, bool) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = input.ClientID, input.ClientSecret
+	}
+
+	client, err := env.OAuthClients.GetClient(id)
+	if err != nil {
+		return nil, false
+	}
+
+	if client.Confidential != (secret != "") {
+		return nil, false
+	}
+
+	if client.Confidential && !client.VerifySecret(secret) {
+		return nil, false
+	}
+
+	return client, true
+}
+
+// OAuthToken exchanges an authorization code or a refresh token for new
+// access and refresh tokens
+func OAuthToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	var input OAuthTokenRequest
+	if err := utils.ParseRequest(r, &input); err != nil {
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "invalid_request",
+		})
+		return
+	}
+
+	client, ok := authenticateClient(r, &input)
+	if !ok {
+		utils.JSONResponse(w, 401, &OAuthErrorResponse{
+			Error: "invalid_client",
+		})
+		return
+	}
+
+	var grant *models.Token
+	switch input.GrantType {
+	case "authorization_code":
+		grant, ok = exchangeCode(client, &input)
+	case "refresh_token":
+		grant, ok = exchangeRefreshToken(client, &input)
+	default:
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "unsupported_grant_type",
+		})
+		return
+	}
+
+	if !ok {
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "invalid_grant",
+		})
+		return
+	}
+
+	// Access is granted only while the consent exists
+	consent, err := env.Tokens.GetConsent(grant.Owner, client.ID)
+	if err != nil || consent == nil {
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "invalid_grant",
+		})
+		return
+	}
+
+	token := models.MakeOAuthToken(grant.Owner, client.ID, grant.Family, grant.Scopes)
+	refresh := models.MakeOAuthRefreshToken(grant.Owner, client.ID, grant.Family, grant.Scopes)
+
+	err = env.Tokens.Insert(&token)
+	if err == nil {
+		err = env.Tokens.Insert(&refresh)
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"client": client.ID,
+		}).Error("Unable to insert OAuth tokens")
+
+		utils.JSONResponse(w, 500, &OAuthErrorResponse{
+			Error:            "server_error",
+			ErrorDescription: "Internal error (code OA/TO/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &OAuthTokenResponse{
+		AccessToken:  token.ID,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(token.ExpiryDate.Sub(time.Now().UTC()).Seconds()),
+		RefreshToken: refresh.ID,
+		Scope:        strings.Join(token.Scopes, " "),
+	})
+}
+
+// exchangeCode redeems an authorization code. Codes can be used only once,
+// reusing one revokes the tokens issued using it. The returned token is the
+// code, its ID becomes the family of the grant.
+func exchangeCode(client *models.OAuthClient, input *OAuthTokenRequest) (*models.Token, bool) {
+	code, err := env.Tokens.GetToken(input.Code)
+	if err != nil || code.Client != client.ID {
+		return nil, false
+	}
+
+	if code.Type == ".oauth_code" {
+		revokeGrant(code.Owner, code.Client)
+		return nil, false
+	}
+
+	if code.Type != "oauth_code" {
+		return nil, false
+	}
+
+	// Only one of concurrent requests using the code can remove it
+	deleted, err := env.Tokens.DeleteIDIf(code.ID, map[string]interface{}{
+		"type": "oauth_code",
+	})
+	if err != nil {
+		return nil, false
+	}
+
+	if !deleted {
+		revokeGrant(code.Owner, code.Client)
+		return nil, false
+	}
+
+	// The used code is kept until it expires to detect its reuse
+	used := *code
+	used.Type = ".oauth_code"
+	if err := env.Tokens.Insert(&used); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    code.ID,
+		}).Warn("Unable to mark an authorization code as used")
+	}
+
+	if code.Expired() || code.RedirectURI != input.RedirectURI {
+		return nil, false
+	}
+
+	// Verify the PKCE challenge
+	if !isCodeVerifier(input.CodeVerifier) {
+		return nil, false
+	}
+
+	hash := sha256.Sum256([]byte(input.CodeVerifier))
+	challenge := strings.TrimRight(base64.URLEncoding.EncodeToString(hash[:]), "=")
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(code.CodeChallenge)) != 1 {
+		return nil, false
+	}
+
+	code.Family = code.ID
+	return code, true
+}
+
+// isCodeVerifier checks whether a PKCE code verifier has 43-128 characters
+// of the unreserved set defined in RFC 7636
+func isCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	for _, c := range verifier {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// revokeGrant removes all tokens that the owner gave to the client after a
+// reused authorization code or refresh token, so the user has to authorize
+// the client again
+func revokeGrant(owner string, client string) {
+	revoked, err := env.Tokens.DeleteOwnedByClient(owner, client)
+	closeSubscriptions(owner, revoked...)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"owner":  owner,
+			"client": client,
+		}).Error("Unable to revoke an OAuth grant")
+	}
+
+	env.Log.WithFields(logrus.Fields{
+		"owner":  owner,
+		"client": client,
+	}).Warn("OAuth grant reuse detected, revoked the grant")
+}
+
+// exchangeRefreshToken redeems a refresh token, which is replaced by the new
+// one. Reusing a refresh token revokes the grant.
+func exchangeRefreshToken(client *models.OAuthClient, input *OAuthTokenRequest) (*models.Token, bool) {
+	refresh, err := env.Tokens.GetToken(input.RefreshToken)
+	if err != nil || refresh.Client != client.ID {
+		return nil, false
+	}
+
+	if refresh.Type == ".oauth_refresh" {
+		revokeGrant(refresh.Owner, refresh.Client)
+		return nil, false
+	}
+
+	if refresh.Type != "oauth_refresh" || refresh.Expired() {
+		return nil, false
+	}
+
+	// Keep the used token until it expires to detect its reuse. Only one of
+	// concurrent requests using the same token can invalidate it.
+	invalidated, err := env.Tokens.UpdateIDIf(refresh.ID, map[string]interface{}{
+		"type": "oauth_refresh",
+	}, map[string]interface{}{
+		"type": ".oauth_refresh",
+	})
+	if err != nil {
+		return nil, false
+	}
+
+	if !invalidated {
+		revokeGrant(refresh.Owner, refresh.Client)
+		return nil, false
+	}
+
+	return refresh, true
+}
+
+// OAuthIntrospectResponse is the introspection response defined in RFC 7662
+type OAuthIntrospectResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// OAuthIntrospect returns information about a token issued to the client
+// making the request. Tokens of other clients are reported as inactive.
+func OAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	var input OAuthTokenRequest
+	if err := utils.ParseRequest(r, &input); err != nil {
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "invalid_request",
+		})
+		return
+	}
+
+	client, ok := authenticateClient(r, &input)
+	if !ok {
+		utils.JSONResponse(w, 401, &OAuthErrorResponse{
+			Error: "invalid_client",
+		})
+		return
+	}
+
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Client != client.ID || token.Expired() ||
+		(token.Type != "oauth" && token.Type != "oauth_refresh") {
+		utils.JSONResponse(w, 200, &OAuthIntrospectResponse{
+			Active: false,
+		})
+		return
+	}
+
+	tokenType := "access_token"
+	if token.Type == "oauth_refresh" {
+		tokenType = "refresh_token"
+	}
+
+	utils.JSONResponse(w, 200, &OAuthIntrospectResponse{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, " "),
+		ClientID:  client.ID,
+		Subject:   token.Owner,
+		TokenType: tokenType,
+		ExpiresAt: token.ExpiryDate.Unix(),
+		IssuedAt:  token.DateCreated.Unix(),
+	})
+}
+
+// OAuthRevoke revokes a token issued to the client making the request, as
+// defined in RFC 7009. Revoking a refresh token also revokes access tokens
+// of the same grant.
+func OAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	var input OAuthTokenRequest
+	if err := utils.ParseRequest(r, &input); err != nil {
+		utils.JSONResponse(w, 400, &OAuthErrorResponse{
+			Error: "invalid_request",
+		})
+		return
+	}
+
+	client, ok := authenticateClient(r, &input)
+	if !ok {
+		utils.JSONResponse(w, 401, &OAuthErrorResponse{
+			Error: "invalid_client",
+		})
+		return
+	}
+
+	// Unknown tokens don't cause errors
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Client != client.ID {
+		w.WriteHeader(200)
+		return
+	}
+
+	var revoked []string
+	switch token.Type {
+	case "oauth":
+		err = env.Tokens.DeleteID(token.ID)
+		revoked = []string{token.ID}
+	case "oauth_refresh":
+		revoked, err = env.Tokens.DeleteFamily(token.Owner, token.Family)
+	}
+	closeSubscriptions(token.Owner, revoked...)
+
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to revoke an OAuth token")
+
+		utils.JSONResponse(w, 503, &OAuthErrorResponse{
+			Error: "temporarily_unavailable",
+		})
+		return
+	}
+
+	w.WriteHeader(200)
+}
+
+// OAuthConsent is a client that the user has allowed to access the account
+type OAuthConsent struct {
+	Client      *models.OAuthClient `json:"client"`
+	Scopes      []string            `json:"scopes"`
+	DateCreated time.Time           `json:"date_created"`
+}
+
+// OAuthConsentsListResponse contains the result of the OAuthConsentsList request.
+type OAuthConsentsListResponse struct {
+	Success  bool            `json:"success"`
+	Message  string          `json:"message,omitempty"`
+	Consents []*OAuthConsent `json:"consents,omitempty"`
+}
+
+// OAuthConsentsList returns the clients authorized by the current user
+func OAuthConsentsList(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	consents, err := env.Tokens.GetOwnedByType(session.Owner, "consent")
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch consents")
+
+		utils.JSONResponse(w, 500, &OAuthConsentsListResponse{
+			Success: false,
+			Message: "Internal error (code OA/CO/01)",
+		})
+		return
+	}
+
+	result := []*OAuthConsent{}
+	for _, consent := range consents {
+		// Skip consents of removed clients
+		client, err := env.OAuthClients.GetClient(consent.Client)
+		if err != nil {
+			continue
+		}
+
+		result = append(result, &OAuthConsent{
+			Client:      client,
+			Scopes:      consent.Scopes,
+			DateCreated: consent.DateCreated,
+		})
+	}
+
+	utils.JSONResponse(w, 200, &OAuthConsentsListResponse{
+		Success:  true,
+		Consents: result,
+	})
+}
+
+// OAuthConsentsDeleteResponse contains the result of the OAuthConsentsDelete request.
+type OAuthConsentsDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// OAuthConsentsDelete withdraws the consent given to a client and revokes
+// all of its tokens. The ID is the client's ID.
+func OAuthConsentsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &OAuthConsentsDeleteResponse{
+			Success: false,
+			Message: "Consents can be withdrawn only using an auth token",
+		})
+		return
+	}
+
+	revoked, err := env.Tokens.DeleteOwnedByClient(session.Owner, c.URLParams["id"])
+	closeSubscriptions(session.Owner, revoked...)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"client": c.URLParams["id"],
+		}).Error("Unable to withdraw a consent")
+
+		utils.JSONResponse(w, 500, &OAuthConsentsDeleteResponse{
+			Success: false,
+			Message: "Internal error (code OA/CO/02)",
+		})
+		return
+	}
+
+	if len(revoked) == 0 {
+		utils.JSONResponse(w, 404, &OAuthConsentsDeleteResponse{
+			Success: false,
+			Message: "Consent not found",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &OAuthConsentsDeleteResponse{
+		Success: true,
+		Message: "Consent successfully withdrawn",
+	})
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lavab/api/db"
+)
+
+// parsePage reads the pagination parameters of a list request. cursor is the
+// token returned in the "next" or "prev" field of the previous page, limit is
+// the count of items in a page and defaults to db.DefaultPageLimit. Filtered
+// lists are counted only if count is set to true.
+func parsePage(r *http.Request) (*db.Page, error) {
+	var (
+		query     = r.URL.Query()
+		cursorRaw = query.Get("cursor")
+		limitRaw  = query.Get("limit")
+		countRaw  = query.Get("count")
+		page      = &db.Page{}
+	)
+
+	// Lists used to accept offset and sort, don't let them be ignored silently
+	if query.Get("offset") != "" {
+		return nil, errors.New("Offset is no longer supported, use cursor instead")
+	}
+	if sort := query.Get("sort"); sort != "" && sort != "-date_modified" {
+		return nil, errors.New("Lists are always sorted by -date_modified")
+	}
+
+	if cursorRaw != "" {
+		cursor, err := db.DecodeCursor(cursorRaw)
+		if err != nil {
+			return nil, err
+		}
+		page.Cursor = cursor
+	}
+
+	if limitRaw != "" {
+		limit, err := strconv.Atoi(limitRaw)
+		if err != nil || limit < 1 || limit > db.MaxPageLimit {
+			return nil, errors.New("Invalid limit")
+		}
+		page.Limit = limit
+	}
+
+	if countRaw != "" {
+		count, err := strconv.ParseBool(countRaw)
+		if err != nil {
+			return nil, errors.New("Invalid count")
+		}
+		page.Count = count
+	}
+
+	return page, nil
+}
+
+// setTotalCount adds the count of all items matching the filter to the
+// response, unless the list wasn't counted.
+func setTotalCount(w http.ResponseWriter, result *db.PageResult) {
+	if result.Total < 0 {
+		return
+	}
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(result.Total))
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/utils"
+)
+
+// RateLimiter limits the count of requests in a sliding window. Counters are
+// kept in the cache, so the limits are shared by all API instances.
+type RateLimiter struct {
+	// Name separates counters of different limiters
+	Name string
+
+	// Limit is the count of requests allowed in a window
+	Limit int
+
+	// Window is the length of the sliding window
+	Window time.Duration
+
+	// Key returns the key that requests are counted by, the client's IP by default
+	Key func(r *http.Request) string
+}
+
+// NewRateLimiter creates a limiter allowing limit requests per window from
+// a single IP
+func NewRateLimiter(name string, limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Name:   name,
+		Limit:  limit,
+		Window: window,
+		Key:    utils.RequestIP,
+	}
+}
+
+// Hit counts a request of the key. If the limit is exceeded, it returns false
+// and the time after which requests are allowed again.
+func (l *RateLimiter) Hit(key string) (bool, time.Duration, error) {
+	now := time.Now().UnixNano()
+	window := int64(l.Window)
+	index := now / window
+	elapsed := now % window
+
+	prefix := "ratelimit:" + l.Name + ":" + key + ":"
+
+	current, err := env.Cache.Increment(prefix+strconv.FormatInt(index, 10), 1, 2*l.Window)
+	if err != nil {
+		return false, 0, err
+	}
+
+	previous, err := env.Cache.Increment(prefix+strconv.FormatInt(index-1, 10), 0, 2*l.Window)
+	if err != nil {
+		return false, 0, err
+	}
+
+	// Requests of the previous window are weighted by the part of it that's
+	// still inside the sliding window
+	estimate := float64(previous)*float64(window-elapsed)/float64(window) + float64(current)
+	if estimate <= float64(l.Limit) {
+		return true, 0, nil
+	}
+
+	return false, time.Duration(window - elapsed), nil
+}
+
+// RateLimitResponse is returned when a rate limit is exceeded.
+type RateLimitResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// Middleware is a goji middleware rejecting requests over the limit with
+// 429 Too Many Requests. Requests are let through if the cache fails.
+func (l *RateLimiter) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, r) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// MiddlewareC works like Middleware, but wraps a handler that uses the
+// request context.
+func (l *RateLimiter) MiddlewareC(h web.HandlerFunc) web.HandlerFunc {
+	return func(c web.C, w http.ResponseWriter, r *http.Request) {
+		if l.allow(w, r) {
+			h(c, w, r)
+		}
+	}
+}
+
+// allow counts the request and writes the 429 response if it's over the limit
+func (l *RateLimiter) allow(w http.ResponseWriter, r *http.Request) bool {
+	allowed, wait, err := l.Hit(l.Key(r))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":   err.Error(),
+			"limiter": l.Name,
+		}).Error("Unable to check a rate limit")
+
+		return true
+	}
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.Limit))
+
+	if !allowed {
+		setRetryAfter(w, wait)
+		utils.JSONResponse(w, 429, &RateLimitResponse{
+			Success: false,
+			Message: "Too many requests",
+		})
+		return false
+	}
+
+	return true
+}
+
+// setRetryAfter sets the Retry-After header, rounded up to whole seconds
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	seconds := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,440 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+const (
+	// reservationLifetime is the count of hours after which a confirmed reservation expires
+	reservationLifetime = 30 * 24
+
+	// pendingReservationLifetime is the count of hours to confirm a reservation
+	pendingReservationLifetime = 24
+
+	// maxSuggestions is the count of alternative usernames returned for taken ones
+	maxSuggestions = 3
+)
+
+// suggestionPatterns are used to create alternatives of a taken username
+var suggestionPatterns = []string{"%s1", "%s2", "%s3", "the%s", "%smail", "real%s", "%s99"}
+
+// isValidUsername checks the length of a normalized username
+func isValidUsername(username string) bool {
+	return len(username) >= 3 && len(utils.RemoveDots(username)) >= 3 && len(username) <= 32
+}
+
+// isUsernameTaken checks whether a username is used by an account or an
+// address, or reserved for an email other than the passed one.
+func isUsernameTaken(name string, email string) (bool, error) {
+	if _, err := env.Addresses.GetAddress(name); err == nil {
+		return true, nil
+	}
+
+	if used, err := env.Accounts.IsUsernameUsed(name); err != nil || used {
+		return used, err
+	}
+
+	reservation, err := env.Reservations.GetByName(name)
+	if err != nil {
+		return false, err
+	}
+
+	return reservation != nil && reservation.Email != strings.ToLower(email), nil
+}
+
+// suggestUsernames returns available alternatives of a taken username
+func suggestUsernames(username string) ([]string, error) {
+	suggestions := []string{}
+
+	for _, pattern := range suggestionPatterns {
+		suggestion := fmt.Sprintf(pattern, username)
+		if !isValidUsername(suggestion) {
+			continue
+		}
+
+		taken, err := isUsernameTaken(utils.RemoveDots(suggestion), "")
+		if err != nil {
+			return nil, err
+		}
+
+		if !taken {
+			suggestions = append(suggestions, suggestion)
+			if len(suggestions) == maxSuggestions {
+				break
+			}
+		}
+	}
+
+	return suggestions, nil
+}
+
+// ReservationsCheckResponse contains the result of the ReservationsCheck request.
+type ReservationsCheckResponse struct {
+	Success     bool     `json:"success"`
+	Message     string   `json:"message,omitempty"`
+	Available   bool     `json:"available"`
+	Suggestions []string `json:"suggestions,omitempty"`
+}
+
+// ReservationsCheck checks whether the username passed in the query can be
+// reserved. Available alternatives are suggested for taken usernames. Usernames
+// reserved by anyone, including the caller, are reported as taken.
+func ReservationsCheck(w http.ResponseWriter, r *http.Request) {
+	username := utils.NormalizeUsername(r.URL.Query().Get("username"))
+	if !isValidUsername(username) {
+		utils.JSONResponse(w, 400, &ReservationsCheckResponse{
+			Success: false,
+			Message: "Invalid username - it has to be at least 3 and at max 32 characters long",
+		})
+		return
+	}
+
+	taken, err := isUsernameTaken(utils.RemoveDots(username), "")
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"username": username,
+		}).Error("Unable to check whether an username is taken")
+
+		utils.JSONResponse(w, 500, &ReservationsCheckResponse{
+			Success: false,
+			Message: "Internal error (code RE/CH/01)",
+		})
+		return
+	}
+
+	if !taken {
+		utils.JSONResponse(w, 200, &ReservationsCheckResponse{
+			Success:   true,
+			Available: true,
+		})
+		return
+	}
+
+	suggestions, err := suggestUsernames(username)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"username": username,
+		}).Error("Unable to suggest alternative usernames")
+
+		utils.JSONResponse(w, 500, &ReservationsCheckResponse{
+			Success: false,
+			Message: "Internal error (code RE/CH/02)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &ReservationsCheckResponse{
+		Success:     true,
+		Available:   false,
+		Suggestions: suggestions,
+	})
+}
+
+// ReservationsCreateRequest contains the input for the ReservationsCreate endpoint.
+type ReservationsCreateRequest struct {
+	Username string `json:"username" schema:"username"`
+	Email    string `json:"email" schema:"email"`
+}
+
+// ReservationsCreateResponse contains the output of the ReservationsCreate request.
+type ReservationsCreateResponse struct {
+	Success     bool                `json:"success"`
+	Message     string              `json:"message"`
+	Reservation *models.Reservation `json:"reservation,omitempty"`
+	Suggestions []string            `json:"suggestions,omitempty"`
+}
+
+// ReservationsCreate starts a reservation of a username for an email. The
+// reservation is held after it's confirmed using the token sent to the email,
+// then only that email can register the username until it expires.
+func ReservationsCreate(w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input ReservationsCreateRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	input.Username = utils.NormalizeUsername(input.Username)
+	if !isValidUsername(input.Username) {
+		utils.JSONResponse(w, 400, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Invalid username - it has to be at least 3 and at max 32 characters long",
+		})
+		return
+	}
+
+	if _, err := mail.ParseAddress(input.Email); err != nil {
+		utils.JSONResponse(w, 400, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Invalid email",
+		})
+		return
+	}
+	input.Email = strings.ToLower(input.Email)
+
+	// Each email can hold only a single confirmed reservation
+	if used, err := env.Reservations.IsEmailUsed(input.Email); err != nil || used {
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to lookup reservations for emails")
+		}
+
+		utils.JSONResponse(w, 409, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Email already used",
+		})
+		return
+	}
+
+	if used, err := env.Accounts.IsEmailUsed(input.Email); err != nil || used {
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to lookup registered accounts for emails")
+		}
+
+		utils.JSONResponse(w, 409, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Email already used",
+		})
+		return
+	}
+
+	taken, err := isUsernameTaken(utils.RemoveDots(input.Username), input.Email)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":    err.Error(),
+			"username": input.Username,
+		}).Error("Unable to check whether an username is taken")
+
+		utils.JSONResponse(w, 500, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Internal error (code RE/CR/01)",
+		})
+		return
+	}
+
+	if taken {
+		suggestions, _ := suggestUsernames(input.Username)
+
+		utils.JSONResponse(w, 409, &ReservationsCreateResponse{
+			Success:     false,
+			Message:     "Username already used",
+			Suggestions: suggestions,
+		})
+		return
+	}
+
+	// Only the latest unconfirmed reservation of the email is kept
+	if err := env.Reservations.DeleteUnconfirmed(input.Email); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to remove unconfirmed reservations")
+
+		utils.JSONResponse(w, 500, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Internal error (code RE/CR/03)",
+		})
+		return
+	}
+
+	reservation := &models.Reservation{
+		Resource:   models.MakeResource("", utils.RemoveDots(input.Username)),
+		StyledName: input.Username,
+		Email:      input.Email,
+	}
+	reservation.ExpireAfterNHours(pendingReservationLifetime)
+
+	if err := env.Reservations.Insert(reservation); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert a reservation")
+
+		utils.JSONResponse(w, 500, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Internal error (code RE/CR/02)",
+		})
+		return
+	}
+
+	if err := sendReservationConfirmation(reservation); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    reservation.ID,
+		}).Error("Unable to send a reservation confirmation")
+
+		utils.JSONResponse(w, 500, &ReservationsCreateResponse{
+			Success: false,
+			Message: "Internal error (code RE/CR/04)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &ReservationsCreateResponse{
+		Success:     true,
+		Message:     "Confirm the reservation using the link sent to your email",
+		Reservation: reservation,
+	})
+}
+
+// sendReservationConfirmation emails a confirmation token of the reservation
+// using the hook_confirm_reservation topic
+func sendReservationConfirmation(reservation *models.Reservation) error {
+	token := models.MakeReservationToken(reservation.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"reservation": reservation.ID,
+		"username":    reservation.StyledName,
+		"email":       reservation.Email,
+		"token":       token.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_confirm_reservation", data)
+}
+
+// ReservationsConfirmRequest contains the input for the ReservationsConfirm endpoint.
+type ReservationsConfirmRequest struct {
+	Token string `json:"token" schema:"token"`
+}
+
+// ReservationsConfirmResponse contains the output of the ReservationsConfirm request.
+type ReservationsConfirmResponse struct {
+	Success     bool                `json:"success"`
+	Message     string              `json:"message"`
+	Reservation *models.Reservation `json:"reservation,omitempty"`
+}
+
+// ReservationsConfirm holds a reservation using the token sent to its email,
+// unless the username was taken in the meantime.
+func ReservationsConfirm(w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input ReservationsConfirmRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &ReservationsConfirmResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	token, err := env.Tokens.GetToken(input.Token)
+	if err != nil || token.Type != "reservation" || token.Expired() {
+		utils.JSONResponse(w, 400, &ReservationsConfirmResponse{
+			Success: false,
+			Message: "Invalid confirmation token",
+		})
+		return
+	}
+
+	var reservation models.Reservation
+	if err := env.Reservations.FindFetchOne(token.Name, &reservation); err != nil || reservation.Expired() {
+		utils.JSONResponse(w, 410, &ReservationsConfirmResponse{
+			Success: false,
+			Message: "The reservation has expired",
+		})
+		return
+	}
+
+	if !reservation.Confirmed {
+		if used, err := env.Reservations.IsEmailUsed(reservation.Email); err != nil || used {
+			if err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to lookup reservations for emails")
+			}
+
+			utils.JSONResponse(w, 409, &ReservationsConfirmResponse{
+				Success: false,
+				Message: "Email already used",
+			})
+			return
+		}
+
+		taken, err := isUsernameTaken(reservation.Name, reservation.Email)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error":    err.Error(),
+				"username": reservation.Name,
+			}).Error("Unable to check whether an username is taken")
+
+			utils.JSONResponse(w, 500, &ReservationsConfirmResponse{
+				Success: false,
+				Message: "Internal error (code RE/CO/01)",
+			})
+			return
+		}
+
+		if taken {
+			utils.JSONResponse(w, 409, &ReservationsConfirmResponse{
+				Success: false,
+				Message: "Username already used",
+			})
+			return
+		}
+
+		reservation.Confirmed = true
+		reservation.ExpireAfterNHours(reservationLifetime)
+
+		if err := env.Reservations.UpdateID(reservation.ID, map[string]interface{}{
+			"confirmed":   reservation.Confirmed,
+			"expiry_date": reservation.ExpiryDate,
+		}); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    reservation.ID,
+			}).Error("Unable to confirm a reservation")
+
+			utils.JSONResponse(w, 500, &ReservationsConfirmResponse{
+				Success: false,
+				Message: "Internal error (code RE/CO/02)",
+			})
+			return
+		}
+	}
+
+	if err := env.Tokens.DeleteID(token.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    token.ID,
+		}).Error("Unable to remove a used reservation token")
+	}
+
+	utils.JSONResponse(w, 200, &ReservationsConfirmResponse{
+		Success:     true,
+		Message:     "Your username has been reserved",
+		Reservation: &reservation,
+	})
+}
@@ -0,0 +1,347 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// recoveryCodesCount is the count of recovery codes generated for an account
+const recoveryCodesCount = 10
+
+// resetWarning is shown in every step of the password reset
+const resetWarning = "Your private key is encrypted using your password. " +
+	"After the reset, emails and contacts encrypted using your old key stay unreadable " +
+	"unless you still have a copy of your old private key."
+
+// AccountsResetRequest contains the input for the AccountsReset endpoint.
+type AccountsResetRequest struct {
+	Username     string `json:"username,omitempty" schema:"username"`
+	Token        string `json:"token,omitempty" schema:"token"`
+	RecoveryCode string `json:"recovery_code,omitempty" schema:"recovery_code"`
+	Password     string `json:"password,omitempty" schema:"password"`
+	DeviceToken  string `json:"device_token,omitempty" schema:"device_token"`
+}
+
+// AccountsResetResponse contains the output of the AccountsReset request.
+type AccountsResetResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// AccountsReset resets a forgotten password.
+func AccountsReset(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input AccountsResetRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsResetResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	// Reset flow:
+	// 1) POST /accounts/reset {username}                          => sends a token to the alt email
+	// 2) POST /accounts/reset {token}                             => checks token validity
+	// 3) POST /accounts/reset {token, password}                   => sets the password
+	//    POST /accounts/reset {username, recovery_code, password} => sets the password using a recovery code
+	if input.Username != "" && input.Token == "" && input.RecoveryCode == "" && input.Password == "" {
+		requestReset(w, input.Username)
+		return
+	}
+
+	var (
+		account *models.Account
+		token   *models.Token
+	)
+
+	if input.Token != "" && input.Username == "" && input.RecoveryCode == "" {
+		token, err = env.Tokens.GetToken(input.Token)
+		if err != nil || token.Type != "reset" {
+			utils.JSONResponse(w, 400, &AccountsResetResponse{
+				Success: false,
+				Message: "Invalid reset token",
+			})
+			return
+		}
+
+		if token.Expired() {
+			utils.JSONResponse(w, 400, &AccountsResetResponse{
+				Success: false,
+				Message: "Expired reset token",
+			})
+			return
+		}
+
+		account, err = env.Accounts.GetTokenOwner(token)
+		if err != nil || !account.AltEmailVerified {
+			utils.JSONResponse(w, 400, &AccountsResetResponse{
+				Success: false,
+				Message: "Invalid reset token",
+			})
+			return
+		}
+
+		// Only check the token
+		if input.Password == "" {
+			utils.JSONResponse(w, 200, &AccountsResetResponse{
+				Success: true,
+				Message: "Valid token was provided",
+				Warning: resetWarning,
+			})
+			return
+		}
+	} else if input.Username != "" && input.RecoveryCode != "" && input.Password != "" && input.Token == "" {
+		username := utils.RemoveDots(utils.NormalizeUsername(input.Username))
+
+		// Recovery codes are guessed like passwords, so they share the limits
+		account, err = env.Accounts.FindAccountByName(username)
+		if err != nil {
+			account = nil
+		}
+		if !checkLoginLimits(w, r, username, account, input.DeviceToken) {
+			return
+		}
+
+		if account == nil || !account.UseRecoveryCode(input.RecoveryCode) {
+			if account != nil {
+				recordFailedLogin(c, r, account, models.AuditRecoveryCodeFailed)
+			}
+
+			utils.JSONResponse(w, 403, &AccountsResetResponse{
+				Success: false,
+				Message: "Invalid username or recovery code",
+			})
+			return
+		}
+	} else {
+		utils.JSONResponse(w, 400, &AccountsResetResponse{
+			Success: false,
+			Message: "Invalid request",
+		})
+		return
+	}
+
+	// Ensure that user has chosen a secure password (check against 10k most used)
+	if env.PasswordBF.TestString(input.Password) {
+		utils.JSONResponse(w, 403, &AccountsResetResponse{
+			Success: false,
+			Message: "Weak password",
+			Warning: resetWarning,
+		})
+		return
+	}
+
+	if err := account.SetPassword(input.Password); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to hash the password")
+
+		utils.JSONResponse(w, 500, &AccountsResetResponse{
+			Success: false,
+			Message: "Internal error (code AC/RE/01)",
+		})
+		return
+	}
+	account.PasswordResetRequired = false
+	account.Touch()
+
+	// Also saves the used recovery code
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsResetResponse{
+			Success: false,
+			Message: "Internal error (code AC/RE/02)",
+		})
+		return
+	}
+
+	// The owner got back in, failed logins don't matter anymore
+	resetFailedLogins(account)
+
+	// Revoke all sessions, device tokens and remaining reset tokens
+	revoked, err := env.Tokens.DeleteSessions(account.ID, "")
+	closeSubscriptions(account.ID, revoked...)
+	if err == nil {
+		err = env.Tokens.DeleteOwnedByType(account.ID, "device")
+	}
+	if err == nil {
+		err = env.Tokens.DeleteOwnedByType(account.ID, "reset")
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to revoke tokens after a password reset")
+	}
+
+	method := "token"
+	if token == nil {
+		method = "recovery_code"
+	}
+	recordAudit(c, r, account.ID, models.AuditPasswordReset, map[string]interface{}{
+		"method": method,
+	})
+
+	utils.JSONResponse(w, 200, &AccountsResetResponse{
+		Success: true,
+		Message: "Your password has been changed",
+		Warning: resetWarning,
+	})
+}
+
+// requestReset sends a reset token to account's alt email. The response
+// doesn't reveal whether the account exists.
+func requestReset(w http.ResponseWriter, username string) {
+	response := &AccountsResetResponse{
+		Success: true,
+		Message: "If the account has a verified alternative email, a reset link has been sent to it",
+		Warning: resetWarning,
+	}
+
+	account, err := env.Accounts.FindAccountByName(utils.RemoveDots(utils.NormalizeUsername(username)))
+	if err != nil || account.AltEmail == "" || !account.AltEmailVerified || !account.CanLogIn() {
+		utils.JSONResponse(w, 200, response)
+		return
+	}
+
+	if err := sendResetLink(account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to queue a password reset email")
+
+		utils.JSONResponse(w, 500, &AccountsResetResponse{
+			Success: false,
+			Message: "Internal error (code AC/RE/03)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, response)
+}
+
+// sendResetLink emails a reset token to the alt email of the account using
+// the hook_password_reset topic
+func sendResetLink(account *models.Account) error {
+	// Only the latest token is valid
+	if err := env.Tokens.DeleteOwnedByType(account.ID, "reset"); err != nil {
+		return err
+	}
+
+	token := models.MakeResetToken(account.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account": account.ID,
+		"email":   account.AltEmail,
+		"token":   token.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_password_reset", data)
+}
+
+// AccountsRecoveryCodesRequest contains the input for the AccountsRecoveryCodes endpoint.
+type AccountsRecoveryCodesRequest struct {
+	CurrentPassword string `json:"current_password" schema:"current_password"`
+}
+
+// AccountsRecoveryCodesResponse contains the output of the AccountsRecoveryCodes request.
+type AccountsRecoveryCodesResponse struct {
+	Success       bool     `json:"success"`
+	Message       string   `json:"message,omitempty"`
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// AccountsRecoveryCodes replaces recovery codes of the account. Codes are
+// shown only once, old codes stop working.
+func AccountsRecoveryCodes(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input AccountsRecoveryCodesRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &AccountsRecoveryCodesResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsRecoveryCodesResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	// Fetch the current session from the database
+	session := c.Env["token"].(*models.Token)
+
+	account, err := env.Accounts.GetAccount(session.Owner)
+	if err != nil {
+		utils.JSONResponse(w, 500, &AccountsRecoveryCodesResponse{
+			Success: false,
+			Message: "Unable to resolve the account",
+		})
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsRecoveryCodesResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	codes := account.GenerateRecoveryCodes(recoveryCodesCount)
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsRecoveryCodesResponse{
+			Success: false,
+			Message: "Internal error (code AC/RC/01)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditRecoveryCodesCreated, nil)
+
+	utils.JSONResponse(w, 200, &AccountsRecoveryCodesResponse{
+		Success:       true,
+		RecoveryCodes: codes,
+	})
+}
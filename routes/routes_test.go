@@ -0,0 +1,1477 @@
+package routes_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/willf/bloom"
+
+	"github.com/lavab/api/cache"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/routes"
+	"github.com/lavab/api/setup"
+)
+
+// server runs the API using the memory backend, so no external services are required
+var server *httptest.Server
+
+func TestMain(m *testing.M) {
+	// An empty bloom filter of leaked passwords
+	bf, err := ioutil.TempFile("", "api-bloom")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(bf.Name())
+
+	if _, err := bloom.NewWithEstimates(1000, 0.001).WriteTo(bf); err != nil {
+		panic(err)
+	}
+	bf.Close()
+
+	env.Config = &env.Flags{
+		APIVersion:       "v0",
+		LogFormatterType: "text",
+		EmailDomain:      "lavaboom.io",
+		WebURL:           "https://lavaboom.com",
+
+		SessionDuration:     72,
+		AccessTokenDuration: 15,
+		DeletionGracePeriod: 168,
+		SyncRetention:       720,
+
+		RethinkDBDatabase: "test",
+		MemoryBackend:     true,
+
+		BloomFilter: bf.Name(),
+		BloomCount:  1000,
+	}
+
+	server = httptest.NewServer(setup.PrepareMux(env.Config))
+
+	// Requests of the test client come from a trusted proxy, so that the
+	// client's IP can be passed in X-Real-IP
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	env.TrustedProxies = []*net.IPNet{loopback}
+
+	code := m.Run()
+	server.Close()
+
+	os.Exit(code)
+}
+
+// clients counts the requests of the test client
+var clients uint32
+
+// clientIP returns the next address of the client's network, so that the
+// per-IP rate limits aren't exhausted by all tests together
+func clientIP() string {
+	return fmt.Sprintf("192.0.2.%d", atomic.AddUint32(&clients, 1)%250+1)
+}
+
+// request sends a JSON request to the test server and decodes the response
+func request(t *testing.T, method, path, token string, input, output interface{}) *http.Response {
+	var body bytes.Buffer
+	if input != nil {
+		if err := json.NewEncoder(&body).Encode(input); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, server.URL+path, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Real-IP", clientIP())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if output != nil {
+		if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+
+	return resp
+}
+
+// createAccount inserts an account with builtin labels and signs into it
+func createAccount(t *testing.T, name string) (*models.Account, string) {
+	account := &models.Account{
+		Resource: models.MakeResource("", name),
+		Type:     "std",
+		Status:   models.StatusActive,
+	}
+	if err := account.SetPassword("fruityloops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Accounts.Insert(account); err != nil {
+		t.Fatal(err)
+	}
+
+	var labels []*models.Label
+	for _, label := range []string{"Inbox", "Sent", "Drafts", "Trash", "Spam", "Starred"} {
+		labels = append(labels, &models.Label{
+			Resource: models.MakeResource(account.ID, label),
+			Builtin:  true,
+		})
+	}
+	if err := env.Labels.Insert(labels); err != nil {
+		t.Fatal(err)
+	}
+
+	var response routes.TokensCreateResponse
+	resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: name,
+		Password: "fruityloops",
+	}, &response)
+	if resp.StatusCode != 201 || !response.Success {
+		t.Fatalf("unable to sign in: %d %s", resp.StatusCode, response.Message)
+	}
+
+	return account, response.Token.ID
+}
+
+func TestHello(t *testing.T) {
+	var response routes.HelloResponse
+	request(t, "GET", "/", "", nil, &response)
+
+	if response.Message != "Lavaboom API" {
+		t.Fatalf("unexpected message %q", response.Message)
+	}
+}
+
+func TestListRoutes(t *testing.T) {
+	_, token := createAccount(t, "johnorange")
+
+	for _, path := range []string{
+		"/threads",
+		"/emails",
+		"/files",
+		"/contacts",
+		"/labels",
+		"/addresses",
+		"/webhooks",
+		"/accounts/me/audit",
+		"/accounts/me/invoices",
+	} {
+		var response struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}
+		resp := request(t, "GET", path, token, nil, &response)
+		if resp.StatusCode != 200 || !response.Success {
+			t.Errorf("GET %s failed: %d %s", path, resp.StatusCode, response.Message)
+		}
+	}
+}
+
+func TestListParameters(t *testing.T) {
+	_, token := createAccount(t, "jackorange")
+
+	for path, status := range map[string]int{
+		"/threads?sort=-date_modified":  200,
+		"/threads?sort=date_created":    400,
+		"/emails?offset=10":             400,
+		"/emails?count=maybe":           400,
+		"/threads?label=Inbox&count=1":  200,
+		"/threads?label=Inbox&limit=10": 200,
+	} {
+		resp := request(t, "GET", path, token, nil, nil)
+		if resp.StatusCode != status {
+			t.Errorf("GET %s: expected %d, got %d", path, status, resp.StatusCode)
+		}
+	}
+
+	// Filtered lists are counted only on request
+	resp := request(t, "GET", "/threads?label=Inbox", token, nil, nil)
+	if resp.Header.Get("X-Total-Count") != "" {
+		t.Fatalf("filtered list was counted: %s", resp.Header.Get("X-Total-Count"))
+	}
+	resp = request(t, "GET", "/threads?label=Inbox&count=true", token, nil, nil)
+	if resp.Header.Get("X-Total-Count") != "0" {
+		t.Fatalf("filtered list wasn't counted: %q", resp.Header.Get("X-Total-Count"))
+	}
+}
+
+func TestLabelCounts(t *testing.T) {
+	account, token := createAccount(t, "jamesorange")
+
+	inbox, err := env.Labels.GetLabelByNameAndOwner(account.ID, "Inbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, read := range []bool{true, false} {
+		if err := env.Threads.Insert(&models.Thread{
+			Resource: models.MakeResource(account.ID, "thread"),
+			Labels:   []string{inbox.ID},
+			IsRead:   read,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var response routes.LabelsListResponse
+	resp := request(t, "GET", "/labels", token, nil, &response)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to list labels: %s", response.Message)
+	}
+
+	for _, label := range *response.Labels {
+		if label.ID != inbox.ID {
+			continue
+		}
+
+		if label.TotalThreadsCount != 2 || label.UnreadThreadsCount != 1 {
+			t.Fatalf("unexpected counts of the inbox: %d total, %d unread", label.TotalThreadsCount, label.UnreadThreadsCount)
+		}
+		return
+	}
+
+	t.Fatal("inbox was not listed")
+}
+
+func TestContactsSync(t *testing.T) {
+	_, token := createAccount(t, "janeorange")
+
+	var start routes.SyncResponse
+	request(t, "GET", "/sync", token, nil, &start)
+	if !start.Success || start.Token == "" {
+		t.Fatalf("unable to get the sync token: %s", start.Message)
+	}
+
+	var created routes.ContactsCreateResponse
+	resp := request(t, "POST", "/contacts", token, &routes.ContactsCreateRequest{
+		Data:            "encrypted",
+		Name:            "contact",
+		Encoding:        "json",
+		PGPFingerprints: []string{"fingerprint"},
+	}, &created)
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to create a contact: %d %s", resp.StatusCode, created.Message)
+	}
+
+	var list struct {
+		Success  bool              `json:"success"`
+		Contacts []*models.Contact `json:"contacts"`
+	}
+	resp = request(t, "GET", "/contacts?limit=1", token, nil, &list)
+	if len(list.Contacts) != 1 || resp.Header.Get("X-Total-Count") != "1" {
+		t.Fatalf("contact was not listed: %v, total %s", list.Contacts, resp.Header.Get("X-Total-Count"))
+	}
+
+	var changes routes.SyncResponse
+	request(t, "GET", "/sync?since="+start.Token, token, nil, &changes)
+	if !changes.Success || changes.Changes["contacts"] == nil || len(changes.Changes["contacts"].Created) != 1 {
+		t.Fatalf("the contact wasn't synced: %+v", changes)
+	}
+
+	var again routes.SyncResponse
+	request(t, "GET", "/sync?since="+changes.Token, token, nil, &again)
+	if !again.Success || len(again.Changes) != 0 {
+		t.Fatalf("the contact was synced twice: %+v", again)
+	}
+
+	// Tokens older than the retention of the change log expire
+	resp = request(t, "GET", "/sync?since=1.1000000000", token, nil, nil)
+	if resp.StatusCode != 410 {
+		t.Fatalf("expected an expired token, got %d", resp.StatusCode)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	var created routes.ReservationsCreateResponse
+	resp := request(t, "POST", "/reservations", "", &routes.ReservationsCreateRequest{
+		Username: "joeorange",
+		Email:    "joe@example.com",
+	}, &created)
+	if resp.StatusCode != 201 || created.Reservation == nil || created.Reservation.Confirmed {
+		t.Fatalf("unable to create a reservation: %d %s", resp.StatusCode, created.Message)
+	}
+
+	// Unconfirmed reservations don't hold the username
+	var check routes.ReservationsCheckResponse
+	request(t, "GET", "/reservations/check?username=joeorange", "", nil, &check)
+	if !check.Available {
+		t.Fatal("unconfirmed reservation holds the username")
+	}
+
+	var tokens []*models.Token
+	if err := env.Tokens.FindByAndFetch("name", created.Reservation.ID, &tokens); err != nil || len(tokens) != 1 {
+		t.Fatalf("confirmation token wasn't created: %v", err)
+	}
+
+	var confirmed routes.ReservationsConfirmResponse
+	resp = request(t, "POST", "/reservations/confirm", "", &routes.ReservationsConfirmRequest{
+		Token: tokens[0].ID,
+	}, &confirmed)
+	if resp.StatusCode != 200 || !confirmed.Reservation.Confirmed {
+		t.Fatalf("unable to confirm a reservation: %d %s", resp.StatusCode, confirmed.Message)
+	}
+
+	// The check doesn't tell whose reservation it is
+	request(t, "GET", "/reservations/check?username=joeorange&email=joe@example.com", "", nil, &check)
+	if check.Available {
+		t.Fatal("confirmed reservation doesn't hold the username")
+	}
+
+	resp = request(t, "POST", "/reservations/confirm", "", &routes.ReservationsConfirmRequest{
+		Token: tokens[0].ID,
+	}, nil)
+	if resp.StatusCode != 400 {
+		t.Fatalf("confirmation token was reused: %d", resp.StatusCode)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	_, token := createAccount(t, "jillorange")
+
+	resp := request(t, "POST", "/api-tokens", token, &routes.APITokensCreateRequest{
+		Name:   "script",
+		Scopes: []string{"accounts:write"},
+	}, nil)
+	if resp.StatusCode != 400 {
+		t.Fatalf("accounts:write scope was accepted: %d", resp.StatusCode)
+	}
+
+	var created routes.APITokensCreateResponse
+	resp = request(t, "POST", "/api-tokens", token, &routes.APITokensCreateRequest{
+		Name:   "script",
+		Scopes: []string{"emails:read"},
+	}, &created)
+	if resp.StatusCode != 201 || created.Secret == "" || created.Token.ID == created.Secret {
+		t.Fatalf("unable to create an API token: %d %s", resp.StatusCode, created.Message)
+	}
+
+	// Secrets are shown only once
+	var list routes.APITokensListResponse
+	request(t, "GET", "/api-tokens", token, nil, &list)
+	if len(list.Tokens) != 1 || list.Tokens[0].ID != created.Token.ID {
+		t.Fatalf("unexpected tokens %+v", list.Tokens)
+	}
+
+	if resp := request(t, "DELETE", "/api-tokens/"+created.Secret, token, nil, nil); resp.StatusCode != 404 {
+		t.Fatalf("token was revoked using its secret: %d", resp.StatusCode)
+	}
+	if resp := request(t, "DELETE", "/api-tokens/"+created.Token.ID, token, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to revoke a token: %d", resp.StatusCode)
+	}
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	_, token := createAccount(t, "jaceorange")
+
+	var created routes.APITokensCreateResponse
+	resp := request(t, "POST", "/api-tokens", token, &routes.APITokensCreateRequest{
+		Name:   "reader",
+		Scopes: []string{"labels:read", "accounts:read"},
+	}, &created)
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to create an API token: %d %s", resp.StatusCode, created.Message)
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		input  interface{}
+		status int
+	}{
+		{"GET", "/labels", nil, 200},
+		{"GET", "/accounts/me", nil, 200},
+		// Writes need a write scope
+		{"POST", "/labels", map[string]interface{}{"name": "Work"}, 403},
+		// Accounts can't be changed using API tokens
+		{"PUT", "/accounts/me", &routes.AccountsUpdateRequest{AltEmail: "jace@example.com"}, 403},
+		// Resources outside of the scopes
+		{"GET", "/contacts", nil, 403},
+		{"GET", "/api-tokens", nil, 403},
+	}
+	for _, c := range cases {
+		var response routes.AuthMiddlewareResponse
+		resp := request(t, c.method, c.path, created.Secret, c.input, &response)
+		if resp.StatusCode != c.status {
+			t.Fatalf("%s %s: expected %d, got %d", c.method, c.path, c.status, resp.StatusCode)
+		}
+		if c.status == 403 && response.Message != "Insufficient token scope" {
+			t.Fatalf("%s %s: unexpected message %q", c.method, c.path, response.Message)
+		}
+	}
+}
+
+func TestRefreshReplay(t *testing.T) {
+	createAccount(t, "jeanorange")
+
+	var login routes.TokensCreateResponse
+	request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jeanorange",
+		Password: "fruityloops",
+	}, &login)
+	if login.RefreshToken == nil {
+		t.Fatalf("no refresh token was returned: %s", login.Message)
+	}
+
+	var refreshed routes.TokensCreateResponse
+	resp := request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: login.RefreshToken.ID,
+	}, &refreshed)
+	if resp.StatusCode != 201 || refreshed.RefreshToken == nil {
+		t.Fatalf("unable to refresh a session: %d %s", resp.StatusCode, refreshed.Message)
+	}
+
+	// Reusing a refresh token ends the whole session
+	resp = request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: login.RefreshToken.ID,
+	}, nil)
+	if resp.StatusCode != 401 {
+		t.Fatalf("refresh token was reused: %d", resp.StatusCode)
+	}
+
+	resp = request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: refreshed.RefreshToken.ID,
+	}, nil)
+	if resp.StatusCode != 401 {
+		t.Fatalf("session survived a replay: %d", resp.StatusCode)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	createAccount(t, "jimorange")
+
+	login := func(ip string, password string, device string) (*http.Response, *routes.TokensCreateResponse) {
+		body, _ := json.Marshal(&routes.TokensCreateRequest{
+			Type:        "auth",
+			Username:    "jimorange",
+			Password:    password,
+			DeviceToken: device,
+		})
+
+		req, err := http.NewRequest("POST", server.URL+"/tokens", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Real-IP", ip)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var response routes.TokensCreateResponse
+		json.NewDecoder(resp.Body).Decode(&response)
+		return resp, &response
+	}
+
+	resp, owner := login("198.51.100.7", "fruityloops", "")
+	if resp.StatusCode != 201 || owner.DeviceToken == nil {
+		t.Fatalf("no device token was returned: %d %s", resp.StatusCode, owner.Message)
+	}
+
+	for i := 0; i < 5; i++ {
+		if resp, _ := login("203.0.113.7", "wrong", ""); resp.StatusCode != 403 {
+			t.Fatalf("unexpected status of a failed login: %d", resp.StatusCode)
+		}
+	}
+
+	resp, _ = login("203.0.113.7", "fruityloops", "")
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("locked account was logged into from a new device: %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Access-Control-Expose-Headers") != "Retry-After" {
+		t.Fatal("Retry-After isn't exposed to browsers")
+	}
+
+	// The same user agent from the owner's network isn't a known device
+	if resp, _ := login("198.51.100.8", "fruityloops", ""); resp.StatusCode != 429 {
+		t.Fatalf("locked account was logged into using a forged device: %d", resp.StatusCode)
+	}
+
+	// The owner's device token isn't locked out, but it's still limited
+	// per username
+	if resp, _ := login("198.51.100.7", "fruityloops", owner.DeviceToken.ID); resp.StatusCode != 201 {
+		t.Fatalf("locked account couldn't log in from a known device: %d", resp.StatusCode)
+	}
+	if resp, response := login("198.51.100.7", "fruityloops", owner.DeviceToken.ID); resp.StatusCode != 429 ||
+		response.Message != "Too many login attempts, try again later" {
+		t.Fatalf("known device wasn't limited per username: %d", resp.StatusCode)
+	}
+}
+
+func TestPendingDeletion(t *testing.T) {
+	account, token := createAccount(t, "joyorange")
+
+	var other routes.TokensCreateResponse
+	request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "joyorange",
+		Password: "fruityloops",
+	}, &other)
+	if other.Token == nil {
+		t.Fatalf("unable to sign in: %s", other.Message)
+	}
+
+	// Caches the active status
+	if resp := request(t, "GET", "/accounts/me", token, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to get the account: %d", resp.StatusCode)
+	}
+
+	if err := account.SetStatus(models.StatusPendingDeletion, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"status":         account.Status,
+		"status_history": account.StatusHistory,
+		"deletion_date":  time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := request(t, "POST", "/labels", token, map[string]interface{}{
+		"name": "Work",
+	}, nil); resp.StatusCode != 403 {
+		t.Fatalf("account pending deletion was changed: %d", resp.StatusCode)
+	}
+
+	// Other sessions can still be ended
+	if resp := request(t, "DELETE", "/tokens/"+other.Token.ID, token, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to end a session: %d", resp.StatusCode)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	inviter, token := createAccount(t, "jakeorange")
+
+	// std accounts have 3 invitations
+	var codes []string
+	for i := 0; i < 3; i++ {
+		var created routes.InvitesCreateResponse
+		resp := request(t, "POST", "/invites", token, nil, &created)
+		if resp.StatusCode != 201 {
+			t.Fatalf("unable to create an invitation: %d %s", resp.StatusCode, created.Message)
+		}
+		codes = append(codes, created.Invite.ID)
+	}
+	if resp := request(t, "POST", "/invites", token, nil, nil); resp.StatusCode != 403 {
+		t.Fatalf("invitation over the quota was created: %d", resp.StatusCode)
+	}
+
+	register := func(username string, code string) *http.Response {
+		return request(t, "POST", "/accounts", "", &routes.AccountsCreateRequest{
+			Username:   username,
+			AltEmail:   username + "@example.com",
+			InviteCode: code,
+		}, nil)
+	}
+
+	if resp := register("jakeinvitee", codes[0]); resp.StatusCode != 201 {
+		t.Fatalf("unable to register using an invitation: %d", resp.StatusCode)
+	}
+	if resp := register("jakeinvitee2", codes[0]); resp.StatusCode != 400 {
+		t.Fatalf("invitation was used twice: %d", resp.StatusCode)
+	}
+
+	if err := env.Tokens.UpdateID(codes[1], map[string]interface{}{
+		"expiry_date": time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp := register("jakeinvitee3", codes[1]); resp.StatusCode != 400 {
+		t.Fatalf("expired invitation was accepted: %d", resp.StatusCode)
+	}
+
+	// The tree is limited to 3 levels of invitees
+	invitee, err := env.Accounts.FindAccountByName("jakeinvitee")
+	if err != nil || invitee.InvitedBy != inviter.ID {
+		t.Fatalf("invitee wasn't linked to the inviter: %v", err)
+	}
+	parent := invitee.ID
+	for _, name := range []string{"jakesecond", "jakethird", "jakefourth"} {
+		account := &models.Account{
+			Resource:  models.MakeResource("", name),
+			Type:      "std",
+			Status:    models.StatusActive,
+			InvitedBy: parent,
+		}
+		if err := env.Accounts.Insert(account); err != nil {
+			t.Fatal(err)
+		}
+		parent = account.ID
+	}
+
+	// Expired invitations don't count
+	var list routes.InvitesListResponse
+	request(t, "GET", "/invites", token, nil, &list)
+	if list.Quota != 3 || list.Remaining != 1 || len(list.Invites) != 1 {
+		t.Fatalf("unexpected quota %d, remaining %d, invites %d", list.Quota, list.Remaining, len(list.Invites))
+	}
+
+	depth := 0
+	for nodes := list.Invited; len(nodes) > 0; nodes = nodes[0].Invited {
+		if len(nodes) != 1 {
+			t.Fatalf("unexpected invitees %v", nodes)
+		}
+		depth++
+	}
+	if depth != 3 {
+		t.Fatalf("expected 3 levels of invitees, got %d", depth)
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	account, token := createAccount(t, "judeorange")
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"alt_email":          "jude@example.com",
+		"alt_email_verified": true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var codes routes.AccountsRecoveryCodesResponse
+	resp := request(t, "POST", "/accounts/me/recovery-codes", token, &routes.AccountsRecoveryCodesRequest{
+		CurrentPassword: "fruityloops",
+	}, &codes)
+	if resp.StatusCode != 200 || len(codes.RecoveryCodes) == 0 {
+		t.Fatalf("unable to generate recovery codes: %d %s", resp.StatusCode, codes.Message)
+	}
+
+	reset := models.MakeResetToken(account.ID)
+	if err := env.Tokens.Insert(&reset); err != nil {
+		t.Fatal(err)
+	}
+
+	resp = request(t, "POST", "/accounts/reset", "", &routes.AccountsResetRequest{
+		Token:    reset.ID,
+		Password: "bananasplit",
+	}, nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to reset the password using a token: %d", resp.StatusCode)
+	}
+
+	// All sessions are revoked after a reset
+	if resp := request(t, "GET", "/accounts/me", token, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("session survived a password reset: %d", resp.StatusCode)
+	}
+
+	resp = request(t, "POST", "/accounts/reset", "", &routes.AccountsResetRequest{
+		Token:    reset.ID,
+		Password: "applepie",
+	}, nil)
+	if resp.StatusCode != 400 {
+		t.Fatalf("reset token was used twice: %d", resp.StatusCode)
+	}
+
+	resp = request(t, "POST", "/accounts/reset", "", &routes.AccountsResetRequest{
+		Username:     "judeorange",
+		RecoveryCode: codes.RecoveryCodes[0],
+		Password:     "cherrycake",
+	}, nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to reset the password using a recovery code: %d", resp.StatusCode)
+	}
+
+	resp = request(t, "POST", "/accounts/reset", "", &routes.AccountsResetRequest{
+		Username:     "judeorange",
+		RecoveryCode: codes.RecoveryCodes[0],
+		Password:     "applepie",
+	}, nil)
+	if resp.StatusCode != 403 {
+		t.Fatalf("recovery code was used twice: %d", resp.StatusCode)
+	}
+
+	var login routes.TokensCreateResponse
+	resp = request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "judeorange",
+		Password: "cherrycake",
+	}, &login)
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to sign in using the new password: %d %s", resp.StatusCode, login.Message)
+	}
+}
+
+func TestAltEmailConfirmation(t *testing.T) {
+	account, token := createAccount(t, "juneorange")
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"alt_email":          "june@example.com",
+		"alt_email_verified": true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// changeEmail requests a change of the alt email and returns the sent token
+	changeEmail := func(email string) *models.Token {
+		if resp := request(t, "PUT", "/accounts/me", token, &routes.AccountsUpdateRequest{
+			AltEmail: email,
+		}, nil); resp.StatusCode != 200 {
+			t.Fatalf("unable to change the alt email: %d", resp.StatusCode)
+		}
+
+		tokens, err := env.Tokens.GetOwnedByType(account.ID, "confirm")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tokens) != 1 || tokens[0].Name != email {
+			t.Fatalf("unexpected confirmation tokens %v", tokens)
+		}
+		return tokens[0]
+	}
+
+	confirm := func(id string) *http.Response {
+		return request(t, "POST", "/accounts/confirm", "", &routes.AccountsConfirmRequest{
+			Token: id,
+		}, nil)
+	}
+
+	// The old email stays in use until the new one is confirmed
+	first := changeEmail("june@example.org")
+	account, err := env.Accounts.GetAccount(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.AltEmail != "june@example.com" || account.PendingAltEmail != "june@example.org" {
+		t.Fatalf("unexpected emails %q, pending %q", account.AltEmail, account.PendingAltEmail)
+	}
+
+	if resp := confirm(first.ID); resp.StatusCode != 200 {
+		t.Fatalf("unable to confirm the alt email: %d", resp.StatusCode)
+	}
+	account, err = env.Accounts.GetAccount(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.AltEmail != "june@example.org" || account.PendingAltEmail != "" || !account.AltEmailVerified {
+		t.Fatalf("alt email wasn't replaced: %q, pending %q", account.AltEmail, account.PendingAltEmail)
+	}
+
+	if resp := confirm(first.ID); resp.StatusCode != 400 {
+		t.Fatalf("confirmation token was used twice: %d", resp.StatusCode)
+	}
+
+	// Cancelling a change invalidates its token
+	second := changeEmail("june@example.net")
+	if resp := request(t, "PUT", "/accounts/me", token, &routes.AccountsUpdateRequest{
+		AltEmail: "june@example.org",
+	}, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to cancel the change: %d", resp.StatusCode)
+	}
+	if resp := confirm(second.ID); resp.StatusCode != 400 {
+		t.Fatalf("outdated confirmation token was accepted: %d", resp.StatusCode)
+	}
+	account, err = env.Accounts.GetAccount(account.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.AltEmail != "june@example.org" {
+		t.Fatalf("cancelled email %q was confirmed", account.AltEmail)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	createAccount(t, "jessorange")
+
+	login := func() *routes.TokensCreateResponse {
+		var response routes.TokensCreateResponse
+		resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+			Type:     "auth",
+			Username: "jessorange",
+			Password: "fruityloops",
+		}, &response)
+		if resp.StatusCode != 201 || response.RefreshToken == nil {
+			t.Fatalf("unable to sign in: %d %s", resp.StatusCode, response.Message)
+		}
+		return &response
+	}
+	current := login()
+	other := login()
+
+	if resp := request(t, "DELETE", "/accounts/me/sessions?except=other", current.Token.ID, nil, nil); resp.StatusCode != 400 {
+		t.Fatalf("unexpected exception was accepted: %d", resp.StatusCode)
+	}
+	if resp := request(t, "DELETE", "/accounts/me/sessions?except=current", current.Token.ID, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to revoke other sessions: %d", resp.StatusCode)
+	}
+
+	if resp := request(t, "GET", "/accounts/me", other.Token.ID, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("other session wasn't revoked: %d", resp.StatusCode)
+	}
+	if resp := request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: other.RefreshToken.ID,
+	}, nil); resp.StatusCode != 401 {
+		t.Fatalf("other session was refreshed: %d", resp.StatusCode)
+	}
+
+	// The current session, including its refresh token, is kept
+	var sessions routes.AccountsSessionsListResponse
+	request(t, "GET", "/accounts/me/sessions", current.Token.ID, nil, &sessions)
+	if len(sessions.Sessions) != 1 || !sessions.Sessions[0].Current {
+		t.Fatalf("unexpected sessions %+v", sessions.Sessions)
+	}
+	if resp := request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: current.RefreshToken.ID,
+	}, nil); resp.StatusCode != 201 {
+		t.Fatalf("current session couldn't be refreshed: %d", resp.StatusCode)
+	}
+}
+
+func TestOAuth(t *testing.T) {
+	_, token := createAccount(t, "jadeorange")
+
+	if resp := request(t, "POST", "/oauth/clients", token, &routes.OAuthClientsCreateRequest{
+		Name:         "Reader",
+		Homepage:     "javascript:alert(1)",
+		RedirectURIs: []string{"https://reader.example.com/callback"},
+	}, nil); resp.StatusCode != 400 {
+		t.Fatalf("invalid homepage was accepted: %d", resp.StatusCode)
+	}
+
+	var client routes.OAuthClientsCreateResponse
+	resp := request(t, "POST", "/oauth/clients", token, &routes.OAuthClientsCreateRequest{
+		Name:         "Reader",
+		Homepage:     "https://reader.example.com",
+		RedirectURIs: []string{"https://reader.example.com/callback"},
+	}, &client)
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to register a client: %d %s", resp.StatusCode, client.Message)
+	}
+
+	verifier := strings.Repeat("abcdefgh-._~", 4)
+	hash := sha256.Sum256([]byte(verifier))
+	challenge := strings.TrimRight(base64.URLEncoding.EncodeToString(hash[:]), "=")
+
+	// authorize returns a new authorization code
+	authorize := func(challenge string) string {
+		var response routes.OAuthAuthorizeResponse
+		resp := request(t, "POST", "/oauth/authorize", token, &routes.OAuthAuthorizeRequest{
+			ResponseType:        "code",
+			ClientID:            client.Client.ID,
+			Scope:               "labels:read",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+			Approve:             true,
+		}, &response)
+		if resp.StatusCode != 200 {
+			t.Fatalf("unable to authorize the client: %d %s", resp.StatusCode, response.Message)
+		}
+
+		redirect, err := url.Parse(response.Redirect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return redirect.Query().Get("code")
+	}
+
+	exchange := func(input *routes.OAuthTokenRequest) (*http.Response, *routes.OAuthTokenResponse) {
+		input.ClientID = client.Client.ID
+		input.RedirectURI = "https://reader.example.com/callback"
+
+		var response routes.OAuthTokenResponse
+		resp := request(t, "POST", "/oauth/token", "", input, &response)
+		return resp, &response
+	}
+
+	// Verifiers have to be 43-128 characters of the unreserved set
+	for _, invalid := range []string{"short", verifier + "!", strings.Repeat("a", 129)} {
+		hash := sha256.Sum256([]byte(invalid))
+		code := authorize(strings.TrimRight(base64.URLEncoding.EncodeToString(hash[:]), "="))
+		if resp, _ := exchange(&routes.OAuthTokenRequest{
+			GrantType:    "authorization_code",
+			Code:         code,
+			CodeVerifier: invalid,
+		}); resp.StatusCode != 400 {
+			t.Fatalf("invalid code verifier %q was accepted: %d", invalid, resp.StatusCode)
+		}
+	}
+
+	code := authorize(challenge)
+	if resp, _ := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		CodeVerifier: strings.Repeat("x", 48),
+	}); resp.StatusCode != 400 {
+		t.Fatalf("wrong code verifier was accepted: %d", resp.StatusCode)
+	}
+
+	code = authorize(challenge)
+	resp, first := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		CodeVerifier: verifier,
+	})
+	if resp.StatusCode != 200 || first.AccessToken == "" || first.RefreshToken == "" {
+		t.Fatalf("unable to exchange a code: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/labels", first.AccessToken, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to use an access token: %d", resp.StatusCode)
+	}
+
+	// Refresh tokens are rotated
+	resp, second := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: first.RefreshToken,
+	})
+	if resp.StatusCode != 200 || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("unable to refresh a token: %d", resp.StatusCode)
+	}
+	resp, third := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: second.RefreshToken,
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to refresh a rotated token: %d", resp.StatusCode)
+	}
+
+	// Reusing a refresh token revokes the grant
+	if resp, _ := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: first.RefreshToken,
+	}); resp.StatusCode != 400 {
+		t.Fatalf("refresh token was reused: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/labels", third.AccessToken, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("access token survived a refresh token reuse: %d", resp.StatusCode)
+	}
+	if resp, _ := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "refresh_token",
+		RefreshToken: third.RefreshToken,
+	}); resp.StatusCode != 400 {
+		t.Fatalf("refresh token survived a refresh token reuse: %d", resp.StatusCode)
+	}
+
+	// Reusing a code revokes the grant
+	code = authorize(challenge)
+	resp, tokens := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		CodeVerifier: verifier,
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to exchange a code: %d", resp.StatusCode)
+	}
+	if resp, _ := exchange(&routes.OAuthTokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		CodeVerifier: verifier,
+	}); resp.StatusCode != 400 {
+		t.Fatalf("code was reused: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/labels", tokens.AccessToken, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("access token survived a code reuse: %d", resp.StatusCode)
+	}
+
+	var consents routes.OAuthConsentsListResponse
+	request(t, "GET", "/oauth/consents", token, nil, &consents)
+	if len(consents.Consents) != 0 {
+		t.Fatalf("consent survived a code reuse: %+v", consents.Consents)
+	}
+}
+
+func TestFactorReenrollment(t *testing.T) {
+	account, _ := createAccount(t, "jodyorange")
+
+	// Set by the 0016_legacy_authenticators migration
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"factor_reenrollment_required": true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var login routes.TokensCreateResponse
+	resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jodyorange",
+		Password: "fruityloops",
+	}, &login)
+	if resp.StatusCode != 201 || !login.FactorReenrollment {
+		t.Fatalf("login didn't ask for a new factor: %d %s", resp.StatusCode, login.Message)
+	}
+
+	// Backup codes alone don't complete the re-enrollment
+	account.FactorReenrollmentRequired = true
+	account.GenerateBackupCodes(1)
+	if !account.FactorReenrollmentRequired {
+		t.Fatal("backup codes completed the re-enrollment")
+	}
+	account.AddFactor("authenticator", "Phone", []string{"secret"})
+	if account.FactorReenrollmentRequired {
+		t.Fatal("a new authenticator didn't complete the re-enrollment")
+	}
+}
+
+// fakeYubiKey accepts OTPs starting with the public ID of the key, so that
+// YubiCloud isn't needed
+type fakeYubiKey struct{}
+
+func (fakeYubiKey) Type() string                        { return "yubicloud" }
+func (fakeYubiKey) Request(data string) (string, error) { return "", nil }
+func (fakeYubiKey) Verify(data []string, input string) (bool, error) {
+	return len(data) > 0 && len(input) > 12 && input[:12] == data[0], nil
+}
+
+func TestFactors(t *testing.T) {
+	account, token := createAccount(t, "joanorange")
+
+	yubicloud := env.Factors["yubicloud"]
+	env.Factors["yubicloud"] = fakeYubiKey{}
+	defer func() {
+		env.Factors["yubicloud"] = yubicloud
+	}()
+
+	// The factor in the format left by the 0010_account_factors migration
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"factors": []interface{}{map[string]interface{}{
+			"id":           "legacy",
+			"type":         "yubicloud",
+			"name":         "yubicloud",
+			"value":        []string{"cccccbhuvcrl"},
+			"date_created": time.Now(),
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(factorID string, token string) *routes.TokensCreateResponse {
+		var response routes.TokensCreateResponse
+		request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+			Type:     "auth",
+			Username: "joanorange",
+			Password: "fruityloops",
+			FactorID: factorID,
+			Token:    token,
+		}, &response)
+		return &response
+	}
+
+	challenge := login("", "")
+	if challenge.Success || len(challenge.Factors) != 1 || challenge.Factors[0].ID != "legacy" {
+		t.Fatalf("unexpected 2FA challenge %+v", challenge)
+	}
+	if response := login("legacy", "cccccbhuvcrl"+strings.Repeat("t", 32)); !response.Success {
+		t.Fatalf("unable to log in using a migrated YubiKey: %s", response.Message)
+	}
+
+	var codes routes.AccountsFactorsResponse
+	request(t, "POST", "/accounts/me/backup-codes", token, &routes.AccountsFactorsPasswordRequest{
+		CurrentPassword: "fruityloops",
+	}, &codes)
+	if len(codes.BackupCodes) == 0 {
+		t.Fatalf("unable to generate backup codes: %s", codes.Message)
+	}
+
+	if response := login("", codes.BackupCodes[0]); !response.Success {
+		t.Fatalf("unable to log in using a backup code: %s", response.Message)
+	}
+	if response := login("", codes.BackupCodes[0]); response.Success {
+		t.Fatal("backup code was used twice")
+	}
+
+	// Removing a factor requires the password
+	for _, password := range []string{"", "wrong"} {
+		if resp := request(t, "DELETE", "/accounts/me/factors/legacy", token, &routes.AccountsFactorsPasswordRequest{
+			CurrentPassword: password,
+		}, nil); resp.StatusCode != 403 {
+			t.Fatalf("factor was removed using password %q: %d", password, resp.StatusCode)
+		}
+	}
+
+	var removed routes.AccountsFactorsResponse
+	resp := request(t, "DELETE", "/accounts/me/factors/legacy", token, &routes.AccountsFactorsPasswordRequest{
+		CurrentPassword: "fruityloops",
+	}, &removed)
+	if resp.StatusCode != 200 || len(removed.Factors) != 0 {
+		t.Fatalf("unable to remove the factor: %d %+v", resp.StatusCode, removed.Factors)
+	}
+
+	// Backup codes are removed with the last factor
+	if response := login("", ""); !response.Success {
+		t.Fatalf("unable to log in without 2FA: %s", response.Message)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	_, token := createAccount(t, "jadaorange")
+
+	if resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jadaorange",
+		Password: "wrong",
+	}, nil); resp.StatusCode != 403 {
+		t.Fatalf("wrong password was accepted: %d", resp.StatusCode)
+	}
+
+	var log routes.AccountsAuditListResponse
+	resp := request(t, "GET", "/accounts/me/audit", token, nil, &log)
+	if resp.StatusCode != 200 || len(log.Events) != 2 {
+		t.Fatalf("unexpected audit log: %d %+v", resp.StatusCode, log.Events)
+	}
+	if log.Events[0].Type != models.AuditLoginFailed || log.Events[1].Type != models.AuditLogin {
+		t.Fatalf("unexpected events %s, %s", log.Events[0].Type, log.Events[1].Type)
+	}
+	if log.Events[0].IP == "" || log.Events[0].UserAgent == "" {
+		t.Fatalf("request of the event wasn't recorded: %+v", log.Events[0])
+	}
+
+	// Only the owner's auth tokens can read the log
+	var created routes.APITokensCreateResponse
+	request(t, "POST", "/api-tokens", token, &routes.APITokensCreateRequest{
+		Name:   "reader",
+		Scopes: []string{"accounts:read"},
+	}, &created)
+	if resp := request(t, "GET", "/accounts/me/audit", created.Secret, nil, nil); resp.StatusCode != 403 {
+		t.Fatalf("audit log was read using an API token: %d", resp.StatusCode)
+	}
+}
+
+func TestNotMe(t *testing.T) {
+	account, token := createAccount(t, "jonaorange")
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"alt_email":          "jona@example.com",
+		"alt_email_verified": true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else logs in from a new device
+	body, _ := json.Marshal(&routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jonaorange",
+		Password: "fruityloops",
+	})
+	req, err := http.NewRequest("POST", server.URL+"/tokens", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; rv:50.0) Gecko/20100101 Firefox/50.0")
+	req.Header.Set("X-Real-IP", "203.0.113.60")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var other routes.TokensCreateResponse
+	json.NewDecoder(resp.Body).Decode(&other)
+	resp.Body.Close()
+	if resp.StatusCode != 201 {
+		t.Fatalf("unable to log in from a new device: %d %s", resp.StatusCode, other.Message)
+	}
+
+	tokens, err := env.Tokens.GetOwnedByType(account.ID, "not_me")
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("expected a not_me token, got %v %v", tokens, err)
+	}
+
+	var response routes.AccountsNotMeResponse
+	resp = request(t, "POST", "/accounts/not-me", "", &routes.AccountsNotMeRequest{
+		Token: tokens[0].ID,
+	}, &response)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unable to reject the device: %d %s", resp.StatusCode, response.Message)
+	}
+	if resp := request(t, "POST", "/accounts/not-me", "", &routes.AccountsNotMeRequest{
+		Token: tokens[0].ID,
+	}, nil); resp.StatusCode != 400 {
+		t.Fatalf("not_me token was used twice: %d", resp.StatusCode)
+	}
+
+	// Only the session of the rejected device is revoked
+	if resp := request(t, "GET", "/accounts/me", other.Token.ID, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("session of the rejected device survived: %d", resp.StatusCode)
+	}
+	if resp := request(t, "POST", "/tokens/refresh", "", &routes.TokensRefreshRequest{
+		RefreshToken: other.RefreshToken.ID,
+	}, nil); resp.StatusCode != 401 {
+		t.Fatalf("session of the rejected device was refreshed: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/accounts/me", token, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("owner's session was revoked: %d", resp.StatusCode)
+	}
+
+	// Password logins are disabled until a reset, whose link was emailed
+	var login routes.TokensCreateResponse
+	if resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jonaorange",
+		Password: "fruityloops",
+	}, &login); resp.StatusCode != 403 {
+		t.Fatalf("password login was accepted before a reset: %d", resp.StatusCode)
+	}
+	if resets, err := env.Tokens.GetOwnedByType(account.ID, "reset"); err != nil || len(resets) != 1 {
+		t.Fatalf("expected a reset token, got %v %v", resets, err)
+	}
+
+	var log routes.AccountsAuditListResponse
+	request(t, "GET", "/accounts/me/audit", token, nil, &log)
+	if len(log.Events) == 0 || log.Events[0].Type != models.AuditDeviceRejected {
+		t.Fatalf("rejection wasn't audited: %+v", log.Events)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	admin, adminToken := createAccount(t, "jaxorange")
+	user, userToken := createAccount(t, "jayorange")
+	if err := env.Accounts.UpdateID(admin.ID, map[string]interface{}{
+		"type": "superuser",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	queued := &models.Account{
+		Resource: models.MakeResource("", "jettorange"),
+		Type:     "beta",
+		AltEmail: "jett@example.com",
+		Status:   models.StatusRegistered,
+	}
+	if err := env.Accounts.Insert(queued); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := request(t, "GET", "/admin/accounts", userToken, nil, nil); resp.StatusCode != 403 {
+		t.Fatalf("admin API was available to a std account: %d", resp.StatusCode)
+	}
+
+	// Search and the beta queue
+	var list routes.AdminAccountsResponse
+	resp := request(t, "GET", "/admin/accounts?query=jayorange", adminToken, nil, &list)
+	if resp.StatusCode != 200 || len(list.Accounts) != 1 || list.Accounts[0].ID != user.ID {
+		t.Fatalf("unexpected search result: %d %+v", resp.StatusCode, list.Accounts)
+	}
+	if resp.Header.Get("X-Total-Count") != "1" {
+		t.Fatalf("unexpected total count %q", resp.Header.Get("X-Total-Count"))
+	}
+	if resp := request(t, "GET", "/admin/accounts?limit=0", adminToken, nil, nil); resp.StatusCode != 400 {
+		t.Fatalf("invalid limit was accepted: %d", resp.StatusCode)
+	}
+
+	list = routes.AdminAccountsResponse{}
+	request(t, "GET", "/admin/beta-queue", adminToken, nil, &list)
+	found := false
+	for _, account := range list.Accounts {
+		if account.Status != models.StatusRegistered {
+			t.Fatalf("account %s with status %s is in the beta queue", account.Name, account.Status)
+		}
+		if account.ID == queued.ID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("registered account is missing from the beta queue")
+	}
+
+	if resp := request(t, "GET", "/admin/accounts/nonexistent", adminToken, nil, nil); resp.StatusCode != 404 {
+		t.Fatalf("unknown account was found: %d", resp.StatusCode)
+	}
+
+	// Approvals
+	var approval routes.AdminAccountsResponse
+	resp = request(t, "POST", "/admin/accounts/"+queued.ID+"/approve", adminToken, nil, &approval)
+	if resp.StatusCode != 200 || approval.Token == "" {
+		t.Fatalf("unable to approve an account: %d %s", resp.StatusCode, approval.Message)
+	}
+	if account, err := env.Accounts.GetAccount(queued.ID); err != nil || account.Status != models.StatusInvited {
+		t.Fatalf("approved account wasn't invited: %v %v", account, err)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+queued.ID+"/approve", adminToken, nil, nil); resp.StatusCode != 409 {
+		t.Fatalf("account was approved twice: %d", resp.StatusCode)
+	}
+
+	// Suspensions
+	if resp := request(t, "POST", "/admin/accounts/"+user.ID+"/suspend", adminToken, &routes.AdminSuspendRequest{}, nil); resp.StatusCode != 400 {
+		t.Fatalf("suspension without a reason was accepted: %d", resp.StatusCode)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+admin.ID+"/suspend", adminToken, &routes.AdminSuspendRequest{
+		Reason: "Testing",
+	}, nil); resp.StatusCode != 403 {
+		t.Fatalf("admin account was suspended: %d", resp.StatusCode)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+user.ID+"/suspend", adminToken, &routes.AdminSuspendRequest{
+		Reason: "Spam",
+	}, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to suspend an account: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/accounts/me", userToken, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("session of a suspended account survived: %d", resp.StatusCode)
+	}
+
+	var login routes.TokensCreateResponse
+	resp = request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jayorange",
+		Password: "fruityloops",
+	}, &login)
+	if resp.StatusCode != 403 || login.Message != "Your account is suspended: Spam" {
+		t.Fatalf("suspended account logged in: %d %s", resp.StatusCode, login.Message)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+user.ID+"/suspend", adminToken, &routes.AdminSuspendRequest{
+		Reason: "Spam",
+	}, nil); resp.StatusCode != 409 {
+		t.Fatalf("account was suspended twice: %d", resp.StatusCode)
+	}
+
+	var unsuspended routes.AdminAccountsResponse
+	resp = request(t, "POST", "/admin/accounts/"+user.ID+"/unsuspend", adminToken, nil, &unsuspended)
+	if resp.StatusCode != 200 || unsuspended.Account.Status != models.StatusActive {
+		t.Fatalf("unable to lift a suspension: %d %s", resp.StatusCode, unsuspended.Message)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+user.ID+"/unsuspend", adminToken, nil, nil); resp.StatusCode != 409 {
+		t.Fatalf("account was unsuspended twice: %d", resp.StatusCode)
+	}
+
+	// Logouts
+	login = routes.TokensCreateResponse{}
+	if resp := request(t, "POST", "/tokens", "", &routes.TokensCreateRequest{
+		Type:     "auth",
+		Username: "jayorange",
+		Password: "fruityloops",
+	}, &login); resp.StatusCode != 201 {
+		t.Fatalf("unable to log in after the suspension: %d %s", resp.StatusCode, login.Message)
+	}
+	if resp := request(t, "POST", "/admin/accounts/"+user.ID+"/logout", adminToken, nil, nil); resp.StatusCode != 200 {
+		t.Fatalf("unable to log out an account: %d", resp.StatusCode)
+	}
+	if resp := request(t, "GET", "/accounts/me", login.Token.ID, nil, nil); resp.StatusCode != 401 {
+		t.Fatalf("session survived an admin logout: %d", resp.StatusCode)
+	}
+
+	// Every admin action is audited
+	var log routes.AccountsAuditListResponse
+	request(t, "GET", "/accounts/me/audit", adminToken, nil, &log)
+	actions := map[string]bool{}
+	for _, event := range log.Events {
+		if event.Type == models.AuditAdminAction {
+			actions[event.Details["action"].(string)] = true
+		}
+	}
+	for _, action := range []string{"search", "beta_queue", "approve", "suspend", "unsuspend", "logout"} {
+		if !actions[action] {
+			t.Fatalf("admin action %s wasn't audited", action)
+		}
+	}
+}
+
+// failingCounters is a cache that can't update counters
+type failingCounters struct {
+	cache.Cache
+}
+
+func (failingCounters) Increment(key string, delta int64, expires time.Duration) (int64, error) {
+	return 0, errors.New("counters are unavailable")
+}
+
+func TestStorageCounter(t *testing.T) {
+	account, token := createAccount(t, "jaelorange")
+	key := "storage:" + account.ID
+
+	createFile := func(data string) {
+		var response routes.FilesCreateResponse
+		resp := request(t, "POST", "/files", token, &routes.FilesCreateRequest{
+			Data:     data,
+			Name:     "file.txt",
+			Encoding: "json",
+		}, &response)
+		if resp.StatusCode != 201 {
+			t.Fatalf("unable to create a file: %d %s", resp.StatusCode, response.Message)
+		}
+	}
+
+	// The counter is created by the first check after something was stored
+	createFile("first")
+	createFile("second")
+	if used, err := env.Cache.Increment(key, 0, time.Hour); err != nil || used != 11 {
+		t.Fatalf("expected 11 used bytes, got %d %v", used, err)
+	}
+
+	// Counters that missed an update are summed up again
+	working := env.Cache
+	env.Cache = failingCounters{working}
+	createFile("third")
+	env.Cache = working
+
+	if used, err := env.Cache.Increment(key, 0, time.Hour); err != nil || used != 0 {
+		t.Fatalf("counter wasn't dropped after a failed update: %d %v", used, err)
+	}
+
+	createFile("fourth")
+	if used, err := env.Cache.Increment(key, 0, time.Hour); err != nil || used != 22 {
+		t.Fatalf("expected 22 used bytes, got %d %v", used, err)
+	}
+}
+
+func TestAddressLimit(t *testing.T) {
+	account := &models.Account{
+		Resource: models.MakeResource("", "jazzorange"),
+		Type:     "std",
+		AltEmail: "jazz@example.com",
+		Status:   models.StatusInvited,
+	}
+	if err := env.Accounts.Insert(account); err != nil {
+		t.Fatal(err)
+	}
+	token := models.MakeVerifyToken(account.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		t.Fatal(err)
+	}
+
+	// The account already has an address, which is all that std plans allow
+	if err := env.Addresses.Insert(&models.Address{
+		Resource: models.Resource{
+			ID:    "jazzalias",
+			Owner: account.ID,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	setup := func() (*http.Response, string) {
+		var response routes.AccountsCreateResponse
+		resp := request(t, "POST", "/accounts", "", &routes.AccountsCreateRequest{
+			Username:   "jazzorange",
+			InviteCode: token.ID,
+			Password:   "orange juice with pulp",
+		}, &response)
+		return resp, response.Message
+	}
+
+	if resp, message := setup(); resp.StatusCode != 403 {
+		t.Fatalf("address over the plan's limit was created: %d %s", resp.StatusCode, message)
+	}
+	if _, err := env.Addresses.GetAddress("jazzorange"); err == nil {
+		t.Fatal("address over the plan's limit was inserted")
+	}
+
+	if err := env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"type": "beta",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, message := setup(); resp.StatusCode != 200 {
+		t.Fatalf("unable to set up an account within the plan's limit: %d %s", resp.StatusCode, message)
+	}
+	if address, err := env.Addresses.GetAddress("jazzorange"); err != nil || address.Owner != account.ID {
+		t.Fatalf("address wasn't created: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// closeSubscriptions notifies all API instances that the tokens were revoked,
+// so that SockJS subscriptions made using them are closed.
+func closeSubscriptions(owner string, tokens ...string) {
+	if len(tokens) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"owner":  owner,
+		"tokens": tokens,
+	})
+	if err == nil {
+		err = env.Producer.Publish("token_revocations", data)
+	}
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+		}).Error("Unable to publish token revocations")
+	}
+}
+
+const (
+	// maxRememberMe is the longest remember me duration in hours
+	maxRememberMe = 365 * 24
+
+	// maxIdleTimeout is the longest idle timeout in minutes
+	maxIdleTimeout = 30 * 24 * 60
+)
+
+// Session describes a device that's logged in. Token IDs are secret, so
+// they aren't included.
+type Session struct {
+	IP           string    `json:"ip,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	Device       string    `json:"device,omitempty"`
+	Current      bool      `json:"current"`
+	DateCreated  time.Time `json:"date_created"`
+	DateLastUsed time.Time `json:"date_last_used,omitempty"`
+	ExpiryDate   time.Time `json:"expiry_date"`
+}
+
+// AccountsSessionsListResponse contains the result of the AccountsSessionsList request.
+type AccountsSessionsListResponse struct {
+	Success  bool       `json:"success"`
+	Message  string     `json:"message,omitempty"`
+	Sessions []*Session `json:"sessions,omitempty"`
+}
+
+// AccountsSessionsList returns active sessions of the account, most recently
+// used first.
+func AccountsSessionsList(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsSessionsListResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+	if session.Type != "auth" {
+		utils.JSONResponse(w, 403, &AccountsSessionsListResponse{
+			Success: false,
+			Message: "Sessions can be listed only using an auth token",
+		})
+		return
+	}
+
+	sessions, err := env.Tokens.GetSessions(session.Owner)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch sessions")
+
+		utils.JSONResponse(w, 500, &AccountsSessionsListResponse{
+			Success: false,
+			Message: "Internal error (code AC/SL/01)",
+		})
+		return
+	}
+
+	result := make([]*Session, len(sessions))
+	for i, token := range sessions {
+		result[i] = &Session{
+			IP:           token.IP,
+			UserAgent:    token.UserAgent,
+			Device:       token.Device,
+			Current:      token.ID == session.ID || (token.Family != "" && token.Family == session.Family),
+			DateCreated:  token.DateCreated,
+			DateLastUsed: token.DateLastUsed,
+			ExpiryDate:   token.ExpiryDate,
+		}
+	}
+
+	utils.JSONResponse(w, 200, &AccountsSessionsListResponse{
+		Success:  true,
+		Sessions: result,
+	})
+}
+
+// AccountsSessionsDeleteResponse contains the result of the AccountsSessionsDelete request.
+type AccountsSessionsDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// AccountsSessionsDelete revokes all sessions of the account. Passing
+// ?except=current keeps the session making the request.
+func AccountsSessionsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Right now we only support "me" as the ID
+	if c.URLParams["id"] != "me" {
+		utils.JSONResponse(w, 501, &AccountsSessionsDeleteResponse{
+			Success: false,
+			Message: `Only the "me" user is implemented`,
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+
+	except := r.URL.Query().Get("except")
+	if except != "" && except != "current" {
+		utils.JSONResponse(w, 400, &AccountsSessionsDeleteResponse{
+			Success: false,
+			Message: `Only "current" can be excepted`,
+		})
+		return
+	}
+	if except == "current" {
+		except = session.ID
+		if session.Family != "" {
+			except = session.Family
+		}
+	}
+
+	revoked, err := env.Tokens.DeleteSessions(session.Owner, except)
+	closeSubscriptions(session.Owner, revoked...)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to revoke sessions")
+
+		utils.JSONResponse(w, 500, &AccountsSessionsDeleteResponse{
+			Success: false,
+			Message: "Internal error (code AC/SD/01)",
+		})
+		return
+	}
+
+	recordAudit(c, r, session.Owner, models.AuditSessionsRevoked, map[string]interface{}{
+		"count": len(revoked),
+	})
+
+	utils.JSONResponse(w, 200, &AccountsSessionsDeleteResponse{
+		Success: true,
+		Message: "Sessions successfully revoked",
+	})
+}
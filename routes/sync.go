@@ -0,0 +1,281 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+const (
+	// syncLimit is the maximal count of change log entries returned in a single response
+	syncLimit = 500
+
+	// syncGapTimeout is the time after which a missing sequence is skipped. Sequences
+	// are reserved before their entries are stored, so a gap usually means that
+	// another instance is still writing the entry. Entries are never written
+	// this late, the writer must have failed.
+	syncGapTimeout = time.Minute
+)
+
+// syncToken is the position of a client in the change log. Since is the time
+// of the oldest change the client might not have received yet, it's used to
+// find out whether the changes were already removed from the log.
+type syncToken struct {
+	Sequence int64
+	Since    time.Time
+}
+
+func (t *syncToken) String() string {
+	return strconv.FormatInt(t.Sequence, 10) + "." + strconv.FormatInt(t.Since.Unix(), 10)
+}
+
+func parseSyncToken(raw string) (*syncToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("Invalid sync token")
+	}
+
+	sequence, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || sequence < 0 {
+		return nil, errors.New("Invalid sync token")
+	}
+
+	since, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid sync token")
+	}
+
+	return &syncToken{
+		Sequence: sequence,
+		Since:    time.Unix(since, 0),
+	}, nil
+}
+
+// syncTable describes a table included in the delta sync
+type syncTable struct {
+	table   db.RethinkCRUD
+	results func() interface{}
+}
+
+func syncTables() map[string]syncTable {
+	return map[string]syncTable{
+		"contacts": {env.Contacts, func() interface{} { return &[]*models.Contact{} }},
+		"emails":   {env.Emails, func() interface{} { return &[]*models.Email{} }},
+		"files":    {env.Files, func() interface{} { return &[]*models.File{} }},
+		"keys":     {env.Keys, func() interface{} { return &[]*models.Key{} }},
+		"labels":   {env.Labels, func() interface{} { return &[]*models.Label{} }},
+		"threads":  {env.Threads, func() interface{} { return &[]*models.Thread{} }},
+	}
+}
+
+// SyncChanges contains changed resources of a single type
+type SyncChanges struct {
+	Created []interface{} `json:"created,omitempty"`
+	Updated []interface{} `json:"updated,omitempty"`
+	Deleted []string      `json:"deleted,omitempty"`
+}
+
+// SyncResponse contains the result of the Sync request.
+type SyncResponse struct {
+	Success bool                    `json:"success"`
+	Message string                  `json:"message,omitempty"`
+	Token   string                  `json:"token,omitempty"`
+	More    bool                    `json:"more"`
+	Changes map[string]*SyncChanges `json:"changes,omitempty"`
+}
+
+// Sync returns resources created, updated and deleted since the passed sync token.
+// Requests without a token only return the current token, so clients should
+// request it before downloading the initial state using the list endpoints.
+func Sync(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	sinceRaw := r.URL.Query().Get("since")
+	if sinceRaw == "" {
+		// Resources are written before their sequences are reserved, so the
+		// lists downloaded after this request contain all the changes until now
+		current, err := env.Changes.Sequences.Current(session.Owner)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to fetch the change log sequence")
+
+			utils.JSONResponse(w, 500, &SyncResponse{
+				Success: false,
+				Message: "Internal error (code SY/SY/03)",
+			})
+			return
+		}
+
+		utils.JSONResponse(w, 200, &SyncResponse{
+			Success: true,
+			Token: (&syncToken{
+				Sequence: current,
+				Since:    time.Now().Add(-syncGapTimeout),
+			}).String(),
+		})
+		return
+	}
+
+	since, err := parseSyncToken(sinceRaw)
+	if err != nil {
+		utils.JSONResponse(w, 400, &SyncResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// Older changes might have been removed already
+	retention := time.Duration(env.Config.SyncRetention) * time.Hour
+	if since.Since.Before(time.Now().Add(-retention)) {
+		utils.JSONResponse(w, 410, &SyncResponse{
+			Success: false,
+			Message: "Sync token expired, download the lists again",
+		})
+		return
+	}
+
+	// Fetch one more change to find out whether there are more of them
+	changes, err := env.Changes.ListSince(session.Owner, since.Sequence, syncLimit+1)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to fetch changes")
+
+		utils.JSONResponse(w, 500, &SyncResponse{
+			Success: false,
+			Message: "Internal error (code SY/SY/01)",
+		})
+		return
+	}
+
+	// Stop at the first sequence that's still being written
+	token := &syncToken{
+		Sequence: since.Sequence,
+		Since:    time.Now().Add(-syncGapTimeout),
+	}
+	for i, change := range changes {
+		if i == syncLimit {
+			token.Since = change.DateCreated
+			break
+		}
+
+		if change.Sequence != token.Sequence+1 && time.Since(change.DateCreated) < syncGapTimeout {
+			changes = changes[:i]
+			break
+		}
+
+		token.Sequence = change.Sequence
+	}
+
+	more := len(changes) > syncLimit
+	if more {
+		changes = changes[:syncLimit]
+	}
+
+	// Collapse the changes of every resource into the first and the last one
+	type state struct {
+		first string
+		last  string
+	}
+	states := map[string]map[string]*state{}
+	order := map[string][]string{}
+	for _, change := range changes {
+		if _, ok := states[change.Table]; !ok {
+			states[change.Table] = map[string]*state{}
+		}
+
+		if s, ok := states[change.Table][change.Resource]; ok {
+			s.last = change.Kind
+		} else {
+			states[change.Table][change.Resource] = &state{
+				first: change.Kind,
+				last:  change.Kind,
+			}
+			order[change.Table] = append(order[change.Table], change.Resource)
+		}
+	}
+
+	tables := syncTables()
+	result := map[string]*SyncChanges{}
+	for name, resources := range states {
+		table, ok := tables[name]
+		if !ok {
+			continue
+		}
+
+		var ids []interface{}
+		for id, s := range resources {
+			if s.last != models.ChangeDeleted {
+				ids = append(ids, id)
+			}
+		}
+
+		documents := map[string]interface{}{}
+		if len(ids) > 0 {
+			documents, err = fetchSynced(table, ids)
+			if err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"table": name,
+				}).Error("Unable to fetch changed resources")
+
+				utils.JSONResponse(w, 500, &SyncResponse{
+					Success: false,
+					Message: "Internal error (code SY/SY/02)",
+				})
+				return
+			}
+		}
+
+		changes := &SyncChanges{}
+		for _, id := range order[name] {
+			document, ok := documents[id]
+			if !ok {
+				// Deleted in the meantime
+				changes.Deleted = append(changes.Deleted, id)
+			} else if resources[id].first == models.ChangeCreated {
+				changes.Created = append(changes.Created, document)
+			} else {
+				changes.Updated = append(changes.Updated, document)
+			}
+		}
+		result[name] = changes
+	}
+
+	utils.JSONResponse(w, 200, &SyncResponse{
+		Success: true,
+		Token:   token.String(),
+		More:    more,
+		Changes: result,
+	})
+}
+
+// fetchSynced fetches resources of a synced table and maps them by their IDs
+func fetchSynced(table syncTable, ids []interface{}) (map[string]interface{}, error) {
+	results := table.results()
+	if err := table.table.FindByIndexFetch(results, "id", ids...); err != nil {
+		return nil, err
+	}
+
+	documents := map[string]interface{}{}
+	slice := reflect.ValueOf(results).Elem()
+	for i := 0; i < slice.Len(); i++ {
+		item := slice.Index(i)
+		documents[item.Elem().FieldByName("ID").String()] = item.Interface()
+	}
+
+	return documents, nil
+}
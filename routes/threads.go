@@ -3,7 +3,6 @@ package routes
 import (
 	"net/http"
 	"reflect"
-	"strconv"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
@@ -19,67 +18,29 @@ type ThreadsListResponse struct {
 	Success bool              `json:"success"`
 	Message string            `json:"message,omitempty"`
 	Threads *[]*models.Thread `json:"threads,omitempty"`
+	Next    string            `json:"next,omitempty"`
+	Prev    string            `json:"prev,omitempty"`
 }
 
-// ThreadsList shows all threads
+// ThreadsList shows a page of threads, most recently modified first
 func ThreadsList(c web.C, w http.ResponseWriter, r *http.Request) {
 	session := c.Env["token"].(*models.Token)
 
-	var (
-		query     = r.URL.Query()
-		sortRaw   = query.Get("sort")
-		offsetRaw = query.Get("offset")
-		limitRaw  = query.Get("limit")
-		labelsRaw = query.Get("label")
-		labels    []string
-		sort      []string
-		offset    int
-		limit     int
-	)
-
-	if offsetRaw != "" {
-		o, err := strconv.Atoi(offsetRaw)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error":  err,
-				"offset": offset,
-			}).Error("Invalid offset")
-
-			utils.JSONResponse(w, 400, &ThreadsListResponse{
-				Success: false,
-				Message: "Invalid offset",
-			})
-			return
-		}
-		offset = o
-	}
-
-	if limitRaw != "" {
-		l, err := strconv.Atoi(limitRaw)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-				"limit": limit,
-			}).Error("Invalid limit")
-
-			utils.JSONResponse(w, 400, &ThreadsListResponse{
-				Success: false,
-				Message: "Invalid limit",
-			})
-			return
-		}
-		limit = l
-	}
-
-	if sortRaw != "" {
-		sort = strings.Split(sortRaw, ",")
+	page, err := parsePage(r)
+	if err != nil {
+		utils.JSONResponse(w, 400, &ThreadsListResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
 	}
 
-	if labelsRaw != "" {
+	var labels []string
+	if labelsRaw := r.URL.Query().Get("label"); labelsRaw != "" {
 		labels = strings.Split(labelsRaw, ",")
 	}
 
-	threads, err := env.Threads.List(session.Owner, sort, offset, limit, labels)
+	threads, result, err := env.Threads.List(session.Owner, labels, page)
 	if err != nil {
 		env.Log.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -92,25 +53,12 @@ func ThreadsList(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if offsetRaw != "" || limitRaw != "" {
-		count, err := env.Threads.CountOwnedBy(session.Owner)
-		if err != nil {
-			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Unable to count threads")
-
-			utils.JSONResponse(w, 500, &ThreadsListResponse{
-				Success: false,
-				Message: "Internal error (code TH/LI/02)",
-			})
-			return
-		}
-		w.Header().Set("X-Total-Count", strconv.Itoa(count))
-	}
-
+	setTotalCount(w, result)
 	utils.JSONResponse(w, 200, &ThreadsListResponse{
 		Success: true,
 		Threads: &threads,
+		Next:    result.Next,
+		Prev:    result.Prev,
 	})
 }
 
@@ -256,6 +204,8 @@ func ThreadsUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	publishEvent(session.Owner, models.EventThreadUpdate, thread)
+
 	// Write the thread to the response
 	utils.JSONResponse(w, 200, &ThreadsUpdateResponse{
 		Success: true,
@@ -322,6 +272,12 @@ func ThreadsDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if err := ResetStorage(thread.Owner); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": thread.Owner,
+		}).Warn("Unable to reset the storage counter")
+	}
 
 	// Write the thread to the response
 	utils.JSONResponse(w, 200, &ThreadsDeleteResponse{
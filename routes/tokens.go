@@ -33,10 +33,9 @@ func TokensGet(c web.C, w http.ResponseWriter, r *http.Request) {
 		token = c.Env["token"].(*models.Token)
 	} else {
 		token, err = env.Tokens.GetToken(id)
-		if err != nil {
+		if err != nil || token.Owner != c.Env["token"].(*models.Token).Owner {
 			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-				"id":    id,
+				"id": id,
 			}).Warn("Unable to find the token")
 
 			utils.JSONResponse(w, 404, &TokensGetResponse{
@@ -56,23 +55,34 @@ func TokensGet(c web.C, w http.ResponseWriter, r *http.Request) {
 
 // TokensCreateRequest contains the input for the TokensCreate endpoint.
 type TokensCreateRequest struct {
-	Username string `json:"username" schema:"username"`
-	Password string `json:"password" schema:"password"`
-	Type     string `json:"type" schema:"type"`
-	Token    string `json:"token" schema:"token"`
+	Username   string `json:"username" schema:"username"`
+	Password   string `json:"password" schema:"password"`
+	Type       string `json:"type" schema:"type"`
+	FactorID   string `json:"factor_id" schema:"factor_id"`
+	Token      string `json:"token" schema:"token"`
+	RememberMe bool   `json:"remember_me" schema:"remember_me"`
+
+	// DeviceToken is the device token returned by a previous login
+	DeviceToken string `json:"device_token" schema:"device_token"`
 }
 
 // TokensCreateResponse contains the result of the TokensCreate request.
 type TokensCreateResponse struct {
-	Success         bool          `json:"success"`
-	Message         string        `json:"message,omitempty"`
-	Token           *models.Token `json:"token,omitempty"`
-	FactorType      string        `json:"factor_type,omitempty"`
-	FactorChallenge string        `json:"factor_challenge,omitempty"`
+	Success         bool                    `json:"success"`
+	Message         string                  `json:"message,omitempty"`
+	Token           *models.Token           `json:"token,omitempty"`
+	RefreshToken    *models.Token           `json:"refresh_token,omitempty"`
+	DeviceToken     *models.Token           `json:"device_token,omitempty"`
+	FactorType      string                  `json:"factor_type,omitempty"`
+	FactorChallenge string                  `json:"factor_challenge,omitempty"`
+	Factors         []*models.AccountFactor `json:"factors,omitempty"`
+
+	// FactorReenrollment asks the client to set up a second factor again
+	FactorReenrollment bool `json:"factor_reenrollment_required,omitempty"`
 }
 
 // TokensCreate allows logging in to an account.
-func TokensCreate(w http.ResponseWriter, r *http.Request) {
+func TokensCreate(c web.C, w http.ResponseWriter, r *http.Request) {
 	// Decode the request
 	var input TokensCreateRequest
 	err := utils.ParseRequest(r, &input)
@@ -101,9 +111,13 @@ func TokensCreate(w http.ResponseWriter, r *http.Request) {
 		utils.NormalizeUsername(input.Username),
 	)
 
-	// Check if account exists
+	// Check if account exists, deleted accounts are treated as if they didn't
 	user, err := env.Accounts.FindAccountByName(input.Username)
-	if err != nil {
+	if err != nil || user.Status == models.StatusDeleted {
+		if !checkLoginLimits(w, r, input.Username, nil, "") {
+			return
+		}
+
 		utils.JSONResponse(w, 403, &TokensCreateResponse{
 			Success: false,
 			Message: "Wrong username or password",
@@ -111,8 +125,8 @@ func TokensCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// "registered" accounts can't log in
-	if user.Status == "registered" {
+	// Accounts that weren't set up yet can't log in
+	if user.Status == models.StatusRegistered || user.Status == models.StatusInvited {
 		utils.JSONResponse(w, 403, &TokensCreateResponse{
 			Success: false,
 			Message: "Your account is not confirmed",
@@ -120,9 +134,15 @@ func TokensCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Locked accounts can log in only from known devices
+	if !checkLoginLimits(w, r, input.Username, user, input.DeviceToken) {
+		return
+	}
+
 	// Verify the password
 	valid, updated, err := user.VerifyPassword(input.Password)
 	if err != nil || !valid {
+		recordFailedLogin(c, r, user, models.AuditLoginFailed)
 		utils.JSONResponse(w, 403, &TokensCreateResponse{
 			Success: false,
 			Message: "Wrong username or password",
@@ -144,66 +164,313 @@ func TokensCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Suspended accounts can't log in until an admin lifts the suspension
+	if user.Status == models.StatusSuspended {
+		message := "Your account is suspended"
+		if user.Suspension != nil && user.Suspension.Reason != "" {
+			message += ": " + user.Suspension.Reason
+		}
+
+		utils.JSONResponse(w, 403, &TokensCreateResponse{
+			Success: false,
+			Message: message,
+		})
+		return
+	}
+
+	// The owner reported a login that wasn't theirs
+	if user.PasswordResetRequired {
+		utils.JSONResponse(w, 403, &TokensCreateResponse{
+			Success: false,
+			Message: "Your password has to be reset before logging in",
+		})
+		return
+	}
+
 	// Check for 2nd factor
-	if user.FactorType != "" {
-		factor, ok := env.Factors[user.FactorType]
-		if ok {
-			// Verify the 2FA
-			verified, challenge, err := user.Verify2FA(factor, input.Token)
-			if err != nil {
-				utils.JSONResponse(w, 500, &TokensCreateResponse{
-					Success: false,
-					Message: "Internal 2FA error",
-				})
-
-				env.Log.WithFields(logrus.Fields{
-					"err":    err.Error(),
-					"factor": user.FactorType,
-				}).Warn("2FA authentication error")
-				return
-			}
+	if len(user.Factors) > 0 {
+		verified, challenge, err := verifySecondFactor(user, input.FactorID, input.Token)
+		if err != nil {
+			utils.JSONResponse(w, 500, &TokensCreateResponse{
+				Success: false,
+				Message: "Internal 2FA error",
+			})
 
-			// Token was probably empty. Return the challenge.
-			if !verified && challenge != "" {
-				utils.JSONResponse(w, 403, &TokensCreateResponse{
-					Success:         false,
-					Message:         "2FA token was not passed",
-					FactorType:      user.FactorType,
-					FactorChallenge: challenge,
-				})
-				return
-			}
+			env.Log.WithFields(logrus.Fields{
+				"err":    err.Error(),
+				"factor": chosenFactorType(user, input.FactorID),
+			}).Warn("2FA authentication error")
+			return
+		}
 
-			// Token was incorrect
-			if !verified {
-				utils.JSONResponse(w, 403, &TokensCreateResponse{
-					Success:    false,
-					Message:    "Invalid token passed",
-					FactorType: user.FactorType,
-				})
-				return
+		if !verified {
+			// The client can pick one of the factors and retry
+			message := "Invalid token passed"
+			if input.Token == "" {
+				message = "2FA token was not passed"
+			} else {
+				recordFailedLogin(c, r, user, models.AuditFactorFailed)
 			}
+
+			utils.JSONResponse(w, 403, &TokensCreateResponse{
+				Success:         false,
+				Message:         message,
+				FactorType:      chosenFactorType(user, input.FactorID),
+				FactorChallenge: challenge,
+				Factors:         listFactors(user),
+			})
+			return
 		}
 	}
 
-	// Calculate the expiry date
-	expDate := time.Now().Add(time.Hour * time.Duration(env.Config.SessionDuration))
+	resetFailedLogins(user)
 
-	// Create a new token
-	token := &models.Token{
-		Expiring: models.Expiring{ExpiryDate: expDate},
-		Resource: models.MakeResource(user.ID, "Auth token expiring on "+expDate.Format(time.RFC3339)),
-		Type:     input.Type,
+	// The first login activates the account
+	if user.Status == models.StatusSetup {
+		user.SetStatus(models.StatusActive, "")
+		if err := env.Accounts.UpdateID(user.ID, map[string]interface{}{
+			"status":         user.Status,
+			"status_history": user.StatusHistory,
+		}); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    user.ID,
+			}).Error("Unable to activate an account")
+		}
 	}
 
-	// Insert int into the database
-	env.Tokens.Insert(token)
+	// Remembered sessions last longer, their duration can be set by the user
+	duration := env.Config.SessionDuration
+	if input.RememberMe {
+		duration = env.Config.RememberMeDuration
+		if user.RememberMe > 0 {
+			duration = user.RememberMe
+		}
+	}
+
+	token, refresh, err := issueTokens(r, user, "", time.Now().UTC().Add(time.Duration(duration)*time.Hour))
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert session tokens")
+
+		utils.JSONResponse(w, 500, &TokensCreateResponse{
+			Success: false,
+			Message: "Internal error (code TO/CR/01)",
+		})
+		return
+	}
+
+	// The device token exempts next logins of the device from lockouts
+	device := getDeviceToken(user, input.DeviceToken)
+	if device == nil {
+		newDevice := models.MakeDeviceToken(user.ID)
+		if err := env.Tokens.Insert(&newDevice); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    user.ID,
+			}).Error("Unable to insert a device token")
+		} else {
+			device = &newDevice
+		}
+	}
+
+	recordAudit(c, r, user.ID, models.AuditLogin, map[string]interface{}{
+		"device":      token.Device,
+		"remember_me": input.RememberMe,
+	})
+	checkDevice(c, r, user, token)
 
 	// Respond with the freshly created token
 	utils.JSONResponse(w, 201, &TokensCreateResponse{
-		Success: true,
-		Message: "Authentication successful",
-		Token:   token,
+		Success:            true,
+		Message:            "Authentication successful",
+		Token:              token,
+		RefreshToken:       refresh,
+		DeviceToken:        device,
+		FactorReenrollment: user.FactorReenrollmentRequired,
+	})
+}
+
+// issueTokens creates a short-lived auth token and a refresh token of the
+// session. If family is empty, a new session is started.
+func issueTokens(r *http.Request, account *models.Account, family string, sessionExpiry time.Time) (*models.Token, *models.Token, error) {
+	refresh := models.MakeRefreshToken(account.ID, family, sessionExpiry, account.IdleTimeout)
+	refresh.IP = utils.RequestIP(r)
+	refresh.UserAgent = r.UserAgent()
+	refresh.Device = utils.DeviceName(r.UserAgent())
+	refresh.DateLastUsed = refresh.DateCreated
+
+	// Auth tokens never outlive their sessions
+	expDate := time.Now().UTC().Add(time.Duration(env.Config.AccessTokenDuration) * time.Minute)
+	if expDate.After(refresh.ExpiryDate) {
+		expDate = refresh.ExpiryDate
+	}
+
+	token := &models.Token{
+		Expiring:  models.Expiring{ExpiryDate: expDate},
+		Resource:  models.MakeResource(account.ID, "Auth token expiring on "+expDate.Format(time.RFC3339)),
+		Type:      "auth",
+		IP:        refresh.IP,
+		UserAgent: refresh.UserAgent,
+		Device:    refresh.Device,
+		Family:    refresh.Family,
+	}
+
+	if err := env.Tokens.Insert(&refresh); err != nil {
+		return nil, nil, err
+	}
+
+	if err := env.Tokens.Insert(token); err != nil {
+		return nil, nil, err
+	}
+
+	return token, &refresh, nil
+}
+
+// TokensRefreshRequest contains the input for the TokensRefresh endpoint.
+type TokensRefreshRequest struct {
+	RefreshToken string `json:"refresh_token" schema:"refresh_token"`
+}
+
+// TokensRefresh exchanges a refresh token for a new auth token and a new
+// refresh token. Each refresh token can be used only once - using it again
+// revokes the whole session, as the token was probably stolen.
+func TokensRefresh(w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input TokensRefreshRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &TokensCreateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	refresh, err := env.Tokens.GetToken(input.RefreshToken)
+	if err != nil || (refresh.Type != "refresh" && refresh.Type != ".refresh") {
+		utils.JSONResponse(w, 401, &TokensCreateResponse{
+			Success: false,
+			Message: "Invalid refresh token",
+		})
+		return
+	}
+
+	// Used refresh tokens are invalidated, so this is a replay
+	if refresh.Type == ".refresh" {
+		revokeReplayedSession(w, refresh)
+		return
+	}
+
+	if refresh.Expired() {
+		utils.JSONResponse(w, 419, &TokensCreateResponse{
+			Success: false,
+			Message: "Refresh token has expired",
+		})
+		env.Tokens.DeleteFamily(refresh.Owner, refresh.Family)
+		return
+	}
+
+	account, err := env.Accounts.GetTokenOwner(refresh)
+	if err != nil {
+		utils.JSONResponse(w, 401, &TokensCreateResponse{
+			Success: false,
+			Message: "Invalid refresh token",
+		})
+		return
+	}
+
+	// Keep the used token until the session ends to detect replays. Only one
+	// of concurrent requests using the same token can invalidate it.
+	invalidated, err := env.Tokens.UpdateIDIf(refresh.ID, map[string]interface{}{
+		"type": "refresh",
+	}, map[string]interface{}{
+		"type":        ".refresh",
+		"expiry_date": refresh.SessionExpiryDate,
+	})
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    refresh.ID,
+		}).Error("Unable to invalidate a refresh token")
+
+		utils.JSONResponse(w, 500, &TokensCreateResponse{
+			Success: false,
+			Message: "Internal error (code TO/RE/01)",
+		})
+		return
+	}
+
+	if !invalidated {
+		revokeReplayedSession(w, refresh)
+		return
+	}
+
+	// Previous auth tokens of the session are replaced by the new one
+	var previous []*models.Token
+	if tokens, err := env.Tokens.GetOwnedByType(account.ID, "auth"); err == nil {
+		for _, token := range tokens {
+			if token.Family == refresh.Family {
+				previous = append(previous, token)
+			}
+		}
+	}
+
+	token, next, err := issueTokens(r, account, refresh.Family, refresh.SessionExpiryDate)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert session tokens")
+
+		utils.JSONResponse(w, 500, &TokensCreateResponse{
+			Success: false,
+			Message: "Internal error (code TO/RE/02)",
+		})
+		return
+	}
+
+	for _, token := range previous {
+		if err := env.Tokens.DeleteID(token.ID); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    token.ID,
+			}).Warn("Unable to delete a replaced auth token")
+		}
+	}
+
+	utils.JSONResponse(w, 201, &TokensCreateResponse{
+		Success:      true,
+		Message:      "Session refreshed",
+		Token:        token,
+		RefreshToken: next,
+	})
+}
+
+// revokeReplayedSession ends the session of a refresh token that was used
+// more than once
+func revokeReplayedSession(w http.ResponseWriter, refresh *models.Token) {
+	revoked, err := env.Tokens.DeleteFamily(refresh.Owner, refresh.Family)
+	closeSubscriptions(refresh.Owner, revoked...)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"family": refresh.Family,
+		}).Error("Unable to revoke a session")
+	}
+
+	env.Log.WithFields(logrus.Fields{
+		"owner":  refresh.Owner,
+		"family": refresh.Family,
+	}).Warn("Refresh token reuse detected, revoked the session")
+
+	utils.JSONResponse(w, 401, &TokensCreateResponse{
+		Success: false,
+		Message: "Invalid refresh token",
 	})
 }
 
@@ -227,10 +494,9 @@ func TokensDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		token = c.Env["token"].(*models.Token)
 	} else {
 		token, err = env.Tokens.GetToken(id)
-		if err != nil {
+		if err != nil || token.Owner != c.Env["token"].(*models.Token).Owner {
 			env.Log.WithFields(logrus.Fields{
-				"error": err.Error(),
-				"id":    id,
+				"id": id,
 			}).Warn("Unable to find the token")
 
 			utils.JSONResponse(w, 404, &TokensDeleteResponse{
@@ -254,6 +520,28 @@ func TokensDelete(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	revoked := []string{token.ID}
+
+	// Logging out also ends the refreshable session
+	if token.Family != "" {
+		family, err := env.Tokens.DeleteFamily(token.Owner, token.Family)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"family": token.Family,
+			}).Error("Unable to revoke a session")
+		}
+
+		revoked = append(revoked, family...)
+	}
+
+	closeSubscriptions(token.Owner, revoked...)
+
+	recordAudit(c, r, token.Owner, models.AuditTokenRevoked, map[string]interface{}{
+		"current": !ok || id == "",
+		"device":  token.Device,
+	})
+
 	utils.JSONResponse(w, 200, &TokensDeleteResponse{
 		Success: true,
 		Message: "Successfully logged out",
@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/factor"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// AccountsWebAuthnRequest contains the input for the WebAuthn endpoints.
+type AccountsWebAuthnRequest struct {
+	CurrentPassword string `json:"current_password" schema:"current_password"`
+	Name            string `json:"name" schema:"name"`
+	Credential      string `json:"credential" schema:"credential"`
+}
+
+// AccountsWebAuthnResponse contains the output of the WebAuthn requests.
+type AccountsWebAuthnResponse struct {
+	Success bool                  `json:"success"`
+	Message string                `json:"message,omitempty"`
+	Options json.RawMessage       `json:"options,omitempty"`
+	Factor  *models.AccountFactor `json:"factor,omitempty"`
+}
+
+// webAuthnAccount resolves the account and the WebAuthn factor. If it fails,
+// the response is already written.
+func webAuthnAccount(c web.C, w http.ResponseWriter, r *http.Request, input *AccountsWebAuthnRequest) (*models.Account, *factor.WebAuthn, bool) {
+	webauthn, ok := env.Factors["webauthn"].(*factor.WebAuthn)
+	if !ok {
+		utils.JSONResponse(w, 501, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "WebAuthn is not enabled",
+		})
+		return nil, nil, false
+	}
+
+	account, ok := factorsAccount(c, w, r, input)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return account, webauthn, true
+}
+
+// AccountsWebAuthnCreate starts the registration of a WebAuthn credential.
+// The returned options are passed to navigator.credentials.create.
+func AccountsWebAuthnCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsWebAuthnRequest
+	account, webauthn, ok := webAuthnAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if valid, _, err := account.VerifyPassword(input.CurrentPassword); err != nil || !valid {
+		utils.JSONResponse(w, 403, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Invalid current password",
+		})
+		return
+	}
+
+	if len(account.Factors) >= maxFactors {
+		utils.JSONResponse(w, 403, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Too many second factors",
+		})
+		return
+	}
+
+	exclude := []string{}
+	for _, existing := range account.Factors {
+		if existing.Type == "webauthn" && len(existing.Value) > 0 {
+			exclude = append(exclude, existing.Value[0])
+		}
+	}
+
+	options, err := webauthn.BeginRegistration(account.ID, account.Name+"@"+env.Config.EmailDomain, exclude)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to start a WebAuthn registration")
+
+		utils.JSONResponse(w, 500, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Internal error (code AC/WA/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &AccountsWebAuthnResponse{
+		Success: true,
+		Message: "Create the credential and confirm it",
+		Options: json.RawMessage(options),
+	})
+}
+
+// AccountsWebAuthnConfirm verifies the created credential and adds it to
+// the second factors of the account.
+func AccountsWebAuthnConfirm(c web.C, w http.ResponseWriter, r *http.Request) {
+	var input AccountsWebAuthnRequest
+	account, webauthn, ok := webAuthnAccount(c, w, r, &input)
+	if !ok {
+		return
+	}
+
+	if input.Name == "" {
+		input.Name = "Security key"
+	}
+
+	if len(input.Name) > 64 {
+		utils.JSONResponse(w, 400, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Invalid factor name - it has to be at max 64 characters long",
+		})
+		return
+	}
+
+	if len(account.Factors) >= maxFactors {
+		utils.JSONResponse(w, 403, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Too many second factors",
+		})
+		return
+	}
+
+	value, err := webauthn.FinishRegistration(account.ID, input.Credential)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Warn("Unable to register a WebAuthn credential")
+
+		utils.JSONResponse(w, 400, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Invalid credential",
+		})
+		return
+	}
+
+	for _, existing := range account.Factors {
+		if existing.Type == "webauthn" && len(existing.Value) > 0 && existing.Value[0] == value[0] {
+			utils.JSONResponse(w, 409, &AccountsWebAuthnResponse{
+				Success: false,
+				Message: "This credential is already registered",
+			})
+			return
+		}
+	}
+
+	enabled := account.AddFactor("webauthn", input.Name, value)
+	account.Touch()
+
+	if err := env.Accounts.UpdateID(account.ID, account); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    account.ID,
+		}).Error("Unable to update an account")
+
+		utils.JSONResponse(w, 500, &AccountsWebAuthnResponse{
+			Success: false,
+			Message: "Internal error (code AC/WA/02)",
+		})
+		return
+	}
+
+	recordAudit(c, r, account.ID, models.AuditFactorAdded, factorDetails(enabled))
+
+	utils.JSONResponse(w, 200, &AccountsWebAuthnResponse{
+		Success: true,
+		Message: "Credential successfully registered",
+		Factor:  enabled,
+	})
+}
@@ -0,0 +1,377 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dchest/uniuri"
+	"github.com/zenazn/goji/web"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/utils"
+)
+
+// webhookDeliveriesLimit is the count of deliveries returned by WebhooksDeliveries
+const webhookDeliveriesLimit = 50
+
+// publishEvent queues an event of the owner for delivery to the subscribed
+// webhooks. Failures are only logged, as the event was already handled.
+func publishEvent(owner string, event string, data interface{}) {
+	body, err := json.Marshal(map[string]interface{}{
+		"type":  event,
+		"owner": owner,
+		"data":  data,
+	})
+	if err == nil {
+		err = env.Producer.Publish("webhook_events", body)
+	}
+
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": owner,
+			"event": event,
+		}).Error("Unable to publish a webhook event")
+	}
+}
+
+// isWebhookEvent checks whether an event can be subscribed to
+func isWebhookEvent(event string) bool {
+	for _, known := range models.WebhookEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// isWebhookAddress checks whether address is an absolute HTTP(S) URL
+func isWebhookAddress(address string) bool {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return false
+	}
+
+	return (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+// WebhooksListResponse contains the result of the WebhooksList request.
+type WebhooksListResponse struct {
+	Success  bool              `json:"success"`
+	Message  string            `json:"message,omitempty"`
+	Webhooks []*models.Webhook `json:"webhooks,omitempty"`
+}
+
+// WebhooksList returns all webhooks of the current user
+func WebhooksList(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	webhooks, err := env.Webhooks.GetOwnedBy(session.Owner)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"owner": session.Owner,
+		}).Error("Unable to fetch webhooks")
+
+		utils.JSONResponse(w, 500, &WebhooksListResponse{
+			Success: false,
+			Message: "Internal error (code WH/LI/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &WebhooksListResponse{
+		Success:  true,
+		Webhooks: webhooks,
+	})
+}
+
+// WebhooksCreateRequest is the payload of the WebhooksCreate request.
+type WebhooksCreateRequest struct {
+	Type    string `json:"type" schema:"type"`
+	Address string `json:"address" schema:"address"`
+}
+
+// WebhooksCreateResponse contains the result of the WebhooksCreate request.
+type WebhooksCreateResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	Webhook *models.Webhook `json:"webhook,omitempty"`
+	Secret  string          `json:"secret,omitempty"`
+}
+
+// WebhooksCreate subscribes an URL to an event of the current user. Deliveries
+// are signed with the returned secret.
+func WebhooksCreate(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input WebhooksCreateRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &WebhooksCreateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	if !isWebhookEvent(input.Type) {
+		utils.JSONResponse(w, 400, &WebhooksCreateResponse{
+			Success: false,
+			Message: "Invalid event type",
+		})
+		return
+	}
+
+	if !isWebhookAddress(input.Address) {
+		utils.JSONResponse(w, 400, &WebhooksCreateResponse{
+			Success: false,
+			Message: "Invalid address",
+		})
+		return
+	}
+
+	// Fetch the current session from the middleware
+	session := c.Env["token"].(*models.Token)
+
+	webhook := &models.Webhook{
+		Resource: models.MakeResource(session.Owner, input.Type),
+		Target:   session.Owner,
+		Type:     input.Type,
+		Address:  input.Address,
+		Secret:   uniuri.NewLen(32),
+	}
+
+	if err := env.Webhooks.Insert(webhook); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Error("Unable to insert a webhook")
+
+		utils.JSONResponse(w, 500, &WebhooksCreateResponse{
+			Success: false,
+			Message: "Internal error (code WH/CR/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 201, &WebhooksCreateResponse{
+		Success: true,
+		Webhook: webhook,
+		Secret:  webhook.Secret,
+	})
+}
+
+// WebhooksGetResponse contains the result of the WebhooksGet request.
+type WebhooksGetResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	Webhook *models.Webhook `json:"webhook,omitempty"`
+}
+
+// WebhooksGet returns a webhook of the current user
+func WebhooksGet(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	webhook, err := env.Webhooks.GetWebhook(c.URLParams["id"])
+	if err != nil || webhook.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &WebhooksGetResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &WebhooksGetResponse{
+		Success: true,
+		Webhook: webhook,
+	})
+}
+
+// WebhooksUpdateRequest is the payload of the WebhooksUpdate request.
+type WebhooksUpdateRequest struct {
+	Type     string `json:"type" schema:"type"`
+	Address  string `json:"address" schema:"address"`
+	Disabled *bool  `json:"disabled" schema:"disabled"`
+}
+
+// WebhooksUpdateResponse contains the result of the WebhooksUpdate request.
+type WebhooksUpdateResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	Webhook *models.Webhook `json:"webhook,omitempty"`
+}
+
+// WebhooksUpdate changes a webhook of the current user. Enabling a webhook
+// resets its failures count.
+func WebhooksUpdate(c web.C, w http.ResponseWriter, r *http.Request) {
+	// Decode the request
+	var input WebhooksUpdateRequest
+	err := utils.ParseRequest(r, &input)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Warn("Unable to decode a request")
+
+		utils.JSONResponse(w, 400, &WebhooksUpdateResponse{
+			Success: false,
+			Message: "Invalid input format",
+		})
+		return
+	}
+
+	session := c.Env["token"].(*models.Token)
+
+	webhook, err := env.Webhooks.GetWebhook(c.URLParams["id"])
+	if err != nil || webhook.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &WebhooksUpdateResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+
+	if input.Type != "" {
+		if !isWebhookEvent(input.Type) {
+			utils.JSONResponse(w, 400, &WebhooksUpdateResponse{
+				Success: false,
+				Message: "Invalid event type",
+			})
+			return
+		}
+
+		webhook.Type = input.Type
+		webhook.Name = input.Type
+	}
+
+	if input.Address != "" {
+		if !isWebhookAddress(input.Address) {
+			utils.JSONResponse(w, 400, &WebhooksUpdateResponse{
+				Success: false,
+				Message: "Invalid address",
+			})
+			return
+		}
+
+		webhook.Address = input.Address
+	}
+
+	if input.Disabled != nil {
+		if webhook.Disabled && !*input.Disabled {
+			webhook.Failures = 0
+		}
+
+		webhook.Disabled = *input.Disabled
+	}
+
+	webhook.DateModified = time.Now()
+
+	if err := env.Webhooks.UpdateID(webhook.ID, webhook); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    webhook.ID,
+		}).Error("Unable to update a webhook")
+
+		utils.JSONResponse(w, 500, &WebhooksUpdateResponse{
+			Success: false,
+			Message: "Internal error (code WH/UP/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &WebhooksUpdateResponse{
+		Success: true,
+		Webhook: webhook,
+	})
+}
+
+// WebhooksDeleteResponse contains the result of the WebhooksDelete request.
+type WebhooksDeleteResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+}
+
+// WebhooksDelete removes a webhook of the current user and its delivery log
+func WebhooksDelete(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	webhook, err := env.Webhooks.GetWebhook(c.URLParams["id"])
+	if err != nil || webhook.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &WebhooksDeleteResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+
+	if err := env.Webhooks.DeleteID(webhook.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    webhook.ID,
+		}).Error("Unable to delete a webhook")
+
+		utils.JSONResponse(w, 500, &WebhooksDeleteResponse{
+			Success: false,
+			Message: "Internal error (code WH/DE/01)",
+		})
+		return
+	}
+
+	if err := env.WebhookDeliveries.DeleteByWebhook(webhook.ID); err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    webhook.ID,
+		}).Error("Unable to delete deliveries of a webhook")
+	}
+
+	utils.JSONResponse(w, 200, &WebhooksDeleteResponse{
+		Success: true,
+		Message: "Webhook successfully removed",
+	})
+}
+
+// WebhooksDeliveriesResponse contains the result of the WebhooksDeliveries request.
+type WebhooksDeliveriesResponse struct {
+	Success    bool                      `json:"success"`
+	Message    string                    `json:"message,omitempty"`
+	Deliveries []*models.WebhookDelivery `json:"deliveries,omitempty"`
+}
+
+// WebhooksDeliveries returns the latest deliveries of a webhook
+func WebhooksDeliveries(c web.C, w http.ResponseWriter, r *http.Request) {
+	session := c.Env["token"].(*models.Token)
+
+	webhook, err := env.Webhooks.GetWebhook(c.URLParams["id"])
+	if err != nil || webhook.Owner != session.Owner {
+		utils.JSONResponse(w, 404, &WebhooksDeliveriesResponse{
+			Success: false,
+			Message: "Webhook not found",
+		})
+		return
+	}
+
+	deliveries, err := env.WebhookDeliveries.GetByWebhook(webhook.ID, webhookDeliveriesLimit)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    webhook.ID,
+		}).Error("Unable to fetch deliveries of a webhook")
+
+		utils.JSONResponse(w, 500, &WebhooksDeliveriesResponse{
+			Success: false,
+			Message: "Internal error (code WH/DL/01)",
+		})
+		return
+	}
+
+	utils.JSONResponse(w, 200, &WebhooksDeliveriesResponse{
+		Success:    true,
+		Deliveries: deliveries,
+	})
+}
@@ -0,0 +1,185 @@
+package setup
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/dancannon/gorethink/encoding"
+
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+)
+
+// ExportManifest is stored as manifest.json in every account data export
+type ExportManifest struct {
+	Version     int            `json:"version"`
+	Account     string         `json:"account"`
+	Name        string         `json:"name"`
+	DateCreated time.Time      `json:"date_created"`
+	Counts      map[string]int `json:"counts"`
+}
+
+// exportTables contains the exported tables and the models of their documents
+var exportTables = []struct {
+	name     string
+	table    func() db.RethinkCRUD
+	document func() interface{}
+}{
+	{"emails", func() db.RethinkCRUD { return env.Emails }, func() interface{} { return &models.Email{} }},
+	{"threads", func() db.RethinkCRUD { return env.Threads }, func() interface{} { return &models.Thread{} }},
+	{"labels", func() db.RethinkCRUD { return env.Labels }, func() interface{} { return &models.Label{} }},
+	{"contacts", func() db.RethinkCRUD { return env.Contacts }, func() interface{} { return &models.Contact{} }},
+	{"files", func() db.RethinkCRUD { return env.Files }, func() interface{} { return &models.File{} }},
+	{"keys", func() db.RethinkCRUD { return env.Keys }, func() interface{} { return &models.Key{} }},
+}
+
+// exportRetention is how long archives are stored. Download links are valid
+// for 48 hours after the archive is written, archives are kept a day longer.
+const exportRetention = 72 * time.Hour
+
+// writeExport writes all data of the job's owner into a zip archive stored
+// in the blobs table under the ID of the job. The archive can't be read until
+// it's completely written, a crashed export simply writes it again.
+func writeExport(job *models.Job) error {
+	account, err := env.Accounts.GetAccount(job.Owner)
+	if err != nil {
+		return err
+	}
+
+	blob, err := env.Blobs.Create(job.Owner, job.ID, time.Now().Add(exportRetention))
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(blob)
+
+	manifest := &ExportManifest{
+		Version:     1,
+		Account:     account.ID,
+		Name:        account.Name,
+		DateCreated: time.Now(),
+		Counts:      map[string]int{},
+	}
+
+	if err := writeExportEntry(archive, "account.json", account); err != nil {
+		return err
+	}
+
+	for _, table := range exportTables {
+		count, err := exportTable(archive, table.name, table.table(), table.document, account.ID)
+		if err != nil {
+			return err
+		}
+		manifest.Counts[table.name] = count
+	}
+
+	if err := writeExportEntry(archive, "manifest.json", manifest); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	return blob.Close()
+}
+
+// exportTable streams all documents of the owner into separate JSON files
+func exportTable(archive *zip.Writer, name string, table db.RethinkCRUD, document func() interface{}, owner string) (int, error) {
+	count := 0
+	write := func(raw map[string]interface{}) error {
+		id, ok := raw["id"].(string)
+		if !ok {
+			return errors.New("document without an ID in " + name)
+		}
+
+		value := document()
+		if err := encoding.Decode(value, raw); err != nil {
+			return err
+		}
+
+		if err := writeExportEntry(archive, name+"/"+id+".json", value); err != nil {
+			return err
+		}
+
+		count++
+		return nil
+	}
+
+	// In-memory tables don't support cursors
+	if table.GetSession() == nil {
+		var documents []map[string]interface{}
+		if err := table.FindByIndexFetch(&documents, "owner", owner); err != nil {
+			return 0, err
+		}
+
+		for _, raw := range documents {
+			if err := write(raw); err != nil {
+				return count, err
+			}
+		}
+
+		return count, nil
+	}
+
+	cursor, err := table.FindByIndex("owner", owner)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	var raw map[string]interface{}
+	for cursor.Next(&raw) {
+		if err := write(raw); err != nil {
+			return count, err
+		}
+
+		raw = nil
+	}
+
+	return count, cursor.Err()
+}
+
+// writeExportEntry adds a JSON-encoded value to the archive
+func writeExportEntry(archive *zip.Writer, name string, value interface{}) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(writer)
+	return encoder.Encode(value)
+}
+
+// createExportToken creates the download link of a finished export
+func createExportToken(job *models.Job) error {
+	if job.Result != "" {
+		return nil
+	}
+
+	token := models.MakeExportToken(job.Owner, job.ID)
+	if err := env.Tokens.Insert(&token); err != nil {
+		return err
+	}
+
+	job.Result = "/exports/" + token.ID
+	return nil
+}
+
+// notifyExportReady sends the export_ready event to the owner's subscribed sessions
+func notifyExportReady(job *models.Job) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":  "export_ready",
+		"owner": job.Owner,
+		"job":   job.ID,
+		"url":   job.Result,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("account_events", data)
+}
@@ -0,0 +1,463 @@
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/routes"
+	"github.com/lavab/api/utils"
+)
+
+// importLabel is the name of the label assigned to all imported threads
+const importLabel = "Imported"
+
+// importMailbox imports all messages of an uploaded mailbox. Progress is saved
+// after every message, so a resumed import skips already handled messages.
+func importMailbox(job *models.Job) error {
+	label, err := getImportLabel(job.Owner)
+	if err != nil {
+		return err
+	}
+
+	if job.Total == 0 {
+		total, err := countMailbox(job.ID)
+		if err != nil {
+			return err
+		}
+		job.Total = total
+	}
+
+	mailbox, err := env.Blobs.Open(job.ID)
+	if err != nil {
+		return err
+	}
+
+	index := 0
+	return readMailbox(mailbox, func(raw []byte) error {
+		index++
+		if index <= job.Processed {
+			return nil
+		}
+
+		// Unparseable messages are skipped, database errors fail the step
+		message, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			job.Failed++
+			env.Log.WithFields(logrus.Fields{
+				"job":   job.ID,
+				"index": index,
+				"error": err.Error(),
+			}).Warn("Unable to parse an imported message")
+		} else if err := importMessage(job.Owner, label.ID, message, raw); err != nil {
+			return err
+		}
+
+		job.Processed++
+		job.DateModified = time.Now()
+		return updateJob(job, map[string]interface{}{
+			"total":         job.Total,
+			"processed":     job.Processed,
+			"failed":        job.Failed,
+			"date_modified": job.DateModified,
+		})
+	})
+}
+
+// removeImport deletes the uploaded mailbox of a finished import
+func removeImport(job *models.Job) error {
+	return env.Blobs.DeleteBlob(job.ID)
+}
+
+// getImportLabel returns the owner's "Imported" label, creating it if needed
+func getImportLabel(owner string) (*models.Label, error) {
+	if label, err := env.Labels.GetLabelByNameAndOwner(owner, importLabel); err == nil {
+		return label, nil
+	}
+
+	label := &models.Label{
+		Resource: models.MakeResource(owner, importLabel),
+		Builtin:  false,
+	}
+	if err := env.Labels.Insert(label); err != nil {
+		return nil, err
+	}
+
+	return label, nil
+}
+
+// countMailbox returns the count of messages in an uploaded mailbox
+func countMailbox(id string) (int, error) {
+	mailbox, err := env.Blobs.Open(id)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = readMailbox(mailbox, func(raw []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// readMailbox calls fn with the source of every message in an mbox file.
+// Files not starting with a "From " line are read as a single EML message.
+// The slice passed to fn is reused, so it must not be retained.
+func readMailbox(r io.Reader, fn func(raw []byte) error) error {
+	var (
+		reader  = bufio.NewReader(r)
+		message bytes.Buffer
+		first   = true
+		mbox    bool
+	)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if first {
+				first = false
+				mbox = bytes.HasPrefix(line, []byte("From "))
+			}
+
+			if mbox && bytes.HasPrefix(line, []byte("From ")) {
+				if message.Len() > 0 {
+					if err := fn(message.Bytes()); err != nil {
+						return err
+					}
+					message.Reset()
+				}
+				continue
+			}
+
+			// Unescape ">From " lines (mboxrd)
+			if mbox && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+				line = line[1:]
+			}
+
+			message.Write(line)
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if message.Len() > 0 {
+		return fn(message.Bytes())
+	}
+
+	return nil
+}
+
+// importMessage stores a parsed message in the owner's mailbox. Messages that
+// were already imported are skipped.
+func importMessage(owner string, label string, message *mail.Message, raw []byte) error {
+	header := message.Header
+
+	messageID := trimMessageID(header.Get("Message-Id"))
+	if messageID == "" {
+		hash := sha256.Sum256(raw)
+		messageID = hex.EncodeToString(hash[:]) + "@import"
+	}
+
+	if _, err := env.Emails.GetByMessageID(owner, messageID); err == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(message.Body)
+	if err != nil {
+		return err
+	}
+
+	subject := decodeHeader(header.Get("Subject"))
+	date, err := header.Date()
+	if err != nil {
+		date = time.Now()
+	}
+
+	// Already encrypted messages are stored as they are
+	kind := "raw"
+	contentType := "message/rfc822"
+	secure := "none"
+	if isEncrypted(header, body) {
+		kind = "pgpmime"
+		contentType = ""
+		secure = "all"
+	}
+
+	from := importAddresses(header, "From")
+	to := importAddresses(header, "To")
+	cc := importAddresses(header, "Cc")
+
+	email := &models.Email{
+		Resource:    models.MakeResource(owner, subject),
+		MessageID:   messageID,
+		Kind:        kind,
+		To:          to,
+		CC:          cc,
+		Body:        string(raw),
+		ContentType: contentType,
+		ReplyTo:     header.Get("Reply-To"),
+		Status:      "received",
+	}
+	email.DateCreated = date
+	email.DateModified = date
+	if len(from) > 0 {
+		email.From = from[0]
+	}
+
+	// Find the thread by references first, then by the subject
+	thread := findImportThread(owner, header, subject)
+	if thread == nil {
+		thread = &models.Thread{
+			Resource:    models.MakeResource(owner, utils.StripSubjectPrefixes(subject)),
+			Emails:      []string{},
+			Labels:      []string{},
+			Members:     []string{},
+			IsRead:      true,
+			SubjectHash: utils.SubjectHash(subject),
+			Secure:      secure,
+		}
+		thread.DateCreated = date
+		thread.DateModified = date
+
+		if err := env.Threads.Insert(thread); err != nil {
+			return err
+		}
+	} else if thread.Secure != secure {
+		thread.Secure = "some"
+	}
+
+	email.Thread = thread.ID
+	if err := env.Emails.Insert(email); err != nil {
+		return err
+	}
+	routes.AddStorage(email.Owner, len(email.Body)+len(email.Manifest))
+
+	thread.Emails = appendMissing(thread.Emails, email.ID)
+	thread.Labels = appendMissing(thread.Labels, label)
+	for _, address := range append(append(from, to...), cc...) {
+		if parsed, err := mail.ParseAddress(address); err == nil {
+			address = parsed.Address
+		}
+		thread.Members = appendMissing(thread.Members, address)
+	}
+	if date.After(thread.DateModified) {
+		thread.DateModified = date
+	}
+
+	return env.Threads.UpdateID(thread.ID, thread)
+}
+
+// findImportThread returns the thread that a message replies to
+func findImportThread(owner string, header mail.Header, subject string) *models.Thread {
+	references := strings.Fields(header.Get("In-Reply-To"))
+	fields := strings.Fields(header.Get("References"))
+	for i := len(fields) - 1; i >= 0; i-- {
+		references = append(references, fields[i])
+	}
+
+	for _, reference := range references {
+		email, err := env.Emails.GetByMessageID(owner, trimMessageID(reference))
+		if err != nil {
+			continue
+		}
+
+		if thread, err := env.Threads.GetThread(email.Thread); err == nil {
+			return thread
+		}
+	}
+
+	if strings.TrimSpace(utils.StripSubjectPrefixes(subject)) == "" {
+		return nil
+	}
+
+	thread, err := env.Threads.GetBySubjectHash(owner, utils.SubjectHash(subject))
+	if err != nil {
+		return nil
+	}
+
+	return thread
+}
+
+// isEncrypted checks whether a message uses PGP/MIME or inline PGP
+func isEncrypted(header mail.Header, body []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err == nil && mediaType == "multipart/encrypted" {
+		return true
+	}
+
+	return bytes.Contains(body, []byte("-----BEGIN PGP MESSAGE-----"))
+}
+
+// importAddresses parses an address list header. Unparseable headers are
+// returned as they are.
+func importAddresses(header mail.Header, key string) []string {
+	value := header.Get(key)
+	if value == "" {
+		return []string{}
+	}
+
+	list, err := header.AddressList(key)
+	if err != nil {
+		return []string{decodeHeader(value)}
+	}
+
+	result := make([]string, len(list))
+	for i, address := range list {
+		result[i] = address.String()
+	}
+	return result
+}
+
+// decodeHeader decodes RFC 2047 encoded words. Words that can't be decoded
+// are kept as they are. Whitespace between two encoded words is dropped.
+func decodeHeader(value string) string {
+	var (
+		buffer      bytes.Buffer
+		lastEncoded bool
+	)
+
+	for value != "" {
+		// Split the value into whitespace and the following word
+		start := 0
+		for start < len(value) && strings.IndexByte(" \t\r\n", value[start]) != -1 {
+			start++
+		}
+		space := value[:start]
+		value = value[start:]
+
+		end := strings.IndexAny(value, " \t\r\n")
+		if end == -1 {
+			end = len(value)
+		}
+		word := value[:end]
+		value = value[end:]
+
+		decoded, ok := decodeWord(word)
+		if !ok || !lastEncoded {
+			buffer.WriteString(space)
+		}
+		if ok {
+			buffer.WriteString(decoded)
+		} else {
+			buffer.WriteString(word)
+		}
+		lastEncoded = ok
+	}
+
+	return buffer.String()
+}
+
+// decodeWord decodes a single "=?charset?encoding?text?=" word. Only UTF-8,
+// US-ASCII and ISO-8859-1 texts are supported.
+func decodeWord(word string) (string, bool) {
+	if len(word) < 8 || !strings.HasPrefix(word, "=?") || !strings.HasSuffix(word, "?=") {
+		return "", false
+	}
+
+	parts := strings.Split(word[2:len(word)-2], "?")
+	if len(parts) != 3 {
+		return "", false
+	}
+
+	// RFC 2231 allows a language after the charset
+	charset := strings.ToLower(parts[0])
+	if i := strings.IndexByte(charset, '*'); i != -1 {
+		charset = charset[:i]
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	switch strings.ToUpper(parts[1]) {
+	case "B":
+		data, err = base64.StdEncoding.DecodeString(parts[2])
+	case "Q":
+		data, err = decodeQ(parts[2])
+	default:
+		return "", false
+	}
+	if err != nil {
+		return "", false
+	}
+
+	switch charset {
+	case "utf-8", "us-ascii":
+		if !utf8.Valid(data) {
+			return "", false
+		}
+		return string(data), true
+	case "iso-8859-1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), true
+	}
+
+	return "", false
+}
+
+// errInvalidQ is returned for malformed Q-encoded texts
+var errInvalidQ = errors.New("Invalid Q-encoded text")
+
+// decodeQ decodes the Q encoding of RFC 2047, a variant of quoted-printable
+// using underscores for spaces
+func decodeQ(text string) ([]byte, error) {
+	result := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; c {
+		case '_':
+			result = append(result, ' ')
+		case '=':
+			if i+2 >= len(text) {
+				return nil, errInvalidQ
+			}
+			decoded, err := hex.DecodeString(text[i+1 : i+3])
+			if err != nil {
+				return nil, errInvalidQ
+			}
+			result = append(result, decoded[0])
+			i += 2
+		default:
+			result = append(result, c)
+		}
+	}
+
+	return result, nil
+}
+
+// trimMessageID removes angle brackets around a Message-ID
+func trimMessageID(id string) string {
+	return strings.Trim(strings.TrimSpace(id), "<>")
+}
+
+// appendMissing appends value to slice unless it's already there
+func appendMissing(slice []string, value string) []string {
+	for _, item := range slice {
+		if item == value {
+			return slice
+		}
+	}
+	return append(slice, value)
+}
@@ -0,0 +1,37 @@
+package setup
+
+import (
+	"testing"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"plain subject", "plain subject"},
+		// B encoding
+		{"=?UTF-8?B?WmHFvMOzxYLEhyBnxJnFm2zEhSBqYcW6xYQ=?=", "Zażółć gęślą jaźń"},
+		{"=?utf-8?b?SGVsbG8=?= world", "Hello world"},
+		// Q encoding
+		{"=?ISO-8859-1?Q?Caf=E9_cr=E8me?=", "Café crème"},
+		{"=?utf-8?q?=C5=BC=C3=B3=C5=82w?=", "żółw"},
+		// Whitespace between encoded words is dropped, but not around text
+		{"=?utf-8?q?Hello,?= =?utf-8?q?_world?=", "Hello, world"},
+		{"Re: =?utf-8?q?Caf=C3=A9?= menu", "Re: Café menu"},
+		// RFC 2231 languages
+		{"=?US-ASCII*EN?Q?Keith_Moore?=", "Keith Moore"},
+		// Invalid words are kept
+		{"=?utf-8?q?broken=Z?=", "=?utf-8?q?broken=Z?="},
+		{"=?utf-8?b?!!!?=", "=?utf-8?b?!!!?="},
+		{"=?koi8-r?b?8NLJ18XU?=", "=?koi8-r?b?8NLJ18XU?="},
+		{"=?utf-8?x?text?=", "=?utf-8?x?text?="},
+		{"=?utf-8?q?trailing=?=", "=?utf-8?q?trailing=?="},
+	}
+
+	for _, c := range cases {
+		if result := decodeHeader(c.input); result != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.input, c.expected, result)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package setup
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/dchest/uniuri"
+
+	"github.com/lavab/api/billing"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/routes"
+)
+
+// jobStep is a single idempotent part of a job
+type jobStep struct {
+	name string
+	run  func(job *models.Job) error
+}
+
+// jobSteps contains steps of all job types in the order of execution
+var jobSteps = map[string][]jobStep{
+	models.JobAccountWipe: {
+		{"contacts", func(job *models.Job) error { return env.Contacts.DeleteOwnedBy(job.Owner) }},
+		{"emails", func(job *models.Job) error { return env.Emails.DeleteOwnedBy(job.Owner) }},
+		{"threads", func(job *models.Job) error { return env.Threads.DeleteOwnedBy(job.Owner) }},
+		{"files", func(job *models.Job) error { return env.Files.DeleteOwnedBy(job.Owner) }},
+		{"labels", func(job *models.Job) error { return env.Labels.DeleteCustomOwnedBy(job.Owner) }},
+		{"tokens", func(job *models.Job) error { return env.Tokens.DeleteOwnedBy(job.Owner) }},
+		{"storage", func(job *models.Job) error { return routes.ResetStorage(job.Owner) }},
+	},
+	models.JobAccountDelete: {
+		{"status", markDeleted},
+		{"subscription", endSubscription},
+		{"contacts", func(job *models.Job) error { return env.Contacts.DeleteOwnedBy(job.Owner) }},
+		{"emails", func(job *models.Job) error { return env.Emails.DeleteOwnedBy(job.Owner) }},
+		{"threads", func(job *models.Job) error { return env.Threads.DeleteOwnedBy(job.Owner) }},
+		{"files", func(job *models.Job) error { return env.Files.DeleteOwnedBy(job.Owner) }},
+		{"labels", func(job *models.Job) error { return env.Labels.DeleteOwnedBy(job.Owner) }},
+		{"keys", func(job *models.Job) error { return env.Keys.DeleteOwnedBy(job.Owner) }},
+		{"addresses", func(job *models.Job) error { return env.Addresses.QuarantineOwnedBy(job.Owner) }},
+		{"changes", func(job *models.Job) error { return env.Changes.DeleteOwnedBy(job.Owner) }},
+		{"webhooks", func(job *models.Job) error { return env.Webhooks.DeleteOwnedBy(job.Owner) }},
+		{"webhook_deliveries", func(job *models.Job) error { return env.WebhookDeliveries.DeleteOwnedBy(job.Owner) }},
+		{"audit_events", func(job *models.Job) error { return env.AuditEvents.DeleteOwnedBy(job.Owner) }},
+		{"blobs", func(job *models.Job) error { return env.Blobs.DeleteOwnedBy(job.Owner) }},
+		{"oauth_clients", deleteOAuthClients},
+		{"tokens", func(job *models.Job) error { return env.Tokens.DeleteOwnedBy(job.Owner) }},
+		{"account", func(job *models.Job) error { return env.Accounts.DeleteID(job.Owner) }},
+	},
+	models.JobAccountExport: {
+		{"archive", writeExport},
+		{"token", createExportToken},
+		{"notify", notifyExportReady},
+	},
+	models.JobAccountImport: {
+		{"messages", importMailbox},
+		{"cleanup", removeImport},
+	},
+	models.JobFactorReenrollment: {
+		{"notify", notifyFactorReenrollment},
+	},
+}
+
+// errJobCancelled is returned by steps of jobs that shouldn't run anymore
+var errJobCancelled = errors.New("job cancelled")
+
+// errJobClaimed is returned if another run of the job owns it
+var errJobClaimed = errors.New("job is claimed by another run")
+
+const (
+	// scheduleInterval is how often delayed jobs are checked
+	scheduleInterval = time.Minute
+
+	// jobTouchInterval is how often a running job extends its claim and the
+	// timeout of its message
+	jobTouchInterval = 20 * time.Second
+
+	// jobClaimDuration is how long a claim lasts without being extended
+	jobClaimDuration = 2 * time.Minute
+)
+
+// claimJob fetches a job and marks it as owned by a new run, so that no other
+// instance changes it. It returns nil if the job shouldn't run now and
+// errJobClaimed if it's already running elsewhere.
+func claimJob(id string) (*models.Job, error) {
+	job, err := env.Jobs.GetJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.IsFinished() || !job.IsDue() {
+		return nil, nil
+	}
+
+	if job.Status == models.JobRunning && time.Now().Before(job.DateClaimExpires) {
+		return nil, errJobClaimed
+	}
+
+	previous := map[string]interface{}{
+		"status": job.Status,
+		"claim":  job.Claim,
+	}
+
+	job.Status = models.JobRunning
+	job.Claim = uniuri.NewLen(uniuri.UUIDLen)
+	job.DateClaimExpires = time.Now().Add(jobClaimDuration)
+	job.DateModified = time.Now()
+
+	claimed, err := env.Jobs.UpdateIDIf(job.ID, previous, job)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, errJobClaimed
+	}
+
+	return job, nil
+}
+
+// updateJob changes a job if the run still owns it
+func updateJob(job *models.Job, data interface{}) error {
+	updated, err := env.Jobs.UpdateIDIf(job.ID, map[string]interface{}{
+		"claim": job.Claim,
+	}, data)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errJobClaimed
+	}
+
+	return nil
+}
+
+// runJob executes all steps of a job that haven't been completed yet. Jobs
+// interrupted by an error or a crash are resumed when run again. Delayed
+// jobs are skipped until they are due. touch is called periodically while
+// the job runs.
+func runJob(id string, touch func()) error {
+	job, err := claimJob(id)
+	if err != nil || job == nil {
+		return err
+	}
+
+	// Keep the claim while the steps run
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(jobTouchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				touch()
+
+				if err := updateJob(job, map[string]interface{}{
+					"date_claim_expires": time.Now().Add(jobClaimDuration),
+				}); err != nil {
+					env.Log.WithFields(logrus.Fields{
+						"error": err.Error(),
+						"id":    job.ID,
+					}).Warn("Unable to extend the claim of a job")
+				}
+			}
+		}
+	}()
+
+	steps, ok := jobSteps[job.Type]
+	if !ok {
+		job.Status = models.JobFailed
+		job.Error = "Unknown job type"
+		job.DateModified = time.Now()
+		return updateJob(job, job)
+	}
+
+	job.Steps = make([]string, len(steps))
+	for i, step := range steps {
+		job.Steps[i] = step.name
+	}
+	job.DateModified = time.Now()
+	if err := updateJob(job, job); err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if job.IsCompleted(step.name) {
+			continue
+		}
+
+		if err := step.run(job); err == errJobCancelled {
+			job.Status = models.JobCancelled
+			job.DateModified = time.Now()
+			return updateJob(job, job)
+		} else if err != nil {
+			// Release the claim, so that the next attempt doesn't have to wait
+			job.Error = err.Error()
+			job.DateClaimExpires = time.Time{}
+			job.DateModified = time.Now()
+			if err := updateJob(job, job); err != nil {
+				return err
+			}
+
+			return errors.New("step " + step.name + " failed: " + err.Error())
+		}
+
+		job.Completed = append(job.Completed, step.name)
+		job.DateModified = time.Now()
+		if err := updateJob(job, job); err != nil {
+			return err
+		}
+	}
+
+	job.Status = models.JobDone
+	job.Error = ""
+	job.DateModified = time.Now()
+	return updateJob(job, job)
+}
+
+// failJob marks a job that can't be retried anymore as failed. Jobs that were
+// finished or claimed by another run in the meantime are left untouched.
+func failJob(id string, reason error) error {
+	job, err := env.Jobs.GetJob(id)
+	if err != nil {
+		return err
+	}
+
+	if job.IsFinished() || time.Now().Before(job.DateClaimExpires) {
+		return nil
+	}
+
+	_, err = env.Jobs.UpdateIDIf(job.ID, map[string]interface{}{
+		"status": job.Status,
+		"claim":  job.Claim,
+	}, map[string]interface{}{
+		"status":        models.JobFailed,
+		"error":         reason.Error(),
+		"date_modified": time.Now(),
+	})
+	return err
+}
+
+// publishDueJobs periodically queues delayed jobs that are due. Only the
+// instance that wins the interval in the cache checks the jobs, the consumers
+// claim them anyway.
+func publishDueJobs() {
+	for now := range time.Tick(scheduleInterval) {
+		interval := now.UnixNano() / int64(scheduleInterval)
+		won, err := env.Cache.Increment("jobs:schedule:"+strconv.FormatInt(interval, 10), 1, 2*scheduleInterval)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to lock the job schedule")
+			continue
+		}
+		if won != 1 {
+			continue
+		}
+
+		jobs, err := env.Jobs.GetScheduled()
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to fetch scheduled jobs")
+			continue
+		}
+
+		for _, job := range jobs {
+			if !job.IsDue() {
+				continue
+			}
+
+			if err := env.Producer.Publish("account_jobs", []byte(`"`+job.ID+`"`)); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"id":    job.ID,
+				}).Error("Unable to queue a scheduled job")
+			}
+		}
+	}
+}
+
+// notifyFactorReenrollment emails the verified alt email of an account whose
+// second factor was removed using the hook_factor_reenrollment topic
+func notifyFactorReenrollment(job *models.Job) error {
+	account, err := env.Accounts.GetAccount(job.Owner)
+	if err != nil {
+		return err
+	}
+
+	if account.AltEmail == "" || !account.AltEmailVerified {
+		return nil
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"account": account.ID,
+		"email":   account.AltEmail,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("hook_factor_reenrollment", data)
+}
+
+// markDeleted changes the status of an account pending deletion to deleted,
+// so that it can't be used anymore. The job is cancelled if the deletion was.
+func markDeleted(job *models.Job) error {
+	account, err := env.Accounts.GetAccount(job.Owner)
+	if err != nil {
+		return err
+	}
+
+	if account.Status == models.StatusDeleted {
+		return nil
+	}
+
+	if err := account.SetStatus(models.StatusDeleted, ""); err != nil {
+		return errJobCancelled
+	}
+
+	return env.Accounts.UpdateID(account.ID, map[string]interface{}{
+		"status":         account.Status,
+		"status_history": account.StatusHistory,
+	})
+}
+
+// endSubscription stops charging the account. Invoices are kept for accounting.
+func endSubscription(job *models.Job) error {
+	subscription, err := env.Subscriptions.GetCurrent(job.Owner)
+	if err != nil || subscription == nil {
+		return err
+	}
+
+	if env.Billing != nil && env.Billing.Name() == subscription.Provider && !subscription.Cancelled {
+		if err := env.Billing.Cancel(subscription.ProviderID); err != nil && err != billing.ErrUnknownSubscription {
+			return err
+		}
+	}
+
+	return env.Subscriptions.UpdateID(subscription.ID, map[string]interface{}{
+		"status":        models.SubscriptionEnded,
+		"cancelled":     true,
+		"date_modified": time.Now(),
+	})
+}
+
+// deleteOAuthClients removes OAuth clients registered by the account, together
+// with the tokens that other users gave to them
+func deleteOAuthClients(job *models.Job) error {
+	clients, err := env.OAuthClients.GetOwnedBy(job.Owner)
+	if err != nil {
+		return err
+	}
+
+	for _, client := range clients {
+		if _, err := env.Tokens.DeleteByClient(client.ID); err != nil {
+			return err
+		}
+
+		if err := env.OAuthClients.DeleteID(client.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package setup
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bitly/go-nsq"
+
+	"github.com/lavab/api/cache"
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+)
+
+func TestJobClaims(t *testing.T) {
+	env.Jobs = &db.JobsTable{
+		RethinkCRUD: db.NewMemoryTable("test", "jobs", db.TableIndexes["jobs"]...),
+	}
+
+	job := &models.Job{
+		Resource: models.MakeResource("alice", "job"),
+		Type:     "unknown",
+		Status:   models.JobQueued,
+	}
+	if err := env.Jobs.Insert(job); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := claimJob(job.ID)
+	if err != nil || claimed == nil {
+		t.Fatalf("unable to claim a queued job: %v", err)
+	}
+
+	// Running jobs can't be claimed or failed until their claim expires
+	if _, err := claimJob(job.ID); err != errJobClaimed {
+		t.Fatalf("claimed a running job: %v", err)
+	}
+	if err := failJob(job.ID, errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := env.Jobs.GetJob(job.ID); current.Status != models.JobRunning {
+		t.Fatalf("failJob changed a claimed job to %s", current.Status)
+	}
+
+	// An expired claim is taken over and the previous run can't change the job
+	if err := env.Jobs.UpdateID(job.ID, map[string]interface{}{
+		"date_claim_expires": time.Now().Add(-time.Second),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runJob(job.ID, func() {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateJob(claimed, map[string]interface{}{"status": models.JobDone}); err != errJobClaimed {
+		t.Fatalf("stale run changed the job: %v", err)
+	}
+
+	current, err := env.Jobs.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Status != models.JobFailed || current.Error != "Unknown job type" {
+		t.Fatalf("unexpected job %+v", current)
+	}
+
+	// Finished jobs aren't failed again
+	if err := failJob(job.ID, errors.New("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := env.Jobs.GetJob(job.ID); current.Error != "Unknown job type" {
+		t.Fatalf("failJob changed a finished job: %s", current.Error)
+	}
+}
+
+func TestFactorReenrollmentJob(t *testing.T) {
+	env.Jobs = &db.JobsTable{
+		RethinkCRUD: db.NewMemoryTable("test", "jobs", db.TableIndexes["jobs"]...),
+	}
+	env.Accounts = &db.AccountsTable{
+		RethinkCRUD: db.NewMemoryTable("test", "accounts", db.TableIndexes["accounts"]...),
+		Cache:       cache.NewMemoryCache(),
+	}
+
+	queue := newMemoryQueue()
+	env.Producer = queue
+
+	notices := make(chan map[string]string, 2)
+	queue.AddHandler("hook_factor_reenrollment", nsq.NewConfig(), nsq.HandlerFunc(func(m *nsq.Message) error {
+		var notice map[string]string
+		if err := json.Unmarshal(m.Body, &notice); err != nil {
+			t.Error(err)
+		}
+		notices <- notice
+		return nil
+	}))
+
+	// Only verified alt emails are notified
+	for _, verified := range []bool{true, false} {
+		account := &models.Account{
+			Resource:                   models.MakeResource("", "alice"),
+			AltEmail:                   "alice@example.com",
+			AltEmailVerified:           verified,
+			FactorReenrollmentRequired: true,
+		}
+		if err := env.Accounts.Insert(account); err != nil {
+			t.Fatal(err)
+		}
+
+		job := &models.Job{
+			Resource: models.MakeResource(account.ID, ""),
+			Type:     models.JobFactorReenrollment,
+			Status:   models.JobQueued,
+		}
+		if err := env.Jobs.Insert(job); err != nil {
+			t.Fatal(err)
+		}
+		if err := runJob(job.ID, func() {}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case notice := <-notices:
+			if !verified {
+				t.Fatalf("unverified email was notified: %v", notice)
+			}
+			if notice["account"] != account.ID || notice["email"] != "alice@example.com" {
+				t.Fatalf("unexpected notice %v", notice)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if verified {
+				t.Fatal("verified email was not notified")
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dancannon/gorethink"
+
+	"github.com/lavab/api/db"
+	"github.com/lavab/api/env"
+)
+
+// RunMigrations handles the `migrate` command. Supported commands are "up" and "status".
+func RunMigrations(flags *env.Flags, command string) error {
+	if command != "up" && command != "status" {
+		return errors.New("usage: api [flags] migrate up|status")
+	}
+
+	session, err := gorethink.Connect(gorethink.ConnectOpts{
+		Address:  flags.RethinkDBAddress,
+		AuthKey:  flags.RethinkDBKey,
+		Database: flags.RethinkDBDatabase,
+		Timeout:  time.Second * 10,
+	})
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if command == "up" {
+		applied, err := db.Migrate(session, flags.RethinkDBDatabase)
+		for _, name := range applied {
+			fmt.Printf("applied %s\n", name)
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+		return nil
+	}
+
+	status, err := db.GetSchemaStatus(session, flags.RethinkDBDatabase)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range status.Applied {
+		fmt.Printf("applied  %s\n", name)
+	}
+	for _, name := range status.Pending {
+		fmt.Printf("pending  %s\n", name)
+	}
+	if len(status.MissingTables) > 0 {
+		fmt.Printf("missing tables: %s\n", strings.Join(status.MissingTables, ", "))
+	}
+	if len(status.MissingIndexes) > 0 {
+		fmt.Printf("missing indexes: %s\n", strings.Join(status.MissingIndexes, ", "))
+	}
+	if len(status.ChangedIndexes) > 0 {
+		fmt.Printf("indexes with different fields: %s\n", strings.Join(status.ChangedIndexes, ", "))
+	}
+	if len(status.ExtraIndexes) > 0 {
+		fmt.Printf("unknown indexes: %s\n", strings.Join(status.ExtraIndexes, ", "))
+	}
+
+	if !status.UpToDate() {
+		return errors.New("database schema is outdated")
+	}
+	return nil
+}
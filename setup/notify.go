@@ -0,0 +1,100 @@
+package setup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+	"github.com/lavab/api/routes"
+	"github.com/lavab/api/utils"
+)
+
+// deliverNewDeviceNotice puts a new device notification into the Inbox of the
+// account as a system thread. The notice is readable by API tokens with the
+// emails:read scope, so it doesn't contain the "this wasn't me" token, which
+// is only emailed to the verified alt email.
+func deliverNewDeviceNotice(body []byte) error {
+	var event routes.NewDeviceEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+
+	// Redelivered messages are only stored once
+	hash := sha256.Sum256([]byte(event.Token))
+	messageID := "new-device-" + hex.EncodeToString(hash[:16]) + "@" + env.Config.EmailDomain
+	if _, err := env.Emails.GetByMessageID(event.Account, messageID); err == nil {
+		return nil
+	}
+
+	account, err := env.Accounts.GetAccount(event.Account)
+	if err != nil {
+		return err
+	}
+
+	inbox, err := env.Labels.GetLabelByNameAndOwner(account.ID, "Inbox")
+	if err != nil {
+		return err
+	}
+
+	var text bytes.Buffer
+	fmt.Fprintf(&text, "Your account was just accessed from a new device.\n\n")
+	fmt.Fprintf(&text, "Device: %s\nIP address: %s\nDate: %s\n\n", event.Device, event.IP, event.Date.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintf(&text, "If this was you, you can ignore this message. If it wasn't, end that session in the list of your sessions and change your password.\n")
+	if event.Email != "" {
+		fmt.Fprintf(&text, "\nA link that ends the session and resets your password was sent to your alternative email.\n")
+	}
+
+	from := "Lavaboom <no-reply@" + env.Config.EmailDomain + ">"
+	to := account.Name + "@" + env.Config.EmailDomain
+	subject := "New login to your account"
+
+	thread := &models.Thread{
+		Resource:    models.MakeResource(account.ID, subject),
+		Emails:      []string{},
+		Labels:      []string{inbox.ID},
+		Members:     []string{"no-reply@" + env.Config.EmailDomain, to},
+		IsRead:      false,
+		SubjectHash: utils.SubjectHash(subject),
+		Secure:      "none",
+	}
+	if err := env.Threads.Insert(thread); err != nil {
+		return err
+	}
+
+	email := &models.Email{
+		Resource:    models.MakeResource(account.ID, subject),
+		MessageID:   messageID,
+		Kind:        "raw",
+		From:        from,
+		To:          []string{to},
+		CC:          []string{},
+		Body:        text.String(),
+		ContentType: "text/plain",
+		Thread:      thread.ID,
+		Status:      "received",
+	}
+	if err := env.Emails.Insert(email); err != nil {
+		return err
+	}
+	routes.AddStorage(email.Owner, len(email.Body))
+
+	thread.Emails = []string{email.ID}
+	if err := env.Threads.UpdateID(thread.ID, thread); err != nil {
+		return err
+	}
+
+	// Announce it like any other received email
+	data, err := json.Marshal(map[string]interface{}{
+		"id":    email.ID,
+		"owner": account.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	return env.Producer.Publish("email_receipt", data)
+}
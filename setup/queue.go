@@ -0,0 +1,103 @@
+package setup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bitly/go-nsq"
+	"github.com/dchest/uniuri"
+)
+
+// memoryQueue replaces nsq when the API runs with the memory backend. Messages
+// are delivered to all handlers of the topic in the same process, attempts are
+// limited and delayed using the consumer's config like nsq does.
+type memoryQueue struct {
+	lock     sync.RWMutex
+	handlers map[string][]*memoryHandler
+}
+
+// memoryHandler is a handler of a topic registered in the memoryQueue
+type memoryHandler struct {
+	handler nsq.Handler
+	config  *nsq.Config
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		handlers: map[string][]*memoryHandler{},
+	}
+}
+
+// AddHandler registers a handler of messages published into the topic
+func (q *memoryQueue) AddHandler(topic string, config *nsq.Config, handler nsq.Handler) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.handlers[topic] = append(q.handlers[topic], &memoryHandler{
+		handler: handler,
+		config:  config,
+	})
+}
+
+// Publish delivers the message to all handlers of the topic in the background
+func (q *memoryQueue) Publish(topic string, body []byte) error {
+	q.lock.RLock()
+	handlers := q.handlers[topic]
+	q.lock.RUnlock()
+
+	for _, handler := range handlers {
+		var id nsq.MessageID
+		copy(id[:], uniuri.NewLen(nsq.MsgIDLength))
+
+		message := nsq.NewMessage(id, body)
+		message.Delegate = handler
+		go handler.deliver(message)
+	}
+
+	return nil
+}
+
+// deliver passes a message to the handler and responds like an nsq consumer
+func (h *memoryHandler) deliver(message *nsq.Message) {
+	message.Attempts++
+	if h.config.MaxAttempts > 0 && message.Attempts > h.config.MaxAttempts {
+		message.Finish()
+		return
+	}
+
+	if err := h.handler.HandleMessage(message); err != nil {
+		if !message.IsAutoResponseDisabled() {
+			message.Requeue(-1)
+		}
+		return
+	}
+
+	if !message.IsAutoResponseDisabled() {
+		message.Finish()
+	}
+}
+
+// OnFinish implements nsq.MessageDelegate, finished messages are dropped
+func (h *memoryHandler) OnFinish(message *nsq.Message) {}
+
+// OnTouch implements nsq.MessageDelegate, messages in memory don't time out
+func (h *memoryHandler) OnTouch(message *nsq.Message) {}
+
+// OnRequeue implements nsq.MessageDelegate by delivering a copy of the
+// message again after the delay
+func (h *memoryHandler) OnRequeue(message *nsq.Message, delay time.Duration, backoff bool) {
+	if delay == -1 {
+		delay = h.config.DefaultRequeueDelay * time.Duration(message.Attempts)
+		if delay > h.config.MaxRequeueDelay {
+			delay = h.config.MaxRequeueDelay
+		}
+	}
+
+	retry := nsq.NewMessage(message.ID, message.Body)
+	retry.Attempts = message.Attempts
+	retry.Delegate = h
+
+	time.AfterFunc(delay, func() {
+		h.deliver(retry)
+	})
+}
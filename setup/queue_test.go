@@ -0,0 +1,72 @@
+package setup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bitly/go-nsq"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	queue := newMemoryQueue()
+
+	config := nsq.NewConfig()
+	config.DefaultRequeueDelay = time.Millisecond
+	config.MaxAttempts = 3
+
+	// Every handler of the topic receives the message
+	received := make(chan string, 2)
+	queue.AddHandler("topic", config, nsq.HandlerFunc(func(m *nsq.Message) error {
+		received <- "first " + string(m.Body)
+		return nil
+	}))
+	queue.AddHandler("topic", config, nsq.HandlerFunc(func(m *nsq.Message) error {
+		received <- "second " + string(m.Body)
+		return nil
+	}))
+
+	// Failed messages are retried until they run out of attempts
+	attempts := make(chan uint16, 5)
+	queue.AddHandler("failing", config, nsq.HandlerFunc(func(m *nsq.Message) error {
+		attempts <- m.Attempts
+		return errors.New("failed")
+	}))
+
+	if err := queue.Publish("topic", []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+	if err := queue.Publish("failing", []byte("body")); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-received:
+			seen[message] = true
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+	}
+	if !seen["first body"] || !seen["second body"] {
+		t.Fatalf("unexpected deliveries %v", seen)
+	}
+
+	for i := uint16(1); i <= 3; i++ {
+		select {
+		case attempt := <-attempts:
+			if attempt != i {
+				t.Fatalf("expected attempt %d, got %d", i, attempt)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d was not delivered", i)
+		}
+	}
+
+	select {
+	case attempt := <-attempts:
+		t.Fatalf("message was delivered after the last attempt (%d)", attempt)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -24,18 +24,22 @@ import (
 	"github.com/zenazn/goji/web/middleware"
 	"gopkg.in/igm/sockjs-go.v2/sockjs"
 
+	"github.com/lavab/api/billing"
 	"github.com/lavab/api/cache"
 	"github.com/lavab/api/db"
 	"github.com/lavab/api/env"
 	"github.com/lavab/api/factor"
+	"github.com/lavab/api/models"
 	"github.com/lavab/api/routes"
 	"github.com/lavab/api/utils"
 )
 
-// sessions contains all "subscribing" WebSockets sessions
+// sessions contains all "subscribing" WebSockets sessions, subscriptionTokens
+// maps their IDs to the tokens used to subscribe
 var (
-	sessions     = map[string][]sockjs.Session{}
-	sessionsLock sync.Mutex
+	sessions           = map[string][]sockjs.Session{}
+	subscriptionTokens = map[string]string{}
+	sessionsLock       sync.Mutex
 )
 
 type nopCloser struct {
@@ -123,44 +127,98 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 	env.PasswordBF = bf
 
 	// Initialize the cache
-	redis, err := cache.NewRedisCache(&cache.RedisCacheOpts{
-		Address:  flags.RedisAddress,
-		Database: flags.RedisDatabase,
-		Password: flags.RedisPassword,
-	})
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Unable to connect to the redis server")
-	}
+	if flags.MemoryBackend {
+		env.Cache = cache.NewMemoryCache()
+	} else {
+		redis, err := cache.NewRedisCache(&cache.RedisCacheOpts{
+			Address:  flags.RedisAddress,
+			Database: flags.RedisDatabase,
+			Password: flags.RedisPassword,
+		})
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Unable to connect to the redis server")
+		}
 
-	env.Cache = redis
+		env.Cache = redis
+	}
 
 	// Set up the database
-	rethinkOpts := gorethink.ConnectOpts{
-		Address: flags.RethinkDBAddress,
-		AuthKey: flags.RethinkDBKey,
-		MaxIdle: 10,
-		Timeout: time.Second * 10,
+	var rethinkSession *gorethink.Session
+	if !flags.MemoryBackend {
+		rethinkSession, err = gorethink.Connect(gorethink.ConnectOpts{
+			Address:  flags.RethinkDBAddress,
+			AuthKey:  flags.RethinkDBKey,
+			Database: flags.RethinkDBDatabase,
+			MaxIdle:  10,
+			Timeout:  time.Second * 10,
+		})
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Unable to connect to the database")
+		}
+
+		// Apply pending migrations if we're allowed to
+		if flags.AutoMigrate {
+			applied, err := db.Migrate(rethinkSession, flags.RethinkDBDatabase)
+			for _, name := range applied {
+				log.WithFields(logrus.Fields{
+					"migration": name,
+				}).Info("Applied a migration")
+			}
+			if err != nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Fatal("Unable to migrate the database")
+			}
+		}
+
+		// Refuse to start with an outdated schema
+		status, err := db.GetSchemaStatus(rethinkSession, flags.RethinkDBDatabase)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Unable to check the database schema")
+		}
+
+		if len(status.ExtraIndexes) > 0 {
+			log.WithFields(logrus.Fields{
+				"indexes": status.ExtraIndexes,
+			}).Warn("Database contains unknown indexes")
+		}
+
+		if !status.UpToDate() {
+			log.WithFields(logrus.Fields{
+				"pending":         status.Pending,
+				"missing_tables":  status.MissingTables,
+				"missing_indexes": status.MissingIndexes,
+				"changed_indexes": status.ChangedIndexes,
+			}).Fatal("Database schema is outdated, run `api migrate up` or start with -auto_migrate")
+		}
 	}
-	err = db.Setup(rethinkOpts)
-	if err != nil {
-		log.WithFields(logrus.Fields{
-			"error": err,
-		}).Fatal("Unable to set up the database")
+
+	// Put the RethinkDB session into the environment package
+	env.Rethink = rethinkSession
+
+	// newTable creates a CRUD implementation of a table using the chosen backend
+	newTable := func(name string) db.RethinkCRUD {
+		if flags.MemoryBackend {
+			return db.NewMemoryTable(flags.RethinkDBDatabase, name, db.TableIndexes[name]...)
+		}
+
+		return db.NewCRUDTable(rethinkSession, flags.RethinkDBDatabase, name)
 	}
 
-	// Initialize the actual connection
-	rethinkOpts.Database = flags.RethinkDBDatabase
-	rethinkSession, err := gorethink.Connect(rethinkOpts)
+	// X-Real-IP is honoured only from the reverse proxies
+	proxies, err := utils.ParseNetworks(flags.TrustedProxies)
 	if err != nil {
-		log.WithFields(logrus.Fields{
+		env.Log.WithFields(logrus.Fields{
 			"error": err,
-		}).Fatal("Unable to connect to the database")
+		}).Fatal("Unable to parse trusted proxies")
 	}
-
-	// Put the RethinkDB session into the environment package
-	env.Rethink = rethinkSession
+	env.TrustedProxies = proxies
 
 	// Initialize factors
 	env.Factors = make(map[string]factor.Factor)
@@ -174,99 +232,189 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 		env.Factors[yubicloud.Type()] = yubicloud
 	}
 
-	authenticator := factor.NewAuthenticator(6)
+	authenticator := factor.NewAuthenticator(6, env.Cache)
 	env.Factors[authenticator.Type()] = authenticator
 
+	if flags.WebAuthnOrigins != "" {
+		webauthn, err := factor.NewWebAuthn(flags.WebAuthnRPID, strings.Split(flags.WebAuthnOrigins, ","), env.Cache)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Unable to initiate WebAuthn")
+		}
+		env.Factors[webauthn.Type()] = webauthn
+	}
+
+	// Initialize the payment provider
+	switch flags.BillingProvider {
+	case "":
+	case "fake":
+		fake, err := billing.NewFake(flags.BillingSecret)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err,
+			}).Fatal("Unable to initiate the fake billing provider")
+		}
+		env.Billing = fake
+	default:
+		env.Log.WithFields(logrus.Fields{
+			"provider": flags.BillingProvider,
+		}).Fatal("Unknown billing provider")
+	}
+
 	// Initialize the tables
+	env.Changes = &db.ChangesTable{
+		RethinkCRUD: newTable("changes"),
+		Sequences: &db.SequencesTable{
+			RethinkCRUD: newTable("sequences"),
+		},
+	}
+	env.Jobs = &db.JobsTable{
+		RethinkCRUD: newTable("jobs"),
+	}
+	env.Blobs = &db.BlobsTable{
+		RethinkCRUD: newTable("blobs"),
+	}
+	env.Webhooks = &db.WebhooksTable{
+		RethinkCRUD: newTable("webhooks"),
+	}
+	env.WebhookDeliveries = &db.WebhookDeliveriesTable{
+		RethinkCRUD: newTable("webhook_deliveries"),
+	}
+	env.OAuthClients = &db.OAuthClientsTable{
+		RethinkCRUD: newTable("oauth_clients"),
+	}
+	env.AuditEvents = db.NewAuditEventsTable(newTable("audit_events"))
+	env.Subscriptions = &db.SubscriptionsTable{
+		RethinkCRUD: newTable("subscriptions"),
+	}
+	env.Invoices = &db.InvoicesTable{
+		RethinkCRUD: newTable("invoices"),
+	}
+
+	// synced creates a table whose writes are recorded in the change log
+	synced := func(name string) db.RethinkCRUD {
+		return db.NewChangeLog(newTable(name), env.Changes)
+	}
+
 	env.Tokens = &db.TokensTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"tokens",
-		),
-		Cache: redis,
+		RethinkCRUD: newTable("tokens"),
+		Cache:       env.Cache,
 	}
 	env.Accounts = &db.AccountsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"accounts",
-		),
-		Tokens: env.Tokens,
+		RethinkCRUD: newTable("accounts"),
+		Tokens:      env.Tokens,
+		Cache:       env.Cache,
 	}
 	env.Addresses = &db.AddressesTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"addresses",
-		),
+		RethinkCRUD: newTable("addresses"),
 	}
 	env.Keys = &db.KeysTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"keys",
-		),
+		RethinkCRUD: synced("keys"),
 	}
 	env.Contacts = &db.ContactsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"contacts",
-		),
+		RethinkCRUD: synced("contacts"),
 	}
 	env.Reservations = &db.ReservationsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"reservations",
-		),
+		RethinkCRUD: newTable("reservations"),
 	}
 	env.Emails = &db.EmailsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"emails",
-		),
+		RethinkCRUD: synced("emails"),
 	}
 	env.Threads = &db.ThreadsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"threads",
-		),
+		RethinkCRUD: synced("threads"),
+		Emails:      env.Emails,
 	}
 	env.Labels = &db.LabelsTable{
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"labels",
-		),
-		Emails: env.Emails,
+		RethinkCRUD: synced("labels"),
+		Emails:      env.Emails,
 		//Cache:  redis,
 	}
 	env.Files = &db.FilesTable{
-		Emails: env.Emails,
-		RethinkCRUD: db.NewCRUDTable(
-			rethinkSession,
-			rethinkOpts.Database,
-			"files",
-		),
+		Emails:      env.Emails,
+		RethinkCRUD: synced("files"),
 	}
 
-	// Create a producer
-	producer, err := nsq.NewProducer(flags.NSQdAddress, nsq.NewConfig())
-	if err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Fatal("Unable to create a new nsq producer")
+	// Remove expired reservations every hour. Lookups skip them anyway, this
+	// only keeps the table small.
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := env.Reservations.DeleteExpired(); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to remove expired reservations")
+			}
+		}
+	}()
+
+	// Remove expired data exports
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := env.Blobs.DeleteExpired(); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to remove expired blobs")
+			}
+		}
+	}()
+
+	// Remove change log entries older than the sync tokens that are still accepted
+	go func() {
+		retention := time.Duration(flags.SyncRetention) * time.Hour
+		for range time.Tick(time.Hour) {
+			if err := env.Changes.DeleteOlderThan(time.Now().Add(-retention)); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Error("Unable to remove old change log entries")
+			}
+		}
+	}()
+
+	// Messages are passed in the process when the memory backend is used
+	var queue *memoryQueue
+	if flags.MemoryBackend {
+		queue = newMemoryQueue()
+		env.Producer = queue
+	} else {
+		// Create a producer
+		producer, err := nsq.NewProducer(flags.NSQdAddress, nsq.NewConfig())
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatal("Unable to create a new nsq producer")
+		}
+
+		/*defer func(producer *nsq.Producer) {
+			producer.Stop()
+		}(producer)*/
+
+		env.Producer = producer
 	}
 
-	/*defer func(producer *nsq.Producer) {
-		producer.Stop()
-	}(producer)*/
+	// consume creates a consumer of the topic running handler in concurrency goroutines
+	consume := func(topic, channel string, config *nsq.Config, concurrency int, handler nsq.HandlerFunc) {
+		if queue != nil {
+			queue.AddHandler(topic, config, handler)
+			return
+		}
+
+		consumer, err := nsq.NewConsumer(topic, channel, config)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"topic": topic,
+			}).Fatal("Unable to create a new nsq consumer")
+		}
 
-	env.Producer = producer
+		consumer.AddConcurrentHandlers(handler, concurrency)
+
+		if err := consumer.ConnectToNSQLookupd(flags.LookupdAddress); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"topic": topic,
+			}).Fatal("Unable to connect to nsqlookupd")
+		}
+	}
 
 	// Get the hostname
 	hostname, err := os.Hostname()
@@ -277,16 +425,7 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 	}
 
 	// Create a delivery consumer
-	deliveryConsumer, err := nsq.NewConsumer("email_delivery", hostname, nsq.NewConfig())
-	if err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-			"topic": "email_delivery",
-		}).Fatal("Unable to create a new nsq consumer")
-	}
-	//defer deliveryConsumer.Stop()
-
-	deliveryConsumer.AddConcurrentHandlers(nsq.HandlerFunc(func(m *nsq.Message) error {
+	consume("email_delivery", hostname, nsq.NewConfig(), 10, func(m *nsq.Message) error {
 		// Raven recoverer
 		defer func() {
 			rec := recover()
@@ -369,25 +508,10 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 		}
 
 		return nil
-	}), 10)
-
-	if err := deliveryConsumer.ConnectToNSQLookupd(flags.LookupdAddress); err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Fatal("Unable to connect to nsqlookupd")
-	}
+	})
 
 	// Create a receipt consumer
-	receiptConsumer, err := nsq.NewConsumer("email_receipt", hostname, nsq.NewConfig())
-	if err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-			"topic": "email_receipt",
-		}).Fatal("Unable to create a new nsq consumer")
-	}
-	//defer receiptConsumer.Stop()
-
-	receiptConsumer.AddConcurrentHandlers(nsq.HandlerFunc(func(m *nsq.Message) error {
+	consume("email_receipt", hostname, nsq.NewConfig(), 10, func(m *nsq.Message) error {
 		// Raven recoverer
 		defer func() {
 			rec := recover()
@@ -470,14 +594,167 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 		}
 
 		return nil
-	}), 10)
+	})
 
-	if err := receiptConsumer.ConnectToNSQLookupd(flags.LookupdAddress); err != nil {
-		env.Log.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Fatal("Unable to connect to nsqlookupd")
+	// Create a consumer of background jobs. The channel is shared, so that
+	// each job is run by a single instance.
+	jobsConfig := nsq.NewConfig()
+	consume("account_jobs", "jobs", jobsConfig, 1, func(m *nsq.Message) error {
+		var id string
+		if err := json.Unmarshal(m.Body, &id); err != nil {
+			return err
+		}
+
+		if err := runJob(id, m.Touch); err == errJobClaimed {
+			// Wait until the other run finishes or its claim expires
+			return err
+		} else if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error":    err.Error(),
+				"id":       id,
+				"attempts": m.Attempts,
+			}).Error("Unable to run a job")
+
+			// Give up after the last attempt
+			if jobsConfig.MaxAttempts > 0 && m.Attempts >= jobsConfig.MaxAttempts {
+				if err := failJob(id, err); err != nil {
+					env.Log.WithFields(logrus.Fields{
+						"error": err.Error(),
+						"id":    id,
+					}).Error("Unable to mark a job as failed")
+				}
+				return nil
+			}
+
+			return err
+		}
+
+		return nil
+	})
+
+	// Queue delayed jobs, e.g. account deletions after the grace period
+	go publishDueJobs()
+
+	// Create a consumer of account events, which are forwarded to subscribed sessions
+	consume("account_events", hostname, nsq.NewConfig(), 1, func(m *nsq.Message) error {
+		var msg struct {
+			Owner string `json:"owner"`
+		}
+
+		if err := json.Unmarshal(m.Body, &msg); err != nil {
+			return err
+		}
+
+		sessionsLock.Lock()
+		subscribers := sessions[msg.Owner]
+		sessionsLock.Unlock()
+
+		for _, session := range subscribers {
+			if err := session.Send(string(m.Body)); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"id":    session.ID(),
+					"error": err.Error(),
+				}).Warn("Error while writing to a WebSocket")
+			}
+		}
+
+		return nil
+	})
+
+	// Create a consumer putting new device notifications into the Inbox. The
+	// channel is shared, so that each notification is stored only once.
+	consume("hook_new_device", "inbox", nsq.NewConfig(), 1, func(m *nsq.Message) error {
+		if err := deliverNewDeviceNotice(m.Body); err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Unable to deliver a new device notification")
+			return err
+		}
+
+		return nil
+	})
+
+	// Create a consumer of token revocations, which closes subscriptions made
+	// using the revoked tokens
+	consume("token_revocations", hostname, nsq.NewConfig(), 1, func(m *nsq.Message) error {
+		var msg struct {
+			Owner  string   `json:"owner"`
+			Tokens []string `json:"tokens"`
+		}
+
+		if err := json.Unmarshal(m.Body, &msg); err != nil {
+			return err
+		}
+
+		revoked := map[string]struct{}{}
+		for _, token := range msg.Tokens {
+			revoked[token] = struct{}{}
+		}
+
+		sessionsLock.Lock()
+		closed := []sockjs.Session{}
+		for _, session := range sessions[msg.Owner] {
+			if _, ok := revoked[subscriptionTokens[session.ID()]]; ok {
+				closed = append(closed, session)
+			}
+		}
+		sessionsLock.Unlock()
+
+		// Closing makes Recv fail, so the handler removes the subscription
+		for _, session := range closed {
+			if err := session.Close(4001, "Session revoked"); err != nil {
+				env.Log.WithFields(logrus.Fields{
+					"id":    session.ID(),
+					"error": err.Error(),
+				}).Warn("Unable to close a revoked WebSocket")
+			}
+		}
+
+		return nil
+	})
+
+	// Create consumers sending events to webhooks. They share a single channel,
+	// so that each event is handled by only one of the instances.
+	webhookSources := map[string]func(body []byte) error{
+		"email_delivery": func(body []byte) error { return fanOutEmailEvent(models.EventDelivery, body) },
+		"email_receipt":  func(body []byte) error { return fanOutEmailEvent(models.EventReceipt, body) },
+		"webhook_events": fanOutEvent,
+	}
+	for topic, handler := range webhookSources {
+		handler := handler
+
+		consume(topic, "webhooks", nsq.NewConfig(), 1, func(m *nsq.Message) error {
+			return handler(m.Body)
+		})
 	}
 
+	// Create a consumer of webhook deliveries. Attempts are counted in the
+	// deliveries, which are requeued with an exponential backoff.
+	deliveriesConfig := nsq.NewConfig()
+	deliveriesConfig.MaxAttempts = 0
+	deliveriesConfig.MaxInFlight = 10
+	consume("webhook_deliveries", "webhooks", deliveriesConfig, 10, func(m *nsq.Message) error {
+		var id string
+		if err := json.Unmarshal(m.Body, &id); err != nil {
+			return err
+		}
+
+		delay, err := deliverWebhook(id)
+		if err != nil {
+			env.Log.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"id":    id,
+			}).Error("Unable to deliver a webhook")
+			return err
+		}
+
+		if delay > 0 {
+			m.RequeueWithoutBackoff(delay)
+		}
+
+		return nil
+	})
+
 	// Create a new goji mux
 	mux := web.New()
 
@@ -518,6 +795,9 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 
 			w.Header().Set("Access-Control-Allow-Headers", strings.Join(resultHeaders, ","))
 
+			// Clients wait for Retry-After of rate limited requests
+			w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+
 			/*
 				if c.Env != nil {
 					if v, ok := c.Env[web.ValidMethodsKey]; ok {
@@ -547,21 +827,54 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 	auth := web.New()
 	auth.Use(routes.AuthMiddleware)
 
+	// Rate limits of the public routes, counted per IP
+	loginLimit := routes.NewRateLimiter("login", 20, time.Minute)
+	oauthLimit := routes.NewRateLimiter("oauth_token", 60, time.Minute)
+	registerLimit := routes.NewRateLimiter("register", 10, time.Hour)
+	emailLimit := routes.NewRateLimiter("email", 10, time.Hour)
+
 	// Index route
 	mux.Get("/", routes.Hello)
 
 	// Accounts
 	auth.Get("/accounts", routes.AccountsList)
-	mux.Post("/accounts", routes.AccountsCreate)
+	mux.Post("/accounts", registerLimit.Middleware(http.HandlerFunc(routes.AccountsCreate)))
+	mux.Post("/accounts/reset", emailLimit.MiddlewareC(routes.AccountsReset))
+	mux.Post("/accounts/confirm", emailLimit.Middleware(http.HandlerFunc(routes.AccountsConfirm)))
+	mux.Post("/accounts/unlock", emailLimit.MiddlewareC(routes.AccountsUnlock))
+	mux.Post("/accounts/not-me", emailLimit.MiddlewareC(routes.AccountsNotMe))
 	auth.Get("/accounts/:id", routes.AccountsGet)
 	auth.Put("/accounts/:id", routes.AccountsUpdate)
 	auth.Delete("/accounts/:id", routes.AccountsDelete)
+	auth.Post("/accounts/:id/cancel-deletion", routes.AccountsCancelDeletion)
 	auth.Post("/accounts/:id/wipe-data", routes.AccountsWipeData)
 	auth.Post("/accounts/:id/start-onboarding", routes.AccountsStartOnboarding)
+	auth.Post("/accounts/:id/export", routes.AccountsExport)
+	auth.Post("/accounts/:id/import", routes.AccountsImport)
+	auth.Post("/accounts/:id/recovery-codes", routes.AccountsRecoveryCodes)
+	auth.Get("/accounts/:id/sessions", routes.AccountsSessionsList)
+	auth.Delete("/accounts/:id/sessions", routes.AccountsSessionsDelete)
+	auth.Post("/accounts/:id/authenticator", routes.AccountsAuthenticatorCreate)
+	auth.Post("/accounts/:id/authenticator/confirm", routes.AccountsAuthenticatorConfirm)
+	auth.Post("/accounts/:id/webauthn", routes.AccountsWebAuthnCreate)
+	auth.Post("/accounts/:id/webauthn/confirm", routes.AccountsWebAuthnConfirm)
+	auth.Get("/accounts/:id/factors", routes.AccountsFactorsList)
+	auth.Post("/accounts/:id/factors", routes.AccountsFactorsCreate)
+	auth.Delete("/accounts/:id/factors/:factor", routes.AccountsFactorsDelete)
+	auth.Post("/accounts/:id/backup-codes", routes.AccountsBackupCodes)
+	auth.Get("/accounts/:id/audit", routes.AccountsAuditList)
 
 	// Addresses
 	auth.Get("/addresses", routes.AddressesList)
 
+	// Billing
+	mux.Get("/billing/plans", routes.BillingPlans)
+	mux.Post("/billing/callback", routes.BillingCallback)
+	auth.Get("/accounts/:id/billing", routes.AccountsBillingGet)
+	auth.Post("/accounts/:id/subscription", routes.SubscriptionsCreate)
+	auth.Delete("/accounts/:id/subscription", routes.SubscriptionsDelete)
+	auth.Get("/accounts/:id/invoices", routes.AccountsInvoicesList)
+
 	// Avatars
 	mux.Get(regexp.MustCompile(`/avatars/(?P<hash>[\S\s]*?)\.(?P<ext>svg|png)(?:[\S\s]*?)$`), routes.Avatars)
 	//mux.Get("/avatars/:hash.:ext", routes.Avatars)
@@ -576,10 +889,28 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 	// Tokens
 	auth.Get("/tokens", routes.TokensGet)
 	auth.Get("/tokens/:id", routes.TokensGet)
-	mux.Post("/tokens", routes.TokensCreate)
+	mux.Post("/tokens", loginLimit.MiddlewareC(routes.TokensCreate))
+	mux.Post("/tokens/refresh", routes.TokensRefresh)
 	auth.Delete("/tokens", routes.TokensDelete)
 	auth.Delete("/tokens/:id", routes.TokensDelete)
 
+	// API tokens
+	auth.Get("/api-tokens", routes.APITokensList)
+	auth.Post("/api-tokens", routes.APITokensCreate)
+	auth.Delete("/api-tokens/:id", routes.APITokensDelete)
+
+	// OAuth
+	auth.Get("/oauth/clients", routes.OAuthClientsList)
+	auth.Post("/oauth/clients", routes.OAuthClientsCreate)
+	auth.Delete("/oauth/clients/:id", routes.OAuthClientsDelete)
+	auth.Get("/oauth/authorize", routes.OAuthAuthorizeGet)
+	auth.Post("/oauth/authorize", routes.OAuthAuthorize)
+	auth.Get("/oauth/consents", routes.OAuthConsentsList)
+	auth.Delete("/oauth/consents/:id", routes.OAuthConsentsDelete)
+	mux.Post("/oauth/token", oauthLimit.Middleware(http.HandlerFunc(routes.OAuthToken)))
+	mux.Post("/oauth/introspect", routes.OAuthIntrospect)
+	mux.Post("/oauth/revoke", routes.OAuthRevoke)
+
 	// Threads
 	auth.Get("/threads", routes.ThreadsList)
 	auth.Get("/threads/:id", routes.ThreadsGet)
@@ -612,6 +943,33 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 	mux.Get("/keys/:id", routes.KeysGet)
 	auth.Post("/keys/:id/vote", routes.KeysVote)
 
+	// Delta sync
+	auth.Get("/sync", routes.Sync)
+
+	// Invitations
+	auth.Get("/invites", routes.InvitesList)
+	auth.Post("/invites", routes.InvitesCreate)
+	auth.Delete("/invites/:id", routes.InvitesDelete)
+
+	// Username reservations
+	mux.Get("/reservations/check", routes.ReservationsCheck)
+	mux.Post("/reservations", emailLimit.Middleware(http.HandlerFunc(routes.ReservationsCreate)))
+	mux.Post("/reservations/confirm", emailLimit.Middleware(http.HandlerFunc(routes.ReservationsConfirm)))
+
+	// Background jobs
+	auth.Get("/jobs/:id", routes.JobsGet)
+
+	// Webhooks
+	auth.Get("/webhooks", routes.WebhooksList)
+	auth.Post("/webhooks", routes.WebhooksCreate)
+	auth.Get("/webhooks/:id", routes.WebhooksGet)
+	auth.Put("/webhooks/:id", routes.WebhooksUpdate)
+	auth.Delete("/webhooks/:id", routes.WebhooksDelete)
+	auth.Get("/webhooks/:id/deliveries", routes.WebhooksDeliveries)
+
+	// Account data exports, authenticated by the token in the URL
+	mux.Get("/exports/:id", routes.ExportsGet)
+
 	// Headers proxy
 	mux.Get("/headers", func(w http.ResponseWriter, r *http.Request) {
 		utils.JSONResponse(w, 200, r.Header)
@@ -686,7 +1044,7 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 
 				// Check the token in database
 				token, err := env.Tokens.GetToken(input.Token)
-				if err != nil {
+				if err != nil || token.Expired() || (token.Type != "auth" && !(token.IsScoped() && token.HasScope("sync:read"))) {
 					// Return an error response
 					resp, _ := json.Marshal(map[string]interface{}{
 						"type":  "error",
@@ -706,6 +1064,11 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 				// Do the actual subscription
 				subscribed = token.Owner
 				sessionsLock.Lock()
+				subscriptionTokens[session.ID()] = token.ID
+				if token.Family != "" {
+					// Auth tokens are replaced on each refresh, so track the session
+					subscriptionTokens[session.ID()] = token.Family
+				}
 
 				// Sessions map already contains this owner
 				if _, ok := sessions[token.Owner]; ok {
@@ -747,6 +1110,7 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 				}
 
 				sessionsLock.Lock()
+				delete(subscriptionTokens, session.ID())
 
 				if _, ok := sessions[subscribed]; !ok {
 					// Return a response
@@ -902,6 +1266,7 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 		}
 
 		sessionsLock.Lock()
+		delete(subscriptionTokens, session.ID())
 
 		if _, ok := sessions[subscribed]; !ok {
 			sessionsLock.Unlock()
@@ -938,7 +1303,21 @@ func PrepareMux(flags *env.Flags) *web.Mux {
 		sessionsLock.Unlock()
 	}))
 
+	// Admin API, available only to superuser accounts
+	admin := web.New()
+	admin.Use(routes.AuthMiddleware)
+	admin.Use(routes.AdminMiddleware)
+	admin.Get("/admin/accounts", routes.AdminAccountsList)
+	admin.Get("/admin/accounts/:id", routes.AdminAccountsGet)
+	admin.Get("/admin/accounts/:id/usage", routes.AdminAccountsUsage)
+	admin.Post("/admin/accounts/:id/approve", routes.AdminAccountsApprove)
+	admin.Post("/admin/accounts/:id/suspend", routes.AdminAccountsSuspend)
+	admin.Post("/admin/accounts/:id/unsuspend", routes.AdminAccountsUnsuspend)
+	admin.Post("/admin/accounts/:id/logout", routes.AdminAccountsLogout)
+	admin.Get("/admin/beta-queue", routes.AdminBetaQueue)
+
 	// Merge the muxes
+	mux.Handle("/admin/*", admin)
 	mux.Handle("/*", auth)
 
 	// Compile the routes
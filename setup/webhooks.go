@@ -0,0 +1,327 @@
+package setup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/lavab/api/env"
+	"github.com/lavab/api/models"
+)
+
+const (
+	// maxDeliveryAttempts is the count of requests made before a delivery fails
+	maxDeliveryAttempts = 8
+
+	// maxWebhookFailures is the count of consecutive failed deliveries that disables a webhook
+	maxWebhookFailures = 5
+
+	// deliveryRetryDelay is the delay before the first retry, doubled after each attempt
+	deliveryRetryDelay = 30 * time.Second
+
+	// maxDeliveryRetryDelay caps the delay between retries
+	maxDeliveryRetryDelay = 15 * time.Minute
+)
+
+// errPrivateAddress is returned when a webhook points into a private network
+var errPrivateAddress = errors.New("webhook address resolves to a private network")
+
+// privateNetworks contains address ranges that webhooks can't connect to
+var privateNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// isPrivateIP checks whether the address belongs to a private network
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dialWebhook resolves the host and connects to its address, unless any of
+// the host's addresses is private. The checked address is dialed directly,
+// so the host can't resolve to another address in the meantime.
+func dialWebhook(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("webhook address doesn't resolve")
+	}
+
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return nil, errPrivateAddress
+		}
+	}
+
+	return net.DialTimeout(network, net.JoinHostPort(ips[0].String(), port), 5*time.Second)
+}
+
+// webhookClient sends deliveries. Connections to loopback and private
+// networks are refused, so webhooks can't be used to reach internal services.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		Dial: dialWebhook,
+	},
+}
+
+// webhookPayload is the body of a webhook request
+type webhookPayload struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Account     string      `json:"account"`
+	DateCreated time.Time   `json:"date_created"`
+	Data        interface{} `json:"data"`
+}
+
+// fanOutWebhooks creates deliveries of an event for all subscribed webhooks
+// of the owner and queues them.
+func fanOutWebhooks(owner string, event string, data interface{}) error {
+	webhooks, err := env.Webhooks.GetSubscribed(owner, event)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		delivery := &models.WebhookDelivery{
+			Resource: models.MakeResource(owner, event),
+			Webhook:  webhook.ID,
+			Event:    event,
+			Status:   models.DeliveryPending,
+		}
+
+		payload, err := json.Marshal(&webhookPayload{
+			ID:          delivery.ID,
+			Type:        event,
+			Account:     owner,
+			DateCreated: delivery.DateCreated,
+			Data:        data,
+		})
+		if err != nil {
+			return err
+		}
+		delivery.Payload = string(payload)
+
+		if err := env.WebhookDeliveries.Insert(delivery); err != nil {
+			return err
+		}
+
+		if err := env.Producer.Publish("webhook_deliveries", []byte(`"`+delivery.ID+`"`)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fanOutEmailEvent sends a delivery or receipt event of an email to the webhooks
+func fanOutEmailEvent(event string, body []byte) error {
+	var msg struct {
+		ID    string `json:"id"`
+		Owner string `json:"owner"`
+	}
+
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return err
+	}
+
+	// Don't resolve anything if there's no subscriber
+	webhooks, err := env.Webhooks.GetSubscribed(msg.Owner, event)
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+
+	email, err := env.Emails.GetEmail(msg.ID)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"id":    msg.ID,
+		}).Error("Unable to resolve an email from queue")
+		return nil
+	}
+
+	thread, err := env.Threads.GetThread(email.Thread)
+	if err != nil {
+		env.Log.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"id":     msg.ID,
+			"thread": email.Thread,
+		}).Error("Unable to resolve a thread from queue")
+		return nil
+	}
+
+	return fanOutWebhooks(msg.Owner, event, map[string]interface{}{
+		"id":     email.ID,
+		"name":   email.Name,
+		"thread": email.Thread,
+		"labels": thread.Labels,
+	})
+}
+
+// deliverWebhook makes a single attempt to deliver an event. If the delivery
+// should be retried, the returned delay is positive.
+func deliverWebhook(id string) (time.Duration, error) {
+	delivery, err := env.WebhookDeliveries.GetDelivery(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if delivery.Status != models.DeliveryPending {
+		return 0, nil
+	}
+
+	webhook, err := env.Webhooks.GetWebhook(delivery.Webhook)
+	if err != nil || webhook.Disabled {
+		delivery.Status = models.DeliveryFailed
+		delivery.Error = "Webhook was removed or disabled"
+		delivery.DateModified = time.Now()
+		return 0, env.WebhookDeliveries.UpdateID(delivery.ID, delivery)
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = postWebhook(webhook, delivery)
+	delivery.DateModified = time.Now()
+
+	if err == nil {
+		delivery.Status = models.DeliverySucceeded
+		delivery.Error = ""
+		delivery.DateDelivered = delivery.DateModified
+
+		if webhook.Failures > 0 {
+			if err := env.Webhooks.UpdateID(webhook.ID, map[string]interface{}{
+				"failures": 0,
+			}); err != nil {
+				return 0, err
+			}
+		}
+
+		return 0, env.WebhookDeliveries.UpdateID(delivery.ID, delivery)
+	}
+
+	delivery.Error = err.Error()
+
+	if delivery.Attempts < maxDeliveryAttempts {
+		delay := deliveryRetryDelay << uint(delivery.Attempts-1)
+		if delay > maxDeliveryRetryDelay {
+			delay = maxDeliveryRetryDelay
+		}
+
+		return delay, env.WebhookDeliveries.UpdateID(delivery.ID, delivery)
+	}
+
+	delivery.Status = models.DeliveryFailed
+	if err := env.WebhookDeliveries.UpdateID(delivery.ID, delivery); err != nil {
+		return 0, err
+	}
+
+	// Disable webhooks that keep failing
+	webhook.Failures++
+	if webhook.Failures >= maxWebhookFailures {
+		webhook.Disabled = true
+
+		env.Log.WithFields(logrus.Fields{
+			"id":       webhook.ID,
+			"failures": webhook.Failures,
+		}).Warn("Disabled a failing webhook")
+	}
+
+	return 0, env.Webhooks.UpdateID(webhook.ID, map[string]interface{}{
+		"failures":      webhook.Failures,
+		"disabled":      webhook.Disabled,
+		"date_modified": time.Now(),
+	})
+}
+
+// postWebhook sends a delivery signed by the webhook's secret. Responses
+// other than 2xx are treated as errors.
+func postWebhook(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", webhook.Address, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(delivery.Payload))
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Lavaboom-Webhooks")
+	req.Header.Set("X-Lavaboom-Event", delivery.Event)
+	req.Header.Set("X-Lavaboom-Delivery", delivery.ID)
+	req.Header.Set("X-Lavaboom-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body to reuse the connection
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %d", resp.StatusCode)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// fanOutEvent sends an event published by the API to the webhooks
+func fanOutEvent(body []byte) error {
+	var msg struct {
+		Type  string          `json:"type"`
+		Owner string          `json:"owner"`
+		Data  json.RawMessage `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return err
+	}
+
+	return fanOutWebhooks(msg.Owner, msg.Type, msg.Data)
+}
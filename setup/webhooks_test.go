@@ -0,0 +1,31 @@
+package setup
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	for address, private := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.20.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"0.0.0.0":          true,
+		"::1":              true,
+		"fd00::1":          true,
+		"::ffff:127.0.0.1": true,
+		"8.8.8.8":          false,
+		"172.32.0.1":       false,
+		"2001:4860::8888":  false,
+	} {
+		if isPrivateIP(net.ParseIP(address)) != private {
+			t.Errorf("isPrivateIP(%s) should be %v", address, private)
+		}
+	}
+
+	if _, err := dialWebhook("tcp", "127.0.0.1:80"); err != errPrivateAddress {
+		t.Fatalf("dialed a loopback address: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// deviceBrowsers and deviceSystems are matched against User-Agent headers
+// in order, so more specific names go first.
+var (
+	deviceBrowsers = []struct{ token, name string }{
+		{"Edge/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Chrome/", "Chrome"},
+		{"Firefox/", "Firefox"},
+		{"Safari/", "Safari"},
+		{"MSIE ", "Internet Explorer"},
+		{"Trident/", "Internet Explorer"},
+		{"curl/", "curl"},
+	}
+	deviceSystems = []struct{ token, name string }{
+		{"Windows Phone", "Windows Phone"},
+		{"Windows", "Windows"},
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Mac OS X", "Mac OS X"},
+		{"CrOS", "Chrome OS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// DeviceName returns a coarse description of the device that sent the
+// User-Agent, e.g. "Firefox on Windows".
+func DeviceName(userAgent string) string {
+	var browser, system string
+
+	for _, b := range deviceBrowsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	for _, s := range deviceSystems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	}
+
+	return "Unknown device"
+}
+
+// DeviceFingerprint identifies a device by the family of its User-Agent and
+// its network, so that browser updates and new addresses from the same /24
+// (IPv4) or /48 (IPv6) network don't make it a new device. Fingerprints can be
+// forged, so they're only used to notify about new devices.
+func DeviceFingerprint(userAgent string, ip string) string {
+	network := ip
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if v4 := parsed.To4(); v4 != nil {
+			network = v4.Mask(net.CIDRMask(24, 32)).String()
+		} else {
+			network = parsed.Mask(net.CIDRMask(48, 128)).String()
+		}
+	}
+
+	hash := sha256.Sum256([]byte(DeviceName(userAgent) + "|" + network))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+)
+
+var rxSubjectPrefixes = regexp.MustCompile(`([\[\(] *)?(RE?S?|FYI|RIF|I|FS|VB|RV|ENC|ODP|PD|YNT|ILT|SV|VS|VL|AW|WG|ΑΠ|ΣΧΕΤ|ΠΡΘ|תגובה|הועבר|主题|转发|FWD?) *([-:;)\]][ :;\])-]*|$)|\]+ *$`)
+
+// StripSubjectPrefixes removes reply and forward prefixes (Re:, Fwd:, AW:, ...) from a subject
+func StripSubjectPrefixes(subject string) string {
+	return rxSubjectPrefixes.ReplaceAllString(subject, "")
+}
+
+// SubjectHash returns the SHA256 hash of the raw subject, used to group emails into threads
+func SubjectHash(subject string) string {
+	hash := sha256.Sum256([]byte(StripSubjectPrefixes(subject)))
+	return hex.EncodeToString(hash[:])
+}